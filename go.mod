@@ -4,8 +4,10 @@ go 1.20
 
 require (
 	github.com/aws/aws-sdk-go v1.44.204
+	github.com/klauspost/compress v1.16.0
 	github.com/labstack/echo/v4 v4.10.0
 	github.com/stretchr/testify v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -24,5 +26,4 @@ require (
 	golang.org/x/text v0.5.0 // indirect
 	golang.org/x/time v0.2.0 // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
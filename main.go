@@ -4,6 +4,9 @@ import (
 	"os"
 
 	v1 "github.com/helloevanhere/simple_saver_service/pkg/api/v1"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/analyze"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/chargeback"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -15,7 +18,28 @@ func main() {
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
-	v1.Register(e)
+	//Thresholds, keyword lists and extension tables come from the policy file, validated before serving
+	p := policy.Default()
+	if policyFile := os.Getenv("POLICY_FILE"); policyFile != "" {
+		var err error
+		if p, err = policy.Load(policyFile); err != nil {
+			e.Logger.Fatal(err)
+		}
+	}
+	if err := analyze.ValidatePolicy(p); err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	//Bucket prefixes are assigned to owners by the optional owner mapping file
+	if mappingFile := os.Getenv("OWNER_MAPPING_FILE"); mappingFile != "" {
+		mapping, err := chargeback.LoadOwnerMapping(mappingFile)
+		if err != nil {
+			e.Logger.Fatal(err)
+		}
+		chargeback.UseOwnerMapping(mapping)
+	}
+
+	v1.Register(e, p)
 
 	httpPort := os.Getenv("HTTP_PORT")
 	if httpPort == "" {
@@ -1,11 +1,17 @@
 package v1
 
 import (
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
 	"github.com/labstack/echo/v4"
 )
 
-func Register(e *echo.Echo) {
+// Registers the routes, p is the policy requests start from
+func Register(e *echo.Echo, p policy.Policy) {
+	serverPolicy = p
+
 	e.GET("/", testHandler)
 	e.POST("/storage_report", storageReportHandler)
 	e.POST("/storage_recommendation", storageRecommendationHandler)
+	e.POST("/chargeback_report", chargebackReportHandler)
+	e.GET("/scanners", scannersHandler)
 }
@@ -7,13 +7,43 @@ import (
 	"github.com/helloevanhere/simple_saver_service/pkg/awsHelpers"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/analyze"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/chargeback"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
 	"github.com/helloevanhere/simple_saver_service/pkg/summary"
 	"github.com/labstack/echo/v4"
 )
 
 type bucketsRequest struct {
-	Buckets []string `json:"buckets"`
+	Buckets          []string `json:"buckets"`
+	scan.ScanOptions          //optional, the zero value runs every registered scan with default settings
+}
+
+// The policy every request starts from, set by Register()
+var serverPolicy = policy.Default()
+
+// Merges a request's policy overrides over the server's policy and checks the result
+// On success opts.Policy is the effective policy
+// Only the server's policy may name files on the server, requests that set rule_files are rejected
+func applyPolicy(opts *scan.ScanOptions) error {
+	effective := serverPolicy
+	if opts.Policy != nil {
+		if opts.Policy.RuleFiles != nil {
+			return fmt.Errorf("rule_files can only be set in the server's policy file")
+		}
+		effective = serverPolicy.Merge(*opts.Policy)
+	}
+	if err := analyze.ValidatePolicy(effective); err != nil {
+		return err
+	}
+	opts.Policy = &effective
+	return nil
+}
+
+// The storage recommendations and the policy they were made with
+type recommendationReport struct {
+	Policy          policy.Policy                   `json:"policy"`
+	Recommendations []recommendation.Recommendation `json:"recommendations"`
 }
 
 func testHandler(c echo.Context) error {
@@ -83,6 +113,15 @@ func storageRecommendationHandler(c echo.Context) error {
 	}
 	buckets := req.Buckets
 
+	//Reject invalid scan options and policies before making any AWS calls
+	opts := req.ScanOptions
+	if err := opts.Validate(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if err := applyPolicy(&opts); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
 	//If * specified, retrieve all buckets
 	if buckets[0] == "*" {
 		// Get List of Buckets
@@ -93,12 +132,12 @@ func storageRecommendationHandler(c echo.Context) error {
 		}
 	}
 
-	scans, err := scan.ScanS3(sess, buckets)
+	scans, err := scan.ScanS3(sess, buckets, opts)
 	if err != nil {
 		return fmt.Errorf("error creating s3 scans: %v", err)
 	}
 
-	analyses, err := analyze.AnalyzeScans(scans)
+	analyses, err := analyze.AnalyzeScans(scans, *opts.Policy)
 	if err != nil {
 		return fmt.Errorf("error creating analyses: %v", err)
 	}
@@ -108,7 +147,85 @@ func storageRecommendationHandler(c echo.Context) error {
 		return fmt.Errorf("error creating recommendations: %v", err)
 	}
 
-	return c.JSON(http.StatusOK, recommendations)
+	return c.JSON(http.StatusOK, recommendationReport{
+		Policy:          *opts.Policy,
+		Recommendations: recommendations,
+	})
+}
+
+// // @Summary Get Chargeback Report
+// // @Tags storage
+// // @Description Get the storage, monthly cost and estimated savings of every owner of the listed S3 buckets
+// // @Produce json
+// // @Success 200 {object} chargeback.Report
+// // @Failure 400 {object} api.httpError
+// // @Param buckets []string "S3 buckets", "*" indicates all buckets
+// // @Router /chargeback_report [post]
+func chargebackReportHandler(c echo.Context) error {
+
+	// Create a new AWS session with the credentials
+	sess, err := awsHelpers.CreateAWSSession()
+	if err != nil {
+		return fmt.Errorf("error creating AWS session: %v", err)
+	}
+
+	req := new(bucketsRequest)
+	if err := c.Bind(req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	buckets := req.Buckets
+
+	//The chargeback scan always runs, alongside any selected scans
+	opts := req.ScanOptions
+	if len(opts.Scanners) > 0 {
+		opts.Scanners = append(opts.Scanners, "chargeback")
+	}
+
+	//Reject invalid scan options and policies before making any AWS calls
+	if err := opts.Validate(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if err := applyPolicy(&opts); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	//If * specified, retrieve all buckets
+	if buckets[0] == "*" {
+		// Get List of Buckets
+		//AWS SDK LIST CALL
+		buckets, err = awsHelpers.ListS3Buckets(sess)
+		if err != nil {
+			return fmt.Errorf("error retrieving bucket list: %v", err)
+		}
+	}
+
+	scans, err := scan.ScanS3(sess, buckets, opts)
+	if err != nil {
+		return fmt.Errorf("error creating s3 scans: %v", err)
+	}
+
+	analyses, err := analyze.AnalyzeScans(scans, *opts.Policy)
+	if err != nil {
+		return fmt.Errorf("error creating analyses: %v", err)
+	}
+
+	report, err := chargeback.CreateReport(scans, analyses)
+	if err != nil {
+		return fmt.Errorf("error creating chargeback report: %v", err)
+	}
+	report.Policy = *opts.Policy
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// // @Summary List Scans
+// // @Tags storage
+// // @Description List the registered scans and the IAM permissions each one requires
+// // @Produce json
+// // @Success 200 {object} []scan.ScannerRegistration
+// // @Router /scanners [get]
+func scannersHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, scan.RegisteredScanners())
 }
 
 type Report struct {
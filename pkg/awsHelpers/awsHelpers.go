@@ -77,3 +77,41 @@ func ListBucketObjects(sess *session.Session, bucketName string) (*s3.ListObject
 		Contents: objects,
 	}, nil
 }
+
+// Lists all objects in a bucket one page at a time
+// fn is called for every page, an error returned by fn stops the listing and is returned
+func ListBucketObjectPages(sess *session.Session, bucketName string, fn func(page *s3.ListObjectsV2Output) error) error {
+	svc := s3.New(sess)
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+	}
+
+	// AWS SDK LIST CALL
+	var pageErr error
+	err := svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		pageErr = fn(page)
+		return pageErr == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return pageErr
+}
+
+// Takes in an AWS session object and a bucket name and returns the bucket's region
+func GetBucketRegion(sess *session.Session, bucketName string) (string, error) {
+	svc := s3.New(sess)
+
+	//AWS SDK GET CALL
+	output, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Buckets in us-east-1 have no location constraint, "EU" is the legacy name of eu-west-1
+	return s3.NormalizeBucketLocation(aws.StringValue(output.LocationConstraint)), nil
+}
@@ -0,0 +1,200 @@
+package access
+
+//This package builds real access recency and frequency for buckets and prefixes from access logs
+//Any log format can feed a Tracker with Events, see serverAccessLog.go and cloudTrail.go
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation is the kind of data access an Event records
+type Operation string
+
+const (
+	Read  Operation = "read"
+	Write Operation = "write"
+)
+
+// Default number of key segments that make up a prefix
+const defaultPrefixDepth = 1
+
+// Event is one successful read or write of an object
+type Event struct {
+	Bucket    string
+	Key       string
+	Time      time.Time
+	Operation Operation
+}
+
+// AccessDetail contains the access history of one bucket as seen in the logs
+type AccessDetail struct {
+	Source       string         `json:"source"`       //log format the detail was built from
+	PrefixDepth  int            `json:"prefix_depth"` //key segments of the tracked prefixes, a read of one key counts as a read of its whole prefix
+	WindowStart  time.Time      `json:"window_start"`
+	WindowEnd    time.Time      `json:"window_end"` //the first and last log entries naming the bucket, zero when none do
+	LastAccessAt time.Time      `json:"last_access_at"`
+	LastReadAt   time.Time      `json:"last_read_at"`
+	LastWriteAt  time.Time      `json:"last_write_at"`
+	GetCount     int64          `json:"get_count"`
+	PutCount     int64          `json:"put_count"`
+	Prefixes     []PrefixAccess `json:"prefixes"`
+
+	MalformedLines int64 `json:"malformed_lines"` //log lines or CloudTrail files that couldn't be parsed and were skipped, across every bucket since they may name any
+}
+
+// PrefixAccess contains the access history of one prefix in a bucket
+type PrefixAccess struct {
+	Prefix       string    `json:"prefix"`
+	LastAccessAt time.Time `json:"last_access_at"`
+	LastReadAt   time.Time `json:"last_read_at"`
+	LastWriteAt  time.Time `json:"last_write_at"`
+	GetCount     int64     `json:"get_count"`
+	PutCount     int64     `json:"put_count"`
+}
+
+// Checks if the logs cover at least the given duration
+func (d AccessDetail) Covers(duration time.Duration) bool {
+	return !d.WindowStart.IsZero() && d.WindowEnd.Sub(d.WindowStart) >= duration
+}
+
+// Returns the access history of the longest prefix of key that was tracked
+func (d AccessDetail) Prefix(key string) (PrefixAccess, bool) {
+	best, found := PrefixAccess{}, false
+	for _, p := range d.Prefixes {
+		if strings.HasPrefix(key, p.Prefix) && (!found || len(p.Prefix) > len(best.Prefix)) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// Tracker aggregates Events into an AccessDetail per bucket
+type Tracker struct {
+	mu          sync.Mutex
+	source      string
+	prefixDepth int
+	buckets     map[string]*AccessDetail
+	prefixes    map[string]map[string]*PrefixAccess
+	malformed   int64
+}
+
+// Creates a Tracker for a log format
+// prefixDepth is the number of "/" separated key segments per prefix, 0 uses the default of 1
+func NewTracker(source string, prefixDepth int) *Tracker {
+	if prefixDepth <= 0 {
+		prefixDepth = defaultPrefixDepth
+	}
+	return &Tracker{
+		source:      source,
+		prefixDepth: prefixDepth,
+		buckets:     make(map[string]*AccessDetail),
+		prefixes:    make(map[string]map[string]*PrefixAccess),
+	}
+}
+
+// Records that the logs contain an entry for a bucket at t, successful or not, to track the window the logs cover it
+func (t *Tracker) Observe(bucket string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.observe(bucket, at)
+}
+
+// Records a log line or file that couldn't be parsed and was skipped
+func (t *Tracker) Malformed() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.malformed++
+}
+
+// Returns the bucket's AccessDetail after moving its window to include at
+func (t *Tracker) observe(bucket string, at time.Time) *AccessDetail {
+	detail, ok := t.buckets[bucket]
+	if !ok {
+		detail = &AccessDetail{Source: t.source, PrefixDepth: t.prefixDepth}
+		t.buckets[bucket] = detail
+		t.prefixes[bucket] = make(map[string]*PrefixAccess)
+	}
+	if detail.WindowStart.IsZero() || at.Before(detail.WindowStart) {
+		detail.WindowStart = at
+	}
+	if at.After(detail.WindowEnd) {
+		detail.WindowEnd = at
+	}
+	return detail
+}
+
+// Adds one Event to the Tracker
+func (t *Tracker) Record(event Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	detail := t.observe(event.Bucket, event.Time)
+	prefix := KeyPrefix(event.Key, t.prefixDepth)
+	prefixAccess, ok := t.prefixes[event.Bucket][prefix]
+	if !ok {
+		prefixAccess = &PrefixAccess{Prefix: prefix}
+		t.prefixes[event.Bucket][prefix] = prefixAccess
+	}
+
+	latest(&detail.LastAccessAt, event.Time)
+	latest(&prefixAccess.LastAccessAt, event.Time)
+	switch event.Operation {
+	case Read:
+		detail.GetCount++
+		prefixAccess.GetCount++
+		latest(&detail.LastReadAt, event.Time)
+		latest(&prefixAccess.LastReadAt, event.Time)
+	case Write:
+		detail.PutCount++
+		prefixAccess.PutCount++
+		latest(&detail.LastWriteAt, event.Time)
+		latest(&prefixAccess.LastWriteAt, event.Time)
+	}
+}
+
+// Returns the AccessDetail of a bucket
+// Buckets the logs never name get a zero window, so callers fall back to LastModified instead of reading "no access"
+func (t *Tracker) Detail(bucket string) AccessDetail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	detail := AccessDetail{Source: t.source, PrefixDepth: t.prefixDepth}
+	if d, ok := t.buckets[bucket]; ok {
+		detail = *d
+		detail.Prefixes = make([]PrefixAccess, 0, len(t.prefixes[bucket]))
+		for _, p := range t.prefixes[bucket] {
+			detail.Prefixes = append(detail.Prefixes, *p)
+		}
+		sort.Slice(detail.Prefixes, func(i, j int) bool {
+			return detail.Prefixes[i].Prefix < detail.Prefixes[j].Prefix
+		})
+	}
+	detail.MalformedLines = t.malformed
+	return detail
+}
+
+// Returns the first depth "/" separated segments of a key, ex. "logs/" for "logs/2023/app.log"
+// Keys with fewer segments get the prefix of the segments they have, "" for keys at the bucket's root
+func KeyPrefix(key string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		next := strings.Index(key[end:], "/")
+		if next < 0 {
+			break
+		}
+		end += next + 1
+	}
+	return key[:end]
+}
+
+// HELPER for Record()
+func latest(current *time.Time, t time.Time) {
+	if t.After(*current) {
+		*current = t
+	}
+}
@@ -0,0 +1,138 @@
+package access
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testLog = `79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:00:38 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be 3E57427F3EXAMPLE REST.GET.VERSIONING - "GET /awsexamplebucket1?versioning HTTP/1.1" 200 - 113 - 7 - "-" "S3Console/0.4" - s9lzHYrFp76ZVxRcpX9+5cjAnEH2ROuNkd2BHfIa6UkFVdtjf5mKR3/eTPFvsiP/XV/VLi31234= SigV2 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.2 - -
+79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:00:40 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be 891CE47D2EXAMPLE REST.GET.OBJECT logs/my%20file.txt "GET /awsexamplebucket1/logs/my%20file.txt HTTP/1.1" 200 - 1024 1024 10 9 "-" "S3Console/0.4" - 9vKBE6vMhrNiWHZmb2L0mXOcqPGzQOI5XLnCtZNPxev+Hf+7tpT6sxDwDty4LHBUOZJG96N1234= SigV4 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.2 - -
+79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:01:00 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be A1206F460EXAMPLE REST.PUT.OBJECT data/part-0001 "PUT /awsexamplebucket1/data/part-0001 HTTP/1.1" 200 - - 2048 40 39 "-" "aws-cli/1.0" - BNaBsXZQQDbssi6xMBdBU2sLt+Yf5kZDmeBUP35sFoKa3sLLeMC78iwEIWxs99CRUrbS4n11234= SigV4 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.2 - -
+79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [07/Feb/2019:00:00:00 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be B1206F460EXAMPLE REST.GET.OBJECT data/missing "GET /awsexamplebucket1/data/missing HTTP/1.1" 404 NoSuchKey 300 - 5 - "-" "aws-cli/1.0" - BNaBsXZQQDbssi6xMBdBU2sLt+Yf5kZDmeBUP35sFoKa3sLLeMC78iwEIWxs99CRUrbS4n11234= SigV4 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.2 - -
+`
+
+// Test ParseServerAccessLogLine
+func TestParseServerAccessLogLine(t *testing.T) {
+	_, _, ok, err := ParseServerAccessLogLine(`owner bucket [06/Feb/2019:00:00:38 +0000] 192.0.2.3 requester id REST.GET.BUCKET - "GET /bucket HTTP/1.1" 200`)
+	assert.NoError(t, err)
+	assert.False(t, ok, "bucket level operations are not object access")
+
+	event, at, ok, err := ParseServerAccessLogLine(`owner bucket [06/Feb/2019:00:00:40 +0000] 192.0.2.3 requester id REST.GET.OBJECT logs/my%20file.txt "GET /bucket/logs/my%20file.txt HTTP/1.1" 200 - 1024`)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, 2, 6, 0, 0, 40, 0, time.UTC), at.UTC())
+	assert.Equal(t, "bucket", event.Bucket)
+	assert.Equal(t, "logs/my file.txt", event.Key)
+	assert.Equal(t, Read, event.Operation)
+
+	_, _, _, err = ParseServerAccessLogLine("not a log line")
+	assert.Error(t, err)
+}
+
+// Test Tracker through the server access log parser
+func TestServerAccessLogTracker(t *testing.T) {
+	tracker := NewTracker("server_access", 0)
+	assert.NoError(t, parseServerAccessLog(bytes.NewBufferString(testLog+"truncated line\n"), tracker))
+
+	detail := tracker.Detail("awsexamplebucket1")
+	assert.Equal(t, int64(1), detail.MalformedLines, "malformed lines are skipped")
+	assert.Equal(t, 1, detail.PrefixDepth, "access is tracked per prefix, not per key")
+	assert.Equal(t, int64(1), detail.GetCount)
+	assert.Equal(t, int64(1), detail.PutCount)
+	assert.Equal(t, time.Date(2019, 2, 6, 0, 1, 0, 0, time.UTC), detail.LastAccessAt.UTC())
+	assert.Equal(t, time.Date(2019, 2, 6, 0, 0, 38, 0, time.UTC), detail.WindowStart.UTC())
+	assert.Equal(t, time.Date(2019, 2, 7, 0, 0, 0, 0, time.UTC), detail.WindowEnd.UTC(), "failed requests still extend the window")
+	assert.Len(t, detail.Prefixes, 2)
+
+	prefix, ok := detail.Prefix("data/part-0002")
+	assert.True(t, ok)
+	assert.Equal(t, "data/", prefix.Prefix)
+	assert.Equal(t, int64(1), prefix.PutCount)
+	assert.True(t, prefix.LastReadAt.IsZero())
+
+	assert.True(t, detail.Covers(time.Hour))
+	assert.False(t, detail.Covers(48*time.Hour))
+
+	//Buckets the logs never name have no window, so they are not read as inactive
+	empty := tracker.Detail("other-bucket")
+	assert.Equal(t, int64(0), empty.GetCount)
+	assert.True(t, empty.WindowStart.IsZero())
+	assert.False(t, empty.Covers(time.Hour))
+
+	//Lines without object access still give their bucket a window
+	tracker.Observe("quiet-bucket", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracker.Observe("quiet-bucket", time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC))
+	quiet := tracker.Detail("quiet-bucket")
+	assert.Equal(t, int64(0), quiet.GetCount)
+	assert.True(t, quiet.Covers(30*24*time.Hour))
+}
+
+// Test KeyPrefix
+func TestKeyPrefix(t *testing.T) {
+	assert.Equal(t, "logs/", KeyPrefix("logs/2023/app.log", 1))
+	assert.Equal(t, "logs/2023/", KeyPrefix("logs/2023/app.log", 2))
+	assert.Equal(t, "logs/2023/", KeyPrefix("logs/2023/app.log", 5))
+	assert.Equal(t, "", KeyPrefix("app.log", 1))
+}
+
+// Test Load from a local directory of plain and gzipped logs
+func TestLoadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "plain.log"), []byte(testLog), 0644))
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testLog))
+	w.Close()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "compressed.log.gz"), gz.Bytes(), 0644))
+
+	//Directories must be allowed by the server
+	_, err := Load(nil, LogSource{Directory: dir})
+	assert.Error(t, err)
+
+	t.Setenv("ACCESS_LOG_DIRS", filepath.Dir(dir))
+	tracker, err := Load(nil, LogSource{Directory: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), tracker.Detail("awsexamplebucket1").GetCount)
+	assert.Error(t, LogSource{Directory: filepath.Join(dir, "..", "..")}.Validate())
+
+	assert.Error(t, LogSource{}.Validate())
+	assert.Error(t, LogSource{Bucket: "logs", Directory: dir}.Validate())
+	assert.Error(t, LogSource{Format: "unknown", Directory: dir}.Validate())
+}
+
+const testCloudTrailLog = `{"Records":[
+{"eventTime":"2019-02-06T00:00:40Z","eventSource":"s3.amazonaws.com","eventName":"GetObject","requestParameters":{"bucketName":"awsexamplebucket1","key":"logs/app.log"}},
+{"eventTime":"2019-02-06T00:01:00Z","eventSource":"s3.amazonaws.com","eventName":"PutObject","requestParameters":{"bucketName":"awsexamplebucket1","key":"data/part-0001"}},
+{"eventTime":"2019-02-06T00:02:00Z","eventSource":"s3.amazonaws.com","eventName":"GetObject","errorCode":"NoSuchKey","requestParameters":{"bucketName":"awsexamplebucket1","key":"data/missing"}},
+{"eventTime":"2019-02-07T00:00:00Z","eventSource":"s3.amazonaws.com","eventName":"ListObjects","requestParameters":{"bucketName":"awsexamplebucket1"}}
+]}`
+
+// Test Tracker through the CloudTrail parser
+func TestCloudTrailTracker(t *testing.T) {
+	tracker := NewTracker("cloudtrail", 1)
+	assert.NoError(t, parseCloudTrailLog(bytes.NewBufferString(testCloudTrailLog), tracker))
+
+	detail := tracker.Detail("awsexamplebucket1")
+	assert.Equal(t, "cloudtrail", detail.Source)
+	assert.Equal(t, int64(1), detail.GetCount)
+	assert.Equal(t, int64(1), detail.PutCount)
+	assert.Equal(t, time.Date(2019, 2, 6, 0, 1, 0, 0, time.UTC), detail.LastAccessAt.UTC())
+	assert.Equal(t, time.Date(2019, 2, 7, 0, 0, 0, 0, time.UTC), detail.WindowEnd.UTC())
+
+	prefix, ok := detail.Prefix("logs/app.log")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, 2, 6, 0, 0, 40, 0, time.UTC), prefix.LastReadAt.UTC())
+
+	//Files that aren't CloudTrail logs, ex. digest files, are skipped
+	assert.NoError(t, parseCloudTrailLog(bytes.NewBufferString("not json"), tracker))
+	assert.NoError(t, parseCloudTrailLog(bytes.NewBufferString(`{"awsAccountId":"111122223333","digestStartTime":"2019-02-06T00:00:00Z"}`), tracker))
+	assert.Equal(t, int64(2), tracker.Detail("awsexamplebucket1").MalformedLines)
+	assert.NoError(t, LogSource{Format: "cloudtrail", Bucket: "trail-bucket"}.Validate())
+}
@@ -0,0 +1,64 @@
+package access
+
+//This file parses CloudTrail log files containing S3 data events
+//https://docs.aws.amazon.com/AmazonS3/latest/userguide/cloudtrail-logging-understanding-s3-entries.html
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Data events that read or write object data
+var cloudTrailOperations = map[string]Operation{
+	"GetObject":               Read,
+	"PutObject":               Write,
+	"CopyObject":              Write,
+	"CompleteMultipartUpload": Write,
+}
+
+// cloudTrailLog is the part of a CloudTrail log file the Tracker needs
+type cloudTrailLog struct {
+	Records []cloudTrailRecord `json:"Records"`
+}
+
+type cloudTrailRecord struct {
+	EventTime         time.Time `json:"eventTime"`
+	EventSource       string    `json:"eventSource"`
+	EventName         string    `json:"eventName"`
+	ErrorCode         string    `json:"errorCode"`
+	RequestParameters struct {
+		BucketName string `json:"bucketName"`
+		Key        string `json:"key"`
+	} `json:"requestParameters"`
+}
+
+// Satisfies fileParser
+// Every S3 record moves the log window of its bucket, only successful S3 reads and writes of objects become Events
+// Files that don't decode or have no Records, ex. digest files or ones cut off when written, are counted as malformed and skipped
+func parseCloudTrailLog(r io.Reader, tracker *Tracker) error {
+	log := cloudTrailLog{}
+	if err := json.NewDecoder(r).Decode(&log); err != nil || log.Records == nil {
+		tracker.Malformed()
+		return nil
+	}
+
+	for _, record := range log.Records {
+		if record.EventSource != "s3.amazonaws.com" || record.RequestParameters.BucketName == "" {
+			continue
+		}
+		tracker.Observe(record.RequestParameters.BucketName, record.EventTime)
+
+		operation, ok := cloudTrailOperations[record.EventName]
+		if !ok || record.ErrorCode != "" || record.RequestParameters.Key == "" {
+			continue
+		}
+		tracker.Record(Event{
+			Bucket:    record.RequestParameters.BucketName,
+			Key:       record.RequestParameters.Key,
+			Time:      record.EventTime,
+			Operation: operation,
+		})
+	}
+	return nil
+}
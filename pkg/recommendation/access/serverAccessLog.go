@@ -0,0 +1,117 @@
+package access
+
+//This file parses S3 server access log files
+//https://docs.aws.amazon.com/AmazonS3/latest/userguide/LogFormat.html
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time format of the [time] field
+const serverAccessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Operations that read or write object data
+var serverAccessLogOperations = map[string]Operation{
+	"REST.GET.OBJECT":      Read,
+	"REST.COPY.OBJECT_GET": Read,
+	"REST.PUT.OBJECT":      Write,
+	"REST.POST.OBJECT":     Write,
+	"REST.POST.UPLOAD":     Write, //CompleteMultipartUpload
+	"REST.COPY.OBJECT":     Write,
+}
+
+// Satisfies fileParser
+// Every line moves the log window of its bucket, only successful reads and writes of objects become Events
+// Malformed lines, ex. one cut off when the file was written, are counted and skipped
+func parseServerAccessLog(r io.Reader, tracker *Tracker) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		event, at, ok, err := ParseServerAccessLogLine(line)
+		if err != nil {
+			tracker.Malformed()
+			continue
+		}
+		tracker.Observe(event.Bucket, at)
+		if ok {
+			tracker.Record(event)
+		}
+	}
+	return scanner.Err()
+}
+
+// Parses one server access log line
+// ok is false for lines that are not a successful read or write of an object, their event only has the Bucket and Time
+func ParseServerAccessLogLine(line string) (event Event, at time.Time, ok bool, err error) {
+	fields := splitServerAccessLogLine(line)
+	//bucket owner, bucket, time, remote ip, requester, request id, operation, key, request uri, http status
+	if len(fields) < 10 {
+		return Event{}, time.Time{}, false, fmt.Errorf("malformed server access log line: %q", line)
+	}
+
+	at, err = time.Parse(serverAccessLogTimeFormat, fields[2])
+	if err != nil {
+		return Event{}, time.Time{}, false, fmt.Errorf("malformed server access log time %q: %v", fields[2], err)
+	}
+
+	operation, known := serverAccessLogOperations[fields[6]]
+	status := fields[9]
+	if !known || fields[7] == "-" || !strings.HasPrefix(status, "2") {
+		return Event{Bucket: fields[1], Time: at}, at, false, nil
+	}
+
+	key, err := url.QueryUnescape(fields[7])
+	if err != nil {
+		key = fields[7]
+	}
+
+	return Event{
+		Bucket:    fields[1],
+		Key:       key,
+		Time:      at,
+		Operation: operation,
+	}, at, true, nil
+}
+
+// HELPER for ParseServerAccessLogLine()
+// Splits on spaces, keeping [bracketed] and "quoted" fields whole and without their delimiters
+func splitServerAccessLogLine(line string) []string {
+	fields := []string{}
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ':
+			i++
+		case '[', '"':
+			closing := byte(']')
+			if line[i] == '"' {
+				closing = '"'
+			}
+			end := strings.IndexByte(line[i+1:], closing)
+			if end < 0 {
+				fields = append(fields, line[i+1:])
+				return fields
+			}
+			fields = append(fields, line[i+1:i+1+end])
+			i += end + 2
+		default:
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				fields = append(fields, line[i:])
+				return fields
+			}
+			fields = append(fields, line[i:i+end])
+			i += end
+		}
+	}
+	return fields
+}
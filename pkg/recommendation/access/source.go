@@ -0,0 +1,179 @@
+package access
+
+//This file reads log files from a log bucket or a local directory
+//Local directories must be under one of the directories the server allows in ACCESS_LOG_DIRS
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// LogSource is where log files are read from, either Bucket and Prefix or Directory
+type LogSource struct {
+	Format      string `json:"format"` //"server_access" or "cloudtrail", defaults to "server_access"
+	Bucket      string `json:"bucket,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+	Directory   string `json:"directory,omitempty"`    //must be under a directory listed in ACCESS_LOG_DIRS
+	PrefixDepth int    `json:"prefix_depth,omitempty"` //key segments per tracked prefix, defaults to 1
+}
+
+// Parses one log file and records its Events in the Tracker
+type fileParser func(r io.Reader, tracker *Tracker) error
+
+// Parsers for each supported log format
+var parsers = map[string]fileParser{
+	"server_access": parseServerAccessLog,
+	"cloudtrail":    parseCloudTrailLog,
+}
+
+// Checks the LogSource before any logs are read
+func (src LogSource) Validate() error {
+	if _, ok := parsers[src.format()]; !ok {
+		return fmt.Errorf("unsupported access log format: %s", src.Format)
+	}
+	if (src.Bucket == "") == (src.Directory == "") {
+		return fmt.Errorf("access logs need either a bucket or a directory")
+	}
+	if src.Directory != "" && !allowedDirectory(src.Directory, filepath.SplitList(os.Getenv("ACCESS_LOG_DIRS"))) {
+		return fmt.Errorf("access log directory %s is not allowed by the server's ACCESS_LOG_DIRS", src.Directory)
+	}
+	return nil
+}
+
+// HELPER for Validate()
+// Checks if dir is one of the allowed directories or under one, after resolving symlinks
+func allowedDirectory(dir string, allowed []string) bool {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return false
+	}
+	for _, root := range allowed {
+		if root == "" {
+			continue
+		}
+		root, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		root, err = filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (src LogSource) format() string {
+	if src.Format == "" {
+		return "server_access"
+	}
+	return src.Format
+}
+
+// Takes in a session and LogSource, reads every log file and returns a Tracker of their Events
+func Load(sess *session.Session, src LogSource) (*Tracker, error) {
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+	parse := parsers[src.format()]
+	tracker := NewTracker(src.format(), src.PrefixDepth)
+
+	if src.Directory != "" {
+		return tracker, loadDirectory(src.Directory, parse, tracker)
+	}
+	return tracker, loadBucket(sess, src.Bucket, src.Prefix, parse, tracker)
+}
+
+// Parses every file under a local directory
+func loadDirectory(dir string, parse fileParser, tracker *Tracker) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := parseFile(f, parse, tracker); err != nil {
+			return fmt.Errorf("error parsing access log %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// Parses every object under a prefix of a log bucket
+func loadBucket(sess *session.Session, bucket string, prefix string, parse fileParser, tracker *Tracker) error {
+	svc := s3.New(sess)
+
+	keys := []string{}
+	//AWS SDK LIST CALL
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		//AWS SDK GET CALL
+		output, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+		err = parseFile(output.Body, parse, tracker)
+		output.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error parsing access log s3://%s/%s: %v", bucket, key, err)
+		}
+	}
+	return nil
+}
+
+// HELPER for loadDirectory() and loadBucket()
+// Log files may be gzipped, detected from the gzip magic bytes
+func parseFile(r io.Reader, parse fileParser, tracker *Tracker) error {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return parse(gz, tracker)
+	}
+	return parse(buffered, tracker)
+}
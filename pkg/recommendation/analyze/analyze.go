@@ -1,7 +1,10 @@
 package analyze
 
 import (
+	"fmt"
+
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
 	"github.com/helloevanhere/simple_saver_service/pkg/summary"
 )
@@ -24,49 +27,101 @@ func (a *Analysis) AppendAnalysisResult(result AnalysisResult) {
 	}
 }
 
-// Takes in an array of BucketScans and returns an array of Analyses
-func AnalyzeScans(scans []scan.BucketScans) ([]Analysis, error) {
+// Returns the ObjectScan of a data category from a bucket's scans, if that scan ran
+func findObjectScan(bucketScans scan.BucketScans, dataCategory string) (scan.ObjectScan, bool) {
+	for _, objectScan := range bucketScans.Scans.ObjectScans {
+		if objectScan.DataCategory == dataCategory {
+			return objectScan, true
+		}
+	}
+	return scan.ObjectScan{}, false
+}
+
+// Names of the bucket Analyses, which always run before the registered object Analyses
+var bucketAnalysisNames = []string{
+	"Archive Storage Analysis",
+	"Bucket Versioning Analysis",
+	"Lifecycle Management Analysis",
+	"Temporary Storage Analysis",
+}
+
+// Returns the names of the bucket Analyses and registered object Analyses
+func builtInAnalysisNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range bucketAnalysisNames {
+		names[name] = true
+	}
+	for _, r := range RegisteredObjectAnalyses() {
+		names[r.Name] = true
+	}
+	return names
+}
+
+// Checks a policy, its rule files and that every Analysis it enables exists
+func ValidatePolicy(p policy.Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	rules, err := LoadRules(p.RuleFiles)
+	if err != nil {
+		return err
+	}
+	known := builtInAnalysisNames()
+	for _, rule := range rules {
+		known[rule.Name] = true
+	}
+	for _, name := range p.Analyses {
+		if !known[name] {
+			return fmt.Errorf("unknown analysis: %s", name)
+		}
+	}
+	return nil
+}
+
+// Takes in an array of BucketScans and the policy to analyze them with and returns an array of the Analyses the policy enables
+func AnalyzeScans(scans []scan.BucketScans, p policy.Policy) ([]Analysis, error) {
+	//User-defined rules are reported after the built-in Analyses
+	rules, err := LoadRules(p.RuleFiles)
+	if err != nil {
+		return nil, err
+	}
+
 	//Initalize Analysis variables
 	//TO DO: Put Analysis metadata in DB and access via query
 	archive := Analysis{
-		Name:        "Archive Storage Analysis",
+		Name:        bucketAnalysisNames[0],
 		Description: "Checks if you have buckets that archive data and if the storage class is suitable",
 	}
 
 	versioning := Analysis{
-		Name:        "Bucket Versioning Analysis",
+		Name:        bucketAnalysisNames[1],
 		Description: "Analyzes the Versioning Status on your buckets",
 	}
 
 	lifecycle := Analysis{
-		Name:        "Lifecycle Management Analysis",
+		Name:        bucketAnalysisNames[2],
 		Description: "Analyzes the Lifecycle Policies of your buckets",
 	}
 
 	tempStorage := Analysis{
-		Name:        "Temporary Storage Analysis",
+		Name:        bucketAnalysisNames[3],
 		Description: "Analyzes the Lifecycle Policies on buckets that have been detected to hold temporary data",
 	}
 
-	compression := Analysis{
-		Name:        "Compressed Data Analysis",
-		Description: "Analyzes if there are objects that can be compressed in your buckets",
-	}
-
-	duplicates := Analysis{
-		Name:        "Duplicate Data Analysis",
-		Description: "Analyzes if there are potentially duplicate objects in your buckets",
-	}
-
-	incomplete := Analysis{
-		Name:        "Incomplete Data Analysis",
-		Description: "Analyzes if there are Incomplete Multipart Uploads in your buckets and if you have the proper policies to manage them",
+	//One Analysis per registered object analysis, in registration order
+	objectRegistrations := RegisteredObjectAnalyses()
+	objectAnalyses := make([]Analysis, 0, len(objectRegistrations))
+	for _, r := range objectRegistrations {
+		objectAnalyses = append(objectAnalyses, Analysis{
+			Name:        r.Name,
+			Description: r.Description,
+		})
 	}
 
 	//Iterate through BucketScans and generate bucket analyses
 	for _, scan := range scans {
 		//is archivable bucket analysis
-		archiveResult, err := archiveAnalysis(scan)
+		archiveResult, err := archiveAnalysis(scan, p)
 		if err != nil {
 			return nil, err
 		}
@@ -88,33 +143,16 @@ func AnalyzeScans(scans []scan.BucketScans) ([]Analysis, error) {
 
 		//Iterate through objectScans to create object Analyses
 		for _, objectScan := range scan.Scans.ObjectScans {
-			switch objectScan.DataCategory {
-			case "incomplete_multipart_upload":
-				dataResult, err := ObjectAnalysis(scan.BucketSummary, scan.Scans.BucketScan, objectScan)
-				if err != nil {
-					return nil, err
+			for i, r := range objectRegistrations {
+				if r.DataCategory != objectScan.DataCategory {
+					continue
 				}
-				if dataResult.BucketSummary.Name != "" {
-					incomplete.AnalysisResults = append(incomplete.AnalysisResults, dataResult)
-				}
-			case "duplicate_objects":
 				dataResult, err := ObjectAnalysis(scan.BucketSummary, scan.Scans.BucketScan, objectScan)
 				if err != nil {
 					return nil, err
 				}
-				if dataResult.BucketSummary.Name != "" {
-					duplicates.AnalysisResults = append(duplicates.AnalysisResults, dataResult)
-				}
-			case "compressible_objects":
-				dataResult, err := ObjectAnalysis(scan.BucketSummary, scan.Scans.BucketScan, objectScan)
-				if err != nil {
-					return nil, err
-				}
-				if dataResult.BucketSummary.Name != "" {
-					compression.AnalysisResults = append(compression.AnalysisResults, dataResult)
-				}
+				objectAnalyses[i].AppendAnalysisResult(dataResult)
 			}
-
 		}
 
 	}
@@ -122,7 +160,7 @@ func AnalyzeScans(scans []scan.BucketScans) ([]Analysis, error) {
 	// temporary storage bucket analysis
 	// Takes in lifecycle.AnalysisResults which is an array of AnalysisResult
 	// for buckets that do not have lifecycle management policies
-	tempStorageResults, err := temporaryStorageAnalysis(lifecycle.AnalysisResults)
+	tempStorageResults, err := temporaryStorageAnalysis(lifecycle.AnalysisResults, p)
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +170,18 @@ func AnalyzeScans(scans []scan.BucketScans) ([]Analysis, error) {
 		}
 	}
 
-	analyses := []Analysis{archive, versioning, lifecycle, tempStorage, compression, duplicates, incomplete}
+	ruleAnalyses := make([]Analysis, 0, len(rules))
+	for _, rule := range rules {
+		ruleAnalyses = append(ruleAnalyses, ruleAnalysis(scans, rule))
+	}
+
+	analyses := []Analysis{}
+	builtIn := append([]Analysis{archive, versioning, lifecycle, tempStorage}, objectAnalyses...)
+	for _, analysis := range append(builtIn, ruleAnalyses...) {
+		if p.AnalysisEnabled(analysis.Name) {
+			analyses = append(analyses, analysis)
+		}
+	}
 
 	return analyses, nil
 
@@ -1,9 +1,15 @@
 package analyze
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
 	"github.com/helloevanhere/simple_saver_service/pkg/summary"
 )
@@ -27,13 +33,13 @@ func TestAnalyzeScans(t *testing.T) {
 	}
 
 	// Call the AnalyzeScans function
-	results, err := AnalyzeScans(scans)
+	results, err := AnalyzeScans(scans, policy.Default())
 	if err != nil {
 		t.Fatalf("AnalyzeScans returned an error: %v", err)
 	}
 
 	// Check that the results contain the expected data
-	if len(results) != 7 {
+	if len(results) != 18 {
 		t.Fatalf("AnalyzeScans did not return the expected number of analyses")
 	}
 	if results[0].Name != "Archive Storage Analysis" {
@@ -57,6 +63,39 @@ func TestAnalyzeScans(t *testing.T) {
 	if results[6].Name != "Incomplete Data Analysis" {
 		t.Fatalf("AnalyzeScans did not return the expected analysis results")
 	}
+	if results[7].Name != "Cross-Bucket Duplicate Data Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[8].Name != "Intelligent-Tiering Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[9].Name != "Replication Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[10].Name != "KMS Request Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[11].Name != "Bucket Configuration Audit" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[12].Name != "Restored Archive Copy Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[13].Name != "Small Archive Object Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[14].Name != "Abandoned Bucket Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[15].Name != "Small File Consolidation Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[16].Name != "Archive Tiering Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
+	if results[17].Name != "Early Deletion Analysis" {
+		t.Fatalf("AnalyzeScans did not return the expected analysis results")
+	}
 }
 
 // Test Object Analysis
@@ -119,3 +158,300 @@ func TestObjectAnalysis(t *testing.T) {
 		t.Errorf("expected Data to be %v, but got %v", objectScan, result.Data)
 	}
 }
+
+// Test Object Analysis for a data category without a registered analysis
+func TestObjectAnalysisUnregisteredCategory(t *testing.T) {
+	objectScan := scan.ObjectScan{
+		DataCategory: "not_a_category",
+		ObjectCount:  1,
+	}
+	result, err := ObjectAnalysis(summary.BucketSummary{Name: "test-bucket-1"}, scan.BucketScan{}, objectScan)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result.BucketSummary.Name != "" {
+		t.Errorf("expected an empty result, but got %v", result)
+	}
+}
+
+// Test Lifecycle Analysis flags buckets whose rules are all disabled
+func TestLifecycleAnalysis(t *testing.T) {
+	bucketScans := scan.BucketScans{
+		BucketSummary: summary.BucketSummary{Name: "test-bucket-1"},
+		Scans: scan.Scans{
+			BucketScan: scan.BucketScan{
+				LifecycleDetail: scan.LifecycleDetail{
+					Rules: []*s3.LifecycleRule{
+						{Status: nil},
+						{Status: aws.String("Disabled")},
+					},
+				},
+			},
+		},
+	}
+	result, err := lifecycleAnalysis(bucketScans)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result.BucketSummary.Name != "test-bucket-1" {
+		t.Errorf("expected bucket without enabled rules to be flagged")
+	}
+
+	bucketScans.Scans.BucketScan.LifecycleDetail.Rules[1].Status = aws.String("Enabled")
+	result, err = lifecycleAnalysis(bucketScans)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result.BucketSummary.Name != "" {
+		t.Errorf("expected bucket with an enabled rule not to be flagged")
+	}
+}
+
+// Test isArchivable with access logs
+func TestIsArchivableWithAccessLogs(t *testing.T) {
+	now := time.Now()
+	bucket := scan.BucketScans{
+		BucketSummary: summary.BucketSummary{Name: "test-bucket", ModifiedLastAt: now},
+		AccessDetail: &access.AccessDetail{
+			WindowStart:  now.AddDate(0, -4, 0),
+			WindowEnd:    now,
+			LastAccessAt: now.AddDate(0, -5, 0),
+		},
+	}
+	if !isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected bucket without reads or writes in the last three months to be archivable")
+	}
+
+	bucket.AccessDetail.LastAccessAt = now.AddDate(0, 0, -1)
+	if isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected recently accessed bucket not to be archivable")
+	}
+
+	//Logs shorter than the threshold fall back to the last modified date
+	bucket.AccessDetail.WindowStart = now.AddDate(0, 0, -7)
+	bucket.BucketSummary.ModifiedLastAt = now.AddDate(-1, 0, 0)
+	if !isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected short access logs to fall back to the last modified date")
+	}
+}
+
+// Test isArchivable and lifecycleAnalysis with date partitions
+func TestDatePartitions(t *testing.T) {
+	now := time.Now()
+	partitions := &scan.DatePartitionDetail{
+		PartitionedSize: 100,
+		TotalSize:       100,
+		NewestPartition: now.AddDate(-1, 0, 0),
+		ProposedRules: []scan.ProposedRule{
+			{Prefix: "events/dt=2021-", Action: "Transition", StorageClass: "GLACIER"},
+		},
+	}
+	bucket := scan.BucketScans{
+		//Partitions were rewritten yesterday
+		BucketSummary: summary.BucketSummary{Name: "test-bucket", ModifiedLastAt: now.AddDate(0, 0, -1)},
+		Scans: scan.Scans{
+			BucketScan: scan.BucketScan{
+				LifecycleDetail: scan.LifecycleDetail{
+					Rules: []*s3.LifecycleRule{{Status: aws.String("Enabled")}},
+				},
+			},
+			ObjectScans: []scan.ObjectScan{{DataCategory: "date_partitions", Details: partitions}},
+		},
+	}
+	if !isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected bucket whose newest partition is a year old to be archivable")
+	}
+
+	result, err := lifecycleAnalysis(bucket)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(result.ProposedRules) != 1 {
+		t.Errorf("expected the proposed rules of the date_partitions scan, got %v", result.ProposedRules)
+	}
+
+	//Partitions don't stand for the bucket's age when most of its data isn't partitioned
+	partitions.TotalSize = 1000
+	if isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected the last modified date to be used for a mostly unpartitioned bucket")
+	}
+}
+
+// Test isTempStorage and isArchivable with workload labels
+func TestWorkloadHeuristics(t *testing.T) {
+	if !isTempStorage(summary.BucketSummary{Name: "analytics", Workload: "athena_results"}, policy.Default()) {
+		t.Errorf("expected Athena query results to be temporary storage")
+	}
+	if isTempStorage(summary.BucketSummary{Name: "org-cloudtrail-logs", Workload: "cloudtrail"}, policy.Default()) {
+		t.Errorf("expected the workload to replace the name heuristic")
+	}
+	if !isTempStorage(summary.BucketSummary{Name: "tmp-uploads"}, policy.Default()) {
+		t.Errorf("expected the name heuristic for buckets without a workload")
+	}
+
+	now := time.Now()
+	bucket := scan.BucketScans{
+		BucketSummary: summary.BucketSummary{Name: "infra-state", ModifiedLastAt: now.AddDate(-1, 0, 0), Workload: "terraform_state"},
+	}
+	if isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected Terraform state never to be archivable")
+	}
+
+	//A backup name doesn't make a known workload archivable
+	bucket.BucketSummary = summary.BucketSummary{Name: "lb-logs-backup", ModifiedLastAt: now, Workload: "alb_logs"}
+	bucket.Scans.BucketScan.StorageClasses = []string{"STANDARD"}
+	if isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected the workload to replace the name heuristic")
+	}
+	bucket.BucketSummary.Workload = ""
+	if !isArchivable(bucket, policy.Default()) {
+		t.Errorf("expected the name heuristic for buckets without a workload")
+	}
+}
+
+// Test AnalyzeScans and the bucket analyses with a policy
+func TestAnalyzeScansWithPolicy(t *testing.T) {
+	p := policy.Default().Merge(policy.Policy{
+		InactiveMonths:      12,
+		TempStorageKeywords: []string{"scratch"},
+		Analyses:            []string{"Archive Storage Analysis", "Temporary Storage Analysis"},
+	})
+	if err := ValidatePolicy(p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	scans := []scan.BucketScans{
+		{BucketSummary: summary.BucketSummary{Name: "old-data", ModifiedLastAt: time.Now().AddDate(0, -6, 0)}},
+		{BucketSummary: summary.BucketSummary{Name: "team-scratch", ModifiedLastAt: time.Now()}},
+	}
+	results, err := AnalyzeScans(scans, p)
+	if err != nil {
+		t.Fatalf("AnalyzeScans returned an error: %v", err)
+	}
+	if len(results) != 2 || results[0].Name != "Archive Storage Analysis" || results[1].Name != "Temporary Storage Analysis" {
+		t.Fatalf("expected only the analyses the policy enables, got %v", results)
+	}
+	if len(results[0].AnalysisResults) != 0 {
+		t.Errorf("expected a bucket written six months ago not to pass a twelve month threshold")
+	}
+	if len(results[1].AnalysisResults) != 1 || results[1].AnalysisResults[0].GetBucketSummary().Name != "team-scratch" {
+		t.Errorf("expected the policy's temporary storage keywords to be used, got %v", results[1].AnalysisResults)
+	}
+
+	p.Analyses = []string{"Unknown Analysis"}
+	if err := ValidatePolicy(p); err == nil {
+		t.Errorf("expected an unknown analysis to be rejected")
+	}
+}
+
+// Test user-defined rules loaded from a rule file
+func TestRuleAnalyses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	err := os.WriteFile(path, []byte(`
+rules:
+  - name: Large Dev Buckets Without Expiration
+    when: tags.env == "dev" && size > 1TB && !has_expiration
+    savings: storage_cost(size, "STANDARD")
+    recommendation: Add an expiration rule to development buckets.
+  - name: Old Standard Prefixes
+    scope: prefix
+    when: class_size_older_than("STANDARD", 180) > 0.5 * size
+    level: FinOps Suggestion
+    recommendation: Transition old STANDARD data to STANDARD_IA.
+`), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := policy.Default().Merge(policy.Policy{RuleFiles: []string{path}})
+	if err := ValidatePolicy(p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ages := &scan.PrefixAgeDetail{}
+	ages.Prefixes = []scan.PrefixAges{{Prefix: "events/"}, {Prefix: "tmp/"}}
+	ages.Prefixes[0].Add("STANDARD", 800, 365)
+	ages.Prefixes[0].Add("STANDARD", 200, 10)
+	ages.Prefixes[1].Add("STANDARD", 500, 1)
+	scans := []scan.BucketScans{
+		{
+			BucketSummary: summary.BucketSummary{Name: "dev-data", Size: 2 << 40},
+			Scans: scan.Scans{
+				BucketScan:  scan.BucketScan{Tags: map[string]string{"env": "dev"}},
+				ObjectScans: []scan.ObjectScan{{DataCategory: "prefix_ages", Details: ages}},
+			},
+		},
+		{BucketSummary: summary.BucketSummary{Name: "prod-data", Size: 2 << 40}},
+	}
+	results, err := AnalyzeScans(scans, p)
+	if err != nil {
+		t.Fatalf("AnalyzeScans returned an error: %v", err)
+	}
+	if len(results) != 20 || results[18].Name != "Large Dev Buckets Without Expiration" || results[19].Name != "Old Standard Prefixes" {
+		t.Fatalf("expected the rules after the built-in analyses, got %d analyses", len(results))
+	}
+
+	dev := results[18].AnalysisResults
+	if len(dev) != 1 || dev[0].GetBucketSummary().Name != "dev-data" || dev[0].GetEstimates().CalculatedMonthlySavingsMax <= 0 {
+		t.Errorf("expected only dev-data with the savings expression, got %v", dev)
+	}
+	old := results[19].AnalysisResults
+	if len(old) != 1 {
+		t.Fatalf("expected one bucket with old prefixes, got %v", old)
+	}
+	r := old[0].(RuleAnalysisResult)
+	if len(r.Prefixes) != 1 || r.Prefixes[0].Prefix != "events/" || r.Level != "FinOps Suggestion" {
+		t.Errorf("expected only events/ with the rule's level, got %v", r)
+	}
+
+	//A bucket the rule fails for is reported with the error, the other buckets are still evaluated
+	classPath := filepath.Join(t.TempDir(), "rules.yaml")
+	err = os.WriteFile(classPath, []byte(`
+rules:
+  - name: Storage Class Cost
+    when: "true"
+    savings: storage_cost(size, tags.class)
+  - name: Old Buckets
+    when: size_older_than(180) > 0
+`), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p = policy.Default().Merge(policy.Policy{RuleFiles: []string{classPath}})
+	scans[0].Scans.BucketScan.Tags = map[string]string{"class": "STANDARD"}
+	scans[1].Scans.BucketScan.Tags = map[string]string{"class": "UNKNOWN"}
+	results, err = AnalyzeScans(scans, p)
+	if err != nil {
+		t.Fatalf("AnalyzeScans returned an error: %v", err)
+	}
+	classCost := results[len(results)-2].AnalysisResults
+	if len(classCost) != 2 {
+		t.Fatalf("expected both buckets, got %v", classCost)
+	}
+	if r := classCost[0].(RuleAnalysisResult); r.Error != "" || r.EstimatedSavings.CalculatedMonthlySavingsMax <= 0 {
+		t.Errorf("expected dev-data to be evaluated, got %v", r)
+	}
+	if r := classCost[1].(RuleAnalysisResult); r.Error == "" || r.EstimatedSavings.CalculatedMonthlySavingsMax != 0 {
+		t.Errorf("expected prod-data to be reported with its error, got %v", r)
+	}
+
+	//Age functions fail for buckets the prefix_ages scan didn't run for instead of reading 0
+	oldBuckets := results[len(results)-1].AnalysisResults
+	if len(oldBuckets) != 1 || oldBuckets[0].GetBucketSummary().Name != "prod-data" || oldBuckets[0].(RuleAnalysisResult).Error == "" {
+		t.Errorf("expected only prod-data to be reported with an error, got %v", oldBuckets)
+	}
+
+	//Rules can't reuse built-in names or use unknown variables
+	for _, rules := range []string{
+		`{"rules": [{"name": "Archive Storage Analysis", "when": "true"}]}`,
+		`{"rules": [{"name": "Bad", "when": "unknown > 1"}]}`,
+		`{"rules": [{"name": "Bad", "scope": "object", "when": "true"}]}`,
+	} {
+		badPath := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(badPath, []byte(rules), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := LoadRules([]string{badPath}); err == nil {
+			t.Errorf("expected %s to be rejected", rules)
+		}
+	}
+}
@@ -6,14 +6,20 @@ import (
 	"strings"
 	"time"
 
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
 	"github.com/helloevanhere/simple_saver_service/pkg/summary"
+	"github.com/helloevanhere/simple_saver_service/pkg/workload"
 )
 
 type ArchivableAnalysisResult struct {
 	BucketSummary    summary.BucketSummary     `json:"bucket_summary"`
 	StorageClasses   []string                  `json:"storage_classes"`
+	LastAccessAt     *time.Time                `json:"last_access_at,omitempty"`   //from access logs, when they cover the inactive threshold
+	NewestPartition  *time.Time                `json:"newest_partition,omitempty"` //from date partitions in keys, when they cover the bucket
 	EstimatedSavings estimate.EstimatedSavings `json:"estimated_savings"`
 }
 
@@ -27,16 +33,23 @@ func (r ArchivableAnalysisResult) GetEstimates() estimate.EstimatedSavings {
 	return r.EstimatedSavings
 }
 
-// TO DO: Make an object scan because storage class is by object
 // Checks if a bucket contains archive/less frequently access data and
 // can be moved to better suited storage class
-func archiveAnalysis(scan scan.BucketScans) (ArchivableAnalysisResult, error) {
+// Savings are left to the Archive Tiering Analysis, which prices each object against its own storage class
+func archiveAnalysis(scan scan.BucketScans, p policy.Policy) (ArchivableAnalysisResult, error) {
 	analysisResult := ArchivableAnalysisResult{}
-	if isArchivable(scan) {
+	if isArchivable(scan, p) {
 		analysisResult := ArchivableAnalysisResult{
 			BucketSummary:  scan.BucketSummary,
 			StorageClasses: scan.Scans.BucketScan.StorageClasses,
 		}
+		if hasAccessHistory(scan.AccessDetail, p) {
+			lastAccessAt := scan.AccessDetail.LastAccessAt
+			analysisResult.LastAccessAt = &lastAccessAt
+		} else if partitions := datePartitionDetail(scan); partitions != nil && partitions.CoversBucket() {
+			newestPartition := partitions.NewestPartition
+			analysisResult.NewestPartition = &newestPartition
+		}
 		return analysisResult, nil
 	}
 
@@ -45,19 +58,36 @@ func archiveAnalysis(scan scan.BucketScans) (ArchivableAnalysisResult, error) {
 }
 
 // HELPER for archiveAnalysis()
-func isArchivable(scan scan.BucketScans) bool {
-	if isPastInactiveThreshold(scan.BucketSummary.ModifiedLastAt) {
+func isArchivable(scan scan.BucketScans, p policy.Policy) bool {
+	//Some workloads are read no matter how old they are, ex. Terraform state
+	fingerprint, knownWorkload := workload.Lookup(scan.BucketSummary.Workload)
+	if knownWorkload && fingerprint.NeverArchive {
+		return false
+	}
+
+	//Real access recency replaces the last modified date when the logs are long enough to tell
+	if hasAccessHistory(scan.AccessDetail, p) {
+		return !scan.AccessDetail.LastAccessAt.After(inactiveSince(p))
+	}
+
+	//Partition dates replace the last modified date, which resets when partitions are rewritten or copied
+	if partitions := datePartitionDetail(scan); partitions != nil && partitions.CoversBucket() {
+		return partitions.NewestPartition.Before(inactiveSince(p))
+	}
+
+	if isPastInactiveThreshold(scan.BucketSummary.ModifiedLastAt, p) {
 		return true
 	}
 
-	archive_indicators := []string{"backup", "back-up", "archive"}
+	//The name only hints at backups when the data's producer is unknown
+	if knownWorkload {
+		return false
+	}
 
-	for _, key := range archive_indicators {
-		if strings.Contains(scan.BucketSummary.Name, key) {
-			for _, class := range scan.Scans.BucketScan.StorageClasses {
-				if !strings.Contains(strings.ToLower(class), "glacier") {
-					return true
-				}
+	if policy.ContainsKeyword(scan.BucketSummary.Name, p.ArchiveKeywords) {
+		for _, class := range scan.Scans.BucketScan.StorageClasses {
+			if !strings.Contains(strings.ToLower(class), "glacier") {
+				return true
 			}
 		}
 	}
@@ -65,15 +95,34 @@ func isArchivable(scan scan.BucketScans) bool {
 	return false
 }
 
+// HELPER for archiveAnalysis() and lifecycleAnalysis()
+// Returns the details of the date_partitions scan, nil if it didn't run or found no date partitions
+func datePartitionDetail(bucketScans scan.BucketScans) *scan.DatePartitionDetail {
+	if partitionScan, ok := findObjectScan(bucketScans, "date_partitions"); ok {
+		detail, _ := partitionScan.Details.(*scan.DatePartitionDetail)
+		return detail
+	}
+	return nil
+}
+
+// HELPER for archiveAnalysis()
+// Checks if access logs cover at least the inactive threshold
+func hasAccessHistory(detail *access.AccessDetail, p policy.Policy) bool {
+	return detail != nil && detail.Covers(time.Since(inactiveSince(p)))
+}
+
+// HELPER for isArchivable() and hasAccessHistory()
+// Returns the start of the policy's inactive threshold
+func inactiveSince(p policy.Policy) time.Time {
+	return time.Now().AddDate(0, -p.InactiveMonths, 0)
+}
+
 // HELPER for archiveAnalysis()
-func isPastInactiveThreshold(modifiedLastAt time.Time) bool {
+func isPastInactiveThreshold(modifiedLastAt time.Time, p policy.Policy) bool {
 	tm, _ := time.Parse("YYYY-MM-DDThh:mm:ssZ", "0001-01-01T00:00:00Z")
 
 	if modifiedLastAt != tm {
-		currentTime := time.Now()
-		threeMonthsAgo := currentTime.AddDate(0, -3, 0)
-
-		return modifiedLastAt.Before(threeMonthsAgo)
+		return modifiedLastAt.Before(inactiveSince(p))
 	}
 	return false
 
@@ -82,6 +131,7 @@ func isPastInactiveThreshold(modifiedLastAt time.Time) bool {
 type VersioningAnalysisResult struct {
 	BucketSummary    summary.BucketSummary     `json:"bucket_summary"`
 	VersioningStatus string                    `json:"versioning_status"`
+	VersionDetail    *scan.VersionDetail       `json:"version_detail,omitempty"`
 	EstimatedSavings estimate.EstimatedSavings `json:"estimated_savings"`
 }
 
@@ -96,27 +146,53 @@ func (r VersioningAnalysisResult) GetEstimates() estimate.EstimatedSavings {
 }
 
 // Takes in BucketScans and returns VersioningAnalysisResult
-// for buckets that do not have versioning
+// for buckets that keep noncurrent versions without lifecycle policies managing them
+// Savings come from the object_versions scan when it ran
 func versioningAnalysis(scan scan.BucketScans) (VersioningAnalysisResult, error) {
 	analysisResult := VersioningAnalysisResult{}
+	status := scan.Scans.BucketScan.VersioningStatus
 
-	//Checks if versioning is enabled with no lifecycle policies managing versions
-	if scan.Scans.BucketScan.VersioningStatus == "Enabled" {
-		if scan.Scans.BucketScan.LifecycleDetail.Rules == nil || len(scan.Scans.BucketScan.LifecycleDetail.Rules) == 0 || (scan.Scans.BucketScan.LifecycleDetail.Rules[0].NoncurrentVersionTransitions == nil && scan.Scans.BucketScan.LifecycleDetail.Rules[0].NoncurrentVersionExpiration == nil) {
-			analysisResult = VersioningAnalysisResult{
-				BucketSummary:    scan.BucketSummary,
-				VersioningStatus: scan.Scans.BucketScan.VersioningStatus,
-			}
-			return analysisResult, nil
+	if lifecycle.HasNoncurrentVersionRule(scan.Scans.BucketScan.LifecycleDetail.Rules) {
+		return analysisResult, nil
+	}
+
+	versionScan, scanned := findObjectScan(scan, "object_versions")
+
+	//Checks if versioning is enabled, or was enabled and left noncurrent versions behind
+	if status == "Enabled" || (status == "Suspended" && scanned && versionScan.ObjectCount > 0) {
+		analysisResult = VersioningAnalysisResult{
+			BucketSummary:    scan.BucketSummary,
+			VersioningStatus: status,
+			VersionDetail:    versionDetail(versionScan),
+			EstimatedSavings: versionScan.EstimatedSavings,
 		}
+		return analysisResult, nil
 	}
 
 	return analysisResult, nil
 }
 
+// HELPER for versioningAnalysis()
+func versionDetail(objectScan scan.ObjectScan) *scan.VersionDetail {
+	detail, _ := objectScan.Details.(*scan.VersionDetail)
+	return detail
+}
+
+// HELPER for lifecycleAnalysis()
+func objectLockDetail(bucketScan scan.BucketScan) *scan.ObjectLockDetail {
+	if !bucketScan.ObjectLockDetail.Enabled {
+		return nil
+	}
+	detail := bucketScan.ObjectLockDetail
+	return &detail
+}
+
 type LifecycleAnalysisResult struct {
 	BucketSummary    summary.BucketSummary     `json:"bucket_summary"`
 	LifecycleDetail  scan.LifecycleDetail      `json:"lifecycle_detail"`
+	Coverage         *lifecycle.Coverage       `json:"coverage,omitempty"`
+	ObjectLock       *scan.ObjectLockDetail    `json:"object_lock,omitempty"`    //expiration can't delete objects before their retention ends
+	ProposedRules    []scan.ProposedRule       `json:"proposed_rules,omitempty"` //date-prefix-aware rules for data partitioned by date
 	EstimatedSavings estimate.EstimatedSavings `json:"estimated_savings"`
 }
 
@@ -131,21 +207,34 @@ func (r LifecycleAnalysisResult) GetEstimates() estimate.EstimatedSavings {
 }
 
 // Takes in BucketScans and returns LifecycleAnalysisResult
-// for buckets that do not have lifecycle policies
+// for buckets that do not have enabled lifecycle policies,
+// or whose policies don't transition or expire any of the bucket's data,
+// or with date partitioned data their policies don't move by partition date
 func lifecycleAnalysis(scan scan.BucketScans) (LifecycleAnalysisResult, error) {
 	analysisResult := LifecycleAnalysisResult{}
+	rules := scan.Scans.BucketScan.LifecycleDetail.Rules
 
-	if scan.Scans.BucketScan.LifecycleDetail.Rules == nil {
-		analysisResult := LifecycleAnalysisResult{
-			BucketSummary:   scan.BucketSummary,
-			LifecycleDetail: scan.Scans.BucketScan.LifecycleDetail,
-		}
-		return analysisResult, nil
+	//Coverage is only available when the lifecycle_coverage scan ran
+	var coverage *lifecycle.Coverage
+	if coverageScan, ok := findObjectScan(scan, "lifecycle_coverage"); ok {
+		coverage, _ = coverageScan.Details.(*lifecycle.Coverage)
+	}
+
+	//Proposed rules are only available when the date_partitions scan ran
+	partitions := datePartitionDetail(scan)
+	hasProposedRules := partitions != nil && len(partitions.ProposedRules) > 0
 
-	} else if *scan.Scans.BucketScan.LifecycleDetail.Rules[0].Status == "Suspended" {
+	if !lifecycle.HasEnabledRule(rules) || (coverage != nil && coverage.DataSize > 0 && !coverage.CoversCurrentData()) || hasProposedRules {
 		analysisResult := LifecycleAnalysisResult{
 			BucketSummary:   scan.BucketSummary,
 			LifecycleDetail: scan.Scans.BucketScan.LifecycleDetail,
+			Coverage:        coverage,
+			ObjectLock:      objectLockDetail(scan.Scans.BucketScan),
+		}
+		if hasProposedRules {
+			analysisResult.ProposedRules = partitions.ProposedRules
+			partitionScan, _ := findObjectScan(scan, "date_partitions")
+			analysisResult.EstimatedSavings = partitionScan.EstimatedSavings
 		}
 		return analysisResult, nil
 	}
@@ -156,11 +245,11 @@ func lifecycleAnalysis(scan scan.BucketScans) (LifecycleAnalysisResult, error) {
 // Takes in []AnalysisResult and returns []AnalysisResult
 // Takes in the output of lifecycleAnalysis() because
 // we want a list of temp storage that does not have lifecycle policies.
-func temporaryStorageAnalysis(analysisResultInput []AnalysisResult) ([]AnalysisResult, error) {
+func temporaryStorageAnalysis(analysisResultInput []AnalysisResult, p policy.Policy) ([]AnalysisResult, error) {
 	analysisResultOutput := []AnalysisResult{}
 
 	for _, result := range analysisResultInput {
-		if isTempStorage(result.GetBucketSummary().Name) {
+		if isTempStorage(result.GetBucketSummary(), p) {
 			analysisResultOutput = append(analysisResultOutput, result)
 			return analysisResultOutput, nil
 		}
@@ -169,15 +258,12 @@ func temporaryStorageAnalysis(analysisResultInput []AnalysisResult) ([]AnalysisR
 }
 
 // HELPER for temporaryStorageAnalysis()
-// Checks is bucket name contains words often used to label temporary storage
-func isTempStorage(bucketName string) bool {
-	filenames := []string{"temp", "log", "tmp", "test"}
-
-	for _, substr := range filenames {
-		if strings.Contains(strings.ToLower(bucketName), substr) {
-			return true
-		}
+// Checks if the bucket's workload only needs its data for a short while,
+// or if the bucket name contains one of the policy's temporary storage keywords when the workload is unknown
+func isTempStorage(bucket summary.BucketSummary, p policy.Policy) bool {
+	if fingerprint, ok := workload.Lookup(bucket.Workload); ok {
+		return fingerprint.Temporary
 	}
 
-	return false
+	return policy.ContainsKeyword(bucket.Name, p.TempStorageKeywords)
 }
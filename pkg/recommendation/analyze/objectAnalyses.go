@@ -1,7 +1,11 @@
 package analyze
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
 	"github.com/helloevanhere/simple_saver_service/pkg/summary"
 )
@@ -21,40 +25,174 @@ func (r ObjectAnalysisResult) GetEstimates() estimate.EstimatedSavings {
 	return r.Data.EstimatedSavings
 }
 
+// ObjectAnalysisCheck reports whether an ObjectScan should be part of an Analysis
+type ObjectAnalysisCheck func(bucketScan scan.BucketScan, objectScan scan.ObjectScan) bool
+
+// ObjectAnalysisRegistration ties the ObjectScans of one data category to an Analysis
+type ObjectAnalysisRegistration struct {
+	DataCategory string //name of the scanner that produces the ObjectScan
+	Name         string
+	Description  string
+	Check        ObjectAnalysisCheck
+}
+
+var (
+	objectAnalysesMu sync.RWMutex
+	objectAnalyses   []ObjectAnalysisRegistration
+)
+
+func init() {
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "compressible_objects",
+		Name:         "Compressed Data Analysis",
+		Description:  "Analyzes if there are objects that can be compressed in your buckets",
+		Check:        hasObjects,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "duplicate_objects",
+		Name:         "Duplicate Data Analysis",
+		Description:  "Analyzes if there are potentially duplicate objects in your buckets",
+		Check:        hasObjects,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "incomplete_multipart_upload",
+		Name:         "Incomplete Data Analysis",
+		Description:  "Analyzes if there are Incomplete Multipart Uploads in your buckets and if you have the proper policies to manage them",
+		Check:        hasUnmanagedIncompleteUploads,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "cross_bucket_duplicates",
+		Name:         "Cross-Bucket Duplicate Data Analysis",
+		Description:  "Analyzes if the same objects are stored in more than one of your buckets",
+		Check:        hasObjects,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "intelligent_tiering",
+		Name:         "Intelligent-Tiering Analysis",
+		Description:  "Analyzes if objects without a lifecycle transition would cost less in Intelligent-Tiering after its monitoring fee",
+		Check:        hasSavings,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "replication",
+		Name:         "Replication Analysis",
+		Description:  "Analyzes the storage and transfer cost of the data your buckets replicate and replication that costs more than its purpose needs",
+		Check:        hasObjects,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "kms_requests",
+		Name:         "KMS Request Analysis",
+		Description:  "Analyzes the KMS request cost of objects encrypted with SSE-KMS without S3 Bucket Keys",
+		Check:        hasSavings,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "bucket_config_audit",
+		Name:         "Bucket Configuration Audit",
+		Description:  "Lists the paid bucket features you have enabled, their approximate monthly cost, and whether they are being used",
+		Check:        hasDetails,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "restored_objects",
+		Name:         "Restored Archive Copy Analysis",
+		Description:  "Analyzes the cost of the temporary STANDARD copies kept for objects restored from GLACIER and DEEP_ARCHIVE",
+		Check:        hasSavings,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "small_archive_objects",
+		Name:         "Small Archive Object Analysis",
+		Description:  "Analyzes archived objects so small that the per-object metadata overhead outweighs the archive discount",
+		Check:        hasSavings,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "abandoned_bucket",
+		Name:         "Abandoned Bucket Analysis",
+		Description:  "Analyzes if you have buckets that are empty, no longer written to or read, or owned by a decommissioned owner, and what leaving them costs",
+		Check:        hasDetails,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "small_files",
+		Name:         "Small File Consolidation Analysis",
+		Description:  "Analyzes prefixes dominated by small objects and what they cost to transition as-is compared to after compaction into larger objects",
+		Check:        hasSavings,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "archive_tiering",
+		Name:         "Archive Tiering Analysis",
+		Description:  "Analyzes which objects are old enough to cost less in STANDARD_IA, GLACIER_IR, GLACIER or DEEP_ARCHIVE after transition fees",
+		Check:        hasSavings,
+	})
+	RegisterObjectAnalysis(ObjectAnalysisRegistration{
+		DataCategory: "early_deletion",
+		Name:         "Early Deletion Analysis",
+		Description:  "Analyzes if your lifecycle rules expire or transition objects before the minimum storage duration of their storage class, which is billed anyway",
+		Check:        hasSavings,
+	})
+}
+
+// Registers an Analysis for the ObjectScans of a data category
+// Intended to be called from an init() function, panics on a duplicate data category
+func RegisterObjectAnalysis(registration ObjectAnalysisRegistration) {
+	objectAnalysesMu.Lock()
+	defer objectAnalysesMu.Unlock()
+
+	if registration.DataCategory == "" || registration.Name == "" || registration.Check == nil {
+		panic("analyze: RegisterObjectAnalysis requires a data category, name and check")
+	}
+	for _, r := range objectAnalyses {
+		if r.DataCategory == registration.DataCategory {
+			panic(fmt.Sprintf("analyze: data category %q registered twice", registration.DataCategory))
+		}
+	}
+	objectAnalyses = append(objectAnalyses, registration)
+}
+
+// Returns every registered object Analysis in registration order
+func RegisteredObjectAnalyses() []ObjectAnalysisRegistration {
+	objectAnalysesMu.RLock()
+	defer objectAnalysesMu.RUnlock()
+
+	registrations := make([]ObjectAnalysisRegistration, len(objectAnalyses))
+	copy(registrations, objectAnalyses)
+	return registrations
+}
+
 // Takes in BucketSummary, BucketScan and ObjectScan and returns ObjectAnalysisResult
+// The result is empty if the data category has no registered Analysis or its check fails
 func ObjectAnalysis(bucketSummary summary.BucketSummary, bucketScan scan.BucketScan, objectScan scan.ObjectScan) (ObjectAnalysisResult, error) {
 	analysisResult := ObjectAnalysisResult{}
 
-	switch objectScan.DataCategory {
-	case "incomplete_multipart_upload":
-		//no null dereference
-		if objectScan.ObjectCount > 0 && (bucketScan.LifecycleDetail.Rules == nil || len(bucketScan.LifecycleDetail.Rules) == 0 || bucketScan.LifecycleDetail.Rules[0].AbortIncompleteMultipartUpload == nil) {
-			analysisResult := ObjectAnalysisResult{
-				BucketSummary: bucketSummary,
-				Data:          objectScan,
-			}
-			return analysisResult, nil
-		}
-
-	case "duplicate_objects":
-		//don't return empty result
-		if objectScan.ObjectCount > 0 {
-			analysisResult := ObjectAnalysisResult{
-				BucketSummary: bucketSummary,
-				Data:          objectScan,
-			}
-			return analysisResult, nil
-		}
-	case "compressible_objects":
-		//don't return empty result
-		if objectScan.ObjectCount > 0 {
+	for _, r := range RegisteredObjectAnalyses() {
+		if r.DataCategory == objectScan.DataCategory && r.Check(bucketScan, objectScan) {
 			analysisResult := ObjectAnalysisResult{
 				BucketSummary: bucketSummary,
 				Data:          objectScan,
 			}
 			return analysisResult, nil
 		}
-
 	}
+
 	return analysisResult, nil
 }
+
+// HELPER for ObjectAnalysis()
+// don't return empty result
+func hasObjects(bucketScan scan.BucketScan, objectScan scan.ObjectScan) bool {
+	return objectScan.ObjectCount > 0
+}
+
+// HELPER for ObjectAnalysis()
+// Checks for incomplete uploads with no lifecycle rule aborting them
+func hasUnmanagedIncompleteUploads(bucketScan scan.BucketScan, objectScan scan.ObjectScan) bool {
+	return objectScan.ObjectCount > 0 && !lifecycle.HasAbortIncompleteMultipartUpload(bucketScan.LifecycleDetail.Rules)
+}
+
+// HELPER for ObjectAnalysis()
+// Checks for objects whose estimated savings outweigh the cost of acting on them
+func hasSavings(bucketScan scan.BucketScan, objectScan scan.ObjectScan) bool {
+	return objectScan.ObjectCount > 0 && objectScan.EstimatedSavings.CalculatedMonthlySavingsMax > 0
+}
+
+// HELPER for ObjectAnalysis()
+// For scans that report findings in their Details instead of objects
+func hasDetails(bucketScan scan.BucketScan, objectScan scan.ObjectScan) bool {
+	return objectScan.Details != nil
+}
@@ -0,0 +1,374 @@
+package analyze
+
+//This file handles user-defined Analyses, rules written in the expression language of the expr package
+//Rules are loaded from the files listed in the policy and evaluated over each bucket, or each prefix of the prefix_ages scan
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/expr"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
+	"github.com/helloevanhere/simple_saver_service/pkg/summary"
+)
+
+// Scopes a Rule is evaluated in
+const (
+	RuleScopeBucket = "bucket" //once per bucket
+	RuleScopePrefix = "prefix" //once per prefix of the prefix_ages scan
+)
+
+// Level of the recommendation of a Rule that doesn't set one
+const defaultRuleLevel = "Custom Rule Suggestion"
+
+// RuleFile is the content of a rule file
+//
+//	rules:
+//	  - name: Large Dev Buckets Without Expiration
+//	    when: tags.env == "dev" && size > 1TB && !has_expiration
+//	    savings: storage_cost(size, "STANDARD")
+//	    recommendation: Add an expiration rule to development buckets.
+type RuleFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Rule is a user-defined Analysis
+type Rule struct {
+	Name           string `json:"name" yaml:"name"`
+	Description    string `json:"description" yaml:"description"`
+	Scope          string `json:"scope" yaml:"scope"`     //bucket (default) or prefix
+	When           string `json:"when" yaml:"when"`       //bool expression, buckets or prefixes it is true for are reported
+	Savings        string `json:"savings" yaml:"savings"` //optional number expression, the monthly savings of a match
+	Level          string `json:"level" yaml:"level"`     //recommendation level, defaults to "Custom Rule Suggestion"
+	Recommendation string `json:"recommendation" yaml:"recommendation"`
+
+	when    *expr.Expr
+	savings *expr.Expr
+}
+
+// RuleAnalysisResult is a bucket a Rule is true for
+type RuleAnalysisResult struct {
+	BucketSummary    summary.BucketSummary     `json:"bucket_summary"`
+	Prefixes         []RulePrefix              `json:"prefixes,omitempty"` //the prefixes the rule is true for, when it has the prefix scope
+	Level            string                    `json:"recommendation_level"`
+	Recommendation   string                    `json:"recommendation"`
+	EstimatedSavings estimate.EstimatedSavings `json:"estimated_savings"`
+	Error            string                    `json:"error,omitempty"` //why the rule couldn't be evaluated for the bucket, it has no savings then
+}
+
+// Statisfies AnalysisResult interface
+func (r RuleAnalysisResult) GetBucketSummary() summary.BucketSummary {
+	return r.BucketSummary
+}
+
+// Statisfies AnalysisResult interface
+func (r RuleAnalysisResult) GetEstimates() estimate.EstimatedSavings {
+	return r.EstimatedSavings
+}
+
+// RulePrefix is a prefix a Rule is true for
+type RulePrefix struct {
+	Prefix         string  `json:"prefix"`
+	ObjectCount    int64   `json:"object_count"`
+	DataSize       int64   `json:"data_size"`
+	MonthlySavings float64 `json:"monthly_savings"`
+}
+
+// Reads the rules of every file and compiles their expressions
+// Rule names have to be unique and can't be the name of a built-in Analysis
+func LoadRules(paths []string) ([]Rule, error) {
+	rules := []Rule{}
+	names := builtInAnalysisNames()
+	for _, path := range paths {
+		file := RuleFile{}
+		if err := policy.ReadFile(path, &file); err != nil {
+			return nil, fmt.Errorf("error loading rules: %v", err)
+		}
+		for _, rule := range file.Rules {
+			if err := rule.compile(); err != nil {
+				return nil, fmt.Errorf("error loading rules from %s: %v", path, err)
+			}
+			if names[rule.Name] {
+				return nil, fmt.Errorf("error loading rules from %s: analysis %q already exists", path, rule.Name)
+			}
+			names[rule.Name] = true
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// HELPER for LoadRules()
+// Checks the rule and compiles its expressions against the variables and functions of its scope
+func (r *Rule) compile() error {
+	if r.Name == "" || r.When == "" {
+		return fmt.Errorf("rules need a name and a when expression")
+	}
+	if r.Scope == "" {
+		r.Scope = RuleScopeBucket
+	}
+	if r.Scope != RuleScopeBucket && r.Scope != RuleScopePrefix {
+		return fmt.Errorf("rule %q: scope has to be %s or %s", r.Name, RuleScopeBucket, RuleScopePrefix)
+	}
+	if r.Level == "" {
+		r.Level = defaultRuleLevel
+	}
+
+	schema := ruleEnv(scan.BucketScans{}, &scan.PrefixAges{}, r.Scope == RuleScopePrefix)
+	var err error
+	if r.when, err = expr.Compile(r.When, schema); err != nil {
+		return fmt.Errorf("rule %q: %v", r.Name, err)
+	}
+	if r.Savings != "" {
+		if r.savings, err = expr.Compile(r.Savings, schema); err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// Takes in the BucketScans and a Rule and returns the Rule's Analysis
+// Buckets the rule fails to evaluate for are reported with the error instead of failing every other bucket
+func ruleAnalysis(scans []scan.BucketScans, rule Rule) Analysis {
+	analysis := Analysis{Name: rule.Name, Description: rule.Description}
+	if analysis.Description == "" {
+		analysis.Description = fmt.Sprintf("Checks %s", rule.When)
+	}
+
+	for _, bucketScans := range scans {
+		result, matches, err := rule.bucketResult(bucketScans)
+		if err != nil {
+			result = RuleAnalysisResult{Level: rule.Level, Recommendation: rule.Recommendation, Error: err.Error()}
+		} else if !matches {
+			continue
+		}
+		result.BucketSummary = bucketScans.BucketSummary
+		analysis.AppendAnalysisResult(result)
+	}
+	return analysis
+}
+
+// HELPER for ruleAnalysis()
+// Evaluates the rule for one bucket, or each of its prefixes, and reports if it is true for any
+func (r Rule) bucketResult(bucketScans scan.BucketScans) (RuleAnalysisResult, bool, error) {
+	result := RuleAnalysisResult{Level: r.Level, Recommendation: r.Recommendation}
+	if r.Scope == RuleScopeBucket {
+		//Without the prefix_ages scan the storage class and age functions fail instead of reading 0
+		var bucketAges *scan.PrefixAges
+		if ages, ok := prefixAgeDetail(bucketScans); ok {
+			bucketAges = &ages.Bucket
+		}
+		matches, savings, err := r.evaluate(ruleEnv(bucketScans, bucketAges, false))
+		if err != nil || !matches {
+			return result, false, err
+		}
+		result.EstimatedSavings = estimate.EstimatedSavings{CalculatedMonthlylSavingsMin: savings, CalculatedMonthlySavingsMax: savings}
+		return result, true, nil
+	}
+
+	ages, ok := prefixAgeDetail(bucketScans)
+	if !ok {
+		return result, false, nil
+	}
+	for i, prefix := range ages.Prefixes {
+		matches, savings, err := r.evaluate(ruleEnv(bucketScans, &ages.Prefixes[i], true))
+		if err != nil {
+			return result, false, fmt.Errorf("prefix %q: %v", prefix.Prefix, err)
+		}
+		if !matches {
+			continue
+		}
+		result.Prefixes = append(result.Prefixes, RulePrefix{
+			Prefix:         prefix.Prefix,
+			ObjectCount:    prefix.ObjectCount,
+			DataSize:       prefix.DataSize,
+			MonthlySavings: savings,
+		})
+		result.EstimatedSavings.CalculatedMonthlylSavingsMin += savings
+		result.EstimatedSavings.CalculatedMonthlySavingsMax += savings
+	}
+	return result, len(result.Prefixes) > 0, nil
+}
+
+// HELPER for bucketResult()
+// Returns whether the rule is true in env and the savings of the match
+func (r Rule) evaluate(env expr.Env) (bool, float64, error) {
+	matches, err := r.when.EvalBool(env)
+	if err != nil || !matches || r.savings == nil {
+		return matches, 0, err
+	}
+	savings, err := r.savings.EvalNumber(env)
+	return true, savings, err
+}
+
+// HELPER for bucketResult()
+// Returns the details of the prefix_ages scan, ok is false if it didn't run
+func prefixAgeDetail(bucketScans scan.BucketScans) (detail scan.PrefixAgeDetail, ok bool) {
+	if ageScan, found := findObjectScan(bucketScans, "prefix_ages"); found {
+		if d, isAges := ageScan.Details.(*scan.PrefixAgeDetail); isAges {
+			return *d, true
+		}
+	}
+	return scan.PrefixAgeDetail{}, false
+}
+
+// HELPER for bucketResult() and compile()
+// Returns the variables and functions rules are evaluated with
+// object_count, size and the storage class functions describe the prefix in the prefix scope and the bucket otherwise
+// ages is nil when the prefix_ages scan didn't run, the storage class functions return an error then
+func ruleEnv(bucketScans scan.BucketScans, ages *scan.PrefixAges, prefixScope bool) expr.Env {
+	bucket := bucketScans.BucketSummary
+	bucketScan := bucketScans.Scans.BucketScan
+
+	tags := bucketScan.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	daysSinceModified := 0.0
+	if !bucket.ModifiedLastAt.IsZero() {
+		daysSinceModified = float64(int(time.Since(bucket.ModifiedLastAt).Hours() / 24))
+	}
+
+	env := expr.Env{
+		Vars: map[string]expr.Value{
+			"name":                bucket.Name,
+			"workload":            bucket.Workload,
+			"object_count":        float64(bucket.ObjectCount),
+			"size":                float64(bucket.Size),
+			"days_since_modified": daysSinceModified,
+			"versioning":          bucketScan.VersioningStatus,
+			"tags":                tags,
+			"has_lifecycle":       lifecycle.HasEnabledRule(bucketScan.LifecycleDetail.Rules),
+			"has_expiration":      lifecycle.HasExpirationRule(bucketScan.LifecycleDetail.Rules),
+		},
+		Funcs: map[string]expr.Func{
+			"class_size": withAges(ages, func(args []expr.Value) (expr.Value, error) {
+				class, err := stringArg(args, 0, 1)
+				return float64(ages.ClassSize(class)), err
+			}),
+			"class_objects": withAges(ages, func(args []expr.Value) (expr.Value, error) {
+				class, err := stringArg(args, 0, 1)
+				return float64(ages.ClassObjects(class)), err
+			}),
+			"size_older_than": withAges(ages, func(args []expr.Value) (expr.Value, error) {
+				days, err := numberArg(args, 0, 1)
+				return float64(ages.SizeOlderThan("", int(days))), err
+			}),
+			"class_size_older_than": withAges(ages, func(args []expr.Value) (expr.Value, error) {
+				class, err := stringArg(args, 0, 2)
+				if err != nil {
+					return nil, err
+				}
+				days, err := numberArg(args, 1, 2)
+				return float64(ages.SizeOlderThan(class, int(days))), err
+			}),
+			"scan_objects": func(args []expr.Value) (expr.Value, error) {
+				objectScan, err := objectScanArg(bucketScans, args)
+				return float64(objectScan.ObjectCount), err
+			},
+			"scan_size": func(args []expr.Value) (expr.Value, error) {
+				objectScan, err := objectScanArg(bucketScans, args)
+				return float64(objectScan.DataSize), err
+			},
+			"scan_savings": func(args []expr.Value) (expr.Value, error) {
+				objectScan, err := objectScanArg(bucketScans, args)
+				return objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, err
+			},
+			"storage_cost": func(args []expr.Value) (expr.Value, error) {
+				size, err := numberArg(args, 0, 2)
+				if err != nil {
+					return nil, err
+				}
+				class, err := stringArg(args, 1, 2)
+				if err != nil {
+					return nil, err
+				}
+				return estimate.CurrentStorageCost(int64(size), class)
+			},
+			"contains": func(args []expr.Value) (expr.Value, error) {
+				s, substr, err := stringArgs(args)
+				return strings.Contains(s, substr), err
+			},
+			"starts_with": func(args []expr.Value) (expr.Value, error) {
+				s, prefix, err := stringArgs(args)
+				return strings.HasPrefix(s, prefix), err
+			},
+			"lower": func(args []expr.Value) (expr.Value, error) {
+				s, err := stringArg(args, 0, 1)
+				return strings.ToLower(s), err
+			},
+		},
+	}
+
+	if prefixScope {
+		env.Vars["prefix"] = ages.Prefix
+		env.Vars["object_count"] = float64(ages.ObjectCount)
+		env.Vars["size"] = float64(ages.DataSize)
+		env.Vars["bucket_object_count"] = float64(bucket.ObjectCount)
+		env.Vars["bucket_size"] = float64(bucket.Size)
+	}
+	return env
+}
+
+// HELPER for ruleEnv()
+// Wraps a function that reads the prefix_ages scan so it fails for buckets the scan didn't run for
+func withAges(ages *scan.PrefixAges, f expr.Func) expr.Func {
+	return func(args []expr.Value) (expr.Value, error) {
+		if ages == nil {
+			return nil, fmt.Errorf("the prefix_ages scan didn't run for the bucket, add it to the request's scans")
+		}
+		return f(args)
+	}
+}
+
+// HELPER for ruleEnv()
+// Returns argument i of n as a string
+func stringArg(args []expr.Value, i int, n int) (string, error) {
+	if len(args) != n {
+		return "", fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	s, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("argument %d has to be a string", i+1)
+	}
+	return s, nil
+}
+
+// HELPER for ruleEnv()
+// Returns argument i of n as a number
+func numberArg(args []expr.Value, i int, n int) (float64, error) {
+	if len(args) != n {
+		return 0, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	f, ok := args[i].(float64)
+	if !ok {
+		return 0, fmt.Errorf("argument %d has to be a number", i+1)
+	}
+	return f, nil
+}
+
+// HELPER for ruleEnv()
+// Returns both arguments of a two string function
+func stringArgs(args []expr.Value) (string, string, error) {
+	first, err := stringArg(args, 0, 2)
+	if err != nil {
+		return "", "", err
+	}
+	second, err := stringArg(args, 1, 2)
+	return first, second, err
+}
+
+// HELPER for ruleEnv()
+// Returns the ObjectScan named by the only argument, the zero ObjectScan if that scan didn't run
+func objectScanArg(bucketScans scan.BucketScans, args []expr.Value) (scan.ObjectScan, error) {
+	category, err := stringArg(args, 0, 1)
+	if err != nil {
+		return scan.ObjectScan{}, err
+	}
+	objectScan, _ := findObjectScan(bucketScans, category)
+	return objectScan, nil
+}
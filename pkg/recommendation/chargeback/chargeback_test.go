@@ -0,0 +1,200 @@
+package chargeback
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/analyze"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
+	"github.com/helloevanhere/simple_saver_service/pkg/summary"
+	"github.com/stretchr/testify/assert"
+)
+
+func testObject(key string, size int64, storageClass string) *s3.Object {
+	return &s3.Object{
+		Key:          aws.String(key),
+		Size:         aws.Int64(size),
+		StorageClass: aws.String(storageClass),
+	}
+}
+
+// Returns a tagFetcher reading from a map of keys to tags
+func testTags(tags map[string]map[string]string) tagFetcher {
+	return func(key string) map[string]string {
+		return tags[key]
+	}
+}
+
+// Test LoadOwnerMapping and ownerOf
+func TestOwnerMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owners.json")
+	err := os.WriteFile(path, []byte(`{"owners": [
+		{"bucket": "data-lake", "owner": "platform"},
+		{"bucket": "data-lake", "prefix": "marketing/", "owner": "marketing"},
+		{"bucket": "other", "prefix": "marketing/", "owner": "finance"}
+	]}`), 0644)
+	assert.NoError(t, err)
+
+	mapping, err := LoadOwnerMapping(path)
+	assert.NoError(t, err)
+
+	owners := mapping.forBucket("data-lake")
+	assert.Len(t, owners, 2)
+	assert.Equal(t, "marketing", ownerOf(owners, "marketing/campaign.csv"))
+	assert.Equal(t, "platform", ownerOf(owners, "logs/app.log"))
+	assert.Equal(t, "", ownerOf(mapping.forBucket("unmapped"), "marketing/campaign.csv"))
+
+	//Entries need a bucket and an owner
+	err = os.WriteFile(path, []byte(`{"owners": [{"bucket": "data-lake"}]}`), 0644)
+	assert.NoError(t, err)
+	_, err = LoadOwnerMapping(path)
+	assert.Error(t, err)
+}
+
+// Test the allocation scanner
+func TestAllocationScanner(t *testing.T) {
+	page := &s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("marketing/a.csv", 100, "STANDARD"),
+		testObject("data/b.parquet", 300, "STANDARD"),
+		testObject("data/c.parquet", 100, "GLACIER"),
+	}}
+	owners := []PrefixOwner{{Bucket: "data-lake", Prefix: "marketing/", Owner: "marketing"}}
+
+	//Without sampling, unmapped objects go to the bucket's owner
+	bucketTags := testTags(map[string]map[string]string{"": {"team": "platform"}})
+	s := newAllocationScanner(scan.ScanOptions{}, owners, bucketTags, testTags(nil))
+	assert.NoError(t, s.Consume(page))
+	objectScan, err := s.Finalize()
+	assert.NoError(t, err)
+
+	allocation := objectScan.Details.(*BucketAllocation)
+	assert.Equal(t, "platform", allocation.BucketOwner)
+	assert.Equal(t, int64(3), objectScan.ObjectCount)
+	assert.Equal(t, int64(500), objectScan.DataSize)
+	assert.Len(t, allocation.Owners, 2)
+	assert.Equal(t, OwnerAllocation{
+		Owner:       "platform",
+		Source:      "bucket_tags",
+		ObjectCount: 2,
+		DataSize:    400,
+		StorageClasses: map[string]scan.StorageClassTotal{
+			"STANDARD": {ObjectCount: 1, DataSize: 300},
+			"GLACIER":  {ObjectCount: 1, DataSize: 100},
+		},
+	}, allocation.Owners[0])
+	assert.Equal(t, "marketing", allocation.Owners[1].Owner)
+	assert.Equal(t, "mapping", allocation.Owners[1].Source)
+
+	//Sampled object tags split unmapped data by byte share, untagged samples go to the bucket's owner
+	objectTags := testTags(map[string]map[string]string{"data/b.parquet": {"cost-center": "analytics"}})
+	s = newAllocationScanner(scan.ScanOptions{ChargebackTagSamples: 10}, owners, testTags(nil), objectTags)
+	assert.NoError(t, s.Consume(page))
+	objectScan, err = s.Finalize()
+	assert.NoError(t, err)
+
+	allocation = objectScan.Details.(*BucketAllocation)
+	assert.Equal(t, Untagged, allocation.BucketOwner)
+	assert.Equal(t, int64(2), allocation.SampledObjects)
+	assert.Len(t, allocation.Owners, 3)
+	assert.Equal(t, "analytics", allocation.Owners[0].Owner)
+	assert.Equal(t, "object_tags", allocation.Owners[0].Source)
+	assert.Equal(t, int64(300), allocation.Owners[0].DataSize)
+	assert.Equal(t, Untagged, allocation.Owners[2].Owner)
+	assert.Equal(t, int64(100), allocation.Owners[2].DataSize)
+
+	//Custom tag keys
+	s = newAllocationScanner(scan.ScanOptions{ChargebackTagKeys: []string{"billing"}}, nil, bucketTags, testTags(nil))
+	assert.NoError(t, s.Consume(page))
+	objectScan, err = s.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, Untagged, objectScan.Details.(*BucketAllocation).BucketOwner)
+}
+
+// Test CreateReport
+func TestCreateReport(t *testing.T) {
+	scans := []scan.BucketScans{
+		{
+			BucketSummary: summary.BucketSummary{Name: "data-lake"},
+			Scans: scan.Scans{ObjectScans: []scan.ObjectScan{{
+				DataCategory: "chargeback",
+				Details: &BucketAllocation{
+					BucketOwner: "platform",
+					Owners: []OwnerAllocation{
+						{Owner: "platform", DataSize: 3e9, ObjectCount: 3, StorageClasses: map[string]scan.StorageClassTotal{
+							"STANDARD": {ObjectCount: 3, DataSize: 3e9},
+						}},
+						{Owner: "marketing", DataSize: 1e9, ObjectCount: 1, StorageClasses: map[string]scan.StorageClassTotal{
+							"GLACIER": {ObjectCount: 1, DataSize: 1e9},
+						}},
+					},
+				},
+			}}},
+		},
+		//Buckets without a chargeback scan are left out
+		{BucketSummary: summary.BucketSummary{Name: "no-scan"}},
+	}
+	dataLake := summary.BucketSummary{Name: "data-lake"}
+	lifecycleResult := analyze.LifecycleAnalysisResult{
+		BucketSummary:    dataLake,
+		EstimatedSavings: estimate.EstimatedSavings{CalculatedMonthlylSavingsMin: 2, CalculatedMonthlySavingsMax: 2},
+	}
+	tiering := estimate.EstimatedSavings{CalculatedMonthlylSavingsMin: 1, CalculatedMonthlySavingsMax: 1}
+	analyses := []analyze.Analysis{
+		{
+			Name: "Duplicate Data Analysis",
+			AnalysisResults: []analyze.AnalysisResult{analyze.ObjectAnalysisResult{
+				BucketSummary: dataLake,
+				Data: scan.ObjectScan{DataCategory: "duplicate_objects", EstimatedSavings: estimate.EstimatedSavings{
+					CalculatedMonthlylSavingsMin: 4,
+					CalculatedMonthlySavingsMax:  8,
+				}},
+			}},
+		},
+		//Savings reported by several Analyses are counted once
+		{Name: "Lifecycle Management Analysis", AnalysisResults: []analyze.AnalysisResult{lifecycleResult}},
+		{Name: "Temporary Storage Analysis", AnalysisResults: []analyze.AnalysisResult{lifecycleResult}},
+		{Name: "Archive Storage Analysis", AnalysisResults: []analyze.AnalysisResult{analyze.ArchivableAnalysisResult{
+			BucketSummary:    dataLake,
+			EstimatedSavings: tiering,
+		}}},
+		{Name: "Archive Tiering Analysis", AnalysisResults: []analyze.AnalysisResult{analyze.ObjectAnalysisResult{
+			BucketSummary: dataLake,
+			Data:          scan.ObjectScan{DataCategory: "archive_tiering", EstimatedSavings: tiering},
+		}}},
+		//Storage class moves of the same bytes are mutually exclusive, only the largest is counted with the lifecycle savings
+		{Name: "Intelligent-Tiering Analysis", AnalysisResults: []analyze.AnalysisResult{analyze.ObjectAnalysisResult{
+			BucketSummary: dataLake,
+			Data: scan.ObjectScan{DataCategory: "intelligent_tiering", EstimatedSavings: estimate.EstimatedSavings{
+				CalculatedMonthlylSavingsMin: 3,
+				CalculatedMonthlySavingsMax:  3,
+			}},
+		}}},
+	}
+
+	report, err := CreateReport(scans, analyses)
+	assert.NoError(t, err)
+	assert.Len(t, report.Owners, 3)
+
+	platform := report.Owners[0]
+	assert.Equal(t, "platform", platform.Owner)
+	assert.Equal(t, []string{"data-lake"}, platform.Buckets)
+	standardCost, _ := estimate.CurrentStorageCost(3e9, "STANDARD")
+	assert.InDelta(t, standardCost, platform.MonthlyCost, 0.0001)
+	assert.InDelta(t, standardCost, platform.StorageClasses["STANDARD"].MonthlyCost, 0.0001)
+	assert.InDelta(t, 5.25, platform.EstimatedSavings.CalculatedMonthlylSavingsMin, 0.0001)
+	assert.InDelta(t, 8.25, platform.EstimatedSavings.CalculatedMonthlySavingsMax, 0.0001)
+
+	assert.Equal(t, "marketing", report.Owners[1].Owner)
+	assert.InDelta(t, 1.75, report.Owners[1].EstimatedSavings.CalculatedMonthlylSavingsMin, 0.0001)
+
+	//Untagged is listed even when it owns nothing
+	assert.Equal(t, Untagged, report.Owners[2].Owner)
+	assert.Equal(t, int64(0), report.Owners[2].DataSize)
+
+	assert.InDelta(t, platform.MonthlyCost+report.Owners[1].MonthlyCost, report.TotalMonthlyCost, 0.0001)
+	assert.InDelta(t, 11, report.TotalEstimatedSavings.CalculatedMonthlySavingsMax, 0.0001)
+}
@@ -0,0 +1,76 @@
+package chargeback
+
+//This file loads the optional file mapping bucket prefixes to owners
+//The server loads it once at startup, requests can't name files on the server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// OwnerMapping assigns the data under bucket prefixes to owners
+// The longest matching prefix wins over tags
+//
+//	{"owners": [{"bucket": "data-lake", "prefix": "marketing/", "owner": "marketing"}]}
+type OwnerMapping struct {
+	Owners []PrefixOwner `json:"owners"`
+}
+
+// PrefixOwner assigns the objects under a prefix of a bucket to an owner, an empty prefix is the whole bucket
+type PrefixOwner struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	Owner  string `json:"owner"`
+}
+
+// The owner mapping every chargeback scan uses, set by UseOwnerMapping()
+var serverMapping = OwnerMapping{}
+
+// Sets the owner mapping of the server, called once before serving
+func UseOwnerMapping(mapping OwnerMapping) {
+	serverMapping = mapping
+}
+
+// Reads an OwnerMapping from a JSON file
+func LoadOwnerMapping(path string) (OwnerMapping, error) {
+	mapping := OwnerMapping{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mapping, fmt.Errorf("error reading owner mapping: %v", err)
+	}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return mapping, fmt.Errorf("error parsing owner mapping: %v", err)
+	}
+	for _, o := range mapping.Owners {
+		if o.Bucket == "" || o.Owner == "" {
+			return mapping, fmt.Errorf("owner mapping entries need a bucket and an owner")
+		}
+	}
+	return mapping, nil
+}
+
+// Returns the mapping entries of one bucket
+func (m OwnerMapping) forBucket(bucket string) []PrefixOwner {
+	owners := []PrefixOwner{}
+	for _, o := range m.Owners {
+		if o.Bucket == bucket {
+			owners = append(owners, o)
+		}
+	}
+	return owners
+}
+
+// HELPER for allocationScanner
+// Returns the owner of the longest prefix matching key, empty if none does
+func ownerOf(owners []PrefixOwner, key string) string {
+	owner, longest := "", -1
+	for _, o := range owners {
+		if strings.HasPrefix(key, o.Prefix) && len(o.Prefix) > longest {
+			owner, longest = o.Owner, len(o.Prefix)
+		}
+	}
+	return owner
+}
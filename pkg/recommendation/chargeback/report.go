@@ -0,0 +1,209 @@
+package chargeback
+
+//This package bills storage back to the teams that own it
+//The chargeback scan allocates each bucket's bytes to owners, the report adds up cost and savings per owner
+
+import (
+	"math"
+	"sort"
+
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/analyze"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
+)
+
+// Report contains the storage, cost and savings of every owner
+// Untagged is always listed so missing tags can be chased
+type Report struct {
+	Owners                []OwnerReport             `json:"owners"`
+	TotalMonthlyCost      float64                   `json:"total_monthly_cost"`
+	TotalEstimatedSavings estimate.EstimatedSavings `json:"total_estimated_savings"`
+	Policy                policy.Policy             `json:"policy"` //the policy the savings were analyzed with
+}
+
+// OwnerReport contains the storage of one owner across buckets
+type OwnerReport struct {
+	Owner            string                      `json:"owner"`
+	Buckets          []string                    `json:"buckets"`
+	ObjectCount      int64                       `json:"object_count"`
+	DataSize         int64                       `json:"data_size"`
+	StorageClasses   map[string]StorageClassCost `json:"storage_classes"`
+	MonthlyCost      float64                     `json:"monthly_cost"`
+	EstimatedSavings estimate.EstimatedSavings   `json:"estimated_savings"` //the owner's share of the savings recommended for its buckets
+}
+
+// StorageClassCost contains the storage and current monthly cost of one storage class
+type StorageClassCost struct {
+	ObjectCount int64   `json:"object_count"`
+	DataSize    int64   `json:"data_size"`
+	MonthlyCost float64 `json:"monthly_cost"` //0 for storage classes without a known price
+}
+
+// Takes in BucketScans with chargeback scans and their Analyses and returns the chargeback Report
+// Savings of a bucket are split between its owners by their share of its bytes
+func CreateReport(scans []scan.BucketScans, analyses []analyze.Analysis) (Report, error) {
+	report := Report{}
+	owners := map[string]*OwnerReport{Untagged: newOwnerReport(Untagged)}
+
+	savings := bucketSavings(analyses)
+
+	for _, bucketScans := range scans {
+		bucket := bucketScans.BucketSummary.Name
+		allocation := findAllocation(bucketScans)
+		if allocation == nil {
+			continue
+		}
+
+		var bucketSize int64
+		for _, a := range allocation.Owners {
+			bucketSize += a.DataSize
+		}
+
+		for _, a := range allocation.Owners {
+			owner, ok := owners[a.Owner]
+			if !ok {
+				owner = newOwnerReport(a.Owner)
+				owners[a.Owner] = owner
+			}
+			if !contains(owner.Buckets, bucket) {
+				owner.Buckets = append(owner.Buckets, bucket)
+			}
+			owner.ObjectCount += a.ObjectCount
+			owner.DataSize += a.DataSize
+
+			for class, total := range a.StorageClasses {
+				//Storage classes without a known price, ex. OUTPOSTS, are reported without a cost
+				cost, _ := estimate.CurrentStorageCost(total.DataSize, class)
+				classCost := owner.StorageClasses[class]
+				classCost.ObjectCount += total.ObjectCount
+				classCost.DataSize += total.DataSize
+				classCost.MonthlyCost += cost
+				owner.StorageClasses[class] = classCost
+				owner.MonthlyCost += cost
+			}
+
+			if bucketSize > 0 {
+				share := float64(a.DataSize) / float64(bucketSize)
+				owner.EstimatedSavings.CalculatedMonthlylSavingsMin += savings[bucket].CalculatedMonthlylSavingsMin * share
+				owner.EstimatedSavings.CalculatedMonthlySavingsMax += savings[bucket].CalculatedMonthlySavingsMax * share
+			}
+		}
+	}
+
+	for _, owner := range owners {
+		sort.Strings(owner.Buckets)
+		report.Owners = append(report.Owners, *owner)
+		report.TotalMonthlyCost += owner.MonthlyCost
+		report.TotalEstimatedSavings.CalculatedMonthlylSavingsMin += owner.EstimatedSavings.CalculatedMonthlylSavingsMin
+		report.TotalEstimatedSavings.CalculatedMonthlySavingsMax += owner.EstimatedSavings.CalculatedMonthlySavingsMax
+	}
+	sort.Slice(report.Owners, func(i, j int) bool {
+		if report.Owners[i].MonthlyCost != report.Owners[j].MonthlyCost {
+			return report.Owners[i].MonthlyCost > report.Owners[j].MonthlyCost
+		}
+		return report.Owners[i].Owner < report.Owners[j].Owner
+	})
+
+	return report, nil
+}
+
+// Scans whose savings come from moving or rewriting the same bytes into a cheaper form
+// Only one of them can be applied to a bucket's data, so only the largest is counted
+var storageClassSavingsSources = map[string]bool{
+	"archive_tiering":       true,
+	"intelligent_tiering":   true,
+	"small_files":           true,
+	"compressible_objects":  true,
+	"small_archive_objects": true,
+	"date_partitions":       true,
+}
+
+// HELPER for CreateReport()
+// Returns the sum of the estimated savings of every Analysis result, by bucket
+// Savings are counted once per scan they come from, ex. Temporary Storage re-reports the Lifecycle result,
+// and of the mutually exclusive storageClassSavingsSources only the largest min and max are counted
+func bucketSavings(analyses []analyze.Analysis) map[string]estimate.EstimatedSavings {
+	savings := make(map[string]estimate.EstimatedSavings)
+	exclusive := make(map[string]estimate.EstimatedSavings)
+	counted := make(map[string]bool)
+	for _, analysis := range analyses {
+		for _, result := range analysis.AnalysisResults {
+			bucket := result.GetBucketSummary().Name
+			category := savingsSource(analysis, result)
+			source := bucket + "/" + category
+			if counted[source] {
+				continue
+			}
+			counted[source] = true
+
+			estimates := result.GetEstimates()
+			if storageClassSavingsSources[category] {
+				largest := exclusive[bucket]
+				largest.CalculatedMonthlylSavingsMin = math.Max(largest.CalculatedMonthlylSavingsMin, estimates.CalculatedMonthlylSavingsMin)
+				largest.CalculatedMonthlySavingsMax = math.Max(largest.CalculatedMonthlySavingsMax, estimates.CalculatedMonthlySavingsMax)
+				exclusive[bucket] = largest
+				continue
+			}
+
+			total := savings[bucket]
+			total.CalculatedMonthlylSavingsMin += estimates.CalculatedMonthlylSavingsMin
+			total.CalculatedMonthlySavingsMax += estimates.CalculatedMonthlySavingsMax
+			savings[bucket] = total
+		}
+	}
+
+	for bucket, largest := range exclusive {
+		total := savings[bucket]
+		total.CalculatedMonthlylSavingsMin += largest.CalculatedMonthlylSavingsMin
+		total.CalculatedMonthlySavingsMax += largest.CalculatedMonthlySavingsMax
+		savings[bucket] = total
+	}
+	return savings
+}
+
+// HELPER for bucketSavings()
+// Returns the scan the savings of an Analysis result come from, or the Analysis for user-defined rules
+func savingsSource(analysis analyze.Analysis, result analyze.AnalysisResult) string {
+	switch r := result.(type) {
+	case analyze.ObjectAnalysisResult:
+		return r.Data.DataCategory
+	case analyze.ArchivableAnalysisResult:
+		return "archive_tiering"
+	case analyze.VersioningAnalysisResult:
+		return "object_versions"
+	case analyze.LifecycleAnalysisResult:
+		return "date_partitions"
+	default:
+		return "analysis/" + analysis.Name
+	}
+}
+
+// HELPER for CreateReport()
+func findAllocation(bucketScans scan.BucketScans) *BucketAllocation {
+	for _, objectScan := range bucketScans.Scans.ObjectScans {
+		if allocation, ok := objectScan.Details.(*BucketAllocation); ok {
+			return allocation
+		}
+	}
+	return nil
+}
+
+// HELPER for CreateReport()
+func newOwnerReport(owner string) *OwnerReport {
+	return &OwnerReport{
+		Owner:          owner,
+		Buckets:        []string{},
+		StorageClasses: make(map[string]StorageClassCost),
+	}
+}
+
+// HELPER for CreateReport()
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package chargeback
+
+//This file registers the chargeback scan, which allocates a bucket's bytes to owners
+//Owners come from the server's owner mapping, then from sampled object tags, then from bucket tags
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
+)
+
+// Owner of data no mapping or tag assigns
+const Untagged = "untagged"
+
+// BucketAllocation is the ObjectScan.Details of the chargeback scan
+type BucketAllocation struct {
+	BucketOwner    string            `json:"bucket_owner"` //from bucket tags, Untagged if no owner tag is set
+	SampledObjects int64             `json:"sampled_objects"`
+	Owners         []OwnerAllocation `json:"owners"`
+}
+
+// OwnerAllocation contains the data of a bucket allocated to one owner
+type OwnerAllocation struct {
+	Owner          string                            `json:"owner"`
+	Source         string                            `json:"source"` //"mapping", "object_tags", "bucket_tags" or "untagged"
+	ObjectCount    int64                             `json:"object_count"`
+	DataSize       int64                             `json:"data_size"`
+	StorageClasses map[string]scan.StorageClassTotal `json:"storage_classes"`
+}
+
+// Reads the tags of a bucket or object, nil when it has none or they can't be read
+type tagFetcher func(key string) map[string]string
+
+func init() {
+	scan.RegisterScanner(scan.ScannerRegistration{
+		Name:                "chargeback",
+		Permissions:         []string{"s3:ListBucket", "s3:GetBucketTagging"},
+		OptionalPermissions: []string{"s3:GetObjectTagging"},
+		New: func(ctx scan.ScanContext) scan.Scanner {
+			svc := s3.New(ctx.Session)
+			bucket := ctx.Bucket.Name
+			//Bucket tags are read with the rest of the BucketScan
+			bucketTags := func(string) map[string]string {
+				return ctx.BucketScan.Tags
+			}
+			objectTags := func(key string) map[string]string {
+				//AWS SDK GET CALL
+				output, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+				if err != nil {
+					return nil
+				}
+				return tagMap(output.TagSet)
+			}
+
+			return newAllocationScanner(ctx.Options, serverMapping.forBucket(bucket), bucketTags, objectTags)
+		},
+	})
+}
+
+// Allocates every object of a bucket to an owner
+type allocationScanner struct {
+	tagKeys    []string
+	owners     []PrefixOwner
+	bucketTags tagFetcher
+	objectTags tagFetcher
+	rng        *rand.Rand
+	maxSamples int
+
+	mapped   map[string]map[string]scan.StorageClassTotal //owner to storage class totals of mapped objects
+	unmapped map[string]scan.StorageClassTotal            //storage class totals of objects no mapping assigns
+	seen     int64
+	samples  []*s3.Object
+}
+
+func newAllocationScanner(opts scan.ScanOptions, owners []PrefixOwner, bucketTags tagFetcher, objectTags tagFetcher) *allocationScanner {
+	s := &allocationScanner{
+		tagKeys:    opts.OwnerTagKeys(),
+		owners:     owners,
+		bucketTags: bucketTags,
+		objectTags: objectTags,
+		rng:        rand.New(rand.NewSource(1)),
+		maxSamples: opts.ChargebackTagSamples,
+		mapped:     make(map[string]map[string]scan.StorageClassTotal),
+		unmapped:   make(map[string]scan.StorageClassTotal),
+	}
+	return s
+}
+
+func (s *allocationScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		if owner := ownerOf(s.owners, *object.Key); owner != "" {
+			if s.mapped[owner] == nil {
+				s.mapped[owner] = make(map[string]scan.StorageClassTotal)
+			}
+			addObject(s.mapped[owner], object)
+			continue
+		}
+		addObject(s.unmapped, object)
+
+		//Reservoir sample of unmapped objects for their tags
+		if s.maxSamples == 0 {
+			continue
+		}
+		s.seen++
+		if len(s.samples) < s.maxSamples {
+			s.samples = append(s.samples, object)
+		} else if i := s.rng.Int63n(s.seen); i < int64(s.maxSamples) {
+			s.samples[i] = object
+		}
+	}
+	return nil
+}
+
+// Unmapped data is split between owners by the bytes of the sampled objects tagged with each,
+// sampled objects without an owner tag stand for the share that goes to the bucket's owner
+func (s *allocationScanner) Finalize() (scan.ObjectScan, error) {
+	objectScan := scan.ObjectScan{DataCategory: "chargeback"}
+	allocation := &BucketAllocation{BucketOwner: ownerTag(s.bucketTags(""), s.tagKeys)}
+	bucketSource := "bucket_tags"
+	if allocation.BucketOwner == "" {
+		allocation.BucketOwner, bucketSource = Untagged, Untagged
+	}
+
+	for owner, classes := range s.mapped {
+		allocation.Owners = append(allocation.Owners, newOwnerAllocation(owner, "mapping", classes))
+	}
+
+	sampledBytes := make(map[string]int64)
+	var totalSampled int64
+	for _, object := range s.samples {
+		allocation.SampledObjects++
+		owner := ownerTag(s.objectTags(*object.Key), s.tagKeys)
+		sampledBytes[owner] += *object.Size
+		totalSampled += *object.Size
+	}
+
+	if totalSampled == 0 {
+		if len(s.unmapped) > 0 {
+			allocation.Owners = append(allocation.Owners, newOwnerAllocation(allocation.BucketOwner, bucketSource, s.unmapped))
+		}
+	} else {
+		for owner, bytes := range sampledBytes {
+			source := "object_tags"
+			if owner == "" {
+				owner, source = allocation.BucketOwner, bucketSource
+			}
+			share := float64(bytes) / float64(totalSampled)
+			allocation.Owners = append(allocation.Owners, newOwnerAllocation(owner, source, scaleTotals(s.unmapped, share)))
+		}
+	}
+
+	sort.Slice(allocation.Owners, func(i, j int) bool {
+		if allocation.Owners[i].DataSize != allocation.Owners[j].DataSize {
+			return allocation.Owners[i].DataSize > allocation.Owners[j].DataSize
+		}
+		return allocation.Owners[i].Owner < allocation.Owners[j].Owner
+	})
+	for _, owner := range allocation.Owners {
+		objectScan.ObjectCount += owner.ObjectCount
+		objectScan.DataSize += owner.DataSize
+	}
+	objectScan.Details = allocation
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+func newOwnerAllocation(owner string, source string, classes map[string]scan.StorageClassTotal) OwnerAllocation {
+	allocation := OwnerAllocation{Owner: owner, Source: source, StorageClasses: classes}
+	for _, total := range classes {
+		allocation.ObjectCount += total.ObjectCount
+		allocation.DataSize += total.DataSize
+	}
+	return allocation
+}
+
+// HELPER for Finalize()
+func scaleTotals(classes map[string]scan.StorageClassTotal, share float64) map[string]scan.StorageClassTotal {
+	scaled := make(map[string]scan.StorageClassTotal)
+	for class, total := range classes {
+		scaled[class] = scan.StorageClassTotal{
+			ObjectCount: int64(math.Round(float64(total.ObjectCount) * share)),
+			DataSize:    int64(math.Round(float64(total.DataSize) * share)),
+		}
+	}
+	return scaled
+}
+
+// HELPER for Consume()
+func addObject(classes map[string]scan.StorageClassTotal, object *s3.Object) {
+	total := classes[*object.StorageClass]
+	total.ObjectCount++
+	total.DataSize += *object.Size
+	classes[*object.StorageClass] = total
+}
+
+// Returns the value of the first owner tag key present, empty if none is
+func ownerTag(tags map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value, ok := tags[key]; ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// HELPER for tagFetchers
+func tagMap(tagSet []*s3.Tag) map[string]string {
+	tags := make(map[string]string, len(tagSet))
+	for _, tag := range tagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags
+}
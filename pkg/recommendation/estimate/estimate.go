@@ -16,8 +16,105 @@ var StorageClassPrices = map[string]float64{
 	"ONEZONE_IA":   0.01,
 	"GLACIER":      0.004,
 	"DEEP_ARCHIVE": 0.00099,
+
+	"GLACIER_IR":          0.004,
+	"INTELLIGENT_TIERING": 0.023, //Frequent Access tier, the most objects in Intelligent-Tiering can cost
+}
+
+// Intelligent-Tiering charges a monthly monitoring fee per 1000 objects
+// Objects smaller than its minimum size are not monitored and always billed at the Frequent Access tier
+const (
+	IntelligentTieringMonitoringFeePer1000 = 0.0025
+	IntelligentTieringMinimumObjectSize    = 128 * 1024
+)
+
+// The pricing of the Intelligent-Tiering access tiers per GB per month
+var IntelligentTieringTierPrices = map[string]float64{
+	"FREQUENT":        0.023,
+	"INFREQUENT":      0.0125,
+	"ARCHIVE_INSTANT": 0.004,
+}
+
+// The price of transferring data between regions per GB, ex. for cross region replication
+const CrossRegionTransferPricePerGB = 0.02
+
+// SSE-KMS makes a KMS request for every object read or written
+// S3 Bucket Keys reduce those requests by up to 99%
+const (
+	KMSRequestPricePer10000   = 0.03
+	BucketKeyRequestReduction = 0.99
+)
+
+// The pricing of paid bucket features
+const (
+	InventoryPricePerMillionObjects            = 0.0025 //per million objects listed in each report
+	StorageClassAnalysisPricePerMillionObjects = 0.10   //per million objects monitored per month
+	RequestMetricsPricePerMetric               = 0.30   //per CloudWatch metric per month
+	RequestMetricsPerConfiguration             = 16     //CloudWatch metrics each request metrics configuration publishes
+	TransferAccelerationPricePerGB             = 0.04   //per GB transferred through an accelerated endpoint
+)
+
+// GLACIER and DEEP_ARCHIVE bill every object for metadata on top of its data,
+// 8 KB at the STANDARD price for its name and 32 KB at the archive price for its index
+const (
+	ArchiveObjectStandardOverheadBytes = 8 * 1024
+	ArchiveObjectArchiveOverheadBytes  = 32 * 1024
+)
+
+// Storage classes that bill every object as at least this many bytes
+var MinimumBillableObjectSizes = map[string]int64{
+	"STANDARD_IA": 128 * 1024,
+	"ONEZONE_IA":  128 * 1024,
+	"GLACIER_IR":  128 * 1024,
+}
+
+// The pricing of lifecycle transitions into a storage class per 1000 objects
+var TransitionPricesPer1000 = map[string]float64{
+	"STANDARD_IA":         0.01,
+	"ONEZONE_IA":          0.01,
+	"INTELLIGENT_TIERING": 0.01,
+	"GLACIER_IR":          0.02,
+	"GLACIER":             0.03,
+	"DEEP_ARCHIVE":        0.05,
 }
 
+// Storage classes that bill every object for at least this many days, even if it is deleted, overwritten or transitioned sooner
+var MinimumStorageDays = map[string]int{
+	"STANDARD_IA":  30,
+	"ONEZONE_IA":   30,
+	"GLACIER_IR":   90,
+	"GLACIER":      90,
+	"DEEP_ARCHIVE": 180,
+}
+
+// The pricing of reading data back from a storage class per GB, standard retrievals for GLACIER and DEEP_ARCHIVE
+var RetrievalPricesPerGB = map[string]float64{
+	"STANDARD_IA":  0.01,
+	"ONEZONE_IA":   0.01,
+	"GLACIER_IR":   0.03,
+	"GLACIER":      0.01,
+	"DEEP_ARCHIVE": 0.02,
+}
+
+// TransitionEstimate is the cost-benefit of moving objects to another storage class
+type TransitionEstimate struct {
+	UpfrontCost     float64 `json:"upfront_cost"`      //one-time, transition fees and early deletion charges
+	MonthlyDelta    float64 `json:"monthly_delta"`     //monthly storage savings net of expected retrievals, negative when the move costs more
+	BreakEvenMonths float64 `json:"break_even_months"` //months of savings that pay back the upfront cost, 0 if it never breaks even
+	BreaksEven      bool    `json:"breaks_even"`
+}
+
+// Checks if the savings pay back the upfront cost within the given months
+func (e TransitionEstimate) BreaksEvenWithin(months int) bool {
+	return e.BreaksEven && e.BreakEvenMonths <= float64(months)
+}
+
+// The pricing of STANDARD requests per 1000 requests
+const (
+	PutRequestPricePer1000 = 0.005 //PUT, COPY, POST and LIST
+	GetRequestPricePer1000 = 0.0004
+)
+
 // Calculate current monthly storage cost
 func CurrentStorageCost(bucketSizeinBytes int64, storageClass string) (float64, error) {
 	price, ok := StorageClassPrices[storageClass]
@@ -38,6 +135,12 @@ func SavingsForBytesDeletedByStorageClass(objectSizeInBytes int64, storageClass
 	return monthlyCost
 }
 
+// CompressionRatio is the measured compressed size as a fraction of the original size
+type CompressionRatio struct {
+	Min float64 `json:"min"` //best compression seen
+	Max float64 `json:"max"` //worst compression seen
+}
+
 // Estimate the min and max savings for compressing compressible file types
 func SavingsForBytesCompressedByStorageClass(dataSize int64, compressionType string, storageClass string) (float64, float64, error) {
 	minSize, maxSize, err := estimateCompressedSize(dataSize, compressionType)
@@ -57,6 +160,24 @@ func SavingsForBytesCompressedByStorageClass(dataSize int64, compressionType str
 	return minSaving, maxSaving, nil
 }
 
+// Estimate the min and max savings for compressing data with a measured CompressionRatio, ex. from sampling the objects
+func SavingsForBytesCompressedWithRatio(dataSize int64, ratio CompressionRatio, storageClass string) (float64, float64, error) {
+	if ratio.Min < 0 || ratio.Max > 1 || ratio.Min > ratio.Max {
+		return 0, 0, fmt.Errorf("invalid compression ratio: %v", ratio)
+	}
+
+	price, ok := StorageClassPrices[storageClass]
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid storage class: %s", storageClass)
+	}
+
+	// Savings are the bytes compression removes, bytes to GB
+	minSaving := (float64(dataSize) * (1 - ratio.Max) / 1000000000) * price
+	maxSaving := (float64(dataSize) * (1 - ratio.Min) / 1000000000) * price
+
+	return minSaving, maxSaving, nil
+}
+
 // HELPER for SavingsForBytesCompressedByStorageClass()
 // compressionType can be one of "gzip", "zlib", "zip" ,"snappy"
 func estimateCompressedSize(dataSize int64, compressionType string) (minSize int64, maxSize int64, err error) {
@@ -102,3 +223,170 @@ func estimateCompressedSize(dataSize int64, compressionType string) (minSize int
 	}
 	return minSize, maxSize, err
 }
+
+// Estimate the monthly savings of moving objects from a storage class to Intelligent-Tiering
+// infrequentBytes end up in the Infrequent Access tier and archiveInstantBytes in the Archive Instant Access tier,
+// the rest of the objects stay in the Frequent Access tier
+// Savings are net of the monitoring fee for every object and are negative when the fee outweighs them
+func SavingsForIntelligentTiering(objectCount int64, infrequentBytes int64, archiveInstantBytes int64, storageClass string) (float64, error) {
+	price, ok := StorageClassPrices[storageClass]
+	if !ok {
+		return 0, fmt.Errorf("invalid storage class: %s", storageClass)
+	}
+
+	// bytes to GB
+	savings := (float64(infrequentBytes) / 1000000000) * (price - IntelligentTieringTierPrices["INFREQUENT"])
+	savings += (float64(archiveInstantBytes) / 1000000000) * (price - IntelligentTieringTierPrices["ARCHIVE_INSTANT"])
+	monitoringFee := (float64(objectCount) / 1000) * IntelligentTieringMonitoringFeePer1000
+
+	return savings - monitoringFee, nil
+}
+
+// Calculate the cost of transferring bytes to another region
+func CostForBytesTransferredCrossRegion(dataSize int64) float64 {
+	// bytes to GB
+	return (float64(dataSize) / 1000000000) * CrossRegionTransferPricePerGB
+}
+
+// Calculate the cost of KMS requests
+func CostForKMSRequests(requests int64) float64 {
+	return (float64(requests) / 10000) * KMSRequestPricePer10000
+}
+
+// Calculate the monthly cost of the per-object metadata of objects in an archive storage class
+func ArchiveOverheadCost(objectCount int64, storageClass string) (float64, error) {
+	if storageClass != "GLACIER" && storageClass != "DEEP_ARCHIVE" {
+		return 0, fmt.Errorf("storage class has no per-object overhead: %s", storageClass)
+	}
+
+	// bytes to GB
+	standardOverhead := (float64(objectCount*ArchiveObjectStandardOverheadBytes) / 1000000000) * StorageClassPrices["STANDARD"]
+	archiveOverhead := (float64(objectCount*ArchiveObjectArchiveOverheadBytes) / 1000000000) * StorageClassPrices[storageClass]
+	return standardOverhead + archiveOverhead, nil
+}
+
+// Returns the object size below which an object costs more to store in an archive storage class than in STANDARD
+func ArchiveBreakEvenSize(storageClass string) (int64, error) {
+	overhead, err := ArchiveOverheadCost(1, storageClass)
+	if err != nil {
+		return 0, err
+	}
+
+	// GB to bytes
+	return int64(overhead / (StorageClassPrices["STANDARD"] - StorageClassPrices[storageClass]) * 1000000000), nil
+}
+
+// Calculate the monthly storage cost of objects of the same average size in a storage class,
+// including its minimum billable object size and per-object archive overhead
+func StorageCostForObjects(objectCount int64, dataSize int64, storageClass string) (float64, error) {
+	billableSize := dataSize
+	if minimum, ok := MinimumBillableObjectSizes[storageClass]; ok && objectCount > 0 && dataSize/objectCount < minimum {
+		billableSize = objectCount * minimum
+	}
+	cost, err := CurrentStorageCost(billableSize, storageClass)
+	if err != nil {
+		return 0, err
+	}
+	if storageClass == "GLACIER" || storageClass == "DEEP_ARCHIVE" {
+		overhead, err := ArchiveOverheadCost(objectCount, storageClass)
+		if err != nil {
+			return 0, err
+		}
+		cost += overhead
+	}
+	return cost, nil
+}
+
+// Calculate the one-time cost of transitioning objects to a storage class with a lifecycle rule
+func CostForTransitions(objectCount int64, storageClass string) (float64, error) {
+	price, ok := TransitionPricesPer1000[storageClass]
+	if !ok {
+		return 0, fmt.Errorf("invalid transition storage class: %s", storageClass)
+	}
+	return (float64(objectCount) / 1000) * price, nil
+}
+
+// Calculate the one-time cost of rewriting objects into fewer, larger objects,
+// reading every object once and writing the compacted ones
+func CostForCompaction(objectCount int64, compactedCount int64) float64 {
+	return (float64(objectCount)/1000)*GetRequestPricePer1000 + (float64(compactedCount)/1000)*PutRequestPricePer1000
+}
+
+// Calculate the cost of reading data back from a storage class
+func RetrievalCost(dataSize int64, storageClass string) float64 {
+	// bytes to GB
+	return (float64(dataSize) / 1000000000) * RetrievalPricesPerGB[storageClass]
+}
+
+// Calculate what objects are still billed for when they leave a storage class after daysStored,
+// the storage of the rest of its minimum duration
+func EarlyDeletionCost(objectCount int64, dataSize int64, storageClass string, daysStored int) (float64, error) {
+	remainingDays := MinimumStorageDays[storageClass] - daysStored
+	if remainingDays <= 0 {
+		return 0, nil
+	}
+	monthlyCost, err := StorageCostForObjects(objectCount, dataSize, storageClass)
+	if err != nil {
+		return 0, err
+	}
+	return monthlyCost * float64(remainingDays) / 30, nil
+}
+
+// Estimate the cost-benefit of transitioning objects from one storage class to another with a lifecycle rule
+// retrievalRate is the share of the data expected to be read back each month,
+// retentionDays the days the objects are kept after the transition, 0 if they are kept indefinitely
+// Objects kept less than the minimum duration pay for the rest of it upfront, and have to break even before they are deleted
+func EstimateTransition(objectCount int64, dataSize int64, fromClass string, toClass string, retrievalRate float64, retentionDays int) (TransitionEstimate, error) {
+	upfront, err := transitionCost(objectCount, dataSize, fromClass, toClass)
+	if err != nil {
+		return TransitionEstimate{}, err
+	}
+	if retentionDays > 0 {
+		penalty, err := EarlyDeletionCost(objectCount, dataSize, toClass, retentionDays)
+		if err != nil {
+			return TransitionEstimate{}, err
+		}
+		upfront += penalty
+	}
+
+	currentCost, err := StorageCostForObjects(objectCount, dataSize, fromClass)
+	if err != nil {
+		return TransitionEstimate{}, err
+	}
+	targetCost, err := StorageCostForObjects(objectCount, dataSize, toClass)
+	if err != nil {
+		return TransitionEstimate{}, err
+	}
+	retrieval := RetrievalCost(int64(float64(dataSize)*retrievalRate), toClass)
+
+	e := NewTransitionEstimate(upfront, currentCost-targetCost-retrieval)
+	if retentionDays > 0 && e.BreakEvenMonths > float64(retentionDays)/30 {
+		e.BreaksEven = false
+	}
+	return e, nil
+}
+
+// HELPER for EstimateTransition()
+// Lifecycle rules only move objects to colder storage classes,
+// archived objects move back to STANDARD by restoring them and copying them over themselves
+func transitionCost(objectCount int64, dataSize int64, fromClass string, toClass string) (float64, error) {
+	if toClass == "STANDARD" && (fromClass == "GLACIER" || fromClass == "DEEP_ARCHIVE") {
+		return RetrievalCost(dataSize, fromClass) + (float64(objectCount)/1000)*PutRequestPricePer1000, nil
+	}
+	return CostForTransitions(objectCount, toClass)
+}
+
+// Takes in an upfront cost and monthly delta and returns a TransitionEstimate with its break-even
+func NewTransitionEstimate(upfrontCost float64, monthlyDelta float64) TransitionEstimate {
+	e := TransitionEstimate{UpfrontCost: upfrontCost, MonthlyDelta: monthlyDelta}
+	if monthlyDelta > 0 {
+		e.BreakEvenMonths = upfrontCost / monthlyDelta
+		e.BreaksEven = true
+	}
+	return e
+}
+
+// Returns the sum of two TransitionEstimates of different objects, with the break-even of the total
+func (e TransitionEstimate) Add(other TransitionEstimate) TransitionEstimate {
+	return NewTransitionEstimate(e.UpfrontCost+other.UpfrontCost, e.MonthlyDelta+other.MonthlyDelta)
+}
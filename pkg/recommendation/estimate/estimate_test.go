@@ -74,3 +74,133 @@ func TestSavingsForBytesCompressedByStorageClass(t *testing.T) {
 		assert.LessOrEqual(t, max, test.expectedMax)
 	}
 }
+
+func TestSavingsForBytesCompressedWithRatio(t *testing.T) {
+	min, max, err := SavingsForBytesCompressedWithRatio(1000000000, CompressionRatio{Min: 0.2, Max: 0.5}, "STANDARD")
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.0115, min, 0.0001)
+	assert.InDelta(t, 0.0184, max, 0.0001)
+
+	//already compressed data saves nothing
+	min, max, err = SavingsForBytesCompressedWithRatio(1000000000, CompressionRatio{Min: 1, Max: 1}, "STANDARD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, min)
+	assert.Equal(t, 0.0, max)
+
+	_, _, err = SavingsForBytesCompressedWithRatio(1000000000, CompressionRatio{Min: 0.8, Max: 0.2}, "STANDARD")
+	assert.Error(t, err)
+}
+
+func TestSavingsForIntelligentTiering(t *testing.T) {
+	savings, err := SavingsForIntelligentTiering(1000, 1000000000, 1000000000, "STANDARD")
+	assert.NoError(t, err)
+	assert.InDelta(t, (0.023-0.0125)+(0.023-0.004)-0.0025, savings, 1e-9)
+
+	// The monitoring fee outweighs the savings of many objects that stay in the Frequent Access tier
+	savings, err = SavingsForIntelligentTiering(1000000, 0, 0, "STANDARD")
+	assert.NoError(t, err)
+	assert.InDelta(t, -2.5, savings, 1e-9)
+
+	_, err = SavingsForIntelligentTiering(1, 1, 1, "INVALID")
+	assert.Error(t, err)
+}
+
+func TestArchiveOverhead(t *testing.T) {
+	cost, err := ArchiveOverheadCost(1000000, "GLACIER")
+	assert.NoError(t, err)
+	assert.InDelta(t, 8192*0.001*0.023+32768*0.001*0.004, cost, 1e-9)
+
+	_, err = ArchiveOverheadCost(1, "STANDARD")
+	assert.Error(t, err)
+
+	// Below the break-even size archiving costs more than STANDARD
+	size, err := ArchiveBreakEvenSize("GLACIER")
+	assert.NoError(t, err)
+	glacierCost, _ := CurrentStorageCost(size, "GLACIER")
+	overhead, _ := ArchiveOverheadCost(1, "GLACIER")
+	standardCost, _ := CurrentStorageCost(size, "STANDARD")
+	assert.InDelta(t, standardCost, glacierCost+overhead, 1e-10)
+
+	deepSize, err := ArchiveBreakEvenSize("DEEP_ARCHIVE")
+	assert.NoError(t, err)
+	assert.Less(t, deepSize, size)
+}
+
+func TestStorageCostForObjects(t *testing.T) {
+	// 1000 objects of 1 KB are billed as 128 KB each in STANDARD_IA
+	cost, err := StorageCostForObjects(1000, 1000*1024, "STANDARD_IA")
+	assert.NoError(t, err)
+	assert.InDelta(t, float64(1000*128*1024)/1000000000*0.0125, cost, 1e-12)
+
+	// Archive classes add the per-object overhead
+	cost, err = StorageCostForObjects(1000, 1000*1024, "GLACIER")
+	assert.NoError(t, err)
+	overhead, _ := ArchiveOverheadCost(1000, "GLACIER")
+	assert.InDelta(t, float64(1000*1024)/1000000000*0.004+overhead, cost, 1e-12)
+
+	transitions, err := CostForTransitions(1000000, "GLACIER")
+	assert.NoError(t, err)
+	assert.InDelta(t, 30, transitions, 1e-9)
+
+	_, err = CostForTransitions(1, "STANDARD")
+	assert.Error(t, err)
+
+	assert.InDelta(t, 0.4+0.005, CostForCompaction(1000000, 1000), 1e-9)
+}
+
+func TestEstimateTransition(t *testing.T) {
+	// 1000 objects of 1 MB from STANDARD to GLACIER, 1% read back each month
+	e, err := EstimateTransition(1000, 1e9, "STANDARD", "GLACIER", 0.01, 0)
+	assert.NoError(t, err)
+	glacierCost, _ := StorageCostForObjects(1000, 1e9, "GLACIER")
+	assert.InDelta(t, 0.03, e.UpfrontCost, 1e-12)
+	assert.InDelta(t, 0.023-glacierCost-0.01*0.01, e.MonthlyDelta, 1e-12)
+	assert.InDelta(t, e.UpfrontCost/e.MonthlyDelta, e.BreakEvenMonths, 1e-12)
+	assert.True(t, e.BreaksEvenWithin(2))
+	assert.False(t, e.BreaksEvenWithin(1))
+
+	// Deleted after 30 days, GLACIER still bills the other 60 and the savings never catch up
+	e, err = EstimateTransition(1000, 1e9, "STANDARD", "GLACIER", 0, 30)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.03+glacierCost*2, e.UpfrontCost, 1e-12)
+	assert.False(t, e.BreaksEven)
+
+	// Tiny objects cost more in STANDARD_IA than in STANDARD
+	e, err = EstimateTransition(1000, 1000*1024, "STANDARD", "STANDARD_IA", 0, 0)
+	assert.NoError(t, err)
+	assert.Less(t, e.MonthlyDelta, 0.0)
+	assert.False(t, e.BreaksEven)
+	assert.Equal(t, 0.0, e.BreakEvenMonths)
+
+	// Retrievals can outweigh the storage savings
+	e, err = EstimateTransition(1000, 1e9, "STANDARD", "GLACIER_IR", 1, 0)
+	assert.NoError(t, err)
+	assert.False(t, e.BreaksEven)
+
+	_, err = EstimateTransition(1, 1, "STANDARD", "STANDARD", 0, 0)
+	assert.Error(t, err)
+
+	// Archived objects move back by restoring and copying them
+	e, err = EstimateTransition(1000, 1000*1024, "GLACIER", "STANDARD", 0.01, 0)
+	assert.NoError(t, err)
+	assert.InDelta(t, RetrievalCost(1000*1024, "GLACIER")+0.005, e.UpfrontCost, 1e-12)
+	assert.Greater(t, e.MonthlyDelta, 0.0)
+
+	total := NewTransitionEstimate(1, 1).Add(NewTransitionEstimate(3, -0.5))
+	assert.InDelta(t, 8, total.BreakEvenMonths, 1e-12)
+}
+
+func TestEarlyDeletionCost(t *testing.T) {
+	cost, err := EarlyDeletionCost(1, 1e9, "DEEP_ARCHIVE", 60)
+	assert.NoError(t, err)
+	monthly, _ := StorageCostForObjects(1, 1e9, "DEEP_ARCHIVE")
+	assert.InDelta(t, monthly*4, cost, 1e-12)
+
+	cost, err = EarlyDeletionCost(1, 1e9, "GLACIER", 90)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, cost)
+
+	cost, err = EarlyDeletionCost(1, 1e9, "STANDARD", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, cost, "STANDARD has no minimum duration")
+}
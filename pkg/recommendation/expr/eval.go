@@ -0,0 +1,228 @@
+package expr
+
+//This file evaluates the nodes of a parsed expression
+
+import (
+	"fmt"
+)
+
+type node interface {
+	eval(env Env) (Value, error)
+}
+
+type literalNode struct {
+	value Value
+}
+
+type identNode struct {
+	name string
+}
+
+type memberNode struct {
+	object node
+	name   string
+}
+
+type indexNode struct {
+	object node
+	index  node
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n literalNode) eval(env Env) (Value, error) {
+	return n.value, nil
+}
+
+func (n identNode) eval(env Env) (Value, error) {
+	v, ok := env.Vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %q", n.name)
+	}
+	return v, nil
+}
+
+// Missing keys of a map are the empty string, so untagged buckets compare like any other value
+func (n memberNode) eval(env Env) (Value, error) {
+	object, err := n.object.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(object, n.name)
+}
+
+func (n indexNode) eval(env Env) (Value, error) {
+	object, err := n.object.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := index.(string)
+	if !ok {
+		return nil, fmt.Errorf("map keys are strings, got %s", typeName(index))
+	}
+	return lookup(object, key)
+}
+
+// HELPER for memberNode and indexNode
+func lookup(object Value, key string) (Value, error) {
+	m, ok := object.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("can't read %q of a %s", key, typeName(object))
+	}
+	return m[key], nil
+}
+
+func (n callNode) eval(env Env) (Value, error) {
+	fn, ok := env.Funcs[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", n.name)
+	}
+	args := make([]Value, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	v, err := fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %v", n.name, err)
+	}
+	return v, nil
+}
+
+func (n unaryNode) eval(env Env) (Value, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! needs a bool, got %s", typeName(v))
+		}
+		return !b, nil
+	default:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("- needs a number, got %s", typeName(v))
+		}
+		return -f, nil
+	}
+}
+
+func (n binaryNode) eval(env Env) (Value, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	//&& and || only evaluate their right side when it decides the result
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs bools, got %s", n.op, typeName(left))
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs bools, got %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return l / r, nil
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "+":
+			return l + r, nil
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		}
+	}
+	return nil, fmt.Errorf("can't apply %s to %s and %s", n.op, typeName(left), typeName(right))
+}
@@ -0,0 +1,144 @@
+package expr
+
+//This package is a small expression language for user-defined analysis rules, ex.
+//
+//	tags.env == "dev" && size > 1TB && !has_expiration
+//	class_size_older_than("STANDARD", 180) > 0.5 * size
+//
+//Values are numbers (float64), strings, bools and string maps (map[string]string), read with . or [].
+//Numbers take an optional binary size suffix: KB, MB, GB, TB or PB.
+//Operators by increasing precedence: ||, &&, == != < <= > >=, + -, * /, unary ! and -.
+
+import (
+	"fmt"
+)
+
+// Func is a function an expression can call
+type Func func(args []Value) (Value, error)
+
+// Value is a float64, string, bool or map[string]string
+type Value interface{}
+
+// Env contains the variables and functions an expression is evaluated with
+type Env struct {
+	Vars  map[string]Value
+	Funcs map[string]Func
+}
+
+// Expr is a compiled expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parses an expression and checks that every variable and function it uses exists in schema
+// Only the names in schema are checked, not the types of its values
+func Compile(src string, schema Env) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", src, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", src, err)
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("error parsing %q: unexpected %q at %d", src, p.peek().text, p.peek().pos)
+	}
+	if err := check(root, schema); err != nil {
+		return nil, fmt.Errorf("error checking %q: %v", src, err)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// Returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Evaluates the expression
+func (e *Expr) Eval(env Env) (Value, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %q: %v", e.src, err)
+	}
+	return v, nil
+}
+
+// Evaluates an expression that has to be true or false
+func (e *Expr) EvalBool(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("error evaluating %q: expected a bool, got %s", e.src, typeName(v))
+	}
+	return b, nil
+}
+
+// Evaluates an expression that has to be a number
+func (e *Expr) EvalNumber(env Env) (float64, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("error evaluating %q: expected a number, got %s", e.src, typeName(v))
+	}
+	return n, nil
+}
+
+// HELPER for Compile()
+// Checks the variables and functions of an expression exist
+func check(n node, schema Env) error {
+	switch n := n.(type) {
+	case identNode:
+		if _, ok := schema.Vars[n.name]; !ok {
+			return fmt.Errorf("unknown variable %q", n.name)
+		}
+	case callNode:
+		if _, ok := schema.Funcs[n.name]; !ok {
+			return fmt.Errorf("unknown function %q", n.name)
+		}
+		for _, arg := range n.args {
+			if err := check(arg, schema); err != nil {
+				return err
+			}
+		}
+	case memberNode:
+		return check(n.object, schema)
+	case indexNode:
+		if err := check(n.object, schema); err != nil {
+			return err
+		}
+		return check(n.index, schema)
+	case unaryNode:
+		return check(n.operand, schema)
+	case binaryNode:
+		if err := check(n.left, schema); err != nil {
+			return err
+		}
+		return check(n.right, schema)
+	}
+	return nil
+}
+
+// Returns the name of a Value's type for error messages
+func typeName(v Value) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case map[string]string:
+		return "map"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,120 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Env used by the tests
+func testEnv() Env {
+	return Env{
+		Vars: map[string]Value{
+			"name":           "dev-data",
+			"size":           float64(2 << 40),
+			"has_expiration": false,
+			"tags":           map[string]string{"env": "dev", "cost-center": "42"},
+		},
+		Funcs: map[string]Func{
+			"lower": func(args []Value) (Value, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("expected 1 argument")
+				}
+				s, ok := args[0].(string)
+				if !ok {
+					return nil, fmt.Errorf("expected a string")
+				}
+				return strings.ToLower(s), nil
+			},
+		},
+	}
+}
+
+// Test Eval with every operator
+func TestEval(t *testing.T) {
+	cases := []struct {
+		src  string
+		want Value
+	}{
+		{`tags.env == "dev" && size > 1TB && !has_expiration`, true},
+		{`tags["cost-center"] == '42'`, true},
+		{`tags.missing == ""`, true},
+		{`1 + 2 * 3`, float64(7)},
+		{`(1 + 2) * 3`, float64(9)},
+		{`-size / 1TB`, float64(-2)},
+		{`1.5KB`, float64(1536)},
+		{`name + "-x"`, "dev-data-x"},
+		{`lower("DEV") == tags.env`, true},
+		{`false || size >= 2TB`, true},
+		{`"a" < "b" && true != false`, true},
+	}
+	for _, c := range cases {
+		e, err := Compile(c.src, testEnv())
+		if !assert.NoError(t, err, c.src) {
+			continue
+		}
+		v, err := e.Eval(testEnv())
+		assert.NoError(t, err, c.src)
+		assert.Equal(t, c.want, v, c.src)
+	}
+}
+
+// Test && and || skip their right side when the left side decides
+func TestShortCircuit(t *testing.T) {
+	e, err := Compile(`has_expiration && size / 0 > 1`, testEnv())
+	assert.NoError(t, err)
+	b, err := e.EvalBool(testEnv())
+	assert.NoError(t, err)
+	assert.False(t, b)
+
+	e, err = Compile(`!has_expiration || size / 0 > 1`, testEnv())
+	assert.NoError(t, err)
+	b, err = e.EvalBool(testEnv())
+	assert.NoError(t, err)
+	assert.True(t, b)
+}
+
+// Test Compile rejects invalid syntax and unknown names
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		`size >`,
+		`(size > 1`,
+		`size > 1XB`,
+		`"unterminated`,
+		`size # 1`,
+		`size 1`,
+		`unknown > 1`,
+		`unknown_fn(size)`,
+		`lower(unknown)`,
+	} {
+		_, err := Compile(src, testEnv())
+		assert.Error(t, err, src)
+	}
+}
+
+// Test Eval reports type errors
+func TestEvalErrors(t *testing.T) {
+	for _, src := range []string{
+		`size == "big"`,
+		`!size`,
+		`name.env`,
+		`size / 0`,
+		`lower(size)`,
+	} {
+		e, err := Compile(src, testEnv())
+		if !assert.NoError(t, err, src) {
+			continue
+		}
+		_, err = e.Eval(testEnv())
+		assert.Error(t, err, src)
+	}
+
+	e, _ := Compile(`size`, testEnv())
+	_, err := e.EvalBool(testEnv())
+	assert.Error(t, err)
+	e, _ = Compile(`name`, testEnv())
+	_, err = e.EvalNumber(testEnv())
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package lifecycle
+
+//This file totals how much of a bucket's data its lifecycle configuration actually covers
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Coverage contains the bytes of a bucket covered by each kind of lifecycle action
+type Coverage struct {
+	ObjectCount                            int64          `json:"object_count"`
+	DataSize                               int64          `json:"data_size"`
+	TransitionBytes                        int64          `json:"transition_bytes"`
+	ExpirationBytes                        int64          `json:"expiration_bytes"`
+	NoncurrentVersionBytes                 int64          `json:"noncurrent_version_bytes"`
+	AbortIncompleteMultipartUploadBytes    int64          `json:"abort_incomplete_multipart_upload_bytes"`
+	UncoveredObjectCount                   int64          `json:"uncovered_object_count"` //no enabled rule applies
+	UncoveredBytes                         int64          `json:"uncovered_bytes"`
+	TagsUnknownBytes                       int64          `json:"tags_unknown_bytes"` //a tag filtered rule could apply but tags were not retrieved
+	TransitionFraction                     float64        `json:"transition_fraction"`
+	ExpirationFraction                     float64        `json:"expiration_fraction"`
+	NoncurrentVersionFraction              float64        `json:"noncurrent_version_fraction"`
+	AbortIncompleteMultipartUploadFraction float64        `json:"abort_incomplete_multipart_upload_fraction"`
+	Rules                                  []RuleCoverage `json:"rules"`
+}
+
+// RuleCoverage contains the objects one rule applies to
+type RuleCoverage struct {
+	ID          string `json:"id"`
+	Enabled     bool   `json:"enabled"`
+	ObjectCount int64  `json:"object_count"`
+	DataSize    int64  `json:"data_size"`
+}
+
+// Creates an empty Coverage with one RuleCoverage per rule in the configuration
+func NewCoverage(rules []*s3.LifecycleRule) *Coverage {
+	coverage := &Coverage{}
+	for _, rule := range rules {
+		ruleCoverage := RuleCoverage{Enabled: IsEnabled(rule)}
+		if rule.ID != nil {
+			ruleCoverage.ID = *rule.ID
+		}
+		coverage.Rules = append(coverage.Rules, ruleCoverage)
+	}
+	return coverage
+}
+
+// Adds one object to the Coverage
+// rules must be the configuration the Coverage was created with
+func (c *Coverage) Add(rules []*s3.LifecycleRule, obj Object) {
+	c.ObjectCount++
+	c.DataSize += obj.Size
+
+	matchedAny := false
+	for i, rule := range rules {
+		if !IsEnabled(rule) || i >= len(c.Rules) {
+			continue
+		}
+		if matches, _ := RuleMatches(rule, obj); matches {
+			matchedAny = true
+			c.Rules[i].ObjectCount++
+			c.Rules[i].DataSize += obj.Size
+		}
+	}
+	if !matchedAny {
+		c.UncoveredObjectCount++
+		c.UncoveredBytes += obj.Size
+	}
+
+	evaluation := Evaluate(rules, obj)
+	if evaluation.Transition {
+		c.TransitionBytes += obj.Size
+	}
+	if evaluation.Expiration {
+		c.ExpirationBytes += obj.Size
+	}
+	if evaluation.NoncurrentVersion {
+		c.NoncurrentVersionBytes += obj.Size
+	}
+	if evaluation.AbortIncompleteMultipartUpload {
+		c.AbortIncompleteMultipartUploadBytes += obj.Size
+	}
+	if evaluation.TagsUnknown {
+		c.TagsUnknownBytes += obj.Size
+	}
+}
+
+// Calculates the covered fraction of the bucket's bytes for each kind of action
+// Call once every object has been added
+func (c *Coverage) CalculateFractions() {
+	if c.DataSize == 0 {
+		return
+	}
+	size := float64(c.DataSize)
+	c.TransitionFraction = float64(c.TransitionBytes) / size
+	c.ExpirationFraction = float64(c.ExpirationBytes) / size
+	c.NoncurrentVersionFraction = float64(c.NoncurrentVersionBytes) / size
+	c.AbortIncompleteMultipartUploadFraction = float64(c.AbortIncompleteMultipartUploadBytes) / size
+}
+
+// Checks if the configuration transitions or expires any of the bucket's current data
+func (c *Coverage) CoversCurrentData() bool {
+	return c.TransitionBytes > 0 || c.ExpirationBytes > 0
+}
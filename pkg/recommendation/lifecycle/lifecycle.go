@@ -0,0 +1,236 @@
+package lifecycle
+
+//This package evaluates a bucket's lifecycle configuration against its objects
+//It honors rule status, prefix, tag and object size filters and And combinators
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Object contains what the evaluator needs to know about an object
+type Object struct {
+	Key  string
+	Size int64
+	Tags map[string]string //nil when the object's tags were not retrieved
+}
+
+// Evaluation contains the lifecycle actions that apply to one object
+type Evaluation struct {
+	RuleIDs                        []string `json:"rule_ids"`
+	Transition                     bool     `json:"transition"`
+	TransitionDays                 int64    `json:"transition_days"` //earliest transition, 0 if the rule uses a date
+	TransitionStorageClass         string   `json:"transition_storage_class"`
+	Expiration                     bool     `json:"expiration"`
+	ExpirationDays                 int64    `json:"expiration_days"` //earliest expiration, 0 if the rule uses a date
+	NoncurrentVersion              bool     `json:"noncurrent_version"`
+	AbortIncompleteMultipartUpload bool     `json:"abort_incomplete_multipart_upload"`
+	TagsUnknown                    bool     `json:"tags_unknown"` //a tag filtered rule could apply but the object's tags are unknown
+}
+
+// Checks if a rule is enabled
+func IsEnabled(rule *s3.LifecycleRule) bool {
+	return rule != nil && rule.Status != nil && *rule.Status == s3.ExpirationStatusEnabled
+}
+
+// Checks if any rule in the configuration is enabled
+func HasEnabledRule(rules []*s3.LifecycleRule) bool {
+	for _, rule := range rules {
+		if IsEnabled(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if any enabled rule transitions or expires noncurrent versions
+func HasNoncurrentVersionRule(rules []*s3.LifecycleRule) bool {
+	for _, rule := range rules {
+		if IsEnabled(rule) && hasNoncurrentVersionAction(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if any enabled rule expires current objects
+func HasExpirationRule(rules []*s3.LifecycleRule) bool {
+	for _, rule := range rules {
+		if IsEnabled(rule) && rule.Expiration != nil && (rule.Expiration.Days != nil || rule.Expiration.Date != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if any enabled rule aborts incomplete multipart uploads
+func HasAbortIncompleteMultipartUpload(rules []*s3.LifecycleRule) bool {
+	for _, rule := range rules {
+		if IsEnabled(rule) && rule.AbortIncompleteMultipartUpload != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Action is one transition or expiration a rule schedules for an object, a number of days after it was created
+type Action struct {
+	RuleID       string `json:"rule_id"`
+	Days         int64  `json:"days"`
+	StorageClass string `json:"storage_class"` //transition target, empty for an expiration
+}
+
+// Checks if the Action expires the object
+func (a Action) IsExpiration() bool {
+	return a.StorageClass == ""
+}
+
+// Takes in a lifecycle configuration and an object and returns the day-based transitions and expirations
+// of every enabled rule that applies to it, ordered by days
+// Date-based actions are left out since when they run doesn't depend on the object's age
+func Schedule(rules []*s3.LifecycleRule, obj Object) []Action {
+	actions := []Action{}
+
+	for _, rule := range rules {
+		if !IsEnabled(rule) {
+			continue
+		}
+		if matches, _ := RuleMatches(rule, obj); !matches {
+			continue
+		}
+		ruleID := ""
+		if rule.ID != nil {
+			ruleID = *rule.ID
+		}
+		for _, transition := range rule.Transitions {
+			if transition.Days != nil && transition.StorageClass != nil {
+				actions = append(actions, Action{RuleID: ruleID, Days: *transition.Days, StorageClass: *transition.StorageClass})
+			}
+		}
+		if rule.Expiration != nil && rule.Expiration.Days != nil {
+			actions = append(actions, Action{RuleID: ruleID, Days: *rule.Expiration.Days})
+		}
+	}
+
+	//Expirations come before transitions on the same day, S3 skips the transition of an object it expires
+	sort.SliceStable(actions, func(i, j int) bool {
+		if actions[i].Days != actions[j].Days {
+			return actions[i].Days < actions[j].Days
+		}
+		return actions[i].IsExpiration() && !actions[j].IsExpiration()
+	})
+	return actions
+}
+
+// Takes in a lifecycle configuration and an object and returns the actions of every enabled rule that applies to it
+func Evaluate(rules []*s3.LifecycleRule, obj Object) Evaluation {
+	evaluation := Evaluation{}
+
+	for _, rule := range rules {
+		if !IsEnabled(rule) {
+			continue
+		}
+		matches, tagsUnknown := RuleMatches(rule, obj)
+		if tagsUnknown {
+			evaluation.TagsUnknown = true
+		}
+		if !matches {
+			continue
+		}
+
+		if rule.ID != nil {
+			evaluation.RuleIDs = append(evaluation.RuleIDs, *rule.ID)
+		}
+
+		for _, transition := range rule.Transitions {
+			days := int64(0)
+			if transition.Days != nil {
+				days = *transition.Days
+			}
+			if !evaluation.Transition || days < evaluation.TransitionDays {
+				evaluation.TransitionDays = days
+				if transition.StorageClass != nil {
+					evaluation.TransitionStorageClass = *transition.StorageClass
+				}
+			}
+			evaluation.Transition = true
+		}
+
+		if rule.Expiration != nil && (rule.Expiration.Days != nil || rule.Expiration.Date != nil) {
+			days := int64(0)
+			if rule.Expiration.Days != nil {
+				days = *rule.Expiration.Days
+			}
+			if !evaluation.Expiration || days < evaluation.ExpirationDays {
+				evaluation.ExpirationDays = days
+			}
+			evaluation.Expiration = true
+		}
+
+		if hasNoncurrentVersionAction(rule) {
+			evaluation.NoncurrentVersion = true
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			evaluation.AbortIncompleteMultipartUpload = true
+		}
+	}
+
+	return evaluation
+}
+
+// Checks if a rule's filter matches an object, ignoring the rule's status
+// tagsUnknown is true when the rule filters on tags and the object's tags are unknown, the rule is then reported as not matching
+func RuleMatches(rule *s3.LifecycleRule, obj Object) (matches bool, tagsUnknown bool) {
+	//Rules without a Filter use the deprecated top level Prefix
+	if rule.Filter == nil {
+		return rule.Prefix == nil || strings.HasPrefix(obj.Key, *rule.Prefix), false
+	}
+
+	filter := rule.Filter
+	if filter.And != nil {
+		return matchesFilter(obj, filter.And.Prefix, filter.And.Tags, filter.And.ObjectSizeGreaterThan, filter.And.ObjectSizeLessThan)
+	}
+
+	tags := []*s3.Tag{}
+	if filter.Tag != nil {
+		tags = append(tags, filter.Tag)
+	}
+	return matchesFilter(obj, filter.Prefix, tags, filter.ObjectSizeGreaterThan, filter.ObjectSizeLessThan)
+}
+
+// HELPER for RuleMatches()
+// Every condition that is set has to match
+func matchesFilter(obj Object, prefix *string, tags []*s3.Tag, sizeGreaterThan *int64, sizeLessThan *int64) (bool, bool) {
+	if prefix != nil && !strings.HasPrefix(obj.Key, *prefix) {
+		return false, false
+	}
+	if sizeGreaterThan != nil && obj.Size <= *sizeGreaterThan {
+		return false, false
+	}
+	if sizeLessThan != nil && obj.Size >= *sizeLessThan {
+		return false, false
+	}
+
+	if len(tags) == 0 {
+		return true, false
+	}
+	if obj.Tags == nil {
+		return false, true
+	}
+	for _, tag := range tags {
+		if tag.Key == nil || tag.Value == nil {
+			continue
+		}
+		if value, ok := obj.Tags[*tag.Key]; !ok || value != *tag.Value {
+			return false, false
+		}
+	}
+	return true, false
+}
+
+// HELPER for HasNoncurrentVersionRule() and Evaluate()
+func hasNoncurrentVersionAction(rule *s3.LifecycleRule) bool {
+	return len(rule.NoncurrentVersionTransitions) > 0 || rule.NoncurrentVersionExpiration != nil
+}
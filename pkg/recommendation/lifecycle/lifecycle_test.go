@@ -0,0 +1,127 @@
+package lifecycle
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRules() []*s3.LifecycleRule {
+	return []*s3.LifecycleRule{
+		{
+			ID:     aws.String("logs-to-glacier"),
+			Status: aws.String("Enabled"),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+			Transitions: []*s3.Transition{
+				{Days: aws.Int64(90), StorageClass: aws.String("GLACIER")},
+				{Days: aws.Int64(30), StorageClass: aws.String("STANDARD_IA")},
+			},
+		},
+		{
+			ID:     aws.String("expire-big-tmp"),
+			Status: aws.String("Enabled"),
+			Filter: &s3.LifecycleRuleFilter{And: &s3.LifecycleRuleAndOperator{
+				Prefix:                aws.String("tmp/"),
+				ObjectSizeGreaterThan: aws.Int64(100),
+				Tags:                  []*s3.Tag{{Key: aws.String("team"), Value: aws.String("data")}},
+			}},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(7)},
+		},
+		{
+			ID:                             aws.String("disabled-abort"),
+			Status:                         aws.String("Disabled"),
+			Filter:                         &s3.LifecycleRuleFilter{},
+			AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int64(7)},
+		},
+		{
+			ID:                          aws.String("legacy-prefix"),
+			Status:                      aws.String("Enabled"),
+			Prefix:                      aws.String("old/"),
+			NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(30)},
+		},
+		{
+			//missing status must not be treated as enabled
+			ID:         aws.String("no-status"),
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := testRules()
+
+	evaluation := Evaluate(rules, Object{Key: "logs/app.log", Size: 10})
+	assert.Equal(t, []string{"logs-to-glacier"}, evaluation.RuleIDs)
+	assert.True(t, evaluation.Transition)
+	assert.Equal(t, int64(30), evaluation.TransitionDays)
+	assert.Equal(t, "STANDARD_IA", evaluation.TransitionStorageClass)
+	assert.False(t, evaluation.Expiration)
+	assert.False(t, evaluation.AbortIncompleteMultipartUpload)
+
+	//tags unknown, the tag filtered rule can't be matched
+	evaluation = Evaluate(rules, Object{Key: "tmp/big", Size: 1000})
+	assert.False(t, evaluation.Expiration)
+	assert.True(t, evaluation.TagsUnknown)
+
+	evaluation = Evaluate(rules, Object{Key: "tmp/big", Size: 1000, Tags: map[string]string{"team": "data"}})
+	assert.True(t, evaluation.Expiration)
+	assert.Equal(t, int64(7), evaluation.ExpirationDays)
+
+	evaluation = Evaluate(rules, Object{Key: "tmp/small", Size: 10, Tags: map[string]string{"team": "data"}})
+	assert.False(t, evaluation.Expiration)
+
+	evaluation = Evaluate(rules, Object{Key: "old/file", Size: 10})
+	assert.True(t, evaluation.NoncurrentVersion)
+	assert.Equal(t, []string{"legacy-prefix"}, evaluation.RuleIDs)
+}
+
+func TestSchedule(t *testing.T) {
+	rules := append(testRules(), &s3.LifecycleRule{
+		ID:         aws.String("expire-logs"),
+		Status:     aws.String("Enabled"),
+		Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+		Expiration: &s3.LifecycleExpiration{Days: aws.Int64(90)},
+	})
+
+	assert.Equal(t, []Action{
+		{RuleID: "logs-to-glacier", Days: 30, StorageClass: "STANDARD_IA"},
+		{RuleID: "expire-logs", Days: 90},
+		{RuleID: "logs-to-glacier", Days: 90, StorageClass: "GLACIER"},
+	}, Schedule(rules, Object{Key: "logs/app.log", Size: 10}))
+	assert.True(t, Action{Days: 90}.IsExpiration())
+
+	assert.Empty(t, Schedule(rules, Object{Key: "data/file", Size: 10}))
+}
+
+func TestRuleHelpers(t *testing.T) {
+	rules := testRules()
+
+	assert.True(t, HasEnabledRule(rules))
+	assert.True(t, HasNoncurrentVersionRule(rules))
+	assert.False(t, HasAbortIncompleteMultipartUpload(rules))
+	assert.False(t, HasEnabledRule(rules[2:3]))
+	assert.False(t, HasEnabledRule(nil))
+}
+
+func TestCoverage(t *testing.T) {
+	rules := testRules()
+	coverage := NewCoverage(rules)
+
+	coverage.Add(rules, Object{Key: "logs/a", Size: 300})
+	coverage.Add(rules, Object{Key: "tmp/b", Size: 200})
+	coverage.Add(rules, Object{Key: "other/c", Size: 500})
+	coverage.CalculateFractions()
+
+	assert.Equal(t, int64(3), coverage.ObjectCount)
+	assert.Equal(t, int64(1000), coverage.DataSize)
+	assert.Equal(t, int64(300), coverage.TransitionBytes)
+	assert.Equal(t, int64(0), coverage.ExpirationBytes)
+	assert.Equal(t, int64(200), coverage.TagsUnknownBytes)
+	assert.Equal(t, int64(2), coverage.UncoveredObjectCount)
+	assert.Equal(t, int64(700), coverage.UncoveredBytes)
+	assert.InDelta(t, 0.3, coverage.TransitionFraction, 0.0001)
+	assert.Equal(t, int64(1), coverage.Rules[0].ObjectCount)
+	assert.True(t, coverage.CoversCurrentData())
+}
@@ -0,0 +1,197 @@
+package policy
+
+//This package contains the thresholds, keyword lists and extension tables the scans and analyses decide with
+//A policy file replaces the defaults at startup, and each request can override the server's policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy controls how buckets are analyzed, the zero value of a field leaves it to the policy it is merged over
+//
+//	inactive_months: 6
+//	archive_keywords: [backup, archive, snapshot]
+//	compressible_extensions: {.log: .gzip, .ndjson: .gzip}
+//	analyses: [Archive Storage Analysis, Lifecycle Management Analysis]
+type Policy struct {
+	InactiveMonths         int               `json:"inactive_months" yaml:"inactive_months"`                 //months without writes, or access when access logs cover them, after which data is archivable
+	ArchiveKeywords        []string          `json:"archive_keywords" yaml:"archive_keywords"`               //bucket name substrings that mark backups and archives
+	TempStorageKeywords    []string          `json:"temp_storage_keywords" yaml:"temp_storage_keywords"`     //bucket name substrings that mark temporary storage
+	CompressedExtensions   []string          `json:"compressed_extensions" yaml:"compressed_extensions"`     //key suffixes of data that is already compressed
+	CompressibleExtensions map[string]string `json:"compressible_extensions" yaml:"compressible_extensions"` //extension to the compression type its savings are estimated with
+	Analyses               []string          `json:"analyses" yaml:"analyses"`                               //names of the analyses to report, empty reports every analysis
+	RuleFiles              []string          `json:"rule_files" yaml:"rule_files"`                           //paths on the server of YAML or JSON files of user-defined analysis rules
+	RetrievalRate          float64           `json:"retrieval_rate" yaml:"retrieval_rate"`                   //share of transitioned data expected to be read back each month
+	MaxBreakEvenMonths     int               `json:"max_break_even_months" yaml:"max_break_even_months"`     //storage class moves that take longer to pay back their upfront cost aren't recommended
+}
+
+// Returns the built-in policy
+func Default() Policy {
+	return Policy{
+		InactiveMonths:       3,
+		RetrievalRate:        0.01,
+		MaxBreakEvenMonths:   12,
+		ArchiveKeywords:      []string{"backup", "back-up", "archive"},
+		TempStorageKeywords:  []string{"temp", "log", "tmp", "test"},
+		CompressedExtensions: []string{".h264", ".zstd", ".7zip", ".gz", ".gzip", ".zip", ".tar", ".rar", ".bz2", ".bzip2", ".tgz", ".snappy", ".jpeg", ".mp3"},
+		CompressibleExtensions: map[string]string{
+			".txt": ".gzip", ".log": ".gzip", ".md": ".gzip", ".yml": ".gzip", ".yaml": ".gzip", ".xml": ".gzip", ".json": ".gzip",
+			".csv": ".gzip", ".conf": ".gzip", ".py": ".gzip", ".java": ".gzip", ".go": ".gzip", ".js": ".gzip", ".rb": ".gzip",
+			".pl": ".gzip", ".php": ".gzip", ".html": ".gzip", ".css": ".gzip", ".scss": ".gzip", ".less": ".gzip", ".svg": ".gzip", ".pdf": ".gzip", ".par": ".gzip", ".gz": ".gzip",
+			".png": ".jpeg", ".gif": ".jpeg", ".bmp": ".jpeg", ".heif": ".jpeg", ".heic": ".jpeg",
+			".wav": ".mp3", ".aac": ".mp3", ".ogg": ".mp3", ".wma": ".mp3",
+			".mp4": ".h264", ".mov": ".h264", ".avi": ".h264", ".mkv": ".h264",
+			".avro": ".snappy", ".parquet": ".snappy", ".orc": ".snappy",
+			".zst": ".zstd",
+			".7z":  ".7zip",
+		},
+	}
+}
+
+// Reads a Policy from a YAML or JSON file and merges it over the default policy
+func Load(path string) (Policy, error) {
+	override := Policy{}
+	if err := ReadFile(path, &override); err != nil {
+		return override, fmt.Errorf("error loading policy: %v", err)
+	}
+
+	p := Default().Merge(override)
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Reads a YAML or JSON file into v, the format is chosen by the file's extension, JSON unless it is .yaml or .yml
+func ReadFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, v)
+	default:
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return nil
+}
+
+// Returns p with the fields set in override replacing its own
+// Lists are replaced as a whole, compressible extensions are replaced one extension at a time
+func (p Policy) Merge(override Policy) Policy {
+	merged := p
+	if override.InactiveMonths != 0 {
+		merged.InactiveMonths = override.InactiveMonths
+	}
+	if override.ArchiveKeywords != nil {
+		merged.ArchiveKeywords = override.ArchiveKeywords
+	}
+	if override.TempStorageKeywords != nil {
+		merged.TempStorageKeywords = override.TempStorageKeywords
+	}
+	if override.CompressedExtensions != nil {
+		merged.CompressedExtensions = override.CompressedExtensions
+	}
+	if override.CompressibleExtensions != nil {
+		merged.CompressibleExtensions = make(map[string]string, len(p.CompressibleExtensions)+len(override.CompressibleExtensions))
+		for ext, compressionType := range p.CompressibleExtensions {
+			merged.CompressibleExtensions[ext] = compressionType
+		}
+		for ext, compressionType := range override.CompressibleExtensions {
+			merged.CompressibleExtensions[ext] = compressionType
+		}
+	}
+	if override.Analyses != nil {
+		merged.Analyses = override.Analyses
+	}
+	if override.RuleFiles != nil {
+		merged.RuleFiles = override.RuleFiles
+	}
+	if override.RetrievalRate != 0 {
+		merged.RetrievalRate = override.RetrievalRate
+	}
+	if override.MaxBreakEvenMonths != 0 {
+		merged.MaxBreakEvenMonths = override.MaxBreakEvenMonths
+	}
+	return merged
+}
+
+// Checks the thresholds and extension tables
+// An empty compression type turns off compression of an extension the policy is merged over
+func (p Policy) Validate() error {
+	if p.InactiveMonths < 0 {
+		return fmt.Errorf("inactive_months can't be negative")
+	}
+	if p.RetrievalRate < 0 || p.RetrievalRate > 1 {
+		return fmt.Errorf("retrieval_rate has to be between 0 and 1")
+	}
+	if p.MaxBreakEvenMonths < 0 {
+		return fmt.Errorf("max_break_even_months can't be negative")
+	}
+	for _, ext := range p.CompressedExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("compressed extension %q has to start with a dot", ext)
+		}
+	}
+	for ext, compressionType := range p.CompressibleExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("compressible extension %q has to start with a dot", ext)
+		}
+		if compressionType == "" {
+			continue
+		}
+		if _, _, err := estimate.SavingsForBytesCompressedByStorageClass(0, compressionType, "STANDARD"); err != nil {
+			return fmt.Errorf("compressible extension %q: %v", ext, err)
+		}
+	}
+	return nil
+}
+
+// Checks if an Analysis is reported
+func (p Policy) AnalysisEnabled(name string) bool {
+	if len(p.Analyses) == 0 {
+		return true
+	}
+	for _, analysis := range p.Analyses {
+		if analysis == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if a key ends in the extension of data that is already compressed
+func (p Policy) IsCompressed(key string) bool {
+	for _, ext := range p.CompressedExtensions {
+		if strings.HasSuffix(key, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the compression type savings of an extension are estimated with, empty if it isn't compressible
+func (p Policy) CompressionType(extension string) string {
+	return p.CompressibleExtensions[extension]
+}
+
+// Checks if a bucket name contains one of the keywords, compared case-insensitively
+func ContainsKeyword(bucketName string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(strings.ToLower(bucketName), strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
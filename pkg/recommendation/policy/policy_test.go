@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test Load with YAML and JSON policy files
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "policy.yaml")
+	assert.NoError(t, os.WriteFile(yamlPath, []byte(`
+inactive_months: 6
+archive_keywords: [snapshot]
+compressible_extensions:
+  .ndjson: .gzip
+  .png: ""
+analyses: [Archive Storage Analysis]
+`), 0644))
+	p, err := Load(yamlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, p.InactiveMonths)
+	assert.Equal(t, []string{"snapshot"}, p.ArchiveKeywords)
+	assert.Equal(t, Default().TempStorageKeywords, p.TempStorageKeywords, "fields left out keep their default")
+	assert.Equal(t, ".gzip", p.CompressionType(".ndjson"))
+	assert.Equal(t, ".gzip", p.CompressionType(".csv"), "extensions left out keep their default")
+	assert.Equal(t, "", p.CompressionType(".png"), "an empty compression type turns an extension off")
+	assert.True(t, p.AnalysisEnabled("Archive Storage Analysis"))
+	assert.False(t, p.AnalysisEnabled("Bucket Versioning Analysis"))
+
+	jsonPath := filepath.Join(dir, "policy.json")
+	assert.NoError(t, os.WriteFile(jsonPath, []byte(`{"temp_storage_keywords": ["scratch"]}`), 0644))
+	p, err = Load(jsonPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"scratch"}, p.TempStorageKeywords)
+	assert.Equal(t, 3, p.InactiveMonths)
+	assert.True(t, p.AnalysisEnabled("Bucket Versioning Analysis"), "an empty list enables every analysis")
+
+	badPath := filepath.Join(dir, "bad.json")
+	assert.NoError(t, os.WriteFile(badPath, []byte(`{"compressible_extensions": {".log": ".lzma"}}`), 0644))
+	_, err = Load(badPath)
+	assert.Error(t, err)
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+// Test Merge leaves the policy it is called on unchanged
+func TestMerge(t *testing.T) {
+	base := Default()
+	merged := base.Merge(Policy{CompressibleExtensions: map[string]string{".ndjson": ".gzip"}})
+	assert.Equal(t, ".gzip", merged.CompressionType(".ndjson"))
+	assert.Equal(t, "", base.CompressionType(".ndjson"))
+	assert.Equal(t, base, base.Merge(Policy{}))
+}
+
+// Test Validate
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Default().Validate())
+	assert.Error(t, Policy{InactiveMonths: -1}.Validate())
+	assert.Error(t, Policy{RetrievalRate: 1.5}.Validate())
+	assert.Error(t, Policy{MaxBreakEvenMonths: -1}.Validate())
+	assert.Error(t, Policy{CompressedExtensions: []string{"gz"}}.Validate())
+	assert.Error(t, Policy{CompressibleExtensions: map[string]string{"log": ".gzip"}}.Validate())
+}
+
+// Test the default extension tables and keywords
+func TestDefaults(t *testing.T) {
+	p := Default()
+	assert.True(t, p.IsCompressed("logs/app.log.gz"))
+	assert.False(t, p.IsCompressed("logs/app.log"))
+	assert.Equal(t, ".snappy", p.CompressionType(".parquet"))
+	assert.True(t, ContainsKeyword("Nightly-Backup", p.ArchiveKeywords))
+	assert.False(t, ContainsKeyword("web-assets", p.TempStorageKeywords))
+}
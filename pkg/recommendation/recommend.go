@@ -3,8 +3,12 @@ package recommendation
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/analyze"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/scan"
+	"github.com/helloevanhere/simple_saver_service/pkg/workload"
 )
 
 type Recommendation struct {
@@ -30,99 +34,224 @@ func CreateRecommendationReport(analyses []analyze.Analysis) ([]Recommendation,
 			return recs, err
 		}
 
-		rec.Recs, err = createRecommendations(analysis.Name, len(rec.TargetBuckets))
+		rec.Recs, err = createRecommendations(analysis, len(rec.TargetBuckets))
 		if err != nil {
 			return recs, err
 		}
+		rec.Recs = append(rec.Recs, objectLockRecs(analysis)...)
+		rec.Recs = append(rec.Recs, proposedRuleRecs(analysis)...)
+		rec.Recs = append(rec.Recs, tieringRecs(analysis)...)
+		rec.Recs = append(rec.Recs, earlyDeletionRecs(analysis)...)
+		rec.Recs = append(rec.Recs, customRuleRecs(analysis)...)
 		recs = append(recs, rec)
 	}
 	return recs, nil
 }
 
-func createRecommendations(name string, bucketsImpacted int) ([]Rec, error) {
-	recs := []Rec{}
-	if bucketsImpacted > 0 {
-		switch name {
-		case "Archive Storage Analysis":
-			recs = []Rec{
-				{
-					Level: "Simple Saver Suggestion",
-					Text:  "We suggest updating the storage class of objects in these buckets to S3 Glacier Storage.",
-				},
-				{
-					Level: "Super Saver Suggestion",
-					Text:  "We suggest enabling lifecycle rules that automatically move older or infrequently accessed data to better suited storage class.",
-				},
-			}
-		case "Bucket Versioning Analysis":
-			recs = []Rec{
-				{
-					Level: "Simple Saver Suggestion",
-					Text:  "We suggest reviewing the purpose and content of these buckets to determine if versioning is necessary.",
-				},
-				{
-					Level: "Super Saver Suggestion",
-					Text:  "We suggest enabling lifecycle rules that limit the number of versions per object.",
-				},
-			}
+// Recommendations for each Analysis, keyed by Analysis name
+var recommendations = map[string][]Rec{
+	"Archive Storage Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest updating the storage class of objects in these buckets to S3 Glacier Storage.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest enabling lifecycle rules that automatically move older or infrequently accessed data to better suited storage class.",
+		},
+	},
+	"Bucket Versioning Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest reviewing the purpose and content of these buckets to determine if versioning is necessary.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest enabling lifecycle rules that limit the number of versions per object.",
+		},
+	},
+	"Lifecycle Management Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest enabling lifecycle rules that automatically move older or infrequently accessed data to better suited storage class.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest utilizing lifecycle filters to more precisely set lifecycle rules, such as only transitioning objects with a certain prefix.",
+		},
+	},
+	"Temporary Storage Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest enabling an Expiration Policy for buckets containing temporary data.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest disabling bucket versioning for buckets containing temporary data.",
+		},
+	},
+	"Compressed Data Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest compressing objects in the listed buckets.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest compressing data prior to storing in S3.",
+		},
+	},
+	"Duplicate Data Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest deleting duplicate objects.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest de-duplicating data prior to storing in S3, or enabling Bucket Versioning and Lifecycle Policies.",
+		},
+	},
+	"Cross-Bucket Duplicate Data Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest deleting the copies of objects that are already stored in another bucket, unless they are kept there on purpose as a backup.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest having pipelines read from the canonical bucket instead of copying data between raw, staging and backup buckets, or moving intentional backup copies to a colder storage class.",
+		},
+	},
+	"Intelligent-Tiering Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest moving objects of 128 KB or more with unknown or changing access patterns to the Intelligent-Tiering storage class, which moves them between access tiers without retrieval fees. Objects smaller than 128 KB are left out since Intelligent-Tiering never tiers them.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest enabling the Archive Access and Deep Archive Access tiers in the bucket's Intelligent-Tiering configuration for data that can wait hours to be restored, and keeping lifecycle transitions for data with a predictable access pattern, where they avoid the monitoring fee.",
+		},
+	},
+	"Replication Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest setting a colder destination storage class, such as STANDARD_IA or GLACIER, on replication rules whose replicas are only kept for backup or disaster recovery.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest adding lifecycle rules to destination buckets for prefixes that expire at the source, since expirations are not replicated, and replicating from the source to every destination directly instead of replicating replicas again.",
+		},
+	},
+	"KMS Request Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest enabling S3 Bucket Keys in the bucket's default encryption, which cuts the KMS requests of new objects by up to 99%. Existing objects keep making a KMS request per access until they are copied in place.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest copying frequently read objects in place after enabling Bucket Keys, or using SSE-S3 for data that doesn't need a customer managed key, which has no KMS request cost.",
+		},
+	},
+	"Bucket Configuration Audit": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest turning off inventory reports, Storage Class Analysis and request metrics nobody reads, and adding a lifecycle rule expiring server access logs in their target bucket.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest switching daily inventory reports to weekly, scoping Storage Class Analysis and request metrics to the prefixes you are deciding on, and disabling Transfer Acceleration where clients are close to the bucket's region.",
+		},
+	},
+	"Restored Archive Copy Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest restoring archived objects for only as many days as they are needed, since the restored copy is billed at the STANDARD price until it expires.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest copying objects that are restored again and again to a warmer storage class, such as STANDARD_IA or GLACIER_IR, instead of paying for repeated restores and temporary copies.",
+		},
+	},
+	"Small Archive Object Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest keeping objects below the break-even size out of GLACIER and DEEP_ARCHIVE, for example with an object size filter on lifecycle transitions, since each archived object is billed for about 40 KB of metadata.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest aggregating small objects into larger archives, such as tar or zip files, before archiving them, so the metadata overhead is paid once per archive instead of once per object.",
+		},
+	},
+	"Abandoned Bucket Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest deleting empty buckets and buckets left with only noncurrent versions and delete markers, after confirming nothing still writes to them.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest moving the data of abandoned and orphaned buckets to DEEP_ARCHIVE with a lifecycle rule if it has to be kept, and deleting the buckets once their owners confirm it doesn't.",
+		},
+	},
+	"Small File Consolidation Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest compacting small objects into objects of about the listed target size, for example 128 MB, before transitioning them, since IA and Glacier Instant Retrieval bill every object as at least 128 KB and transitions are charged per object.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest batching writes in the producers of log and event data, for example with Kinesis Data Firehose buffering or periodic compaction jobs, so small objects aren't written in the first place.",
+		},
+	},
+	"Archive Tiering Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest adding lifecycle transitions for the listed cohorts to the storage class they save the most in, STANDARD_IA after 30 days, GLACIER_IR after 90, GLACIER after 180 or DEEP_ARCHIVE after 365, with an object size filter for objects too small to save after transition fees and archive overhead.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest tiering data in steps as it ages, for example STANDARD_IA then GLACIER_IR then DEEP_ARCHIVE, and keeping data that is still read in GLACIER_IR rather than GLACIER or DEEP_ARCHIVE, whose retrievals take hours and are charged per GB.",
+		},
+	},
+	"Early Deletion Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest moving the listed expirations and transitions to at least the suggested days, so objects stay in STANDARD_IA and ONEZONE_IA for 30 days, GLACIER_IR and GLACIER for 90 and DEEP_ARCHIVE for 180 before they leave.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest leaving short-lived data in STANDARD instead of transitioning it shortly before it expires, and writing data straight to an archive storage class only when it is kept past the class's minimum duration.",
+		},
+	},
+	"Incomplete Data Analysis": {
+		{
+			Level: "Simple Saver Suggestion",
+			Text:  "We suggest deleting incomplete multipart uploads in the listed buckets.",
+		},
+		{
+			Level: "Super Saver Suggestion",
+			Text:  "We suggest enabling Expire Incomplete Multipart Uploads in your bucket's lifecycle policy.",
+		},
+	},
+}
 
-		case "Lifecycle Management Analysis":
-			recs = []Rec{
-				{
-					Level: "Simple Saver Suggestion",
-					Text:  "We suggest enabling lifecycle rules that automatically move older or infrequently accessed data to better suited storage class.",
-				},
-				{
-					Level: "Super Saver Suggestion",
-					Text:  "We suggest utilizing lifecycle filters to more precisely set lifecycle rules, such as only transitioning objects with a certain prefix.",
-				},
-			}
+var recommendationsMu sync.RWMutex
 
-		case "Temporary Storage Analysis":
-			recs = []Rec{
-				{
-					Level: "Simple Saver Suggestion",
-					Text:  "We suggest enabling an Expiration Policy for buckets containing temporary data.",
-				},
-				{
-					Level: "Super Saver Suggestion",
-					Text:  "We suggest disabling bucket versioning for buckets containing temporary data.",
-				},
-			}
-		case "Compressed Data Analysis":
-			recs = []Rec{
-				{
-					Level: "Simple Saver Suggestion",
-					Text:  "We suggest compressing objects in the listed buckets.",
-				},
-				{
-					Level: "Super Saver Suggestion",
-					Text:  "We suggest compressing data prior to storing in S3.",
-				},
-			}
-		case "Duplicate Data Analysis":
-			recs = []Rec{
-				{
-					Level: "Simple Saver Suggestion",
-					Text:  "We suggest deleting duplicate objects.",
-				},
-				{
-					Level: "Super Saver Suggestion",
-					Text:  "We suggest de-duplicating data prior to storing in S3, or enabling Bucket Versioning and Lifecycle Policies.",
-				},
-			}
-		case "Incomplete Data Analysis":
-			recs = []Rec{
-				{
-					Level: "Simple Saver Suggestion",
-					Text:  "We suggest deleting incomplete multipart uploads in the listed buckets.",
-				},
-				{
-					Level: "Super Saver Suggestion",
-					Text:  "We suggest enabling Expire Incomplete Multipart Uploads in your bucket's lifecycle policy.",
-				},
-			}
-		}
+// Registers the recommendations reported when an Analysis has target buckets
+// Intended to be called from an init() function alongside analyze.RegisterObjectAnalysis
+func RegisterRecommendations(analysisName string, recs []Rec) {
+	recommendationsMu.Lock()
+	defer recommendationsMu.Unlock()
+
+	recommendations[analysisName] = recs
+}
+
+func createRecommendations(analysis analyze.Analysis, bucketsImpacted int) ([]Rec, error) {
+	name := analysis.Name
+	recs := []Rec{}
+	if bucketsImpacted > 0 {
+		recommendationsMu.RLock()
+		//Copied so workload advice isn't appended to the shared recommendations
+		recs = append(recs, recommendations[name]...)
+		recommendationsMu.RUnlock()
+		recs = append(recs, workloadRecs(analysis)...)
 
 	} else {
 		recs = []Rec{
@@ -138,6 +267,31 @@ func createRecommendations(name string, bucketsImpacted int) ([]Rec, error) {
 
 }
 
+// Returns the advice of every workload among the buckets of an Analysis, for the workloads that have advice for it
+func workloadRecs(analysis analyze.Analysis) []Rec {
+	recs := []Rec{}
+
+	buckets := make(map[string][]string)
+	for _, result := range analysis.AnalysisResults {
+		bucket := result.GetBucketSummary()
+		if bucket.Workload != "" {
+			buckets[bucket.Workload] = append(buckets[bucket.Workload], bucket.Name)
+		}
+	}
+
+	for _, fingerprint := range workload.Fingerprints {
+		advice, ok := fingerprint.Advice[analysis.Name]
+		if !ok || len(buckets[fingerprint.Name]) == 0 {
+			continue
+		}
+		recs = append(recs, Rec{
+			Level: "Workload Suggestion",
+			Text:  fmt.Sprintf("%s hold %s. %s", strings.Join(buckets[fingerprint.Name], ", "), fingerprint.Description, advice),
+		})
+	}
+	return recs
+}
+
 func collectTargetBuckets(analysis analyze.Analysis) ([]string, error) {
 	targetBuckets := []string{}
 
@@ -152,3 +306,139 @@ func collectTargetBuckets(analysis analyze.Analysis) ([]string, error) {
 	return targetBuckets, nil
 
 }
+
+// Returns a notice for every bucket in an Analysis holding data Object Lock keeps from being deleted
+func objectLockRecs(analysis analyze.Analysis) []Rec {
+	recs := []Rec{}
+
+	for _, result := range analysis.AnalysisResults {
+		bucket := result.GetBucketSummary().Name
+		switch r := result.(type) {
+		case analyze.ObjectAnalysisResult:
+			lock := r.Data.ObjectLock
+			if lock == nil {
+				continue
+			}
+			text := fmt.Sprintf("%d objects (%d bytes) in %s are locked by Object Lock and are not counted in the estimated savings.", lock.LockedObjectCount, lock.LockedSize, bucket)
+			if !lock.DeletableAfter.IsZero() {
+				text += fmt.Sprintf(" Their retention ends by %s.", lock.DeletableAfter.Format("2006-01-02"))
+			}
+			if lock.LegalHoldCount > 0 {
+				text += fmt.Sprintf(" %d of them are under legal hold and can only be deleted once the hold is removed.", lock.LegalHoldCount)
+			}
+			recs = append(recs, Rec{Level: "Object Lock Notice", Text: text})
+		case analyze.LifecycleAnalysisResult:
+			if r.ObjectLock == nil || !r.ObjectLock.Enabled {
+				continue
+			}
+			//Without a default retention objects can still be written with their own retention or legal hold
+			text := fmt.Sprintf("Object Lock is enabled in %s, lifecycle expiration can't delete objects under a retention period or legal hold until it ends.", bucket)
+			if r.ObjectLock.RetentionDays > 0 {
+				text = fmt.Sprintf("New objects in %s are retained for %d days in %s mode, lifecycle expiration can't delete them sooner.", bucket, r.ObjectLock.RetentionDays, r.ObjectLock.Mode)
+			}
+			recs = append(recs, Rec{Level: "Object Lock Notice", Text: text})
+		}
+	}
+	return recs
+}
+
+// Returns the recommendation of a user-defined rule, and the prefixes it is true for
+func customRuleRecs(analysis analyze.Analysis) []Rec {
+	recs := []Rec{}
+
+	for i, result := range analysis.AnalysisResults {
+		r, ok := result.(analyze.RuleAnalysisResult)
+		if !ok {
+			continue
+		}
+		//Every result of a rule has the same recommendation
+		if i == 0 && r.Recommendation != "" {
+			recs = append(recs, Rec{Level: r.Level, Text: r.Recommendation})
+		}
+		if len(r.Prefixes) == 0 {
+			continue
+		}
+		prefixes := []string{}
+		for _, p := range r.Prefixes {
+			prefixes = append(prefixes, fmt.Sprintf("%q", p.Prefix))
+		}
+		recs = append(recs, Rec{
+			Level: r.Level,
+			Text:  fmt.Sprintf("Applies in %s to the prefixes %s.", r.BucketSummary.Name, strings.Join(prefixes, ", ")),
+		})
+	}
+	return recs
+}
+
+// Returns the date-prefix-aware lifecycle rules proposed for every bucket in an Analysis
+func proposedRuleRecs(analysis analyze.Analysis) []Rec {
+	recs := []Rec{}
+
+	for _, result := range analysis.AnalysisResults {
+		r, ok := result.(analyze.LifecycleAnalysisResult)
+		if !ok {
+			continue
+		}
+		bucket := r.BucketSummary.Name
+		for _, rule := range r.ProposedRules {
+			text := fmt.Sprintf("In %s, transition objects with the prefix %q to %s after %d days, %d objects (%d bytes): %s.", bucket, rule.Prefix, rule.StorageClass, rule.Days, rule.ObjectCount, rule.DataSize, rule.Reason)
+			if rule.Days == 0 {
+				text = fmt.Sprintf("In %s, transition objects with the prefix %q to %s now with a one-time rule, %d objects (%d bytes): %s.", bucket, rule.Prefix, rule.StorageClass, rule.ObjectCount, rule.DataSize, rule.Reason)
+			}
+			recs = append(recs, Rec{Level: "Proposed Lifecycle Rule", Text: text + " " + costBenefitText(rule.TransitionEstimate)})
+		}
+	}
+	return recs
+}
+
+// Returns the storage class each group of objects in an Archive Tiering Analysis saves the most in, and what moving them costs
+func tieringRecs(analysis analyze.Analysis) []Rec {
+	recs := []Rec{}
+
+	for _, result := range analysis.AnalysisResults {
+		r, ok := result.(analyze.ObjectAnalysisResult)
+		if !ok {
+			continue
+		}
+		detail, ok := r.Data.Details.(*scan.ArchiveTieringDetail)
+		if !ok {
+			continue
+		}
+		for _, target := range detail.Targets {
+			recs = append(recs, Rec{
+				Level: "Storage Class Transition",
+				Text:  fmt.Sprintf("In %s, transition %d objects (%d bytes) to %s. %s", r.BucketSummary.Name, target.ObjectCount, target.DataSize, target.StorageClass, costBenefitText(target.TransitionEstimate)),
+			})
+		}
+	}
+	return recs
+}
+
+// HELPER for proposedRuleRecs() and tieringRecs()
+func costBenefitText(e estimate.TransitionEstimate) string {
+	return fmt.Sprintf("Moving them costs $%.2f upfront, saves $%.2f a month and breaks even in %.1f months.", e.UpfrontCost, e.MonthlyDelta, e.BreakEvenMonths)
+}
+
+// Returns the corrected timing of every lifecycle action in an Early Deletion Analysis that is billed for early deletion
+func earlyDeletionRecs(analysis analyze.Analysis) []Rec {
+	recs := []Rec{}
+
+	for _, result := range analysis.AnalysisResults {
+		r, ok := result.(analyze.ObjectAnalysisResult)
+		if !ok {
+			continue
+		}
+		detail, ok := r.Data.Details.(*scan.EarlyDeletionDetail)
+		if !ok {
+			continue
+		}
+		for _, finding := range detail.Findings {
+			recs = append(recs, Rec{
+				Level: "Lifecycle Timing Correction",
+				Text: fmt.Sprintf("In %s, the rule %q runs %s after %d days, %d days after %d objects (%d bytes) enter %s, which bills at least %d days. Run it after %d days or later to avoid about $%.2f of one-time early deletion charges on the objects stored now.",
+					r.BucketSummary.Name, finding.RuleID, finding.Action, finding.Days, finding.Days-finding.EnteredDays, finding.ObjectCount, finding.DataSize, finding.StorageClass, finding.MinimumDays, finding.SuggestedDays, finding.Penalty),
+			})
+		}
+	}
+	return recs
+}
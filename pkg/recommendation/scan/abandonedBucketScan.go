@@ -0,0 +1,240 @@
+package scan
+
+//This file scans for buckets nobody uses anymore: empty, abandoned, or owned by a decommissioned owner
+//Versions are only listed for buckets without current objects, to find what they still pay for
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+)
+
+// Months without writes, or access when access logs cover them, after which a bucket is abandoned
+const defaultAbandonedAfterMonths = 6
+
+// Classifications of the abandoned_bucket scan
+const (
+	BucketEmpty     = "empty"     //no objects, versions or delete markers
+	BucketAbandoned = "abandoned" //no writes or access in the threshold, or only noncurrent versions and delete markers left
+	BucketOrphaned  = "orphaned"  //tagged with a decommissioned owner
+)
+
+// AbandonedBucketDetail is the ObjectScan.Details of the abandoned_bucket scan
+// ObjectCount and DataSize of the ObjectScan are the current objects and noncurrent versions left in the bucket
+type AbandonedBucketDetail struct {
+	Classifications        []string          `json:"classifications"`
+	LastWriteAt            time.Time         `json:"last_write_at"`            //zero if nothing was ever written
+	LastAccessAt           *time.Time        `json:"last_access_at,omitempty"` //from access logs, when they cover the threshold
+	NoncurrentVersionCount int64             `json:"noncurrent_version_count"` //listed only when there are no current objects
+	NoncurrentVersionSize  int64             `json:"noncurrent_version_size"`
+	DeleteMarkerCount      int64             `json:"delete_marker_count"`
+	OrphanedTags           map[string]string `json:"orphaned_tags,omitempty"` //tags naming a decommissioned owner
+	MonthlyCost            float64           `json:"monthly_cost"`            //storage cost of leaving the bucket as it is
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:                "abandoned_bucket",
+		Permissions:         []string{"s3:ListBucket", "s3:GetBucketTagging"},
+		OptionalPermissions: []string{"s3:ListBucketVersions", "s3:GetObjectRetention", "s3:GetObjectLegalHold"},
+		New: func(ctx ScanContext) Scanner {
+			listVersions := func(tally *versionTally) error {
+				return objectVersionsScan(ctx.Session, ctx.Bucket.Name, tally)
+			}
+			return newAbandonedBucketScanner(ctx, listVersions, time.Now())
+		},
+	})
+}
+
+// Scanner that classifies a bucket as empty, abandoned or orphaned
+type abandonedBucketScanner struct {
+	versioning     string
+	tags           map[string]string
+	access         *access.AccessDetail
+	decommissioned []string
+	ownerTagKeys   []string
+	months         int
+	policy         policy.Policy
+	listVersions   func(tally *versionTally) error
+	locks          *lockChecker
+	now            time.Time
+
+	objectCount   int64
+	lastWriteAt   time.Time
+	classes       map[string]StorageClassTotal
+	lockedClasses map[string]StorageClassTotal //data Object Lock keeps from being deleted with the bucket
+}
+
+func newAbandonedBucketScanner(ctx ScanContext, listVersions func(tally *versionTally) error, now time.Time) *abandonedBucketScanner {
+	s := &abandonedBucketScanner{
+		versioning:     ctx.BucketScan.VersioningStatus,
+		tags:           ctx.BucketScan.Tags,
+		access:         ctx.Access,
+		decommissioned: ctx.Options.DecommissionedOwners,
+		ownerTagKeys:   ctx.Options.OwnerTagKeys(),
+		months:         ctx.Options.AbandonedAfterMonths,
+		policy:         ctx.Options.EffectivePolicy(),
+		listVersions:   listVersions,
+		locks:          newLockChecker(ctx, now),
+		now:            now,
+		classes:        make(map[string]StorageClassTotal),
+		lockedClasses:  make(map[string]StorageClassTotal),
+	}
+	if s.months == 0 {
+		s.months = defaultAbandonedAfterMonths
+	}
+	return s
+}
+
+func (s *abandonedBucketScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		s.objectCount++
+		if object.LastModified != nil && object.LastModified.After(s.lastWriteAt) {
+			s.lastWriteAt = *object.LastModified
+		}
+
+		storageClass := "STANDARD"
+		if object.StorageClass != nil {
+			storageClass = *object.StorageClass
+		}
+		total := s.classes[storageClass]
+		total.ObjectCount++
+		total.DataSize += *object.Size
+		s.classes[storageClass] = total
+
+		locked, err := s.locks.locked(*object.Key, *object.Size, aws.TimeValue(object.LastModified))
+		if err != nil {
+			return err
+		}
+		if locked {
+			lockedTotal := s.lockedClasses[storageClass]
+			lockedTotal.ObjectCount++
+			lockedTotal.DataSize += *object.Size
+			s.lockedClasses[storageClass] = lockedTotal
+		}
+	}
+	return nil
+}
+
+// Min savings archive what is left to DEEP_ARCHIVE when the move breaks even in time
+// Max savings delete the bucket, data Object Lock keeps from being deleted is archived instead
+func (s *abandonedBucketScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "abandoned_bucket"}
+	detail := &AbandonedBucketDetail{LastWriteAt: s.lastWriteAt}
+
+	//A bucket without current objects can still pay for noncurrent versions
+	onlyVersions := false
+	if s.objectCount == 0 && s.versioning != "Not Enabled" {
+		tally := newVersionTally(s.now, s.locks)
+		if err := s.listVersions(tally); err != nil {
+			return objectScan, err
+		}
+		versions := tally.detail
+		detail.NoncurrentVersionCount = versions.NoncurrentVersionCount
+		detail.NoncurrentVersionSize = versions.NoncurrentVersionSize
+		detail.DeleteMarkerCount = versions.DeleteMarkerCount
+		detail.LastWriteAt = versions.LastModifiedAt
+		for storageClass, total := range versions.NoncurrentStorageClasses {
+			classTotal := s.classes[storageClass]
+			classTotal.ObjectCount += total.ObjectCount
+			classTotal.DataSize += total.DataSize
+			s.classes[storageClass] = classTotal
+		}
+		for storageClass, total := range tally.lockedClasses {
+			lockedTotal := s.lockedClasses[storageClass]
+			lockedTotal.ObjectCount += total.ObjectCount
+			lockedTotal.DataSize += total.DataSize
+			s.lockedClasses[storageClass] = lockedTotal
+		}
+		onlyVersions = versions.KeyCount > 0
+	}
+
+	if s.objectCount == 0 && !onlyVersions {
+		detail.Classifications = append(detail.Classifications, BucketEmpty)
+	} else if onlyVersions || s.isInactive(detail) {
+		detail.Classifications = append(detail.Classifications, BucketAbandoned)
+	}
+	detail.OrphanedTags = s.orphanedTags()
+	if len(detail.OrphanedTags) > 0 {
+		detail.Classifications = append(detail.Classifications, BucketOrphaned)
+	}
+	if len(detail.Classifications) == 0 {
+		return objectScan, nil
+	}
+
+	for storageClass, total := range s.classes {
+		//Storage classes without a known price, ex. OUTPOSTS, are left out of the cost
+		cost, err := estimate.CurrentStorageCost(total.DataSize, storageClass)
+		if err != nil {
+			continue
+		}
+		detail.MonthlyCost += cost
+		locked := s.lockedClasses[storageClass]
+		lockedCost, _ := estimate.CurrentStorageCost(locked.DataSize, storageClass)
+		objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += cost - lockedCost
+		if archive, ok := s.archiveEstimate(storageClass, locked); ok && locked.ObjectCount > 0 {
+			objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += archive.MonthlyDelta
+		}
+		if archive, ok := s.archiveEstimate(storageClass, total); ok {
+			objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin += archive.MonthlyDelta
+		}
+		objectScan.ObjectCount += total.ObjectCount
+		objectScan.DataSize += total.DataSize
+	}
+	objectScan.ObjectLock = s.locks.lockSummary()
+	objectScan.Details = detail
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Returns the cost-benefit of moving a storage class's data to DEEP_ARCHIVE, ok is false when it doesn't break even in time
+func (s *abandonedBucketScanner) archiveEstimate(storageClass string, total StorageClassTotal) (estimate.TransitionEstimate, bool) {
+	temperature, known := storageClassTemperatures[storageClass]
+	if !known || temperature >= storageClassTemperatures["DEEP_ARCHIVE"] {
+		return estimate.TransitionEstimate{}, false
+	}
+	e, err := estimate.EstimateTransition(total.ObjectCount, total.DataSize, storageClass, "DEEP_ARCHIVE", s.policy.RetrievalRate, 0)
+	if err != nil {
+		return estimate.TransitionEstimate{}, false
+	}
+	return e, e.BreaksEvenWithin(s.policy.MaxBreakEvenMonths)
+}
+
+// HELPER for Finalize()
+// Checks for no writes in the threshold, and no access either when access logs cover it
+func (s *abandonedBucketScanner) isInactive(detail *AbandonedBucketDetail) bool {
+	cutoff := s.now.AddDate(0, -s.months, 0)
+	if detail.LastWriteAt.After(cutoff) {
+		return false
+	}
+	if s.access != nil && s.access.Covers(s.now.Sub(cutoff)) {
+		lastAccessAt := s.access.LastAccessAt
+		detail.LastAccessAt = &lastAccessAt
+		return !lastAccessAt.After(cutoff)
+	}
+	return true
+}
+
+// HELPER for Finalize()
+// Returns the owner tags whose value is a decommissioned owner, compared case-insensitively
+// Only the owner tag keys count, so an unrelated tag that happens to share an owner's name doesn't orphan the bucket
+func (s *abandonedBucketScanner) orphanedTags() map[string]string {
+	orphaned := make(map[string]string)
+	for _, key := range s.ownerTagKeys {
+		value, ok := s.tags[key]
+		if !ok {
+			continue
+		}
+		for _, owner := range s.decommissioned {
+			if strings.EqualFold(value, owner) {
+				orphaned[key] = value
+			}
+		}
+	}
+	return orphaned
+}
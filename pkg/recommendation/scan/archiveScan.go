@@ -0,0 +1,328 @@
+package scan
+
+//This file scans objects in the archive storage classes, GLACIER and DEEP_ARCHIVE, for costs on top of their data
+//Restored objects keep a temporary copy billed at the STANDARD price, read with HeadObject on a bounded sample when RestoreSampling is set
+//Every archived object pays for ~40 KB of metadata, which outweighs the archive discount of small objects
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+)
+
+// Defaults for restore sampling
+const (
+	defaultRestoreSampleObjects = 100
+	restoreUseDays              = 7 //days a restored copy is assumed to be needed for
+	restoreMonthDays            = 30
+)
+
+// RestoredObjectDetail is the ObjectScan.Details of the restored_objects scan
+// ObjectCount and DataSize of the ObjectScan are the estimated archived objects with a restored copy
+type RestoredObjectDetail struct {
+	ArchivedObjects  int64   `json:"archived_objects"`
+	ArchivedSize     int64   `json:"archived_size"`
+	SampledObjects   int64   `json:"sampled_objects"`
+	RestoredSamples  int64   `json:"restored_samples"`  //sampled objects with a restored copy
+	OngoingRestores  int64   `json:"ongoing_restores"`  //sampled objects still being restored, not billed yet
+	RestoredFraction float64 `json:"restored_fraction"` //fraction of the archived bytes with a restored copy
+	AverageDaysLeft  float64 `json:"average_days_left"` //days until the restored copies expire, weighted by size
+	MonthlyCost      float64 `json:"monthly_cost"`      //cost of the restored copies over the next 30 days
+}
+
+// SmallArchiveDetail is the ObjectScan.Details of the small_archive_objects scan
+// ObjectCount and DataSize of the ObjectScan are the archived objects below their storage class's break-even size
+type SmallArchiveDetail struct {
+	StorageClasses map[string]SmallArchiveClass `json:"storage_classes"`
+}
+
+// SmallArchiveClass contains the per-object overhead of one archive storage class
+type SmallArchiveClass struct {
+	ObjectCount       int64   `json:"object_count"`
+	OverheadCost      float64 `json:"overhead_cost"`   //monthly cost of the metadata of every object in the class
+	BreakEvenSize     int64   `json:"break_even_size"` //objects smaller than this cost more than in STANDARD
+	SmallObjectCount  int64   `json:"small_object_count"`
+	SmallDataSize     int64   `json:"small_data_size"`
+	SmallOverheadCost float64 `json:"small_overhead_cost"`
+	EarlyDeletionCost float64 `json:"early_deletion_cost"` //rest of the minimum duration of small objects archived too recently to move without a charge
+}
+
+// Reads the Restore header of an object
+// ok is false when the object was deleted since the listing and is skipped
+type restoreFetcher func(key string) (restore string, ok bool, err error)
+
+// Matches the expiry date of x-amz-restore, ex. ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+var restoreExpiryPattern = regexp.MustCompile(`expiry-date="([^"]+)"`)
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:                "restored_objects",
+		Permissions:         []string{"s3:ListBucket"},
+		OptionalPermissions: []string{"s3:GetObject"},
+		New: func(ctx ScanContext) Scanner {
+			svc := s3.New(ctx.Session)
+			bucket := ctx.Bucket.Name
+			fetch := func(key string) (string, bool, error) {
+				//AWS SDK HEAD CALL
+				output, err := svc.HeadObject(&s3.HeadObjectInput{
+					Bucket: aws.String(bucket),
+					Key:    aws.String(key),
+				})
+				if isNotFound(err) {
+					//Deleted since the listing
+					return "", false, nil
+				}
+				if err != nil {
+					return "", false, err
+				}
+				return aws.StringValue(output.Restore), true, nil
+			}
+			return newRestoredObjectScanner(ctx, fetch, time.Now())
+		},
+	})
+	RegisterScanner(ScannerRegistration{
+		Name:        "small_archive_objects",
+		Permissions: []string{"s3:ListBucket"},
+		New: func(ctx ScanContext) Scanner {
+			return newSmallArchiveScanner(ctx.Options.EffectivePolicy(), time.Now())
+		},
+	})
+}
+
+// HELPER for archive scanners
+func isArchiveClass(storageClass string) bool {
+	return storageClass == "GLACIER" || storageClass == "DEEP_ARCHIVE"
+}
+
+// Scanner for the restored copies of archived objects
+type restoredObjectScanner struct {
+	fetch      restoreFetcher
+	now        time.Time
+	rng        *rand.Rand
+	maxSamples int
+
+	objectCount int64
+	dataSize    int64
+	samples     []*s3.Object
+}
+
+func newRestoredObjectScanner(ctx ScanContext, fetch restoreFetcher, now time.Time) *restoredObjectScanner {
+	s := &restoredObjectScanner{
+		fetch:      fetch,
+		now:        now,
+		rng:        rand.New(rand.NewSource(1)),
+		maxSamples: ctx.Options.RestoreSampleObjects,
+	}
+	if !ctx.Options.RestoreSampling {
+		s.maxSamples = 0
+	} else if s.maxSamples == 0 {
+		s.maxSamples = defaultRestoreSampleObjects
+	}
+	return s
+}
+
+// Keeps a uniform reservoir sample of the bucket's archived objects
+func (s *restoredObjectScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		if !isArchiveClass(aws.StringValue(object.StorageClass)) {
+			continue
+		}
+		s.objectCount++
+		s.dataSize += *object.Size
+
+		if s.maxSamples == 0 {
+			continue
+		}
+		if len(s.samples) < s.maxSamples {
+			s.samples = append(s.samples, object)
+		} else if i := s.rng.Int63n(s.objectCount); i < int64(s.maxSamples) {
+			s.samples[i] = object
+		}
+	}
+	return nil
+}
+
+// Restored copies expire on their own, savings are their cost over the next 30 days,
+// min savings leave each copy the first 7 days to be used and max savings don't keep copies at all
+func (s *restoredObjectScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "restored_objects"}
+	if s.objectCount == 0 {
+		return objectScan, nil
+	}
+
+	detail := &RestoredObjectDetail{
+		ArchivedObjects: s.objectCount,
+		ArchivedSize:    s.dataSize,
+	}
+
+	var sampledBytes, restoredBytes int64
+	var byteDaysLeft, byteDaysMin, byteDaysMax float64
+	for _, object := range s.samples {
+		restore, ok, err := s.fetch(*object.Key)
+		if err != nil {
+			return objectScan, err
+		}
+		if !ok {
+			continue
+		}
+		detail.SampledObjects++
+		sampledBytes += *object.Size
+
+		expiry, restored := restoreExpiry(restore)
+		if !restored {
+			if restore != "" {
+				detail.OngoingRestores++
+			}
+			continue
+		}
+		daysLeft := expiry.Sub(s.now).Hours() / 24
+		if daysLeft <= 0 {
+			continue
+		}
+		detail.RestoredSamples++
+		restoredBytes += *object.Size
+		byteDaysLeft += float64(*object.Size) * daysLeft
+		byteDaysMax += float64(*object.Size) * math.Min(daysLeft, restoreMonthDays)
+		byteDaysMin += float64(*object.Size) * math.Max(math.Min(daysLeft, restoreMonthDays)-restoreUseDays, 0)
+	}
+	if restoredBytes == 0 {
+		objectScan.Details = detail
+		return objectScan, nil
+	}
+
+	//The sample stands for every archived object
+	scale := float64(s.dataSize) / float64(sampledBytes)
+	detail.RestoredFraction = float64(restoredBytes) / float64(sampledBytes)
+	detail.AverageDaysLeft = byteDaysLeft / float64(restoredBytes)
+	detail.MonthlyCost = estimate.SavingsForBytesDeletedByStorageClass(int64(byteDaysMax*scale/restoreMonthDays), "STANDARD")
+
+	objectScan.ObjectCount = int64(math.Round(float64(s.objectCount) * float64(detail.RestoredSamples) / float64(detail.SampledObjects)))
+	objectScan.DataSize = int64(math.Round(float64(s.dataSize) * detail.RestoredFraction))
+	objectScan.EstimatedSavings = estimate.EstimatedSavings{
+		CalculatedMonthlylSavingsMin: estimate.SavingsForBytesDeletedByStorageClass(int64(byteDaysMin*scale/restoreMonthDays), "STANDARD"),
+		CalculatedMonthlySavingsMax:  detail.MonthlyCost,
+	}
+	objectScan.Details = detail
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Returns when the restored copy of an object expires, restored is false while the restore is ongoing or if there is none
+func restoreExpiry(restore string) (expiry time.Time, restored bool) {
+	match := restoreExpiryPattern.FindStringSubmatch(restore)
+	if match == nil {
+		return time.Time{}, false
+	}
+	expiry, err := time.Parse(http.TimeFormat, match[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// Scanner for archived objects too small for the archive discount to cover their metadata
+type smallArchiveScanner struct {
+	policy  policy.Policy
+	now     time.Time
+	classes map[string]*smallArchiveTally
+}
+
+// Running totals of one archive storage class
+type smallArchiveTally struct {
+	breakEvenSize int64
+	objectCount   int64
+	smallCount    int64
+	smallSize     int64
+	earlyDeletion float64 //charged when the small objects are moved before their minimum duration
+}
+
+func newSmallArchiveScanner(p policy.Policy, now time.Time) *smallArchiveScanner {
+	return &smallArchiveScanner{policy: p, now: now, classes: make(map[string]*smallArchiveTally)}
+}
+
+func (s *smallArchiveScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		storageClass := aws.StringValue(object.StorageClass)
+		if !isArchiveClass(storageClass) {
+			continue
+		}
+		tally, ok := s.classes[storageClass]
+		if !ok {
+			breakEvenSize, err := estimate.ArchiveBreakEvenSize(storageClass)
+			if err != nil {
+				return err
+			}
+			tally = &smallArchiveTally{breakEvenSize: breakEvenSize}
+			s.classes[storageClass] = tally
+		}
+		tally.objectCount++
+		if *object.Size < tally.breakEvenSize {
+			tally.smallCount++
+			tally.smallSize += *object.Size
+			//Objects are archived when written or transitioned, their age is the most they have been in the class
+			if object.LastModified != nil {
+				charge, err := estimate.EarlyDeletionCost(1, *object.Size, storageClass, ageInDays(*object.LastModified, s.now))
+				if err != nil {
+					return err
+				}
+				tally.earlyDeletion += charge
+			}
+		}
+	}
+	return nil
+}
+
+// Min savings move the small objects back to STANDARD when restoring and copying them, and paying the rest of the minimum duration
+// of recently archived ones, breaks even in time, max savings aggregate them, ex. into tar archives, leaving almost no overhead
+func (s *smallArchiveScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "small_archive_objects"}
+	if len(s.classes) == 0 {
+		return objectScan, nil
+	}
+
+	detail := &SmallArchiveDetail{StorageClasses: make(map[string]SmallArchiveClass)}
+	for storageClass, tally := range s.classes {
+		overheadCost, err := estimate.ArchiveOverheadCost(tally.objectCount, storageClass)
+		if err != nil {
+			return objectScan, err
+		}
+		smallOverheadCost, err := estimate.ArchiveOverheadCost(tally.smallCount, storageClass)
+		if err != nil {
+			return objectScan, err
+		}
+		detail.StorageClasses[storageClass] = SmallArchiveClass{
+			ObjectCount:       tally.objectCount,
+			OverheadCost:      overheadCost,
+			BreakEvenSize:     tally.breakEvenSize,
+			SmallObjectCount:  tally.smallCount,
+			SmallDataSize:     tally.smallSize,
+			SmallOverheadCost: smallOverheadCost,
+			EarlyDeletionCost: tally.earlyDeletion,
+		}
+
+		objectScan.ObjectCount += tally.smallCount
+		objectScan.DataSize += tally.smallSize
+		objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += smallOverheadCost
+		if tally.smallCount == 0 {
+			continue
+		}
+		e, err := estimate.EstimateTransition(tally.smallCount, tally.smallSize, storageClass, "STANDARD", s.policy.RetrievalRate, 0)
+		if err != nil {
+			return objectScan, err
+		}
+		//EstimateTransition charges the minimum duration of the target class, the archive class's is charged on leaving it
+		e = estimate.NewTransitionEstimate(e.UpfrontCost+tally.earlyDeletion, e.MonthlyDelta)
+		if e.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) {
+			objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin += e.MonthlyDelta
+		}
+	}
+	objectScan.Details = detail
+	return objectScan, nil
+}
@@ -0,0 +1,266 @@
+package scan
+
+//This file prices every object in the colder storage classes it is old enough for, against its current storage class
+//Objects are grouped into cohorts by storage class and age so the report shows where eligible bytes sit
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+)
+
+// An archive storage class and the days without writes, or reads when access logs show them, before objects move there
+type tieringTarget struct {
+	StorageClass string
+	MinDays      int
+}
+
+// The storage classes objects are priced in, warmest first
+var archiveTieringTargets = []tieringTarget{
+	{"STANDARD_IA", 30},
+	{"GLACIER_IR", 90},
+	{"GLACIER", 180},
+	{"DEEP_ARCHIVE", 365},
+}
+
+// How cold each storage class is, objects are only priced in colder classes than their own
+// INTELLIGENT_TIERING is left out, it tiers objects itself and has its own analysis
+var storageClassTemperatures = map[string]int{
+	"STANDARD":     0,
+	"STANDARD_IA":  1,
+	"ONEZONE_IA":   1,
+	"GLACIER_IR":   2,
+	"GLACIER":      3,
+	"DEEP_ARCHIVE": 4,
+}
+
+// The age bands cohorts are grouped into
+var archiveTieringAgeBands = []ageBand{
+	{"0-30 days", 0},
+	{"30-90 days", 30},
+	{"90-180 days", 90},
+	{"180-365 days", 180},
+	{"365+ days", 365},
+}
+
+// ArchiveTieringDetail is the ObjectScan.Details of the archive_tiering scan
+// ObjectCount and DataSize of the ObjectScan are the objects that would cost less in a colder storage class
+type ArchiveTieringDetail struct {
+	RetrievalRate      float64         `json:"retrieval_rate"`        //share of moved data assumed to be read back each month
+	MaxBreakEvenMonths int             `json:"max_break_even_months"` //moves that take longer to pay back their transition fees are left out
+	Cohorts            []TieringCohort `json:"cohorts"`
+	Targets            []TieringTarget `json:"targets"`
+
+	TransitionedObjectCount int64 `json:"transitioned_object_count"` //objects a lifecycle rule already transitions
+	TransitionedObjectSize  int64 `json:"transitioned_object_size"`
+}
+
+// TieringCohort contains the objects of one storage class and age band
+type TieringCohort struct {
+	StorageClass  string           `json:"storage_class"`
+	AgeBand       string           `json:"age_band"`
+	ObjectCount   int64            `json:"object_count"`
+	DataSize      int64            `json:"data_size"`
+	EligibleBytes map[string]int64 `json:"eligible_bytes"` //bytes whose move to each colder storage class breaks even in time
+}
+
+// TieringTarget contains the objects that save the most in one storage class, and what moving them costs and saves
+type TieringTarget struct {
+	StorageClass string `json:"storage_class"`
+	ObjectCount  int64  `json:"object_count"`
+	DataSize     int64  `json:"data_size"`
+	estimate.TransitionEstimate
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "archive_tiering",
+		Permissions: []string{"s3:ListBucket", "s3:GetLifecycleConfiguration"},
+		New: func(ctx ScanContext) Scanner {
+			return newArchiveTieringScanner(ctx.BucketScan, ctx.Access, ctx.Options.EffectivePolicy(), time.Now())
+		},
+	})
+}
+
+// Scanner for objects that would cost less in a colder storage class
+type archiveTieringScanner struct {
+	rules       []*s3.LifecycleRule
+	access      *access.AccessDetail
+	now         time.Time
+	policy      policy.Policy
+	objectCount int64
+	dataSize    int64
+	minSavings  float64
+	cohorts     map[string]*TieringCohort
+	targets     map[string]*TieringTarget
+	detail      *ArchiveTieringDetail
+}
+
+func newArchiveTieringScanner(bucketScan BucketScan, accessDetail *access.AccessDetail, p policy.Policy, now time.Time) *archiveTieringScanner {
+	return &archiveTieringScanner{
+		rules:   bucketScan.LifecycleDetail.Rules,
+		access:  accessDetail,
+		now:     now,
+		policy:  p,
+		cohorts: make(map[string]*TieringCohort),
+		targets: make(map[string]*TieringTarget),
+		detail:  &ArchiveTieringDetail{RetrievalRate: p.RetrievalRate, MaxBreakEvenMonths: p.MaxBreakEvenMonths},
+	}
+}
+
+func (s *archiveTieringScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		storageClass := aws.StringValue(object.StorageClass)
+		if storageClass == "" {
+			storageClass = "STANDARD"
+		}
+		temperature, ok := storageClassTemperatures[storageClass]
+		if !ok {
+			continue
+		}
+		size := *object.Size
+		age := s.idleDays(object)
+
+		band := archiveTieringAgeBands[ageBandIndex(archiveTieringAgeBands, age)].Name
+		key := storageClass + "/" + band
+		cohort, ok := s.cohorts[key]
+		if !ok {
+			cohort = &TieringCohort{
+				StorageClass:  storageClass,
+				AgeBand:       band,
+				EligibleBytes: make(map[string]int64),
+			}
+			s.cohorts[key] = cohort
+		}
+		cohort.ObjectCount++
+		cohort.DataSize += size
+
+		evaluation := lifecycle.Evaluate(s.rules, lifecycle.Object{Key: *object.Key, Size: size})
+		if evaluation.Transition {
+			s.detail.TransitionedObjectCount++
+			s.detail.TransitionedObjectSize += size
+			continue
+		}
+		retentionDays, expiring := s.retentionDays(object, evaluation)
+		if expiring {
+			continue
+		}
+
+		//Min savings move the object to the warmest class that pays off, max savings to the class it saves the most in
+		var minSavings float64
+		var best *estimate.TransitionEstimate
+		bestClass := ""
+		for _, target := range archiveTieringTargets {
+			if age < target.MinDays || storageClassTemperatures[target.StorageClass] <= temperature {
+				continue
+			}
+			e, err := estimate.EstimateTransition(1, size, storageClass, target.StorageClass, s.policy.RetrievalRate, retentionDays)
+			if err != nil {
+				return err
+			}
+			if !e.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) {
+				continue
+			}
+			cohort.EligibleBytes[target.StorageClass] += size
+			if best == nil {
+				minSavings = e.MonthlyDelta
+			}
+			if best == nil || e.MonthlyDelta > best.MonthlyDelta {
+				best, bestClass = &e, target.StorageClass
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		t, ok := s.targets[bestClass]
+		if !ok {
+			t = &TieringTarget{StorageClass: bestClass}
+			s.targets[bestClass] = t
+		}
+		t.ObjectCount++
+		t.DataSize += size
+		t.TransitionEstimate = t.TransitionEstimate.Add(*best)
+
+		s.objectCount++
+		s.dataSize += size
+		s.minSavings += minSavings
+	}
+	return nil
+}
+
+// HELPER for Consume()
+// Returns the days since an object was last modified, or last read when access logs show a later read of its prefix
+func (s *archiveTieringScanner) idleDays(object *s3.Object) int {
+	if object.LastModified == nil {
+		return 0
+	}
+	lastUsed := *object.LastModified
+	if s.access != nil {
+		if p, ok := s.access.Prefix(*object.Key); ok && p.LastReadAt.After(lastUsed) {
+			lastUsed = p.LastReadAt
+		}
+	}
+	return ageInDays(lastUsed, s.now)
+}
+
+// HELPER for Consume()
+// Returns the days until a lifecycle rule expires an object, 0 if none does, and whether it expires within a day
+func (s *archiveTieringScanner) retentionDays(object *s3.Object, evaluation lifecycle.Evaluation) (int, bool) {
+	if !evaluation.Expiration || evaluation.ExpirationDays == 0 || object.LastModified == nil {
+		return 0, false
+	}
+	remaining := int(evaluation.ExpirationDays) - ageInDays(*object.LastModified, s.now)
+	return remaining, remaining < 1
+}
+
+func (s *archiveTieringScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{
+		DataCategory: "archive_tiering",
+		ObjectCount:  s.objectCount,
+		DataSize:     s.dataSize,
+	}
+
+	s.detail.Cohorts = []TieringCohort{}
+	for _, cohort := range s.cohorts {
+		s.detail.Cohorts = append(s.detail.Cohorts, *cohort)
+	}
+	sort.Slice(s.detail.Cohorts, func(i, j int) bool {
+		a, b := s.detail.Cohorts[i], s.detail.Cohorts[j]
+		if storageClassTemperatures[a.StorageClass] != storageClassTemperatures[b.StorageClass] {
+			return storageClassTemperatures[a.StorageClass] < storageClassTemperatures[b.StorageClass]
+		}
+		if a.StorageClass != b.StorageClass {
+			return a.StorageClass < b.StorageClass
+		}
+		return ageBandOrder(a.AgeBand) < ageBandOrder(b.AgeBand)
+	})
+
+	s.detail.Targets = []TieringTarget{}
+	for _, target := range archiveTieringTargets {
+		if t, ok := s.targets[target.StorageClass]; ok {
+			s.detail.Targets = append(s.detail.Targets, *t)
+			objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += t.MonthlyDelta
+		}
+	}
+	objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin = s.minSavings
+	objectScan.Details = s.detail
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Returns the position of a named age band in archiveTieringAgeBands
+func ageBandOrder(name string) int {
+	for i, band := range archiveTieringAgeBands {
+		if band.Name == name {
+			return i
+		}
+	}
+	return len(archiveTieringAgeBands)
+}
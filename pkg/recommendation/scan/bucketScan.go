@@ -1,12 +1,15 @@
 package scan
 
 //This file contains scans for information on rules and policies that impact the entire bucket
-//Currently those scans are: Lifecycle Rules, Versioning Status, and Object Storage Classes
+//Currently those scans are: Lifecycle Rules, Versioning Status, Intelligent-Tiering Configurations, Replication Rules,
+//Default Encryption, Object Lock, Tags, and Object Storage Classes
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/awsHelpers"
 	"github.com/helloevanhere/simple_saver_service/pkg/summary"
 )
 
@@ -14,16 +17,103 @@ type LifecycleDetail struct {
 	Rules []*s3.LifecycleRule
 }
 
+// IntelligentTieringDetail contains the bucket's Intelligent-Tiering configurations
+type IntelligentTieringDetail struct {
+	Configurations []*s3.IntelligentTieringConfiguration
+	Unknown        bool `json:"unknown"` //the configurations couldn't be read, ex. access denied
+}
+
+// Returns the opt-in archive access tiers of every enabled configuration, ex. "ARCHIVE_ACCESS"
+func (d IntelligentTieringDetail) ArchiveTiers() []string {
+	tiers := []string{}
+	seen := make(map[string]bool)
+	for _, config := range d.Configurations {
+		if config.Status == nil || *config.Status != s3.IntelligentTieringStatusEnabled {
+			continue
+		}
+		for _, tiering := range config.Tierings {
+			if tiering.AccessTier != nil && !seen[*tiering.AccessTier] {
+				seen[*tiering.AccessTier] = true
+				tiers = append(tiers, *tiering.AccessTier)
+			}
+		}
+	}
+	return tiers
+}
+
+// ReplicationDetail contains the bucket's replication configuration and where it replicates to
+type ReplicationDetail struct {
+	Region       string                            `json:"region"`
+	Rules        []*s3.ReplicationRule             `json:"rules"`
+	Destinations map[string]ReplicationDestination `json:"destinations"` //by destination bucket name
+}
+
+// ReplicationDestination contains what is known about a bucket objects are replicated to
+// Region and ReplicatesTo are empty when the destination, ex. in another account, can't be read
+type ReplicationDestination struct {
+	Region       string   `json:"region"`
+	ReplicatesTo []string `json:"replicates_to"` //buckets the destination replicates the replicas to
+}
+
+// EncryptionDetail contains the bucket's default encryption rules
+type EncryptionDetail struct {
+	Rules []*s3.ServerSideEncryptionRule `json:"rules"`
+}
+
+// Returns the algorithm new objects are encrypted with by default, ex. "aws:kms", empty if there is no default
+func (d EncryptionDetail) DefaultAlgorithm() string {
+	for _, rule := range d.Rules {
+		if rule.ApplyServerSideEncryptionByDefault != nil && rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm != nil {
+			return *rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm
+		}
+	}
+	return ""
+}
+
+// Checks if S3 Bucket Keys are enabled for the bucket's default encryption
+func (d EncryptionDetail) BucketKeyEnabled() bool {
+	for _, rule := range d.Rules {
+		if rule.BucketKeyEnabled != nil && *rule.BucketKeyEnabled {
+			return true
+		}
+	}
+	return false
+}
+
 // BucketScan contains information on rules and policies that impact the entire bucket
 type BucketScan struct {
 	LifecycleDetail  LifecycleDetail `json:"lifecycle_detail"`
 	VersioningStatus string          `json:"versioning_status"`
 	StorageClasses   []string        `json:"storage_classes"`
+
+	IntelligentTieringDetail IntelligentTieringDetail `json:"intelligent_tiering_detail"`
+	ReplicationDetail        ReplicationDetail        `json:"replication_detail"`
+	EncryptionDetail         EncryptionDetail         `json:"encryption_detail"`
+	ObjectLockDetail         ObjectLockDetail         `json:"object_lock_detail"`
+	Tags                     map[string]string        `json:"tags"`
 }
 
-// Takes in a session, a bucket, and the objects in the bucket and returns a BucketScan
+// Scanners that read each part of the BucketScan beyond lifecycle rules and versioning, the part is only fetched when one of them runs
+// Lifecycle analysis reports the Object Lock configuration with the lifecycle_coverage scan
+var bucketScanConsumers = struct {
+	intelligentTiering []string
+	replication        []string
+	encryption         []string
+	objectLock         []string
+	tags               []string
+}{
+	intelligentTiering: []string{"intelligent_tiering"},
+	replication:        []string{"replication"},
+	encryption:         []string{"kms_requests"},
+	objectLock:         []string{"abandoned_bucket", "cross_bucket_duplicates", "duplicate_objects", "object_versions", "lifecycle_coverage"},
+	tags:               []string{"abandoned_bucket", "chargeback"},
+}
+
+// Takes in a session and a bucket and returns a BucketScan
 // which contains information on rules and policies that impact the entire bucket
-func bucketScan(sess *session.Session, bucket summary.BucketSummary, bucketObjs *s3.ListObjectsV2Output) (BucketScan, error) {
+// StorageClasses is filled in by storageClassScan while the bucket's objects are listed
+// Parts no enabled scanner reads are left empty
+func bucketScan(sess *session.Session, bucket summary.BucketSummary, opts ScanOptions) (BucketScan, error) {
 	bucketScan := BucketScan{}
 	var err error
 
@@ -37,15 +127,54 @@ func bucketScan(sess *session.Session, bucket summary.BucketSummary, bucketObjs
 	if err != nil {
 		return bucketScan, err
 	}
-	//Creates a unique array of the storage classes of the objects in the bucket
-	bucketScan.StorageClasses, err = storageClassScan(bucketObjs)
-	if err != nil {
-		return bucketScan, err
+	//Gets the buckets Intelligent-Tiering configurations
+	if anyScannerEnabled(opts, bucketScanConsumers.intelligentTiering) {
+		bucketScan.IntelligentTieringDetail, err = intelligentTieringScan(sess, bucket.Name)
+		if err != nil {
+			return bucketScan, err
+		}
+	}
+	//Gets the buckets replication rules and destinations
+	if anyScannerEnabled(opts, bucketScanConsumers.replication) {
+		bucketScan.ReplicationDetail, err = replicationScan(sess, bucket.Name)
+		if err != nil {
+			return bucketScan, err
+		}
+	}
+	//Gets the buckets default encryption
+	if anyScannerEnabled(opts, bucketScanConsumers.encryption) {
+		bucketScan.EncryptionDetail, err = encryptionScan(sess, bucket.Name)
+		if err != nil {
+			return bucketScan, err
+		}
+	}
+	//Gets the buckets Object Lock configuration
+	if anyScannerEnabled(opts, bucketScanConsumers.objectLock) {
+		bucketScan.ObjectLockDetail, err = objectLockScan(sess, bucket.Name)
+		if err != nil {
+			return bucketScan, err
+		}
+	}
+	//Gets the buckets tags
+	if anyScannerEnabled(opts, bucketScanConsumers.tags) {
+		bucketScan.Tags, err = tagScan(sess, bucket.Name)
+		if err != nil {
+			return bucketScan, err
+		}
 	}
-
 	return bucketScan, nil
 }
 
+// HELPER for bucketScan()
+func anyScannerEnabled(opts ScanOptions, names []string) bool {
+	for _, name := range names {
+		if opts.ScannerEnabled(name) {
+			return true
+		}
+	}
+	return false
+}
+
 // Takes in a session and bucket name and retrieves the Lifecycle Policy details
 func lifecycleScan(sess *session.Session, bucketName string) (LifecycleDetail, error) {
 	svc := s3.New(sess)
@@ -67,6 +196,152 @@ func lifecycleScan(sess *session.Session, bucketName string) (LifecycleDetail, e
 	return lifecycleConfig, nil
 }
 
+// Takes in a session and bucket name and retrieves the Intelligent-Tiering configurations
+// Configurations that can't be read for any reason other than a missing bucket are reported as unknown, not as none
+func intelligentTieringScan(sess *session.Session, bucketName string) (IntelligentTieringDetail, error) {
+	svc := s3.New(sess)
+	detail := IntelligentTieringDetail{}
+
+	input := &s3.ListBucketIntelligentTieringConfigurationsInput{
+		Bucket: &bucketName,
+	}
+	for {
+		//AWS SDK LIST CALL
+		output, err := svc.ListBucketIntelligentTieringConfigurations(input)
+		if isNotFound(err) {
+			return IntelligentTieringDetail{}, nil
+		}
+		if err != nil {
+			fmt.Println("Error getting bucket intelligent-tiering configurations:", err)
+			return IntelligentTieringDetail{Unknown: true}, nil
+		}
+		detail.Configurations = append(detail.Configurations, output.IntelligentTieringConfigurationList...)
+
+		if output.IsTruncated == nil || !*output.IsTruncated {
+			return detail, nil
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+}
+
+// Takes in a session and bucket name and retrieves the replication rules
+// and the region and replication rules of every destination bucket
+func replicationScan(sess *session.Session, bucketName string) (ReplicationDetail, error) {
+	detail := ReplicationDetail{Destinations: make(map[string]ReplicationDestination)}
+
+	//Buckets without replication return ReplicationConfigurationNotFoundError
+	detail.Rules = replicationRules(sess, bucketName)
+	if len(detail.Rules) == 0 {
+		return detail, nil
+	}
+
+	region, err := awsHelpers.GetBucketRegion(sess, bucketName)
+	if err != nil {
+		fmt.Println("Error getting bucket location:", err)
+	}
+	detail.Region = region
+
+	for _, rule := range detail.Rules {
+		destination := destinationBucket(rule)
+		if _, ok := detail.Destinations[destination]; destination == "" || ok {
+			continue
+		}
+		//Destinations in other accounts usually deny these calls, they are reported as unknown
+		destinationRegion, _ := awsHelpers.GetBucketRegion(sess, destination)
+		replicatesTo := []string{}
+		for _, destinationRule := range replicationRules(sess, destination) {
+			if isReplicationRuleEnabled(destinationRule) {
+				replicatesTo = append(replicatesTo, destinationBucket(destinationRule))
+			}
+		}
+		detail.Destinations[destination] = ReplicationDestination{
+			Region:       destinationRegion,
+			ReplicatesTo: replicatesTo,
+		}
+	}
+	return detail, nil
+}
+
+// HELPER for replicationScan()
+func replicationRules(sess *session.Session, bucketName string) []*s3.ReplicationRule {
+	svc := s3.New(sess)
+
+	//AWS SDK GET CALL
+	output, err := svc.GetBucketReplication(&s3.GetBucketReplicationInput{
+		Bucket: &bucketName,
+	})
+	if err != nil || output.ReplicationConfiguration == nil {
+		return nil
+	}
+	return output.ReplicationConfiguration.Rules
+}
+
+// Takes in a session and bucket name and retrieves the default encryption rules
+func encryptionScan(sess *session.Session, bucketName string) (EncryptionDetail, error) {
+	svc := s3.New(sess)
+
+	//AWS SDK GET CALL
+	output, err := svc.GetBucketEncryption(&s3.GetBucketEncryptionInput{
+		Bucket: &bucketName,
+	})
+	//Buckets without a configuration return ServerSideEncryptionConfigurationNotFoundError
+	if err != nil && !isNotFound(err) {
+		fmt.Println("Error getting bucket encryption:", err)
+	}
+	if err != nil || output.ServerSideEncryptionConfiguration == nil {
+		return EncryptionDetail{}, nil
+	}
+
+	return EncryptionDetail{
+		Rules: output.ServerSideEncryptionConfiguration.Rules,
+	}, nil
+}
+
+// Takes in a session and bucket name and retrieves the Object Lock configuration
+func objectLockScan(sess *session.Session, bucketName string) (ObjectLockDetail, error) {
+	svc := s3.New(sess)
+
+	//Buckets without Object Lock return ObjectLockConfigurationNotFoundError
+	//AWS SDK GET CALL
+	output, err := svc.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+		Bucket: &bucketName,
+	})
+	if err != nil || output.ObjectLockConfiguration == nil {
+		return ObjectLockDetail{}, nil
+	}
+
+	config := output.ObjectLockConfiguration
+	detail := ObjectLockDetail{
+		Enabled: aws.StringValue(config.ObjectLockEnabled) == s3.ObjectLockEnabledEnabled,
+	}
+	if config.Rule != nil && config.Rule.DefaultRetention != nil {
+		retention := config.Rule.DefaultRetention
+		detail.Mode = aws.StringValue(retention.Mode)
+		detail.RetentionDays = aws.Int64Value(retention.Days) + 365*aws.Int64Value(retention.Years)
+	}
+	return detail, nil
+}
+
+// Takes in a session and bucket name and retrieves the bucket's tags
+func tagScan(sess *session.Session, bucketName string) (map[string]string, error) {
+	svc := s3.New(sess)
+	tags := make(map[string]string)
+
+	//Buckets without tags return NoSuchTagSet
+	//AWS SDK GET CALL
+	output, err := svc.GetBucketTagging(&s3.GetBucketTaggingInput{
+		Bucket: &bucketName,
+	})
+	if err != nil {
+		return tags, nil
+	}
+
+	for _, tag := range output.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
 // Takes a session and bucket name and returns the versioning Status of type string
 func versioningEnabledScan(sess *session.Session, bucketName string) (string, error) {
 	versioningStatus := "Not Enabled"
@@ -88,11 +363,13 @@ func versioningEnabledScan(sess *session.Session, bucketName string) (string, er
 	return versioningStatus, nil
 }
 
-// Takes in a ptr to an array of objects in a bucket
-// Scans the objects in a bucket and returns a unique list of storage classes
-func storageClassScan(bucketObjs *s3.ListObjectsV2Output) ([]string, error) {
-	var classes []string
+// Takes in the storage classes seen so far and a ptr to a page of objects in a bucket
+// Scans the objects in the page and returns the unique list of storage classes
+func storageClassScan(classes []string, bucketObjs *s3.ListObjectsV2Output) ([]string, error) {
 	unique := make(map[string]bool)
+	for _, class := range classes {
+		unique[class] = true
+	}
 
 	for _, item := range bucketObjs.Contents {
 		storageClass := *item.StorageClass
@@ -0,0 +1,360 @@
+package scan
+
+//This file measures compressibility by sampling object content
+//The start of a bounded sample of objects is downloaded, its format detected from magic bytes,
+//and the sample compressed locally with gzip and zstd to measure real ratios
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Defaults for compression sampling
+const (
+	defaultCompressionSampleObjects = 50
+	defaultCompressionSampleBytes   = 64 * 1024
+	samplesPerExtension             = 5
+	maxSampledExtensions            = 1000 //extensions past this are grouped as otherExtension
+	otherExtension                  = "*other*"
+	compressibleRatio               = 0.9 //data that doesn't shrink below this fraction is not worth compressing
+	minTrialCompressionBytes        = 512 //smaller samples are dominated by compression headers
+)
+
+// CompressionSampleDetail is the ObjectScan.Details of the compressible_objects scan when sampling is enabled
+type CompressionSampleDetail struct {
+	SampledObjects int64             `json:"sampled_objects"`
+	SampledBytes   int64             `json:"sampled_bytes"`
+	Formats        map[string]int64  `json:"formats"` //sampled objects per detected format
+	Extensions     []ExtensionSample `json:"extensions"`
+}
+
+// ExtensionSample contains the measured compressibility of the objects with one file extension
+type ExtensionSample struct {
+	Extension      string                     `json:"extension"` //empty for objects without an extension
+	ObjectCount    int64                      `json:"object_count"`
+	DataSize       int64                      `json:"data_size"`
+	SampleCount    int64                      `json:"sample_count"`
+	DetectedFormat string                     `json:"detected_format,omitempty"`
+	Codec          string                     `json:"codec,omitempty"` //codec that compressed the samples best
+	MeasuredRatio  *estimate.CompressionRatio `json:"measured_ratio,omitempty"`
+	Method         string                     `json:"method"` //"measured", or "extension" when no sample could be read
+	Compressible   bool                       `json:"compressible"`
+}
+
+// Downloads up to n bytes from the start of an object
+// A nil slice without an error means the object can't be read and is skipped
+type sampleFetcher func(key string, n int64) ([]byte, error)
+
+// One object picked for sampling
+type sampleCandidate struct {
+	Key  string
+	Size int64
+}
+
+// Listing totals and sample candidates for one file extension
+type extensionStats struct {
+	extension   string
+	objectCount int64
+	dataSize    int64
+	sizeByClass map[string]int64
+	seen        int64 //objects offered to the reservoir
+	samples     []sampleCandidate
+}
+
+// Scans for compressible data by sampling object content instead of trusting file extensions
+type sampledCompressionScanner struct {
+	fetch       sampleFetcher
+	rng         *rand.Rand
+	maxSamples  int
+	sampleBytes int64
+	byExtension map[string]*extensionStats
+	policy      policy.Policy //extension tables used when no sample of an extension could be read
+}
+
+func newSampledCompressionScanner(ctx ScanContext) *sampledCompressionScanner {
+	svc := s3.New(ctx.Session)
+	bucket := ctx.Bucket.Name
+	fetch := func(key string, n int64) ([]byte, error) {
+		//AWS SDK GET CALL
+		output, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+		})
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == s3.ErrCodeInvalidObjectState) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer output.Body.Close()
+		return io.ReadAll(io.LimitReader(output.Body, n))
+	}
+
+	return newSampledCompressionScannerWithFetcher(ctx.Options, fetch)
+}
+
+func newSampledCompressionScannerWithFetcher(opts ScanOptions, fetch sampleFetcher) *sampledCompressionScanner {
+	s := &sampledCompressionScanner{
+		fetch:       fetch,
+		rng:         rand.New(rand.NewSource(1)),
+		maxSamples:  opts.CompressionSampleObjects,
+		sampleBytes: opts.CompressionSampleBytes,
+		byExtension: make(map[string]*extensionStats),
+		policy:      opts.EffectivePolicy(),
+	}
+	if s.maxSamples == 0 {
+		s.maxSamples = defaultCompressionSampleObjects
+	}
+	if s.sampleBytes == 0 {
+		s.sampleBytes = defaultCompressionSampleBytes
+	}
+	return s
+}
+
+func (s *sampledCompressionScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		ext := strings.ToLower(filepath.Ext(*object.Key))
+		stats, ok := s.byExtension[ext]
+		if !ok {
+			if len(s.byExtension) >= maxSampledExtensions {
+				ext = otherExtension
+				stats, ok = s.byExtension[ext]
+			}
+			if !ok {
+				stats = &extensionStats{extension: ext, sizeByClass: make(map[string]int64)}
+				s.byExtension[ext] = stats
+			}
+		}
+
+		stats.objectCount++
+		stats.dataSize += *object.Size
+		stats.sizeByClass[*object.StorageClass] += *object.Size
+
+		//Archived objects can't be read without a restore
+		if *object.Size == 0 || *object.StorageClass == "GLACIER" || *object.StorageClass == "DEEP_ARCHIVE" {
+			continue
+		}
+
+		//Reservoir sampling keeps an even sample however many objects share the extension
+		stats.seen++
+		candidate := sampleCandidate{Key: *object.Key, Size: *object.Size}
+		if len(stats.samples) < samplesPerExtension {
+			stats.samples = append(stats.samples, candidate)
+		} else if i := s.rng.Int63n(stats.seen); i < samplesPerExtension {
+			stats.samples[i] = candidate
+		}
+	}
+	return nil
+}
+
+// Samples the extensions holding the most data first, until maxSamples objects have been read
+func (s *sampledCompressionScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "compressible_objects"}
+	detail := CompressionSampleDetail{Formats: make(map[string]int64)}
+	var totalMinSavings, totalMaxSavings float64
+
+	extensions := make([]*extensionStats, 0, len(s.byExtension))
+	for _, stats := range s.byExtension {
+		extensions = append(extensions, stats)
+	}
+	sort.Slice(extensions, func(i, j int) bool {
+		if extensions[i].dataSize != extensions[j].dataSize {
+			return extensions[i].dataSize > extensions[j].dataSize
+		}
+		return extensions[i].extension < extensions[j].extension
+	})
+
+	remaining := s.maxSamples
+	for _, stats := range extensions {
+		sample := ExtensionSample{
+			Extension:   stats.extension,
+			ObjectCount: stats.objectCount,
+			DataSize:    stats.dataSize,
+			Method:      "extension",
+		}
+
+		formats := make(map[string]int64)
+		codecs := make(map[string]int64)
+		var ratio *estimate.CompressionRatio
+		var ratioSum float64
+		for _, candidate := range stats.samples {
+			if remaining <= 0 {
+				break
+			}
+			remaining--
+
+			n := s.sampleBytes
+			if candidate.Size < n {
+				n = candidate.Size
+			}
+			data, err := s.fetch(candidate.Key, n)
+			if err != nil {
+				return objectScan, err
+			}
+			if data == nil {
+				continue
+			}
+
+			format, compressed := detectFormat(data)
+			r, codec := 1.0, ""
+			if !compressed {
+				r, codec = trialCompress(data)
+			}
+
+			sample.SampleCount++
+			ratioSum += r
+			detail.SampledObjects++
+			detail.SampledBytes += int64(len(data))
+			detail.Formats[format]++
+			formats[format]++
+			if codec != "" {
+				codecs[codec]++
+			}
+			if ratio == nil {
+				ratio = &estimate.CompressionRatio{Min: r, Max: r}
+			} else {
+				if r < ratio.Min {
+					ratio.Min = r
+				}
+				if r > ratio.Max {
+					ratio.Max = r
+				}
+			}
+		}
+
+		var minSavings, maxSavings float64
+		if ratio != nil {
+			sample.Method = "measured"
+			sample.MeasuredRatio = ratio
+			sample.DetectedFormat = mostCommon(formats)
+			sample.Codec = mostCommon(codecs)
+			sample.Compressible = ratioSum/float64(sample.SampleCount) < compressibleRatio
+			if sample.Compressible {
+				for class, size := range stats.sizeByClass {
+					min, max, err := estimate.SavingsForBytesCompressedWithRatio(size, *ratio, class)
+					if err != nil {
+						return objectScan, err
+					}
+					minSavings += min
+					maxSavings += max
+				}
+			}
+		} else if !s.policy.IsCompressed(stats.extension) && s.policy.CompressionType(stats.extension) != "" {
+			//Nothing could be sampled, fall back to the extension
+			sample.Compressible = true
+			for class, size := range stats.sizeByClass {
+				min, max, err := estimate.SavingsForBytesCompressedByStorageClass(size, s.policy.CompressionType(stats.extension), class)
+				if err != nil {
+					return objectScan, err
+				}
+				minSavings += min
+				maxSavings += max
+			}
+		}
+
+		if sample.Compressible {
+			objectScan.ObjectCount += stats.objectCount
+			objectScan.DataSize += stats.dataSize
+			totalMinSavings += minSavings
+			totalMaxSavings += maxSavings
+		}
+		detail.Extensions = append(detail.Extensions, sample)
+	}
+
+	objectScan.EstimatedSavings = estimate.EstimatedSavings{
+		CalculatedMonthlylSavingsMin: totalMinSavings,
+		CalculatedMonthlySavingsMax:  totalMaxSavings,
+	}
+	if len(detail.Extensions) > 0 {
+		objectScan.Details = &detail
+	}
+	return objectScan, nil
+}
+
+// Magic bytes of common formats and whether their content is already compressed
+var magicFormats = []struct {
+	format     string
+	offset     int
+	magic      []byte
+	compressed bool
+}{
+	{"gzip", 0, []byte{0x1f, 0x8b}, true},
+	{"zstd", 0, []byte{0x28, 0xb5, 0x2f, 0xfd}, true},
+	{"zip", 0, []byte("PK\x03\x04"), true},
+	{"bzip2", 0, []byte("BZh"), true},
+	{"xz", 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, true},
+	{"7zip", 0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, true},
+	{"snappy", 0, []byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}, true},
+	{"lz4", 0, []byte{0x04, 0x22, 0x4d, 0x18}, true},
+	{"png", 0, []byte{0x89, 'P', 'N', 'G'}, true},
+	{"jpeg", 0, []byte{0xff, 0xd8, 0xff}, true},
+	{"gif", 0, []byte("GIF8"), true},
+	{"webp", 8, []byte("WEBP"), true},
+	{"mp4", 4, []byte("ftyp"), true},
+	{"mp3", 0, []byte("ID3"), true},
+	{"pdf", 0, []byte("%PDF"), true},
+	{"parquet", 0, []byte("PAR1"), true},
+	{"orc", 0, []byte("ORC"), true},
+	{"avro", 0, []byte{'O', 'b', 'j', 0x01}, true},
+}
+
+// Detects a sample's format from its magic bytes
+// Samples with no known magic are reported as "unknown" and treated as uncompressed
+func detectFormat(data []byte) (string, bool) {
+	for _, m := range magicFormats {
+		if len(data) >= m.offset+len(m.magic) && bytes.Equal(data[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format, m.compressed
+		}
+	}
+	return "unknown", false
+}
+
+// Compresses a sample with gzip and zstd and returns the best compressed size as a fraction of the sample size
+func trialCompress(data []byte) (float64, string) {
+	if len(data) < minTrialCompressionBytes {
+		return 1, ""
+	}
+
+	best, codec := len(data), ""
+
+	var gz bytes.Buffer
+	gzWriter := gzip.NewWriter(&gz)
+	if _, err := gzWriter.Write(data); err == nil && gzWriter.Close() == nil && gz.Len() < best {
+		best, codec = gz.Len(), "gzip"
+	}
+
+	if zstdEncoder, err := zstd.NewWriter(nil); err == nil {
+		compressed := zstdEncoder.EncodeAll(data, nil)
+		zstdEncoder.Close()
+		if len(compressed) < best {
+			best, codec = len(compressed), "zstd"
+		}
+	}
+
+	return float64(best) / float64(len(data)), codec
+}
+
+// HELPER for Finalize()
+func mostCommon(counts map[string]int64) string {
+	best, bestCount := "", int64(0)
+	for name, count := range counts {
+		if count > bestCount || (count == bestCount && name < best) {
+			best, bestCount = name, count
+		}
+	}
+	return best
+}
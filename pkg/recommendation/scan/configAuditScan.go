@@ -0,0 +1,360 @@
+package scan
+
+//This file audits bucket features that carry hidden costs
+//Features are consumed when the access logs show reads of what they produce, ex. inventory reports
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/awsHelpers"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+)
+
+// Inventory reports per month by schedule frequency
+var inventoryReportsPerMonth = map[string]float64{
+	s3.InventoryFrequencyDaily:  30,
+	s3.InventoryFrequencyWeekly: 4.3,
+}
+
+// At most this many log objects are listed to size a bucket's access logs, more leaves their cost a lower bound
+const maxLogTargetObjects = 1000000
+
+// How often a feature writes what it produces, the access logs of its destination have to cover two periods
+// for a missing read to mean the output is not consumed
+var featureCadences = map[string]time.Duration{
+	s3.InventoryFrequencyDaily:  24 * time.Hour,
+	s3.InventoryFrequencyWeekly: 7 * 24 * time.Hour,
+	"storage_class_analysis":    24 * time.Hour,     //exports are updated daily
+	"access_logging":            7 * 24 * time.Hour, //logs are delivered continuously, read at least weekly when they are used
+}
+
+// ConfigAuditDetail is the ObjectScan.Details of the bucket_config_audit scan
+type ConfigAuditDetail struct {
+	Features []PaidFeature `json:"features"`
+}
+
+// PaidFeature is one enabled bucket feature that costs money
+type PaidFeature struct {
+	Feature     string  `json:"feature"`
+	Config      string  `json:"config,omitempty"` //configuration id or target, ex. inventory id
+	MonthlyCost float64 `json:"monthly_cost"`     //0 when the cost depends on usage that can't be seen
+	CostNote    string  `json:"cost_note"`
+	Consumed    *bool   `json:"consumed"` //nil when the access logs can't tell
+	Evidence    string  `json:"evidence,omitempty"`
+}
+
+// The paid features configured on a bucket
+type bucketFeatures struct {
+	RequesterPays        bool
+	TransferAcceleration bool
+	Inventories          []*s3.InventoryConfiguration
+	Analytics            []*s3.AnalyticsConfiguration
+	MetricsCount         int
+	Logging              *s3.LoggingEnabled
+	SameRegionLogging    bool                         //the log target is in the bucket's region
+	LoggingExpires       bool                         //a lifecycle rule on the log target expires the logs
+	LogStorage           map[string]StorageClassTotal //logs stored under the target prefix by storage class, nil when they can't be listed
+	LogStorageTruncated  bool                         //the listing stopped at maxLogTargetObjects
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name: "bucket_config_audit",
+		Permissions: []string{"s3:ListBucket", "s3:GetBucketRequestPayment", "s3:GetAccelerateConfiguration",
+			"s3:GetInventoryConfiguration", "s3:GetAnalyticsConfiguration", "s3:GetMetricsConfiguration",
+			"s3:GetBucketLogging", "s3:GetBucketLocation", "s3:GetLifecycleConfiguration"},
+		New: func(ctx ScanContext) Scanner {
+			return &configAuditScanner{ctx: ctx}
+		},
+	})
+}
+
+// Scanner for the paid features of a bucket
+// Uses the listing for the object counts features are charged by, the configurations are read in Finalize
+type configAuditScanner struct {
+	ctx         ScanContext
+	objectCount int64
+}
+
+func (s *configAuditScanner) Consume(page *s3.ListObjectsV2Output) error {
+	s.objectCount += int64(len(page.Contents))
+	return nil
+}
+
+func (s *configAuditScanner) Finalize() (ObjectScan, error) {
+	features, err := bucketFeatureScan(s.ctx.Session, s.ctx.Bucket.Name)
+	if err != nil {
+		return ObjectScan{DataCategory: "bucket_config_audit"}, err
+	}
+	return auditBucketFeatures(features, s.objectCount, s.ctx.AccessTracker), nil
+}
+
+// Takes in the features of a bucket, its object count and the access logs, if any,
+// and returns the bucket_config_audit ObjectScan
+// Min savings are the cost of features the logs show are not consumed, max savings add the features that may not be
+func auditBucketFeatures(features bucketFeatures, objectCount int64, tracker *access.Tracker) ObjectScan {
+	objectScan := ObjectScan{DataCategory: "bucket_config_audit"}
+	paid := []PaidFeature{}
+	millions := float64(objectCount) / 1000000
+
+	if features.RequesterPays {
+		paid = append(paid, PaidFeature{
+			Feature:  "requester_pays",
+			CostNote: "requesters pay for requests and transfer, anonymous access is denied",
+		})
+	}
+	if features.TransferAcceleration {
+		paid = append(paid, PaidFeature{
+			Feature:  "transfer_acceleration",
+			CostNote: fmt.Sprintf("$%.2f per GB transferred through the accelerated endpoint, only when it is used", estimate.TransferAccelerationPricePerGB),
+		})
+	}
+	for _, inventory := range features.Inventories {
+		if !aws.BoolValue(inventory.IsEnabled) {
+			continue
+		}
+		frequency := ""
+		if inventory.Schedule != nil {
+			frequency = aws.StringValue(inventory.Schedule.Frequency)
+		}
+		feature := PaidFeature{
+			Feature:     "inventory",
+			Config:      aws.StringValue(inventory.Id),
+			MonthlyCost: millions * inventoryReportsPerMonth[frequency] * estimate.InventoryPricePerMillionObjects,
+			CostNote:    fmt.Sprintf("%s reports of %d objects, plus the storage of the reports", frequency, objectCount),
+		}
+		if inventory.Destination != nil && inventory.Destination.S3BucketDestination != nil {
+			destination := inventory.Destination.S3BucketDestination
+			feature.Consumed, feature.Evidence = readsOf(tracker, bucketName(aws.StringValue(destination.Bucket)), aws.StringValue(destination.Prefix), featureCadences[frequency])
+		}
+		paid = append(paid, feature)
+	}
+	for _, analytics := range features.Analytics {
+		feature := PaidFeature{
+			Feature:     "storage_class_analysis",
+			Config:      aws.StringValue(analytics.Id),
+			MonthlyCost: millions * estimate.StorageClassAnalysisPricePerMillionObjects,
+			CostNote:    fmt.Sprintf("%d objects monitored", objectCount),
+		}
+		if analysis := analytics.StorageClassAnalysis; analysis != nil && analysis.DataExport != nil &&
+			analysis.DataExport.Destination != nil && analysis.DataExport.Destination.S3BucketDestination != nil {
+			destination := analysis.DataExport.Destination.S3BucketDestination
+			feature.Consumed, feature.Evidence = readsOf(tracker, bucketName(aws.StringValue(destination.Bucket)), aws.StringValue(destination.Prefix), featureCadences["storage_class_analysis"])
+		}
+		paid = append(paid, feature)
+	}
+	if features.MetricsCount > 0 {
+		paid = append(paid, PaidFeature{
+			Feature:     "request_metrics",
+			Config:      fmt.Sprintf("%d configurations", features.MetricsCount),
+			MonthlyCost: float64(features.MetricsCount*estimate.RequestMetricsPerConfiguration) * estimate.RequestMetricsPricePerMetric,
+			CostNote:    "CloudWatch metrics for every request metrics configuration",
+		})
+	}
+	if features.Logging != nil && features.SameRegionLogging && !features.LoggingExpires {
+		target := aws.StringValue(features.Logging.TargetBucket)
+		prefix := aws.StringValue(features.Logging.TargetPrefix)
+		feature := PaidFeature{
+			Feature:     "access_logging",
+			Config:      fmt.Sprintf("s3://%s/%s", target, prefix),
+			MonthlyCost: logStorageCost(features.LogStorage),
+			CostNote:    "logs are delivered to a bucket in the same region without a lifecycle rule expiring them, their storage grows forever",
+		}
+		if features.LogStorage == nil {
+			feature.CostNote += "; the logs couldn't be listed, so their storage isn't priced"
+		} else if features.LogStorageTruncated {
+			feature.CostNote += fmt.Sprintf("; the cost is of the first %d logs only", maxLogTargetObjects)
+		}
+		feature.Consumed, feature.Evidence = readsOf(tracker, target, prefix, featureCadences["access_logging"])
+		paid = append(paid, feature)
+	}
+
+	if len(paid) == 0 {
+		return objectScan
+	}
+	for _, feature := range paid {
+		if feature.Consumed == nil {
+			objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += feature.MonthlyCost
+		} else if !*feature.Consumed {
+			objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin += feature.MonthlyCost
+			objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += feature.MonthlyCost
+		}
+	}
+	objectScan.Details = &ConfigAuditDetail{Features: paid}
+	return objectScan
+}
+
+// HELPER for auditBucketFeatures()
+// Returns the monthly storage cost of the stored access logs, storage classes without a known price are left out
+func logStorageCost(storage map[string]StorageClassTotal) float64 {
+	var cost float64
+	for storageClass, total := range storage {
+		classCost, err := estimate.CurrentStorageCost(total.DataSize, storageClass)
+		if err != nil {
+			continue
+		}
+		cost += classCost
+	}
+	return cost
+}
+
+// HELPER for auditBucketFeatures()
+// Checks the access logs for reads under a prefix of a bucket
+// Tracked prefixes shorter than the prefix count too, so reads may be overcounted but never missed
+// Returns nil unless the logs name the bucket over two of the feature's cadence, or any cadence when it is unknown
+func readsOf(tracker *access.Tracker, bucket string, prefix string, cadence time.Duration) (*bool, string) {
+	if tracker == nil {
+		return nil, ""
+	}
+	if cadence == 0 {
+		cadence = featureCadences[s3.InventoryFrequencyWeekly]
+	}
+	detail := tracker.Detail(bucket)
+	if !detail.Covers(2 * cadence) {
+		return nil, ""
+	}
+	var reads int64
+	for _, p := range detail.Prefixes {
+		if strings.HasPrefix(p.Prefix, prefix) || strings.HasPrefix(prefix, p.Prefix) {
+			reads += p.GetCount
+		}
+	}
+	consumed := reads > 0
+	return &consumed, fmt.Sprintf("%d reads of s3://%s/%s from %s to %s", reads, bucket, prefix,
+		detail.WindowStart.Format("2006-01-02"), detail.WindowEnd.Format("2006-01-02"))
+}
+
+// HELPER for auditBucketFeatures()
+// Destinations are bucket ARNs
+func bucketName(arn string) string {
+	return strings.TrimPrefix(arn, "arn:aws:s3:::")
+}
+
+// Takes in a session and bucket name and retrieves the bucket's paid features
+// Features that can't be read are reported as not configured
+func bucketFeatureScan(sess *session.Session, bucketName string) (bucketFeatures, error) {
+	svc := s3.New(sess)
+	features := bucketFeatures{}
+	bucket := aws.String(bucketName)
+
+	//AWS SDK GET CALL
+	if payment, err := svc.GetBucketRequestPayment(&s3.GetBucketRequestPaymentInput{Bucket: bucket}); err == nil {
+		features.RequesterPays = aws.StringValue(payment.Payer) == s3.PayerRequester
+	}
+
+	//AWS SDK GET CALL
+	if accelerate, err := svc.GetBucketAccelerateConfiguration(&s3.GetBucketAccelerateConfigurationInput{Bucket: bucket}); err == nil {
+		features.TransferAcceleration = aws.StringValue(accelerate.Status) == s3.BucketAccelerateStatusEnabled
+	}
+
+	inventoryInput := &s3.ListBucketInventoryConfigurationsInput{Bucket: bucket}
+	for {
+		//AWS SDK LIST CALL
+		output, err := svc.ListBucketInventoryConfigurations(inventoryInput)
+		if err != nil {
+			break
+		}
+		features.Inventories = append(features.Inventories, output.InventoryConfigurationList...)
+		if !aws.BoolValue(output.IsTruncated) {
+			break
+		}
+		inventoryInput.ContinuationToken = output.NextContinuationToken
+	}
+
+	analyticsInput := &s3.ListBucketAnalyticsConfigurationsInput{Bucket: bucket}
+	for {
+		//AWS SDK LIST CALL
+		output, err := svc.ListBucketAnalyticsConfigurations(analyticsInput)
+		if err != nil {
+			break
+		}
+		features.Analytics = append(features.Analytics, output.AnalyticsConfigurationList...)
+		if !aws.BoolValue(output.IsTruncated) {
+			break
+		}
+		analyticsInput.ContinuationToken = output.NextContinuationToken
+	}
+
+	metricsInput := &s3.ListBucketMetricsConfigurationsInput{Bucket: bucket}
+	for {
+		//AWS SDK LIST CALL
+		output, err := svc.ListBucketMetricsConfigurations(metricsInput)
+		if err != nil {
+			break
+		}
+		features.MetricsCount += len(output.MetricsConfigurationList)
+		if !aws.BoolValue(output.IsTruncated) {
+			break
+		}
+		metricsInput.ContinuationToken = output.NextContinuationToken
+	}
+
+	//AWS SDK GET CALL
+	logging, err := svc.GetBucketLogging(&s3.GetBucketLoggingInput{Bucket: bucket})
+	if err != nil || logging.LoggingEnabled == nil {
+		return features, nil
+	}
+	features.Logging = logging.LoggingEnabled
+	target := aws.StringValue(logging.LoggingEnabled.TargetBucket)
+
+	region, err := awsHelpers.GetBucketRegion(sess, bucketName)
+	if err != nil {
+		return features, nil
+	}
+	targetRegion, err := awsHelpers.GetBucketRegion(sess, target)
+	features.SameRegionLogging = err == nil && region == targetRegion
+
+	targetLifecycle, err := lifecycleScan(sess, target)
+	if err != nil {
+		return features, err
+	}
+	features.LoggingExpires = lifecycle.Evaluate(targetLifecycle.Rules, lifecycle.Object{Key: aws.StringValue(logging.LoggingEnabled.TargetPrefix)}).Expiration
+	if features.SameRegionLogging && !features.LoggingExpires {
+		features.LogStorage, features.LogStorageTruncated = logStorageScan(svc, target, aws.StringValue(logging.LoggingEnabled.TargetPrefix))
+	}
+
+	return features, nil
+}
+
+// HELPER for bucketFeatureScan()
+// Lists the logs under the target prefix and sums them by storage class, at most maxLogTargetObjects of them
+// Returns nil when the target can't be listed, ex. it is in another account
+func logStorageScan(svc *s3.S3, target string, prefix string) (map[string]StorageClassTotal, bool) {
+	storage := make(map[string]StorageClassTotal)
+	var listed int64
+	truncated := false
+
+	//AWS SDK LIST CALL
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(target),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			storageClass := aws.StringValue(object.StorageClass)
+			if storageClass == "" {
+				storageClass = "STANDARD"
+			}
+			total := storage[storageClass]
+			total.ObjectCount++
+			total.DataSize += aws.Int64Value(object.Size)
+			storage[storageClass] = total
+			listed++
+		}
+		if listed >= maxLogTargetObjects && !lastPage {
+			truncated = true
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false
+	}
+	return storage, truncated
+}
@@ -0,0 +1,51 @@
+package scan
+
+//This file scans how much of a bucket's data is covered by its lifecycle configuration
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+)
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "lifecycle_coverage",
+		Permissions: []string{"s3:ListBucket", "s3:GetLifecycleConfiguration"},
+		New: func(ctx ScanContext) Scanner {
+			rules := ctx.BucketScan.LifecycleDetail.Rules
+			return &lifecycleCoverageScanner{
+				rules:    rules,
+				coverage: lifecycle.NewCoverage(rules),
+			}
+		},
+	})
+}
+
+// Evaluates the bucket's lifecycle rules against every object
+// ObjectCount and DataSize of the ObjectScan are the objects no enabled rule applies to
+// Object tags are not retrieved, bytes that a tag filtered rule could cover are reported as TagsUnknownBytes
+type lifecycleCoverageScanner struct {
+	rules    []*s3.LifecycleRule
+	coverage *lifecycle.Coverage
+}
+
+func (s *lifecycleCoverageScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		s.coverage.Add(s.rules, lifecycle.Object{
+			Key:  *object.Key,
+			Size: *object.Size,
+		})
+	}
+	return nil
+}
+
+func (s *lifecycleCoverageScanner) Finalize() (ObjectScan, error) {
+	s.coverage.CalculateFractions()
+
+	return ObjectScan{
+		DataCategory: "lifecycle_coverage",
+		ObjectCount:  s.coverage.UncoveredObjectCount,
+		DataSize:     s.coverage.UncoveredBytes,
+		Details:      s.coverage,
+	}, nil
+}
@@ -0,0 +1,401 @@
+package scan
+
+//This file scans for objects duplicated across the buckets of one request
+//Every bucket's listing feeds a shared DuplicateIndex, which ScanS3 resolves once all buckets are scanned
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+)
+
+// Defaults that bound the memory used by a DuplicateIndex
+const (
+	defaultDuplicateIndexMaxEntries = 500000
+	defaultDuplicateIndexMinSize    = 1024 * 1024 //smaller objects are not worth tracking across buckets
+)
+
+// CrossBucketDuplicateDetail is the ObjectScan.Details of the cross_bucket_duplicates scan for one bucket
+type CrossBucketDuplicateDetail struct {
+	Pairs           []BucketPair           `json:"bucket_pairs"` //pairs where this bucket holds the redundant copies
+	Groups          []CrossBucketDuplicate `json:"groups"`       //largest first, at most maxDuplicateGroups
+	MinIndexedSize  int64                  `json:"min_indexed_size"`
+	IndexTruncated  bool                   `json:"index_truncated"` //the index raised its minimum size to stay within its memory bound
+	ConfirmedGroups int64                  `json:"confirmed_groups"`
+}
+
+// BucketPair contains the copies in DuplicateBucket of objects whose canonical copy is in CanonicalBucket
+type BucketPair struct {
+	CanonicalBucket  string                    `json:"canonical_bucket"`
+	DuplicateBucket  string                    `json:"duplicate_bucket"`
+	ObjectCount      int64                     `json:"object_count"`
+	DataSize         int64                     `json:"data_size"`
+	EstimatedSavings estimate.EstimatedSavings `json:"estimated_savings"`
+}
+
+// CrossBucketDuplicate contains the copies of one object across buckets
+type CrossBucketDuplicate struct {
+	Size        int64           `json:"size"`
+	ETag        string          `json:"etag"`
+	ConfirmedBy string          `json:"confirmed_by"` //etag, or checksum when every copy's checksum matched
+	Canonical   DuplicateCopy   `json:"canonical"`
+	Copies      []DuplicateCopy `json:"copies"`
+}
+
+// DuplicateCopy is the first copy of an object found in a bucket and the number of copies in that bucket
+type DuplicateCopy struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	StorageClass string    `json:"storage_class"`
+	LastModified time.Time `json:"last_modified"`
+	Count        int64     `json:"count"`
+}
+
+// The fingerprint of an object in the DuplicateIndex
+type duplicateKey struct {
+	Size int64
+	ETag string
+}
+
+// One fingerprint in the DuplicateIndex
+type duplicateEntry struct {
+	Size   int64
+	ETag   string
+	Copies []DuplicateCopy //one per bucket
+}
+
+// DuplicateIndex groups objects by size and ETag across buckets
+// It holds at most MaxEntries fingerprints, when full it raises its minimum object size and drops smaller entries,
+// so the largest, most valuable duplicates are always kept
+type DuplicateIndex struct {
+	mu         sync.Mutex
+	MaxEntries int
+	MinSize    int64
+	truncated  bool
+	entries    map[duplicateKey]*duplicateEntry
+}
+
+// Creates a DuplicateIndex with the default memory bound
+func NewDuplicateIndex() *DuplicateIndex {
+	return &DuplicateIndex{
+		MaxEntries: defaultDuplicateIndexMaxEntries,
+		MinSize:    defaultDuplicateIndexMinSize,
+		entries:    make(map[duplicateKey]*duplicateEntry),
+	}
+}
+
+// Adds one object of a bucket to the index
+func (idx *DuplicateIndex) Add(bucket string, object *s3.Object) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	size := aws.Int64Value(object.Size)
+	if size < idx.MinSize {
+		return
+	}
+	etag := strings.Trim(aws.StringValue(object.ETag), "\"")
+	fingerprint := duplicateFingerprint(size, etag)
+
+	entry, ok := idx.entries[fingerprint]
+	if !ok {
+		entry = &duplicateEntry{Size: size, ETag: etag}
+		idx.entries[fingerprint] = entry
+	}
+
+	for i := range entry.Copies {
+		if entry.Copies[i].Bucket == bucket {
+			entry.Copies[i].Count++
+			return
+		}
+	}
+	entry.Copies = append(entry.Copies, DuplicateCopy{
+		Bucket:       bucket,
+		Key:          aws.StringValue(object.Key),
+		StorageClass: aws.StringValue(object.StorageClass),
+		LastModified: aws.TimeValue(object.LastModified),
+		Count:        1,
+	})
+
+	if len(idx.entries) > idx.MaxEntries {
+		idx.shrink()
+	}
+}
+
+// HELPER for Add()
+// Raises MinSize to the median indexed size and drops every entry below it
+func (idx *DuplicateIndex) shrink() {
+	sizes := make([]int64, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		sizes = append(sizes, entry.Size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	newMin := sizes[len(sizes)/2]
+	if newMin <= idx.MinSize {
+		newMin = idx.MinSize + 1
+	}
+	for fingerprint, entry := range idx.entries {
+		if entry.Size < newMin {
+			delete(idx.entries, fingerprint)
+		}
+	}
+	idx.MinSize = newMin
+	idx.truncated = true
+}
+
+// Returns the objects found in more than one bucket
+// The canonical copy is the oldest, copies in other buckets are redundant
+func (idx *DuplicateIndex) Duplicates() []CrossBucketDuplicate {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	duplicates := []CrossBucketDuplicate{}
+	for _, entry := range idx.entries {
+		if len(entry.Copies) < 2 {
+			continue
+		}
+		copies := make([]DuplicateCopy, len(entry.Copies))
+		copy(copies, entry.Copies)
+		sort.SliceStable(copies, func(i, j int) bool {
+			if !copies[i].LastModified.Equal(copies[j].LastModified) {
+				return copies[i].LastModified.Before(copies[j].LastModified)
+			}
+			return copies[i].Bucket < copies[j].Bucket
+		})
+		duplicates = append(duplicates, CrossBucketDuplicate{
+			Size:        entry.Size,
+			ETag:        entry.ETag,
+			ConfirmedBy: "etag",
+			Canonical:   copies[0],
+			Copies:      copies[1:],
+		})
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Size != duplicates[j].Size {
+			return duplicates[i].Size > duplicates[j].Size
+		}
+		return duplicates[i].ETag < duplicates[j].ETag
+	})
+	return duplicates
+}
+
+// Takes in the cross bucket duplicates and returns the cross_bucket_duplicates ObjectScan of every bucket holding redundant copies
+// When withinBucketCounted, duplicate_objects already counts the extra copies inside each bucket, so only one copy per bucket is counted
+// Copies locked by Object Lock, checked with the bucket's lockChecker in locks, are left out of the savings
+// Only the first copy in a bucket is looked up, the bucket's other copies share its result
+func crossBucketObjectScans(duplicates []CrossBucketDuplicate, minSize int64, truncated bool, withinBucketCounted bool, locks map[string]*lockChecker) (map[string]ObjectScan, error) {
+	objectScans := make(map[string]ObjectScan)
+	details := make(map[string]*CrossBucketDuplicateDetail)
+	pairs := make(map[string]map[string]*BucketPair)
+
+	for _, duplicate := range duplicates {
+		for _, c := range duplicate.Copies {
+			count := c.Count
+			if withinBucketCounted {
+				count = 1
+			}
+			locked, err := locks[c.Bucket].locked(c.Key, duplicate.Size*count, c.LastModified)
+			if err != nil {
+				return nil, err
+			}
+			objectScan := objectScans[c.Bucket]
+			objectScan.DataCategory = "cross_bucket_duplicates"
+			savings := 0.0
+			if !locked {
+				savings = estimate.SavingsForBytesDeletedByStorageClass(duplicate.Size*count, c.StorageClass)
+			}
+			objectScan.ObjectCount += count
+			objectScan.DataSize += duplicate.Size * count
+			objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin += savings
+			objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += savings
+			objectScans[c.Bucket] = objectScan
+
+			detail, ok := details[c.Bucket]
+			if !ok {
+				detail = &CrossBucketDuplicateDetail{MinIndexedSize: minSize, IndexTruncated: truncated}
+				details[c.Bucket] = detail
+				pairs[c.Bucket] = make(map[string]*BucketPair)
+			}
+			if len(detail.Groups) < maxDuplicateGroups {
+				detail.Groups = append(detail.Groups, duplicate)
+			}
+			if duplicate.ConfirmedBy != "etag" {
+				detail.ConfirmedGroups++
+			}
+
+			pair, ok := pairs[c.Bucket][duplicate.Canonical.Bucket]
+			if !ok {
+				pair = &BucketPair{CanonicalBucket: duplicate.Canonical.Bucket, DuplicateBucket: c.Bucket}
+				pairs[c.Bucket][duplicate.Canonical.Bucket] = pair
+			}
+			pair.ObjectCount += count
+			pair.DataSize += duplicate.Size * count
+			pair.EstimatedSavings.CalculatedMonthlylSavingsMin += savings
+			pair.EstimatedSavings.CalculatedMonthlySavingsMax += savings
+		}
+	}
+
+	for bucket, detail := range details {
+		for _, pair := range pairs[bucket] {
+			detail.Pairs = append(detail.Pairs, *pair)
+		}
+		sort.Slice(detail.Pairs, func(i, j int) bool {
+			return detail.Pairs[i].DataSize > detail.Pairs[j].DataSize
+		})
+		objectScan := objectScans[bucket]
+		objectScan.Details = detail
+		objectScan.ObjectLock = locks[bucket].lockSummary()
+		objectScans[bucket] = objectScan
+	}
+
+	return objectScans, nil
+}
+
+// Confirms cross bucket duplicates with the stored checksums of every copy
+// Copies whose checksum differs from the canonical copy's are dropped, groups left with one copy are removed
+// Checksums of different algorithms can't be compared, those copies are kept but leave the group unconfirmed
+func confirmCrossBucketDuplicates(duplicates []CrossBucketDuplicate, checksum func(bucket string, key string) (string, error)) ([]CrossBucketDuplicate, error) {
+	confirmed := []CrossBucketDuplicate{}
+
+	for _, duplicate := range duplicates {
+		canonicalSum, err := checksum(duplicate.Canonical.Bucket, duplicate.Canonical.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		allChecked := canonicalSum != ""
+		copies := []DuplicateCopy{}
+		for _, c := range duplicate.Copies {
+			sum, err := checksum(c.Bucket, c.Key)
+			if err != nil {
+				return nil, err
+			}
+			if sum == "" || canonicalSum == "" || fingerprintAlgorithm(sum) != fingerprintAlgorithm(canonicalSum) {
+				allChecked = false
+			} else if sum != canonicalSum {
+				continue
+			}
+			copies = append(copies, c)
+		}
+
+		if len(copies) == 0 {
+			continue
+		}
+		duplicate.Copies = copies
+		if allChecked {
+			duplicate.ConfirmedBy = "checksum"
+		}
+		confirmed = append(confirmed, duplicate)
+	}
+
+	return confirmed, nil
+}
+
+// HELPER for confirmCrossBucketDuplicates()
+// Returns the algorithm of a "<algorithm>:<value>" fingerprint
+func fingerprintAlgorithm(fingerprint string) string {
+	algorithm, _, _ := strings.Cut(fingerprint, ":")
+	return algorithm
+}
+
+// Takes in the BucketScans of a request and the DuplicateIndex their objects were added to
+// and replaces each bucket's cross_bucket_duplicates ObjectScan with the bucket's redundant copies
+// In deep mode every copy's stored checksum is compared before it is counted
+func resolveCrossBucketDuplicates(sess *session.Session, results []BucketScans, idx *DuplicateIndex, opts ScanOptions) error {
+	duplicates := idx.Duplicates()
+
+	if opts.DuplicateDeepMode {
+		svc := s3.New(sess)
+		var err error
+		duplicates, err = confirmCrossBucketDuplicates(duplicates, func(bucket string, key string) (string, error) {
+			//AWS SDK GET CALL
+			attributes, err := svc.GetObjectAttributes(&s3.GetObjectAttributesInput{
+				Bucket:           aws.String(bucket),
+				Key:              aws.String(key),
+				ObjectAttributes: aws.StringSlice([]string{s3.ObjectAttributesChecksum}),
+			})
+			if isNoSuchKey(err) {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			fingerprint, _ := checksumFingerprint(attributes.Checksum)
+			return fingerprint, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	idx.mu.Lock()
+	minSize, truncated := idx.MinSize, idx.truncated
+	idx.mu.Unlock()
+	now := time.Now()
+	locks := make(map[string]*lockChecker)
+	for _, result := range results {
+		ctx := ScanContext{Session: sess, Bucket: result.BucketSummary, BucketScan: result.Scans.BucketScan, Options: opts}
+		locks[result.BucketSummary.Name] = newLockChecker(ctx, now)
+	}
+	objectScans, err := crossBucketObjectScans(duplicates, minSize, truncated, opts.ScannerEnabled("duplicate_objects"), locks)
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		for j, objectScan := range results[i].Scans.ObjectScans {
+			if objectScan.DataCategory != "cross_bucket_duplicates" {
+				continue
+			}
+			if resolved, ok := objectScans[results[i].BucketSummary.Name]; ok {
+				results[i].Scans.ObjectScans[j] = resolved
+			}
+		}
+	}
+	return nil
+}
+
+// HELPER for DuplicateIndex
+// Keys entries by the size and ETag themselves, a hash of them could collide and merge different objects
+// The key shares its ETag string with the entry, so it costs little more than a hash
+func duplicateFingerprint(size int64, etag string) duplicateKey {
+	return duplicateKey{Size: size, ETag: etag}
+}
+
+// Feeds every object of a bucket into the request's DuplicateIndex
+// The ObjectScan is filled in by ScanS3 once every bucket has been scanned
+type crossBucketDuplicatesScanner struct {
+	ctx ScanContext
+}
+
+func (s *crossBucketDuplicatesScanner) Consume(page *s3.ListObjectsV2Output) error {
+	if s.ctx.DuplicateIndex == nil {
+		return nil
+	}
+	for _, object := range page.Contents {
+		s.ctx.DuplicateIndex.Add(s.ctx.Bucket.Name, object)
+	}
+	return nil
+}
+
+func (s *crossBucketDuplicatesScanner) Finalize() (ObjectScan, error) {
+	return ObjectScan{DataCategory: "cross_bucket_duplicates"}, nil
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:                "cross_bucket_duplicates",
+		Permissions:         []string{"s3:ListBucket"},
+		OptionalPermissions: []string{"s3:GetObject", "s3:GetObjectAttributes", "s3:GetObjectRetention", "s3:GetObjectLegalHold"},
+		New: func(ctx ScanContext) Scanner {
+			return &crossBucketDuplicatesScanner{ctx: ctx}
+		},
+	})
+}
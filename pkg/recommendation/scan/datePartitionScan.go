@@ -0,0 +1,385 @@
+package scan
+
+//This file scans for date partitions in keys, ex. "events/dt=2022-01-05/part-0001.parquet" or "logs/2021/11/03/app.log"
+//Data age is taken from the partition date, since LastModified resets when a partition is rewritten or copied
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+)
+
+// Partition age, in days, at which data is proposed for GLACIER
+const (
+	partitionTransitionDays = 90
+	partitionRewriteDays    = 30 //objects written this long after their partition date were rewritten or copied
+	partitionedBucketShare  = 0.9
+)
+
+// The age bands partitioned data is grouped into, by partition date
+var partitionAgeBands = []ageBand{
+	{"0-30 days", 0},
+	{"30-90 days", 30},
+	{"90-365 days", 90},
+	{"365+ days", 365},
+}
+
+// A date layout found in keys
+// The pattern's groups are the year, month and day, and the day may be missing
+type partitionLayout struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+var partitionLayouts = []partitionLayout{
+	{"key=YYYY-MM-DD", regexp.MustCompile(`(?i)(?:^|/)[a-z_]*(?:date|dt|ds|day)=(\d{4})-?(\d{2})-?(\d{2})(?:/|$)`)},
+	{"year=YYYY/month=MM/day=DD", regexp.MustCompile(`(?i)(?:^|/)year=(\d{4})/month=(\d{1,2})(?:/day=(\d{1,2}))?(?:/|$)`)},
+	{"YYYY/MM/DD", regexp.MustCompile(`(?:^|/)(\d{4})/(\d{2})/(\d{2})(?:/|$)`)},
+	{"YYYY-MM-DD", regexp.MustCompile(`(?:^|/)(\d{4})-(\d{2})-(\d{2})(?:/|$)`)},
+}
+
+// DatePartitionDetail is the ObjectScan.Details of the date_partitions scan
+// ObjectCount and DataSize of the ObjectScan are the objects in warm storage classes that are past the transition age by partition date
+type DatePartitionDetail struct {
+	PartitionedObjects int64                `json:"partitioned_objects"`
+	PartitionedSize    int64                `json:"partitioned_size"`
+	TotalSize          int64                `json:"total_size"` //every object listed, partitioned or not
+	NewestPartition    time.Time            `json:"newest_partition"`
+	Datasets           []PartitionedDataset `json:"datasets"`
+	ProposedRules      []ProposedRule       `json:"proposed_rules"`
+}
+
+// Checks if nearly all of the bucket's data is partitioned by date, so the partition dates can stand for the bucket's age
+func (d DatePartitionDetail) CoversBucket() bool {
+	return d.TotalSize > 0 && float64(d.PartitionedSize) >= partitionedBucketShare*float64(d.TotalSize)
+}
+
+// PartitionedDataset contains the objects under one prefix partitioned by date with one layout
+type PartitionedDataset struct {
+	Prefix               string               `json:"prefix"` //key prefix before the date, ex. "events/"
+	Layout               string               `json:"layout"`
+	ObjectCount          int64                `json:"object_count"`
+	DataSize             int64                `json:"data_size"`
+	OldestPartition      time.Time            `json:"oldest_partition"`
+	NewestPartition      time.Time            `json:"newest_partition"`
+	AgeBands             []PartitionAgeBand   `json:"age_bands"`
+	RewrittenObjectCount int64                `json:"rewritten_object_count"` //written 30+ days after their partition date
+	RewrittenSize        int64                `json:"rewritten_size"`
+	WarmObjectCount      int64                `json:"warm_object_count"` //in storage classes warmer than GLACIER, the ones a rule on the prefix moves
+	WarmSize             int64                `json:"warm_size"`
+	LifecycleEvaluation  lifecycle.Evaluation `json:"lifecycle_evaluation"` //of the dataset's first key
+}
+
+// PartitionAgeBand contains the partitioned data in an age band, by partition date
+type PartitionAgeBand struct {
+	Band        string `json:"band"`
+	ObjectCount int64  `json:"object_count"`
+	DataSize    int64  `json:"data_size"`
+}
+
+// ProposedRule is a lifecycle rule filtered by a date-aware prefix
+// Days is 0 for one-time rules that move data that is already past the transition age
+// The cost-benefit assumes the data is in STANDARD, rules that don't break even in time aren't proposed
+type ProposedRule struct {
+	Prefix       string `json:"prefix"`
+	Action       string `json:"action"`
+	StorageClass string `json:"storage_class"`
+	Days         int64  `json:"days"`
+	ObjectCount  int64  `json:"object_count"`
+	DataSize     int64  `json:"data_size"`
+	Reason       string `json:"reason"`
+	estimate.TransitionEstimate
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "date_partitions",
+		Permissions: []string{"s3:ListBucket", "s3:GetLifecycleConfiguration"},
+		New: func(ctx ScanContext) Scanner {
+			return newDatePartitionScanner(ctx.BucketScan.LifecycleDetail.Rules, ctx.Options.EffectivePolicy(), time.Now())
+		},
+	})
+}
+
+// Scanner for data partitioned by date in its keys
+type datePartitionScanner struct {
+	rules  []*s3.LifecycleRule
+	policy policy.Policy
+	now    time.Time
+
+	detail   DatePartitionDetail
+	datasets map[string]*PartitionedDataset //by prefix and layout
+	periods  map[string]*partitionPeriod    //by year prefix, ex. "events/dt=2021-"
+	count    int64
+	size     int64
+}
+
+// The partitions of one dataset in one year
+type partitionPeriod struct {
+	newestPartition time.Time
+	objectCount     int64 //warm objects, the ones a rule on the year prefix moves
+	dataSize        int64
+	rewrittenCount  int64 //warm objects past the transition age by partition date but not by LastModified
+	rewrittenSize   int64
+}
+
+func newDatePartitionScanner(rules []*s3.LifecycleRule, p policy.Policy, now time.Time) *datePartitionScanner {
+	return &datePartitionScanner{
+		rules:    rules,
+		policy:   p,
+		now:      now,
+		datasets: make(map[string]*PartitionedDataset),
+		periods:  make(map[string]*partitionPeriod),
+	}
+}
+
+func (s *datePartitionScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		s.detail.TotalSize += *object.Size
+
+		partition, ok := parsePartition(*object.Key)
+		if !ok {
+			continue
+		}
+		s.detail.PartitionedObjects++
+		s.detail.PartitionedSize += *object.Size
+		if partition.date.After(s.detail.NewestPartition) {
+			s.detail.NewestPartition = partition.date
+		}
+
+		dataset, ok := s.datasets[partition.prefix+"|"+partition.layout]
+		if !ok {
+			dataset = &PartitionedDataset{
+				Prefix:              partition.prefix,
+				Layout:              partition.layout,
+				OldestPartition:     partition.date,
+				AgeBands:            make([]PartitionAgeBand, len(partitionAgeBands)),
+				LifecycleEvaluation: lifecycle.Evaluate(s.rules, lifecycle.Object{Key: *object.Key, Size: *object.Size}),
+			}
+			for i, band := range partitionAgeBands {
+				dataset.AgeBands[i].Band = band.Name
+			}
+			s.datasets[partition.prefix+"|"+partition.layout] = dataset
+		}
+		dataset.ObjectCount++
+		dataset.DataSize += *object.Size
+		if partition.date.Before(dataset.OldestPartition) {
+			dataset.OldestPartition = partition.date
+		}
+		if partition.date.After(dataset.NewestPartition) {
+			dataset.NewestPartition = partition.date
+		}
+
+		partitionAge := ageInDays(partition.date, s.now)
+		b := ageBandIndex(partitionAgeBands, partitionAge)
+		dataset.AgeBands[b].ObjectCount++
+		dataset.AgeBands[b].DataSize += *object.Size
+
+		var modifiedAge int
+		if object.LastModified != nil {
+			modifiedAge = ageInDays(*object.LastModified, s.now)
+			if partitionAge-modifiedAge >= partitionRewriteDays {
+				dataset.RewrittenObjectCount++
+				dataset.RewrittenSize += *object.Size
+			}
+		}
+
+		period, ok := s.periods[partition.yearPrefix]
+		if !ok {
+			period = &partitionPeriod{}
+			s.periods[partition.yearPrefix] = period
+		}
+		if partition.date.After(period.newestPartition) {
+			period.newestPartition = partition.date
+		}
+
+		//Warm data past the transition age, savings come from the proposed rules that move it
+		storageClass := "STANDARD"
+		if object.StorageClass != nil {
+			storageClass = *object.StorageClass
+		}
+		price, ok := estimate.StorageClassPrices[storageClass]
+		if !ok || price <= estimate.StorageClassPrices["GLACIER"] {
+			continue
+		}
+		dataset.WarmObjectCount++
+		dataset.WarmSize += *object.Size
+		period.objectCount++
+		period.dataSize += *object.Size
+		if partitionAge < partitionTransitionDays {
+			continue
+		}
+		s.count++
+		s.size += *object.Size
+		if modifiedAge < partitionTransitionDays {
+			period.rewrittenCount++
+			period.rewrittenSize += *object.Size
+		}
+	}
+	return nil
+}
+
+func (s *datePartitionScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "date_partitions"}
+	if len(s.datasets) == 0 {
+		return objectScan, nil
+	}
+
+	detail := &s.detail
+	for _, dataset := range s.datasets {
+		detail.Datasets = append(detail.Datasets, *dataset)
+	}
+	sort.Slice(detail.Datasets, func(i, j int) bool {
+		if detail.Datasets[i].DataSize != detail.Datasets[j].DataSize {
+			return detail.Datasets[i].DataSize > detail.Datasets[j].DataSize
+		}
+		return detail.Datasets[i].Prefix < detail.Datasets[j].Prefix
+	})
+
+	//An ongoing rule for every dataset with warm data that no lifecycle rule transitions or expires, priced for its warm data
+	//Lifecycle rules count days from LastModified, so a rewritten partition only moves 90 days after its rewrite
+	for _, dataset := range detail.Datasets {
+		if dataset.WarmObjectCount == 0 || dataset.LifecycleEvaluation.Transition || dataset.LifecycleEvaluation.Expiration {
+			continue
+		}
+		detail.ProposedRules = append(detail.ProposedRules, ProposedRule{
+			Prefix:       dataset.Prefix,
+			Action:       "Transition",
+			StorageClass: "GLACIER",
+			Days:         partitionTransitionDays,
+			ObjectCount:  dataset.WarmObjectCount,
+			DataSize:     dataset.WarmSize,
+			Reason:       "partitions laid out as " + dataset.Layout + " are no longer written once they are old, no lifecycle rule transitions or expires them; the rule counts days from LastModified, not the partition date",
+		})
+	}
+
+	//A one-time rule for every year of partitions past the transition age that LastModified based rules won't move yet
+	//It moves every warm object under the year prefix, not only the rewritten ones, and is priced for all of them
+	yearPrefixes := make([]string, 0, len(s.periods))
+	for yearPrefix := range s.periods {
+		yearPrefixes = append(yearPrefixes, yearPrefix)
+	}
+	sort.Strings(yearPrefixes)
+	for _, yearPrefix := range yearPrefixes {
+		period := s.periods[yearPrefix]
+		if period.rewrittenCount == 0 || ageInDays(period.newestPartition, s.now) < partitionTransitionDays {
+			continue
+		}
+		detail.ProposedRules = append(detail.ProposedRules, ProposedRule{
+			Prefix:       yearPrefix,
+			Action:       "Transition",
+			StorageClass: "GLACIER",
+			Days:         0,
+			ObjectCount:  period.objectCount,
+			DataSize:     period.dataSize,
+			Reason:       "every partition is past the transition age but was rewritten or copied recently, so rules based on LastModified won't move it yet",
+		})
+	}
+
+	proposedRules, err := s.breakEvenRules(detail.ProposedRules)
+	if err != nil {
+		return objectScan, err
+	}
+	detail.ProposedRules = proposedRules
+
+	objectScan.ObjectCount = s.count
+	objectScan.DataSize = s.size
+	objectScan.EstimatedSavings = proposedRuleSavings(detail.ProposedRules)
+	objectScan.Details = detail
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Returns the savings of the proposed rules, max savings from every rule and min savings from the one-time rules,
+// which move data that is already past the transition age
+// One-time rules under an ongoing rule's prefix only move its data sooner, so they don't add to max savings
+func proposedRuleSavings(rules []ProposedRule) estimate.EstimatedSavings {
+	savings := estimate.EstimatedSavings{}
+	for _, rule := range rules {
+		if rule.Days == 0 {
+			savings.CalculatedMonthlylSavingsMin += rule.MonthlyDelta
+			if underOngoingRule(rules, rule.Prefix) {
+				continue
+			}
+		}
+		savings.CalculatedMonthlySavingsMax += rule.MonthlyDelta
+	}
+	return savings
+}
+
+// HELPER for proposedRuleSavings()
+func underOngoingRule(rules []ProposedRule, prefix string) bool {
+	for _, rule := range rules {
+		if rule.Days > 0 && strings.HasPrefix(prefix, rule.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HELPER for Finalize()
+// Returns the proposed rules whose transition pays back its upfront cost within the policy's break-even months
+func (s *datePartitionScanner) breakEvenRules(rules []ProposedRule) ([]ProposedRule, error) {
+	kept := []ProposedRule{}
+	for _, rule := range rules {
+		e, err := estimate.EstimateTransition(rule.ObjectCount, rule.DataSize, "STANDARD", rule.StorageClass, s.policy.RetrievalRate, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !e.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) {
+			continue
+		}
+		rule.TransitionEstimate = e
+		kept = append(kept, rule)
+	}
+	return kept, nil
+}
+
+// A date partition found in a key
+type keyPartition struct {
+	date       time.Time
+	layout     string
+	prefix     string //key prefix before the date, ex. "events/"
+	yearPrefix string //key prefix up to the partition's year, ex. "events/dt=2021-"
+}
+
+// HELPER for Consume()
+// Returns the first date partition in a key, partitions without a day are dated the first of the month
+func parsePartition(key string) (keyPartition, bool) {
+	for _, layout := range partitionLayouts {
+		match := layout.Pattern.FindStringSubmatchIndex(key)
+		if match == nil {
+			continue
+		}
+		year, month, day := key[match[2]:match[3]], key[match[4]:match[5]], "1"
+		if match[6] >= 0 {
+			day = key[match[6]:match[7]]
+		}
+		date, err := time.Parse("2006-1-2", year+"-"+strings.TrimLeft(month, "0")+"-"+strings.TrimLeft(day, "0"))
+		if err != nil {
+			continue
+		}
+
+		start := match[0]
+		if key[start] == '/' {
+			start++
+		}
+		yearEnd := match[3]
+		if yearEnd < len(key) && (key[yearEnd] == '-' || key[yearEnd] == '/') {
+			yearEnd++
+		}
+		return keyPartition{
+			date:       date,
+			layout:     layout.Name,
+			prefix:     key[:start],
+			yearPrefix: key[:yearEnd],
+		}, true
+	}
+	return keyPartition{}, false
+}
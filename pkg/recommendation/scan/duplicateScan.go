@@ -0,0 +1,455 @@
+package scan
+
+//This file scans for duplicate objects
+//The default mode compares size and ETag from the object listing
+//The opt-in deep mode groups objects by size and confirms duplicates with stored checksums or by hashing their content
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+)
+
+// At most this many duplicate groups are reported, largest duplicate size first
+const maxDuplicateGroups = 100
+
+// Size of each ranged GET when hashing object content
+const duplicateHashChunkSize = 8 * 1024 * 1024
+
+// At most this many objects per bucket are read with HeadObject, the rest can only be identified by hashing
+const maxDuplicateHeadLookups = 10000
+
+// DuplicateDetail is the ObjectScan.Details of the duplicate_objects scan
+type DuplicateDetail struct {
+	Mode             string           `json:"mode"` //"etag" or "deep"
+	GroupCount       int64            `json:"group_count"`
+	Groups           []DuplicateGroup `json:"groups"`
+	UnconfirmedCount int64            `json:"unconfirmed_count"` //deep mode, same size objects that could not be compared
+	BytesHashed      int64            `json:"bytes_hashed"`      //deep mode, bytes read to hash object content
+	LookupErrors     int64            `json:"lookup_errors"`     //deep mode, objects left unconfirmed because a HeadObject or GET failed
+}
+
+// DuplicateGroup contains the keys of objects with identical content
+// The first key is the copy to keep
+type DuplicateGroup struct {
+	Size          int64    `json:"size"`
+	Fingerprint   string   `json:"fingerprint"`
+	ConfirmedBy   string   `json:"confirmed_by"` //etag, checksum type, or sha256 for hashed content
+	Keys          []string `json:"keys"`
+	DuplicateSize int64    `json:"duplicate_size"` //bytes that could be deleted
+}
+
+// One object from the listing that could be a duplicate
+type duplicateCandidate struct {
+	Key          string
+	Size         int64
+	ETag         string
+	StorageClass string
+	LastModified time.Time
+}
+
+func newDuplicateCandidate(object *s3.Object) duplicateCandidate {
+	return duplicateCandidate{
+		Key:          *object.Key,
+		Size:         *object.Size,
+		ETag:         *object.ETag,
+		StorageClass: *object.StorageClass,
+		LastModified: aws.TimeValue(object.LastModified),
+	}
+}
+
+// Checks for potentially duplicate objects based on the Etag hash and size
+// Keeps the count of duplicates and size of duplicates
+// Duplicates locked by Object Lock are kept and left out of the savings
+type duplicateObjectsScanner struct {
+	locks                 *lockChecker
+	seen                  map[string]string //fingerprint to the first key seen with it
+	groups                map[string]*DuplicateGroup
+	totalCount, totalSize int64
+	totalSavings          float64
+}
+
+func newDuplicateObjectsScanner(locks *lockChecker) *duplicateObjectsScanner {
+	return &duplicateObjectsScanner{
+		locks:  locks,
+		seen:   make(map[string]string),
+		groups: make(map[string]*DuplicateGroup),
+	}
+}
+
+func (s *duplicateObjectsScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		// Check if the object's size and ETag have already been seen
+		key := fmt.Sprintf("%d-%s", *object.Size, *object.ETag)
+		if firstKey, ok := s.seen[key]; ok {
+			s.totalCount++
+			s.totalSize += *object.Size
+			locked, err := s.locks.locked(*object.Key, *object.Size, aws.TimeValue(object.LastModified))
+			if err != nil {
+				return err
+			}
+			if !locked {
+				s.totalSavings += estimate.SavingsForBytesDeletedByStorageClass(*object.Size, *object.StorageClass)
+			}
+
+			group, ok := s.groups[key]
+			if !ok {
+				group = &DuplicateGroup{
+					Size:        *object.Size,
+					Fingerprint: key,
+					ConfirmedBy: "etag",
+					Keys:        []string{firstKey},
+				}
+				s.groups[key] = group
+			}
+			group.Keys = append(group.Keys, *object.Key)
+			group.DuplicateSize += *object.Size
+		} else {
+			s.seen[key] = *object.Key
+		}
+	}
+	return nil
+}
+
+func (s *duplicateObjectsScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{
+		DataCategory: "duplicate_objects",
+		ObjectCount:  s.totalCount,
+		DataSize:     s.totalSize,
+		EstimatedSavings: estimate.EstimatedSavings{
+			CalculatedMonthlylSavingsMin: s.totalSavings,
+			CalculatedMonthlySavingsMax:  s.totalSavings,
+		},
+		ObjectLock: s.locks.lockSummary(),
+	}
+
+	if len(s.groups) > 0 {
+		groups := make([]DuplicateGroup, 0, len(s.groups))
+		for _, group := range s.groups {
+			groups = append(groups, *group)
+		}
+		objectScan.Details = &DuplicateDetail{
+			Mode:       "etag",
+			GroupCount: int64(len(groups)),
+			Groups:     topDuplicateGroups(groups),
+		}
+	}
+
+	return objectScan, nil
+}
+
+// Confirms duplicates by content instead of ETag, which misses objects uploaded with different part sizes
+// Objects of the same size are compared by stored checksum, single part ETag, or by hashing their content within HashBudget
+type deepDuplicateObjectsScanner struct {
+	ctx    ScanContext
+	locks  *lockChecker
+	bySize map[int64][]duplicateCandidate
+}
+
+func (s *deepDuplicateObjectsScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		//Empty objects cost nothing to keep
+		if *object.Size == 0 {
+			continue
+		}
+		s.bySize[*object.Size] = append(s.bySize[*object.Size], newDuplicateCandidate(object))
+	}
+	return nil
+}
+
+func (s *deepDuplicateObjectsScanner) Finalize() (ObjectScan, error) {
+	identifier := &s3ContentIdentifier{
+		svc:    s3.New(s.ctx.Session),
+		bucket: s.ctx.Bucket.Name,
+		budget: s.ctx.Options.DuplicateHashBudget,
+	}
+
+	objectScan, err := confirmDuplicates(s.bySize, identifier.identify, identifier.identifyByHash, s.locks)
+	if err != nil {
+		return objectScan, err
+	}
+	if detail, ok := objectScan.Details.(*DuplicateDetail); ok {
+		detail.BytesHashed = identifier.hashed
+	}
+	return objectScan, nil
+}
+
+// Returns a content fingerprint for an object and how it was obtained
+// An empty fingerprint means the object could not be identified, an error that the lookup failed
+type contentIdentifier func(candidate duplicateCandidate) (fingerprint string, confirmedBy string, err error)
+
+// An object of a size group and its content fingerprint
+type identifiedCandidate struct {
+	duplicateCandidate
+	fingerprint string
+	confirmedBy string
+}
+
+// HELPER for deepDuplicateObjectsScanner
+// Takes in objects grouped by size and contentIdentifiers and returns the duplicate_objects ObjectScan
+// Only sizes shared by at least two objects are identified, duplicates locked by Object Lock are left out of the savings
+// Objects whose lookup fails stay unconfirmed and are counted in LookupErrors
+// Fingerprints of different methods never match, sizes identified with more than one method are compared with hash, a SHA-256 of the content
+func confirmDuplicates(bySize map[int64][]duplicateCandidate, identify contentIdentifier, hash contentIdentifier, locks *lockChecker) (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "duplicate_objects"}
+	detail := DuplicateDetail{Mode: "deep"}
+	groups := []DuplicateGroup{}
+	var totalSavings float64
+
+	//Identify in size order so results and hash budget use are repeatable
+	sizes := make([]int64, 0, len(bySize))
+	for size, candidates := range bySize {
+		if len(candidates) > 1 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+
+	for _, size := range sizes {
+		candidates, lookupErrors := identifySize(bySize[size], identify, hash)
+		detail.LookupErrors += lookupErrors
+
+		byFingerprint := make(map[string]*DuplicateGroup)
+		fingerprints := []string{}
+		for _, candidate := range candidates {
+			if candidate.fingerprint == "" {
+				detail.UnconfirmedCount++
+				continue
+			}
+
+			group, ok := byFingerprint[candidate.fingerprint]
+			if !ok {
+				group = &DuplicateGroup{Size: size, Fingerprint: candidate.fingerprint, ConfirmedBy: candidate.confirmedBy}
+				byFingerprint[candidate.fingerprint] = group
+				fingerprints = append(fingerprints, candidate.fingerprint)
+			} else {
+				objectScan.ObjectCount++
+				objectScan.DataSize += size
+				group.DuplicateSize += size
+				locked, err := locks.locked(candidate.Key, size, candidate.LastModified)
+				if err != nil {
+					return objectScan, err
+				}
+				if !locked {
+					totalSavings += estimate.SavingsForBytesDeletedByStorageClass(size, candidate.StorageClass)
+				}
+			}
+			group.Keys = append(group.Keys, candidate.Key)
+		}
+
+		for _, fingerprint := range fingerprints {
+			if group := byFingerprint[fingerprint]; len(group.Keys) > 1 {
+				groups = append(groups, *group)
+			}
+		}
+	}
+
+	objectScan.EstimatedSavings = estimate.EstimatedSavings{
+		CalculatedMonthlylSavingsMin: totalSavings,
+		CalculatedMonthlySavingsMax:  totalSavings,
+	}
+	objectScan.ObjectLock = locks.lockSummary()
+	if len(groups) > 0 || detail.UnconfirmedCount > 0 || detail.LookupErrors > 0 {
+		detail.GroupCount = int64(len(groups))
+		detail.Groups = topDuplicateGroups(groups)
+		objectScan.Details = &detail
+	}
+
+	return objectScan, nil
+}
+
+// HELPER for confirmDuplicates()
+// Identifies the objects of one size, and hashes them all when they were identified with different methods
+// Returns the objects and how many of their lookups failed, an object whose lookup failed has no fingerprint
+func identifySize(candidates []duplicateCandidate, identify contentIdentifier, hash contentIdentifier) ([]identifiedCandidate, int64) {
+	identified := make([]identifiedCandidate, 0, len(candidates))
+	methods := make(map[string]bool)
+	var lookupErrors int64
+	for _, candidate := range candidates {
+		fingerprint, confirmedBy, err := identify(candidate)
+		if err != nil {
+			lookupErrors++
+			fingerprint, confirmedBy = "", ""
+		}
+		if fingerprint != "" {
+			methods[confirmedBy] = true
+		}
+		identified = append(identified, identifiedCandidate{candidate, fingerprint, confirmedBy})
+	}
+	if len(methods) < 2 {
+		return identified, lookupErrors
+	}
+
+	//A stored SHA-256 checksum is the same fingerprint as a hash of the content, those objects don't need to be read
+	for i, candidate := range identified {
+		if candidate.fingerprint == "" || candidate.confirmedBy == "sha256" {
+			continue
+		}
+		fingerprint, confirmedBy, err := hash(candidate.duplicateCandidate)
+		if err != nil {
+			lookupErrors++
+			fingerprint, confirmedBy = "", ""
+		}
+		identified[i].fingerprint, identified[i].confirmedBy = fingerprint, confirmedBy
+	}
+	return identified, lookupErrors
+}
+
+// HELPER for duplicate scanners
+// Sorts groups by duplicate size and returns at most maxDuplicateGroups of them
+func topDuplicateGroups(groups []DuplicateGroup) []DuplicateGroup {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].DuplicateSize != groups[j].DuplicateSize {
+			return groups[i].DuplicateSize > groups[j].DuplicateSize
+		}
+		return groups[i].Fingerprint < groups[j].Fingerprint
+	})
+	for i := range groups {
+		sort.Strings(groups[i].Keys[1:])
+	}
+	if len(groups) > maxDuplicateGroups {
+		groups = groups[:maxDuplicateGroups]
+	}
+	return groups
+}
+
+// Identifies object content with HeadObject and, within budget, ranged GETs
+// Objects deleted since the listing stay unconfirmed, other failed reads are returned as errors
+type s3ContentIdentifier struct {
+	svc     *s3.S3
+	bucket  string
+	budget  int64 //bytes that may be read for hashing, 0 disables hashing
+	hashed  int64
+	lookups int //HeadObject calls made, at most maxDuplicateHeadLookups
+}
+
+// Satisfies contentIdentifier
+// Prefers full object SHA checksums, then single part ETags, then hashing
+// A single part ETag is only an MD5 of the content for unencrypted and SSE-S3 objects, others are hashed
+func (i *s3ContentIdentifier) identify(candidate duplicateCandidate) (string, string, error) {
+	if i.lookups >= maxDuplicateHeadLookups {
+		return i.identifyByHash(candidate)
+	}
+	i.lookups++
+
+	//AWS SDK GET CALL
+	head, err := i.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket:       aws.String(i.bucket),
+		Key:          aws.String(candidate.Key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	})
+	if isNotFound(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	checksum := &s3.Checksum{ChecksumSHA256: head.ChecksumSHA256, ChecksumSHA1: head.ChecksumSHA1}
+	if fingerprint, confirmedBy := checksumFingerprint(checksum); fingerprint != "" {
+		return fingerprint, confirmedBy, nil
+	}
+
+	etag := strings.Trim(candidate.ETag, "\"")
+	if etag != "" && !strings.Contains(etag, "-") && etagIsMD5(head) {
+		return "etag:" + etag, "etag", nil
+	}
+	return i.identifyByHash(candidate)
+}
+
+// HELPER for s3ContentIdentifier.identify()
+// SSE-KMS, DSSE-KMS and SSE-C objects have ETags that aren't an MD5 of their content
+func etagIsMD5(head *s3.HeadObjectOutput) bool {
+	if aws.StringValue(head.SSECustomerAlgorithm) != "" {
+		return false
+	}
+	encryption := aws.StringValue(head.ServerSideEncryption)
+	return encryption == "" || encryption == s3.ServerSideEncryptionAes256
+}
+
+// Satisfies contentIdentifier
+// Hashes the object's content when it fits in the rest of the budget
+func (i *s3ContentIdentifier) identifyByHash(candidate duplicateCandidate) (string, string, error) {
+	if i.budget-i.hashed < candidate.Size {
+		return "", "", nil
+	}
+	sum, err := i.hash(candidate)
+	if isNoSuchKey(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return "sha256:" + sum, "sha256", nil
+}
+
+// Hashes an object's content with ranged GETs and returns its base64 SHA-256
+func (i *s3ContentIdentifier) hash(candidate duplicateCandidate) (string, error) {
+	hash := sha256.New()
+
+	for start := int64(0); start < candidate.Size; start += duplicateHashChunkSize {
+		end := start + duplicateHashChunkSize - 1
+		if end >= candidate.Size {
+			end = candidate.Size - 1
+		}
+
+		//AWS SDK GET CALL
+		output, err := i.svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(i.bucket),
+			Key:    aws.String(candidate.Key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			return "", err
+		}
+		n, err := io.Copy(hash, output.Body)
+		output.Body.Close()
+		i.hashed += n
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// HELPER for s3ContentIdentifier
+// Returns a fingerprint from the strongest full object SHA checksum, composite multipart checksums can't be compared
+// CRC32 and CRC32C checksums are left out, they detect corruption but different content can share one
+func checksumFingerprint(checksum *s3.Checksum) (string, string) {
+	if checksum == nil {
+		return "", ""
+	}
+
+	checksums := []struct {
+		name  string
+		value *string
+	}{
+		{"sha256", checksum.ChecksumSHA256},
+		{"sha1", checksum.ChecksumSHA1},
+	}
+	for _, c := range checksums {
+		if c.value != nil && *c.value != "" && !strings.Contains(*c.value, "-") {
+			return c.name + ":" + *c.value, c.name
+		}
+	}
+	return "", ""
+}
+
+// HELPER for confirmCrossBucketDuplicates() fetchers
+// Objects deleted while the scan runs are skipped
+func isNoSuchKey(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == s3.ErrCodeNoSuchKey
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package scan
+
+//This file scans for lifecycle rules that delete or transition objects before the minimum duration of their storage class
+//S3 bills the rest of the minimum duration anyway, ex. GLACIER data expired after 30 days is billed for 90
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+)
+
+// Days ahead whose early deletion charges count towards the savings,
+// the charge that comes back every month for data written at a steady rate
+const earlyDeletionDueDays = 30
+
+// EarlyDeletionDetail is the ObjectScan.Details of the early_deletion scan
+// ObjectCount and DataSize of the ObjectScan are the objects a rule moves or deletes early
+type EarlyDeletionDetail struct {
+	Findings []EarlyDeletionFinding `json:"findings"`
+}
+
+// EarlyDeletionFinding contains the objects one lifecycle action takes out of a storage class before its minimum duration
+type EarlyDeletionFinding struct {
+	RuleID        string  `json:"rule_id"`
+	Action        string  `json:"action"` //Expiration, or Transition to the next storage class
+	Days          int64   `json:"days"`   //days after creation the action runs
+	StorageClass  string  `json:"storage_class"`
+	EnteredDays   int64   `json:"entered_days"`   //days after creation the objects enter the storage class, 0 if they are written to it
+	MinimumDays   int     `json:"minimum_days"`   //minimum duration of the storage class
+	SuggestedDays int64   `json:"suggested_days"` //earliest days for the action that avoids the charge
+	ObjectCount   int64   `json:"object_count"`
+	DataSize      int64   `json:"data_size"`
+	Penalty       float64 `json:"penalty"` //one-time charge for the rest of the minimum duration of the objects
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "early_deletion",
+		Permissions: []string{"s3:ListBucket", "s3:GetLifecycleConfiguration"},
+		New: func(ctx ScanContext) Scanner {
+			return newEarlyDeletionScanner(ctx.BucketScan.LifecycleDetail.Rules, time.Now())
+		},
+	})
+}
+
+// Scanner for objects lifecycle rules take out of a storage class before its minimum duration
+type earlyDeletionScanner struct {
+	rules       []*s3.LifecycleRule
+	now         time.Time
+	objectCount int64
+	dataSize    int64
+	dueCharges  float64 //charges of actions due within earlyDeletionDueDays
+	findings    map[earlyDeletionKey]*EarlyDeletionFinding
+}
+
+// Findings are grouped by the action and the stay it cuts short
+type earlyDeletionKey struct {
+	ruleID       string
+	action       string
+	days         int64
+	storageClass string
+	enteredDays  int64
+}
+
+// One storage class an object is scheduled to stay in
+type classStay struct {
+	storageClass string
+	enteredDays  int64
+}
+
+func newEarlyDeletionScanner(rules []*s3.LifecycleRule, now time.Time) *earlyDeletionScanner {
+	return &earlyDeletionScanner{
+		rules:    rules,
+		now:      now,
+		findings: make(map[earlyDeletionKey]*EarlyDeletionFinding),
+	}
+}
+
+func (s *earlyDeletionScanner) Consume(page *s3.ListObjectsV2Output) error {
+	if !lifecycle.HasEnabledRule(s.rules) {
+		return nil
+	}
+	for _, object := range page.Contents {
+		storageClass := aws.StringValue(object.StorageClass)
+		if storageClass == "" {
+			storageClass = "STANDARD"
+		}
+		if _, ok := storageClassTemperatures[storageClass]; !ok {
+			continue
+		}
+		size := *object.Size
+		age := int64(0)
+		if object.LastModified != nil {
+			age = int64(ageInDays(*object.LastModified, s.now))
+		}
+
+		actions := lifecycle.Schedule(s.rules, lifecycle.Object{Key: *object.Key, Size: size})
+		stay := classStay{storageClass: storageClass, enteredDays: enteredDays(actions, storageClass, age)}
+		early := false
+		for _, action := range actions {
+			if action.Days <= stay.enteredDays {
+				continue
+			}
+			//S3 only transitions objects to colder storage classes
+			if !action.IsExpiration() {
+				temperature, ok := storageClassTemperatures[action.StorageClass]
+				if !ok || temperature <= storageClassTemperatures[stay.storageClass] {
+					continue
+				}
+			}
+
+			found, err := s.checkStay(stay, action, size, age)
+			if err != nil {
+				return err
+			}
+			early = early || found
+			if action.IsExpiration() {
+				break
+			}
+			stay = classStay{storageClass: action.StorageClass, enteredDays: action.Days}
+		}
+		if early {
+			s.objectCount++
+			s.dataSize += size
+		}
+	}
+	return nil
+}
+
+// HELPER for Consume()
+// Returns the days after creation an object entered its storage class, from the transition into it that already ran,
+// 0 if none did and it was written to the storage class
+func enteredDays(actions []lifecycle.Action, storageClass string, age int64) int64 {
+	entered := int64(0)
+	for _, action := range actions {
+		if action.StorageClass == storageClass && action.Days <= age {
+			entered = action.Days
+		}
+	}
+	return entered
+}
+
+// HELPER for Consume()
+// Records the charge of an action ending a stay before the storage class's minimum duration, and reports if it does
+func (s *earlyDeletionScanner) checkStay(stay classStay, action lifecycle.Action, size int64, age int64) (bool, error) {
+	minimumDays, ok := estimate.MinimumStorageDays[stay.storageClass]
+	daysInClass := action.Days - stay.enteredDays
+	if !ok || daysInClass >= int64(minimumDays) {
+		return false, nil
+	}
+	penalty, err := estimate.EarlyDeletionCost(1, size, stay.storageClass, int(daysInClass))
+	if err != nil {
+		return false, err
+	}
+
+	name := "Expiration"
+	if !action.IsExpiration() {
+		name = "Transition to " + action.StorageClass
+	}
+	key := earlyDeletionKey{action.RuleID, name, action.Days, stay.storageClass, stay.enteredDays}
+	finding, ok := s.findings[key]
+	if !ok {
+		finding = &EarlyDeletionFinding{
+			RuleID:        action.RuleID,
+			Action:        name,
+			Days:          action.Days,
+			StorageClass:  stay.storageClass,
+			EnteredDays:   stay.enteredDays,
+			MinimumDays:   minimumDays,
+			SuggestedDays: stay.enteredDays + int64(minimumDays),
+		}
+		s.findings[key] = finding
+	}
+	finding.ObjectCount++
+	finding.DataSize += size
+	finding.Penalty += penalty
+
+	//Actions whose day has passed are not due again
+	if dueIn := action.Days - age; dueIn >= 0 && dueIn <= earlyDeletionDueDays {
+		s.dueCharges += penalty
+	}
+	return true, nil
+}
+
+// Savings are the charges due in the next 30 days, the one-time charges of every object stored now are the findings' Penalty
+func (s *earlyDeletionScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{
+		DataCategory: "early_deletion",
+		ObjectCount:  s.objectCount,
+		DataSize:     s.dataSize,
+	}
+	if len(s.findings) == 0 {
+		return objectScan, nil
+	}
+
+	detail := &EarlyDeletionDetail{}
+	for _, finding := range s.findings {
+		detail.Findings = append(detail.Findings, *finding)
+	}
+	sort.Slice(detail.Findings, func(i, j int) bool {
+		if detail.Findings[i].Penalty != detail.Findings[j].Penalty {
+			return detail.Findings[i].Penalty > detail.Findings[j].Penalty
+		}
+		if detail.Findings[i].RuleID != detail.Findings[j].RuleID {
+			return detail.Findings[i].RuleID < detail.Findings[j].RuleID
+		}
+		return detail.Findings[i].Days < detail.Findings[j].Days
+	})
+
+	objectScan.EstimatedSavings = estimate.EstimatedSavings{
+		CalculatedMonthlylSavingsMin: s.dueCharges,
+		CalculatedMonthlySavingsMax:  s.dueCharges,
+	}
+	objectScan.Details = detail
+	return objectScan, nil
+}
@@ -0,0 +1,221 @@
+package scan
+
+//This file scans the KMS requests caused by SSE-KMS encrypted objects without S3 Bucket Keys
+//With ScanOptions.EncryptionSampling the encryption of a bounded sample of objects is read with HeadObject,
+//otherwise the bucket's default encryption is assumed for every object
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+)
+
+// Defaults for encryption sampling
+const (
+	defaultEncryptionSampleObjects = 100
+	kmsRequestMonthDays            = 30
+)
+
+// KMSRequestDetail is the ObjectScan.Details of the kms_requests scan
+// ObjectCount and DataSize of the ObjectScan are the estimated SSE-KMS objects without Bucket Keys
+type KMSRequestDetail struct {
+	DefaultAlgorithm string           `json:"default_algorithm"`
+	BucketKeyEnabled bool             `json:"bucket_key_enabled"`
+	SampledObjects   int64            `json:"sampled_objects"`
+	Algorithms       map[string]int64 `json:"algorithms"`     //sampled objects per encryption algorithm, "none" for unencrypted
+	KMSFraction      float64          `json:"kms_fraction"`   //fraction of objects using SSE-KMS without a Bucket Key
+	RequestSource    string           `json:"request_source"` //"access_logs", or "listing" when reads are unknown
+
+	MonthlyRequestsMin int64   `json:"monthly_requests_min"` //object reads and writes per month
+	MonthlyRequestsMax int64   `json:"monthly_requests_max"`
+	MonthlyKMSCostMin  float64 `json:"monthly_kms_cost_min"` //cost of the KMS requests they cause
+	MonthlyKMSCostMax  float64 `json:"monthly_kms_cost_max"`
+}
+
+// The encryption of one object
+type objectEncryption struct {
+	Algorithm        string
+	BucketKeyEnabled bool
+}
+
+// Reads the encryption of an object
+// ok is false when the object can't be read and is skipped
+type encryptionFetcher func(key string) (encryption objectEncryption, ok bool, err error)
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:                "kms_requests",
+		Permissions:         []string{"s3:ListBucket", "s3:GetEncryptionConfiguration"},
+		OptionalPermissions: []string{"s3:GetObject"},
+		New: func(ctx ScanContext) Scanner {
+			svc := s3.New(ctx.Session)
+			bucket := ctx.Bucket.Name
+			fetch := func(key string) (objectEncryption, bool, error) {
+				//AWS SDK HEAD CALL
+				output, err := svc.HeadObject(&s3.HeadObjectInput{
+					Bucket: aws.String(bucket),
+					Key:    aws.String(key),
+				})
+				if isNotFound(err) {
+					//Deleted since the listing
+					return objectEncryption{}, false, nil
+				}
+				if err != nil {
+					return objectEncryption{}, false, err
+				}
+				return objectEncryption{
+					Algorithm:        aws.StringValue(output.ServerSideEncryption),
+					BucketKeyEnabled: aws.BoolValue(output.BucketKeyEnabled),
+				}, true, nil
+			}
+			return newKMSRequestScanner(ctx, fetch, time.Now())
+		},
+	})
+}
+
+// Scanner for the KMS request cost of SSE-KMS objects without Bucket Keys
+type kmsRequestScanner struct {
+	fetch      encryptionFetcher
+	encryption EncryptionDetail
+	access     *access.AccessDetail
+	now        time.Time
+	rng        *rand.Rand
+	maxSamples int
+
+	objectCount  int64
+	dataSize     int64
+	recentWrites int64 //objects modified in the last 30 days
+	seen         int64
+	samples      []string
+}
+
+func newKMSRequestScanner(ctx ScanContext, fetch encryptionFetcher, now time.Time) *kmsRequestScanner {
+	s := &kmsRequestScanner{
+		fetch:      fetch,
+		encryption: ctx.BucketScan.EncryptionDetail,
+		access:     ctx.Access,
+		now:        now,
+		rng:        rand.New(rand.NewSource(1)),
+		maxSamples: ctx.Options.EncryptionSampleObjects,
+	}
+	if !ctx.Options.EncryptionSampling {
+		s.maxSamples = 0
+	} else if s.maxSamples == 0 {
+		s.maxSamples = defaultEncryptionSampleObjects
+	}
+	return s
+}
+
+// Keeps a uniform reservoir sample of the bucket's keys
+func (s *kmsRequestScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		s.objectCount++
+		s.dataSize += *object.Size
+		if object.LastModified != nil && ageInDays(*object.LastModified, s.now) < kmsRequestMonthDays {
+			s.recentWrites++
+		}
+
+		if s.maxSamples == 0 {
+			continue
+		}
+		s.seen++
+		if len(s.samples) < s.maxSamples {
+			s.samples = append(s.samples, *object.Key)
+		} else if i := s.rng.Int63n(s.seen); i < int64(s.maxSamples) {
+			s.samples[i] = *object.Key
+		}
+	}
+	return nil
+}
+
+// Min and max requests are the same when access logs cover the bucket,
+// from the listing alone min requests are the writes of the last 30 days and max requests add one read of every object
+func (s *kmsRequestScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "kms_requests"}
+	if s.objectCount == 0 {
+		return objectScan, nil
+	}
+
+	detail := &KMSRequestDetail{
+		DefaultAlgorithm: s.encryption.DefaultAlgorithm(),
+		BucketKeyEnabled: s.encryption.BucketKeyEnabled(),
+		Algorithms:       make(map[string]int64),
+	}
+
+	var kmsWithoutBucketKey int64
+	for _, key := range s.samples {
+		encryption, ok, err := s.fetch(key)
+		if err != nil {
+			return objectScan, err
+		}
+		if !ok {
+			continue
+		}
+		detail.SampledObjects++
+		algorithm := encryption.Algorithm
+		if algorithm == "" {
+			algorithm = "none"
+		}
+		detail.Algorithms[algorithm]++
+		if isKMS(encryption.Algorithm) && !encryption.BucketKeyEnabled {
+			kmsWithoutBucketKey++
+		}
+	}
+
+	//Without readable samples the bucket's default encryption is assumed for every object
+	if detail.SampledObjects > 0 {
+		detail.KMSFraction = float64(kmsWithoutBucketKey) / float64(detail.SampledObjects)
+	} else if isKMS(detail.DefaultAlgorithm) && !detail.BucketKeyEnabled {
+		detail.KMSFraction = 1
+	}
+	if detail.KMSFraction == 0 {
+		return objectScan, nil
+	}
+
+	detail.RequestSource, detail.MonthlyRequestsMin, detail.MonthlyRequestsMax = s.monthlyRequests()
+	detail.MonthlyKMSCostMin = estimate.CostForKMSRequests(int64(math.Round(float64(detail.MonthlyRequestsMin) * detail.KMSFraction)))
+	detail.MonthlyKMSCostMax = estimate.CostForKMSRequests(int64(math.Round(float64(detail.MonthlyRequestsMax) * detail.KMSFraction)))
+
+	objectScan.ObjectCount = int64(math.Round(float64(s.objectCount) * detail.KMSFraction))
+	objectScan.DataSize = int64(math.Round(float64(s.dataSize) * detail.KMSFraction))
+	objectScan.EstimatedSavings = estimate.EstimatedSavings{
+		CalculatedMonthlylSavingsMin: detail.MonthlyKMSCostMin * estimate.BucketKeyRequestReduction,
+		CalculatedMonthlySavingsMax:  detail.MonthlyKMSCostMax * estimate.BucketKeyRequestReduction,
+	}
+	objectScan.Details = detail
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Returns the min and max object reads and writes per month and where they came from
+func (s *kmsRequestScanner) monthlyRequests() (source string, min int64, max int64) {
+	if s.access != nil && s.access.Covers(24*time.Hour) {
+		window := s.access.WindowEnd.Sub(s.access.WindowStart)
+		monthly := float64(s.access.GetCount+s.access.PutCount) * float64(kmsRequestMonthDays*24*time.Hour) / float64(window)
+		requests := int64(math.Round(monthly))
+		return "access_logs", requests, requests
+	}
+	return "listing", s.recentWrites, s.recentWrites + s.objectCount
+}
+
+// HELPER for HeadObject fetchers and bucket configuration calls
+// HeadObject has no error body, a missing object is only told apart by its status code
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.StatusCode() == 404
+	}
+	return false
+}
+
+// HELPER for Finalize()
+// Includes dual-layer SSE-KMS, "aws:kms:dsse"
+func isKMS(algorithm string) bool {
+	return strings.HasPrefix(algorithm, s3.ServerSideEncryptionAwsKms)
+}
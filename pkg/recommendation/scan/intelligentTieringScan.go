@@ -0,0 +1,194 @@
+package scan
+
+//This file scans which objects would be cheaper in Intelligent-Tiering and the objects already there
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+)
+
+// Days without access after which Intelligent-Tiering moves objects to the Infrequent and Archive Instant Access tiers
+// Access is not known from the listing, objects not modified for this long are assumed not to be read either
+const (
+	intelligentTieringInfrequentDays     = 30
+	intelligentTieringArchiveInstantDays = 90
+)
+
+// IntelligentTieringObjectDetail is the ObjectScan.Details of the intelligent_tiering scan
+// ObjectCount and DataSize of the ObjectScan are the candidates, STANDARD objects of at least the minimum size with no lifecycle transition
+type IntelligentTieringObjectDetail struct {
+	InfrequentBytes     int64   `json:"infrequent_bytes"`      //candidate bytes not modified for 30-90 days
+	ArchiveInstantBytes int64   `json:"archive_instant_bytes"` //candidate bytes not modified for 90+ days
+	MonitoringFee       float64 `json:"monitoring_fee"`        //monthly fee the candidates would pay
+
+	Transition     estimate.TransitionEstimate `json:"transition"`      //cost-benefit of moving the candidates, with the max savings as the monthly delta
+	Lifecycle      estimate.TransitionEstimate `json:"lifecycle"`       //cost-benefit of lifecycle rules moving the same bytes to STANDARD_IA after 30 days and GLACIER_IR after 90
+	BeatsLifecycle bool                        `json:"beats_lifecycle"` //Intelligent-Tiering saves more than the lifecycle rules, savings are only reported when it does
+
+	SmallObjectCount int64 `json:"small_object_count"` //STANDARD objects below the 128 KB minimum, never recommended
+	SmallObjectSize  int64 `json:"small_object_size"`
+
+	TransitionedObjectCount int64 `json:"transitioned_object_count"` //STANDARD objects a lifecycle rule already transitions
+	TransitionedObjectSize  int64 `json:"transitioned_object_size"`
+
+	TieredObjectCount      int64    `json:"tiered_object_count"` //objects already in INTELLIGENT_TIERING
+	TieredObjectSize       int64    `json:"tiered_object_size"`
+	TieredSmallObjectCount int64    `json:"tiered_small_object_count"` //of those, objects billed at the Frequent Access tier for being too small
+	TieredSmallObjectSize  int64    `json:"tiered_small_object_size"`
+	ArchiveTiers           []string `json:"archive_tiers"`         //archive access tiers enabled on the bucket
+	ArchiveTiersUnknown    bool     `json:"archive_tiers_unknown"` //the bucket's configurations couldn't be read
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "intelligent_tiering",
+		Permissions: []string{"s3:ListBucket", "s3:GetIntelligentTieringConfiguration", "s3:GetLifecycleConfiguration"},
+		New: func(ctx ScanContext) Scanner {
+			return newIntelligentTieringScanner(ctx.BucketScan, ctx.Options.EffectivePolicy(), time.Now())
+		},
+	})
+}
+
+// Scanner for Intelligent-Tiering candidates and objects already in Intelligent-Tiering
+type intelligentTieringScanner struct {
+	rules       []*s3.LifecycleRule
+	now         time.Time
+	policy      policy.Policy
+	objectCount int64
+	dataSize    int64
+	detail      *IntelligentTieringObjectDetail
+
+	//candidates by the tier Intelligent-Tiering would move them to
+	infrequentCount     int64
+	archiveInstantCount int64
+}
+
+func newIntelligentTieringScanner(bucketScan BucketScan, p policy.Policy, now time.Time) *intelligentTieringScanner {
+	return &intelligentTieringScanner{
+		rules:  bucketScan.LifecycleDetail.Rules,
+		now:    now,
+		policy: p,
+		detail: &IntelligentTieringObjectDetail{
+			ArchiveTiers:        bucketScan.IntelligentTieringDetail.ArchiveTiers(),
+			ArchiveTiersUnknown: bucketScan.IntelligentTieringDetail.Unknown,
+		},
+	}
+}
+
+func (s *intelligentTieringScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		size := *object.Size
+		small := size < estimate.IntelligentTieringMinimumObjectSize
+
+		switch *object.StorageClass {
+		case s3.ObjectStorageClassIntelligentTiering:
+			s.detail.TieredObjectCount++
+			s.detail.TieredObjectSize += size
+			if small {
+				s.detail.TieredSmallObjectCount++
+				s.detail.TieredSmallObjectSize += size
+			}
+		case s3.ObjectStorageClassStandard:
+			if small {
+				s.detail.SmallObjectCount++
+				s.detail.SmallObjectSize += size
+				continue
+			}
+			if lifecycle.Evaluate(s.rules, lifecycle.Object{Key: *object.Key, Size: size}).Transition {
+				s.detail.TransitionedObjectCount++
+				s.detail.TransitionedObjectSize += size
+				continue
+			}
+
+			s.objectCount++
+			s.dataSize += size
+			age := ageInDays(*object.LastModified, s.now)
+			if age >= intelligentTieringArchiveInstantDays {
+				s.archiveInstantCount++
+				s.detail.ArchiveInstantBytes += size
+			} else if age >= intelligentTieringInfrequentDays {
+				s.infrequentCount++
+				s.detail.InfrequentBytes += size
+			}
+		}
+	}
+	return nil
+}
+
+// Min savings only move the 90+ day old bytes to the Infrequent Access tier,
+// max savings move the 30-90 day old bytes there and the 90+ day old bytes to the Archive Instant Access tier
+// Candidates whose savings don't pay back the transition fee in time, or that lifecycle rules would save more on, save nothing
+func (s *intelligentTieringScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{
+		DataCategory: "intelligent_tiering",
+		ObjectCount:  s.objectCount,
+		DataSize:     s.dataSize,
+	}
+	if s.objectCount == 0 && s.detail.TieredObjectCount == 0 {
+		return objectScan, nil
+	}
+
+	min, err := estimate.SavingsForIntelligentTiering(s.objectCount, s.detail.ArchiveInstantBytes, 0, "STANDARD")
+	if err != nil {
+		return objectScan, err
+	}
+	max, err := estimate.SavingsForIntelligentTiering(s.objectCount, s.detail.InfrequentBytes, s.detail.ArchiveInstantBytes, "STANDARD")
+	if err != nil {
+		return objectScan, err
+	}
+	s.detail.MonitoringFee = (float64(s.objectCount) / 1000) * estimate.IntelligentTieringMonitoringFeePer1000
+	transitionCost, err := estimate.CostForTransitions(s.objectCount, "INTELLIGENT_TIERING")
+	if err != nil {
+		return objectScan, err
+	}
+	s.detail.Transition = estimate.NewTransitionEstimate(transitionCost, max)
+	s.detail.Lifecycle, err = s.lifecycleEstimate()
+	if err != nil {
+		return objectScan, err
+	}
+	s.detail.BeatsLifecycle = !s.detail.Lifecycle.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) || max > s.detail.Lifecycle.MonthlyDelta
+
+	if s.detail.Transition.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) && s.detail.BeatsLifecycle {
+		objectScan.EstimatedSavings = estimate.EstimatedSavings{
+			CalculatedMonthlylSavingsMin: positive(min),
+			CalculatedMonthlySavingsMax:  positive(max),
+		}
+	}
+	objectScan.Details = s.detail
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Estimates the lifecycle rules that would tier the candidates by age instead, STANDARD_IA after 30 days and GLACIER_IR after 90
+// Unlike Intelligent-Tiering they charge for retrievals, at the policy's retrieval rate
+func (s *intelligentTieringScanner) lifecycleEstimate() (estimate.TransitionEstimate, error) {
+	var total estimate.TransitionEstimate
+	if s.infrequentCount > 0 {
+		e, err := estimate.EstimateTransition(s.infrequentCount, s.detail.InfrequentBytes, "STANDARD", "STANDARD_IA", s.policy.RetrievalRate, 0)
+		if err != nil {
+			return total, err
+		}
+		total = total.Add(e)
+	}
+	if s.archiveInstantCount > 0 {
+		e, err := estimate.EstimateTransition(s.archiveInstantCount, s.detail.ArchiveInstantBytes, "STANDARD", "GLACIER_IR", s.policy.RetrievalRate, 0)
+		if err != nil {
+			return total, err
+		}
+		total = total.Add(e)
+	}
+	return total, nil
+}
+
+// HELPER for Finalize()
+// Savings outweighed by the monitoring fee are reported as no savings
+func positive(savings float64) float64 {
+	if savings < 0 {
+		return 0
+	}
+	return savings
+}
@@ -0,0 +1,193 @@
+package scan
+
+//This file scans for incomplete multipart uploads
+//Parts of an incomplete upload are billed but never show up in the bucket's object listing
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+)
+
+// Uploads younger than this may still be in progress, so they only count towards max savings
+const multipartUploadInProgressDays = 7
+
+// An age range data is grouped into, by minimum age in days
+type ageBand struct {
+	Name    string
+	MinDays int
+}
+
+// Takes in age bands ordered by MinDays and an age in days and returns the index of the band the age falls in
+func ageBandIndex(bands []ageBand, ageDays int) int {
+	b := 0
+	for i, band := range bands {
+		if ageDays >= band.MinDays {
+			b = i
+		}
+	}
+	return b
+}
+
+// Returns the age in whole days of something that happened at t
+func ageInDays(t time.Time, now time.Time) int {
+	return int(now.Sub(t).Hours() / 24)
+}
+
+// The age bands incomplete uploads are grouped into
+var multipartUploadAgeBands = []ageBand{
+	{"0-7 days", 0},
+	{"7-30 days", 7},
+	{"30-90 days", 30},
+	{"90+ days", 90},
+}
+
+// MultipartUploadDetail is the ObjectScan.Details of the incomplete_multipart_upload scan
+type MultipartUploadDetail struct {
+	PartCount         int64                 `json:"part_count"`
+	OldestInitiatedAt time.Time             `json:"oldest_initiated_at"`
+	StorageClasses    map[string]int64      `json:"storage_classes"` //bytes of parts per storage class
+	AgeBands          []MultipartUploadBand `json:"age_bands"`
+}
+
+// MultipartUploadBand contains the incomplete uploads that were initiated within an age band
+type MultipartUploadBand struct {
+	Band             string                    `json:"band"`
+	UploadCount      int64                     `json:"upload_count"`
+	PartCount        int64                     `json:"part_count"`
+	DataSize         int64                     `json:"data_size"`
+	EstimatedSavings estimate.EstimatedSavings `json:"estimated_savings"`
+}
+
+// One incomplete multipart upload and the size of the parts uploaded so far
+type multipartUpload struct {
+	Key          string
+	UploadID     string
+	StorageClass string
+	Initiated    time.Time
+	PartCount    int64
+	Size         int64
+}
+
+// Scanner for incomplete multipart uploads
+// Does not use the object listing, uploads and parts are listed in Finalize
+type incompleteMultipartUploadScanner struct {
+	ctx ScanContext
+}
+
+func (s *incompleteMultipartUploadScanner) Consume(page *s3.ListObjectsV2Output) error {
+	return nil
+}
+
+func (s *incompleteMultipartUploadScanner) Finalize() (ObjectScan, error) {
+	uploads, err := incompleteMultipartUploadScan(s.ctx.Session, s.ctx.Bucket.Name)
+	if err != nil {
+		return ObjectScan{DataCategory: "incomplete_multipart_upload"}, err
+	}
+
+	return summarizeMultipartUploads(uploads, time.Now()), nil
+}
+
+// Scans for incomplete multipart uploads
+// Takes in a session and bucketname and returns every incomplete upload with the total size of its parts
+func incompleteMultipartUploadScan(sess *session.Session, bucketName string) ([]multipartUpload, error) {
+	uploads := []multipartUpload{}
+	svc := s3.New(sess)
+
+	//AWS SDK LIST CALL
+	// Retrieve list of multipart uploads
+	err := svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: &bucketName,
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			u := multipartUpload{
+				Key:          *upload.Key,
+				StorageClass: "STANDARD",
+			}
+			if upload.StorageClass != nil {
+				u.StorageClass = *upload.StorageClass
+			}
+			if upload.Initiated != nil {
+				u.Initiated = *upload.Initiated
+			}
+			u.UploadID = *upload.UploadId
+			uploads = append(uploads, u)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//Get total size of the parts of each incomplete multipart upload
+	for i := range uploads {
+		//AWS SDK LIST CALL
+		err := svc.ListPartsPages(&s3.ListPartsInput{
+			Bucket:   &bucketName,
+			Key:      &uploads[i].Key,
+			UploadId: &uploads[i].UploadID,
+		}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+			for _, part := range page.Parts {
+				uploads[i].PartCount++
+				if part.Size != nil {
+					uploads[i].Size += *part.Size
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return uploads, nil
+}
+
+// HELPER for incompleteMultipartUploadScanner
+// Takes in incomplete uploads and the current time and returns the incomplete_multipart_upload ObjectScan
+// Min savings only count uploads old enough to be abandoned, max savings count every upload
+func summarizeMultipartUploads(uploads []multipartUpload, now time.Time) ObjectScan {
+	objectScan := ObjectScan{DataCategory: "incomplete_multipart_upload"}
+	detail := MultipartUploadDetail{
+		StorageClasses: make(map[string]int64),
+		AgeBands:       make([]MultipartUploadBand, len(multipartUploadAgeBands)),
+	}
+	for i, band := range multipartUploadAgeBands {
+		detail.AgeBands[i].Band = band.Name
+	}
+
+	for _, upload := range uploads {
+		ageDays := ageInDays(upload.Initiated, now)
+		savings := estimate.SavingsForBytesDeletedByStorageClass(upload.Size, upload.StorageClass)
+
+		objectScan.ObjectCount++
+		objectScan.DataSize += upload.Size
+		objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += savings
+		if ageDays >= multipartUploadInProgressDays {
+			objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin += savings
+		}
+
+		detail.PartCount += upload.PartCount
+		detail.StorageClasses[upload.StorageClass] += upload.Size
+		if detail.OldestInitiatedAt.IsZero() || upload.Initiated.Before(detail.OldestInitiatedAt) {
+			detail.OldestInitiatedAt = upload.Initiated
+		}
+
+		b := ageBandIndex(multipartUploadAgeBands, ageDays)
+		detail.AgeBands[b].UploadCount++
+		detail.AgeBands[b].PartCount += upload.PartCount
+		detail.AgeBands[b].DataSize += upload.Size
+		detail.AgeBands[b].EstimatedSavings.CalculatedMonthlySavingsMax += savings
+		if ageDays >= multipartUploadInProgressDays {
+			detail.AgeBands[b].EstimatedSavings.CalculatedMonthlylSavingsMin += savings
+		}
+	}
+
+	if objectScan.ObjectCount > 0 {
+		objectScan.Details = &detail
+	}
+
+	return objectScan
+}
@@ -0,0 +1,178 @@
+package scan
+
+//This file checks whether objects are protected by Object Lock and can't be deleted
+//Per-object retention and legal holds are read within a budget, past it the bucket's default retention is assumed
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Objects per bucket whose retention and legal hold are read when ScanOptions.ObjectLockLookups is 0
+// Each lookup is two requests made while the scan waits, so the default stays small
+const defaultObjectLockLookups = 100
+
+// ObjectLockDetail contains the bucket's Object Lock configuration
+type ObjectLockDetail struct {
+	Enabled       bool   `json:"enabled"`
+	Mode          string `json:"mode,omitempty"`           //default retention mode, GOVERNANCE or COMPLIANCE
+	RetentionDays int64  `json:"retention_days,omitempty"` //default retention applied to new objects, 0 if there is none
+}
+
+// LockSummary contains the objects an ObjectScan found that Object Lock keeps from being deleted
+// Their bytes are excluded from the ObjectScan's EstimatedSavings
+type LockSummary struct {
+	LockedObjectCount int64     `json:"locked_object_count"`
+	LockedSize        int64     `json:"locked_size"`
+	LegalHoldCount    int64     `json:"legal_hold_count"` //locked until the hold is removed
+	DeletableAfter    time.Time `json:"deletable_after"`  //when the last retention period ends, legal holds aside
+	Estimated         bool      `json:"estimated"`        //some objects weren't looked up, their retention was assumed from the default retention
+	UncheckedCount    int64     `json:"unchecked_count"`  //objects past the lookup budget in a bucket without default retention, assumed deletable
+}
+
+// The Object Lock protection of one object
+type objectLock struct {
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+// Reads the retention and legal hold of an object version, an empty versionID reads the current version
+type objectLockLookup func(key string, versionID string) (objectLock, error)
+
+// Checks objects against Object Lock and keeps the LockSummary of the locked ones
+// A nil lockChecker reports every object as deletable
+type lockChecker struct {
+	detail  ObjectLockDetail
+	lookup  objectLockLookup
+	budget  int
+	lookups int
+	now     time.Time
+	summary LockSummary
+}
+
+// Returns a lockChecker for the bucket in ctx, nil when the bucket doesn't have Object Lock enabled
+func newLockChecker(ctx ScanContext, now time.Time) *lockChecker {
+	detail := ctx.BucketScan.ObjectLockDetail
+	if !detail.Enabled {
+		return nil
+	}
+
+	svc := s3.New(ctx.Session)
+	bucket := ctx.Bucket.Name
+	lookup := func(key string, versionID string) (objectLock, error) {
+		lock := objectLock{}
+		var version *string
+		if versionID != "" {
+			version = aws.String(versionID)
+		}
+
+		//AWS SDK GET CALL
+		retention, err := svc.GetObjectRetention(&s3.GetObjectRetentionInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: version,
+		})
+		if err != nil && !isNoObjectLock(err) {
+			return lock, err
+		}
+		if err == nil && retention.Retention != nil && retention.Retention.RetainUntilDate != nil {
+			lock.RetainUntil = *retention.Retention.RetainUntilDate
+		}
+
+		//AWS SDK GET CALL
+		legalHold, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: version,
+		})
+		if err != nil && !isNoObjectLock(err) {
+			return lock, err
+		}
+		if err == nil && legalHold.LegalHold != nil {
+			lock.LegalHold = aws.StringValue(legalHold.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn
+		}
+		return lock, nil
+	}
+
+	budget := ctx.Options.ObjectLockLookups
+	if budget == 0 {
+		budget = defaultObjectLockLookups
+	}
+	return newLockCheckerWithLookup(detail, lookup, budget, now)
+}
+
+func newLockCheckerWithLookup(detail ObjectLockDetail, lookup objectLockLookup, budget int, now time.Time) *lockChecker {
+	return &lockChecker{
+		detail: detail,
+		lookup: lookup,
+		budget: budget,
+		now:    now,
+	}
+}
+
+// Checks if the current version of an object is locked and adds it to the LockSummary if it is
+func (c *lockChecker) locked(key string, size int64, lastModified time.Time) (bool, error) {
+	return c.lockedVersion(key, "", size, lastModified)
+}
+
+// Checks if an object version is locked and adds it to the LockSummary if it is
+// Past the lookup budget the default retention is assumed, without one the object is assumed deletable and counted as unchecked
+func (c *lockChecker) lockedVersion(key string, versionID string, size int64, lastModified time.Time) (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+
+	lock := objectLock{}
+	if c.lookups < c.budget {
+		c.lookups++
+		var err error
+		lock, err = c.lookup(key, versionID)
+		if err != nil {
+			return false, err
+		}
+	} else if c.detail.RetentionDays > 0 {
+		//Default retention starts when the object is written
+		lock.RetainUntil = lastModified.AddDate(0, 0, int(c.detail.RetentionDays))
+		c.summary.Estimated = true
+	} else {
+		//Retention set on the object itself or a legal hold can't be known without a lookup
+		c.summary.UncheckedCount++
+		c.summary.Estimated = true
+	}
+
+	retained := lock.RetainUntil.After(c.now)
+	if !retained && !lock.LegalHold {
+		return false, nil
+	}
+
+	c.summary.LockedObjectCount++
+	c.summary.LockedSize += size
+	if lock.LegalHold {
+		c.summary.LegalHoldCount++
+	}
+	if retained && lock.RetainUntil.After(c.summary.DeletableAfter) {
+		c.summary.DeletableAfter = lock.RetainUntil
+	}
+	return true, nil
+}
+
+// Returns the LockSummary of the locked objects, nil if none were found and every object was looked up
+func (c *lockChecker) lockSummary() *LockSummary {
+	if c == nil || (c.summary.LockedObjectCount == 0 && c.summary.UncheckedCount == 0) {
+		return nil
+	}
+	summary := c.summary
+	return &summary
+}
+
+// HELPER for newLockChecker()
+// Objects without a retention period or legal hold return an error instead of an empty configuration
+func isNoObjectLock(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "NoSuchObjectLockConfiguration" || aerr.Code() == "ObjectLockConfigurationNotFoundError"
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package scan
+
+//This file aggregates the bytes of each prefix by storage class and age, for user-defined analysis rules
+//Ages are kept per day so rules can ask for any threshold, ex. STANDARD data older than 180 days
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+)
+
+// Defaults for prefix aggregation
+const (
+	defaultRulePrefixDepth = 1
+	maxRulePrefixes        = 1000 //prefixes kept, by data size
+)
+
+// PrefixAgeDetail is the ObjectScan.Details of the prefix_ages scan
+// ObjectCount and DataSize of the ObjectScan are the whole bucket
+type PrefixAgeDetail struct {
+	Depth    int          `json:"depth"`
+	Bucket   PrefixAges   `json:"bucket"` //every prefix together, Prefix is empty
+	Prefixes []PrefixAges `json:"prefixes"`
+}
+
+// PrefixAges contains the objects of one prefix by storage class
+type PrefixAges struct {
+	Prefix         string                `json:"prefix"`
+	ObjectCount    int64                 `json:"object_count"`
+	DataSize       int64                 `json:"data_size"`
+	StorageClasses map[string]*ClassAges `json:"storage_classes"`
+}
+
+// ClassAges contains the objects of one storage class in a prefix
+type ClassAges struct {
+	ObjectCount int64         `json:"object_count"`
+	DataSize    int64         `json:"data_size"`
+	sizeByAge   map[int]int64 //bytes by age in days, too large to report
+}
+
+// Returns the bytes of a storage class, every storage class if it is empty
+func (p PrefixAges) ClassSize(storageClass string) int64 {
+	if storageClass == "" {
+		return p.DataSize
+	}
+	if class, ok := p.StorageClasses[storageClass]; ok {
+		return class.DataSize
+	}
+	return 0
+}
+
+// Returns the objects of a storage class, every storage class if it is empty
+func (p PrefixAges) ClassObjects(storageClass string) int64 {
+	if storageClass == "" {
+		return p.ObjectCount
+	}
+	if class, ok := p.StorageClasses[storageClass]; ok {
+		return class.ObjectCount
+	}
+	return 0
+}
+
+// Returns the bytes of a storage class last modified more than days ago, every storage class if it is empty
+func (p PrefixAges) SizeOlderThan(storageClass string, days int) int64 {
+	var size int64
+	for name, class := range p.StorageClasses {
+		if storageClass != "" && name != storageClass {
+			continue
+		}
+		for age, bytes := range class.sizeByAge {
+			if age > days {
+				size += bytes
+			}
+		}
+	}
+	return size
+}
+
+// Adds an object last modified ageDays ago to the prefix
+func (p *PrefixAges) Add(storageClass string, size int64, ageDays int) {
+	if p.StorageClasses == nil {
+		p.StorageClasses = make(map[string]*ClassAges)
+	}
+	class, ok := p.StorageClasses[storageClass]
+	if !ok {
+		class = &ClassAges{sizeByAge: make(map[int]int64)}
+		p.StorageClasses[storageClass] = class
+	}
+	p.ObjectCount++
+	p.DataSize += size
+	class.ObjectCount++
+	class.DataSize += size
+	class.sizeByAge[ageDays] += size
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "prefix_ages",
+		Permissions: []string{"s3:ListBucket"},
+		New: func(ctx ScanContext) Scanner {
+			return newPrefixAgeScanner(ctx.Options.RulePrefixDepth, time.Now())
+		},
+	})
+}
+
+// Scanner for the bytes of each prefix by storage class and age
+type prefixAgeScanner struct {
+	depth    int
+	now      time.Time
+	bucket   PrefixAges
+	prefixes map[string]*PrefixAges
+}
+
+func newPrefixAgeScanner(depth int, now time.Time) *prefixAgeScanner {
+	if depth == 0 {
+		depth = defaultRulePrefixDepth
+	}
+	return &prefixAgeScanner{
+		depth:    depth,
+		now:      now,
+		prefixes: make(map[string]*PrefixAges),
+	}
+}
+
+func (s *prefixAgeScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		storageClass := aws.StringValue(object.StorageClass)
+		if storageClass == "" {
+			storageClass = "STANDARD"
+		}
+		age := 0
+		if object.LastModified != nil {
+			age = ageInDays(*object.LastModified, s.now)
+		}
+
+		prefix := access.KeyPrefix(*object.Key, s.depth)
+		p, ok := s.prefixes[prefix]
+		if !ok {
+			p = &PrefixAges{Prefix: prefix}
+			s.prefixes[prefix] = p
+		}
+		p.Add(storageClass, *object.Size, age)
+		s.bucket.Add(storageClass, *object.Size, age)
+	}
+	return nil
+}
+
+// Aggregates have no savings of their own, analysis rules price them
+func (s *prefixAgeScanner) Finalize() (ObjectScan, error) {
+	detail := &PrefixAgeDetail{Depth: s.depth, Bucket: s.bucket, Prefixes: []PrefixAges{}}
+	for _, p := range s.prefixes {
+		detail.Prefixes = append(detail.Prefixes, *p)
+	}
+	sort.Slice(detail.Prefixes, func(i, j int) bool {
+		if detail.Prefixes[i].DataSize != detail.Prefixes[j].DataSize {
+			return detail.Prefixes[i].DataSize > detail.Prefixes[j].DataSize
+		}
+		return detail.Prefixes[i].Prefix < detail.Prefixes[j].Prefix
+	})
+	if len(detail.Prefixes) > maxRulePrefixes {
+		detail.Prefixes = detail.Prefixes[:maxRulePrefixes]
+	}
+
+	return ObjectScan{
+		DataCategory: "prefix_ages",
+		ObjectCount:  s.bucket.ObjectCount,
+		DataSize:     s.bucket.DataSize,
+		Details:      detail,
+	}, nil
+}
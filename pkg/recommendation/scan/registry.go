@@ -0,0 +1,204 @@
+package scan
+
+//This file contains the Scanner interface and the registry of object scans
+//Scanners register themselves in an init() so new scans can be added without editing ScanS3
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+	"github.com/helloevanhere/simple_saver_service/pkg/summary"
+)
+
+// Most objects per bucket whose tags the chargeback scan may read, each one is a GetObjectTagging call
+const maxChargebackTagSamples = 10000
+
+// Scanner builds one ObjectScan for one bucket
+// Consume is called once per page of the bucket's object listing, Finalize once after the last page
+type Scanner interface {
+	Consume(page *s3.ListObjectsV2Output) error
+	Finalize() (ObjectScan, error)
+}
+
+// ScanContext contains everything a Scanner may need to know about the bucket it is scanning
+type ScanContext struct {
+	Session    *session.Session
+	Bucket     summary.BucketSummary
+	BucketScan BucketScan
+	Options    ScanOptions
+	Access     *access.AccessDetail //nil when no access logs were provided
+
+	//Shared by every bucket in the request
+	AccessTracker  *access.Tracker //access of every bucket in the logs, nil when no access logs were provided
+	DuplicateIndex *DuplicateIndex
+}
+
+// ScanOptions contains the per request settings for a scan
+type ScanOptions struct {
+	Scanners            []string `json:"scans"`                 //empty runs every registered scanner
+	DuplicateDeepMode   bool     `json:"duplicate_deep_mode"`   //confirm duplicates with checksums instead of ETags
+	DuplicateHashBudget int64    `json:"duplicate_hash_budget"` //bytes per bucket the deep mode may read to hash objects, 0 disables hashing
+
+	CompressionSampling      bool  `json:"compression_sampling"`       //measure compressibility from object content instead of file extensions
+	CompressionSampleObjects int   `json:"compression_sample_objects"` //objects sampled per bucket, 0 uses the default
+	CompressionSampleBytes   int64 `json:"compression_sample_bytes"`   //bytes read from the start of each sampled object, 0 uses the default
+
+	EncryptionSampling      bool `json:"encryption_sampling"`       //read the encryption of sampled objects instead of assuming the bucket's default
+	EncryptionSampleObjects int  `json:"encryption_sample_objects"` //objects per bucket whose encryption is read with HeadObject, 0 uses the default
+	ObjectLockLookups       int  `json:"object_lock_lookups"`       //objects per scan whose retention and legal hold are read, 0 uses the default
+	RestoreSampling         bool `json:"restore_sampling"`          //check a sample of archived objects for restored copies with HeadObject
+	RestoreSampleObjects    int  `json:"restore_sample_objects"`    //archived objects per bucket checked for a restored copy with HeadObject, 0 uses the default
+	SmallFilePrefixDepth    int  `json:"small_file_prefix_depth"`   //key segments per prefix small objects are grouped by, 0 uses the default
+
+	ChargebackTagKeys    []string `json:"chargeback_tag_keys"`    //tags naming a bucket's or object's owner, first one present wins, empty uses the defaults
+	ChargebackTagSamples int      `json:"chargeback_tag_samples"` //objects per bucket whose tags are read to split unmapped data, 0 disables it
+
+	AbandonedAfterMonths int      `json:"abandoned_after_months"` //months without writes or access after which a bucket is abandoned, 0 uses the default
+	DecommissionedOwners []string `json:"decommissioned_owners"`  //owners whose buckets are orphaned, matched against bucket tag values
+
+	AccessLogs *access.LogSource `json:"access_logs,omitempty"` //logs to build real access recency from
+
+	RulePrefixDepth int `json:"rule_prefix_depth"` //key segments per prefix analysis rules evaluate, 0 uses the default
+
+	Policy *policy.Policy `json:"policy,omitempty"` //overrides of the server's policy, the server replaces it with the effective policy before scanning
+}
+
+// Tags naming a bucket's or object's owner when ChargebackTagKeys is empty
+var defaultOwnerTagKeys = []string{"cost-center", "owner", "team"}
+
+// Returns the tag keys that name an owner, in order of preference
+func (o ScanOptions) OwnerTagKeys() []string {
+	if len(o.ChargebackTagKeys) == 0 {
+		return defaultOwnerTagKeys
+	}
+	return o.ChargebackTagKeys
+}
+
+// Returns whether the named scanner runs with these options
+func (o ScanOptions) ScannerEnabled(name string) bool {
+	if len(o.Scanners) == 0 {
+		return true
+	}
+	for _, scanner := range o.Scanners {
+		if scanner == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the policy the scan runs with, the default policy when none was set
+func (o ScanOptions) EffectivePolicy() policy.Policy {
+	if o.Policy == nil {
+		return policy.Default()
+	}
+	return *o.Policy
+}
+
+// Checks the options before any AWS calls are made
+func (o ScanOptions) Validate() error {
+	if _, err := EnabledScanners(o.Scanners); err != nil {
+		return err
+	}
+	if o.DuplicateHashBudget < 0 {
+		return fmt.Errorf("duplicate_hash_budget can't be negative")
+	}
+	if o.CompressionSampleObjects < 0 || o.CompressionSampleBytes < 0 {
+		return fmt.Errorf("compression_sample_objects and compression_sample_bytes can't be negative")
+	}
+	if o.EncryptionSampleObjects < 0 || o.ObjectLockLookups < 0 || o.ChargebackTagSamples < 0 {
+		return fmt.Errorf("encryption_sample_objects, object_lock_lookups and chargeback_tag_samples can't be negative")
+	}
+	if o.ChargebackTagSamples > maxChargebackTagSamples {
+		return fmt.Errorf("chargeback_tag_samples can't be more than %d", maxChargebackTagSamples)
+	}
+	if o.RestoreSampleObjects < 0 || o.SmallFilePrefixDepth < 0 {
+		return fmt.Errorf("restore_sample_objects and small_file_prefix_depth can't be negative")
+	}
+	if o.AbandonedAfterMonths < 0 || o.RulePrefixDepth < 0 {
+		return fmt.Errorf("abandoned_after_months and rule_prefix_depth can't be negative")
+	}
+	if o.Policy != nil {
+		if err := o.Policy.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.AccessLogs != nil {
+		if err := o.AccessLogs.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScannerRegistration describes a registered Scanner
+type ScannerRegistration struct {
+	Name                string                        `json:"name"`
+	Permissions         []string                      `json:"permissions"`
+	OptionalPermissions []string                      `json:"optional_permissions,omitempty"` //only needed by opt-in ScanOptions
+	New                 func(ctx ScanContext) Scanner `json:"-"`
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []ScannerRegistration
+)
+
+// Registers a Scanner so it runs as part of ScanS3
+// Intended to be called from an init() function, panics on an empty or duplicate name
+func RegisterScanner(registration ScannerRegistration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if registration.Name == "" || registration.New == nil {
+		panic("scan: RegisterScanner requires a name and constructor")
+	}
+	for _, r := range registry {
+		if r.Name == registration.Name {
+			panic(fmt.Sprintf("scan: scanner %q registered twice", registration.Name))
+		}
+	}
+	registry = append(registry, registration)
+}
+
+// Returns every registered Scanner in registration order
+func RegisteredScanners() []ScannerRegistration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registrations := make([]ScannerRegistration, len(registry))
+	copy(registrations, registry)
+	return registrations
+}
+
+// Takes in a list of scanner names and returns their registrations in registration order
+// An empty list returns every registered Scanner
+func EnabledScanners(names []string) ([]ScannerRegistration, error) {
+	registrations := RegisteredScanners()
+	if len(names) == 0 {
+		return registrations, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	enabled := []ScannerRegistration{}
+	for _, r := range registrations {
+		if wanted[r.Name] {
+			enabled = append(enabled, r)
+			delete(wanted, r.Name)
+		}
+	}
+
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown scan: %s", name)
+	}
+
+	return enabled, nil
+}
@@ -0,0 +1,285 @@
+package scan
+
+//This file scans the storage and transfer cost of the data a bucket replicates
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/lifecycle"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+)
+
+// Objects modified within this many days are counted as the data replicated each month
+const replicationMonthDays = 30
+
+// Replicas in these storage classes are not worth moving to a colder class
+var coldReplicaStorageClasses = map[string]bool{
+	"STANDARD_IA":  true,
+	"ONEZONE_IA":   true,
+	"GLACIER":      true,
+	"GLACIER_IR":   true,
+	"DEEP_ARCHIVE": true,
+}
+
+// ReplicationCostDetail is the ObjectScan.Details of the replication scan
+type ReplicationCostDetail struct {
+	Rules []ReplicationRuleCost `json:"rules"`
+}
+
+// ReplicationRuleCost contains the data one replication rule replicates and what its replicas cost per month
+type ReplicationRuleCost struct {
+	ID                      string `json:"id"`
+	Prefix                  string `json:"prefix"`
+	DestinationBucket       string `json:"destination_bucket"`
+	DestinationStorageClass string `json:"destination_storage_class"` //empty keeps each object's storage class
+	CrossRegion             bool   `json:"cross_region"`
+	ObjectCount             int64  `json:"object_count"`
+	DataSize                int64  `json:"data_size"`
+	MonthlyReplicatedSize   int64  `json:"monthly_replicated_size"` //bytes modified in the last 30 days
+
+	StorageCost  float64 `json:"storage_cost"`  //monthly storage cost of the replicas
+	TransferCost float64 `json:"transfer_cost"` //monthly cross region transfer cost
+
+	ReplicaOfReplicas     []string `json:"replica_of_replicas,omitempty"` //buckets the destination replicates the replicas to again
+	ReplicaOfReplicasCost float64  `json:"replica_of_replicas_cost"`      //monthly storage of those copies, assumed in the replicas' storage class, their transfer is not known
+	ExpiredAtSource       bool     `json:"expired_at_source"`             //a source lifecycle rule expires replicated objects, replicas are not expired with them
+	ExpiredAtSourceSize   int64    `json:"expired_at_source_size"`        //bytes of the replicated objects a source lifecycle rule expires
+
+	Transitions      map[string]estimate.TransitionEstimate `json:"transitions,omitempty"` //moving the warm replicas to STANDARD_IA and GLACIER
+	EstimatedSavings estimate.EstimatedSavings              `json:"estimated_savings"`     //from a colder destination storage class, when the move breaks even in time
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "replication",
+		Permissions: []string{"s3:ListBucket", "s3:GetReplicationConfiguration", "s3:GetBucketLocation"},
+		New: func(ctx ScanContext) Scanner {
+			return newReplicationScanner(ctx.BucketScan, ctx.Options.EffectivePolicy(), time.Now())
+		},
+	})
+}
+
+// Scanner for the cost of replicated data
+// ObjectCount and DataSize of the ObjectScan are the objects an enabled replication rule applies to
+// An object is replicated to every destination with a matching rule, by the highest priority rule of each destination
+// Object tags are not retrieved, tag filtered rules are assumed to apply
+type replicationScanner struct {
+	rules          []*s3.ReplicationRule
+	lifecycleRules []*s3.LifecycleRule
+	policy         policy.Policy
+	now            time.Time
+	costs          []ReplicationRuleCost          //one per enabled rule, in the order of rules
+	warm           []map[string]StorageClassTotal //replicas in warm storage classes of each rule, by storage class
+
+	objectCount int64
+	dataSize    int64
+}
+
+func newReplicationScanner(bucketScan BucketScan, pol policy.Policy, now time.Time) *replicationScanner {
+	detail := bucketScan.ReplicationDetail
+	s := &replicationScanner{lifecycleRules: bucketScan.LifecycleDetail.Rules, policy: pol, now: now}
+
+	for _, rule := range detail.Rules {
+		if !isReplicationRuleEnabled(rule) {
+			continue
+		}
+		destination := destinationBucket(rule)
+		cost := ReplicationRuleCost{
+			ID:                aws.StringValue(rule.ID),
+			Prefix:            replicationRulePrefix(rule),
+			DestinationBucket: destination,
+		}
+		if rule.Destination != nil {
+			cost.DestinationStorageClass = aws.StringValue(rule.Destination.StorageClass)
+		}
+		if d, ok := detail.Destinations[destination]; ok {
+			cost.CrossRegion = detail.Region != "" && d.Region != "" && d.Region != detail.Region
+			cost.ReplicaOfReplicas = d.ReplicatesTo
+		}
+		s.rules = append(s.rules, rule)
+		s.costs = append(s.costs, cost)
+		s.warm = append(s.warm, make(map[string]StorageClassTotal))
+	}
+	return s
+}
+
+func (s *replicationScanner) Consume(page *s3.ListObjectsV2Output) error {
+	if len(s.rules) == 0 {
+		return nil
+	}
+	for _, object := range page.Contents {
+		matches := s.matchingRules(*object.Key)
+		if len(matches) == 0 {
+			continue
+		}
+		size := *object.Size
+		s.objectCount++
+		s.dataSize += size
+		//Evaluated per key, lifecycle rules may filter on a longer prefix or on size than the replication rule
+		expired := lifecycle.Evaluate(s.lifecycleRules, lifecycle.Object{Key: *object.Key, Size: size}).Expiration
+		recent := object.LastModified != nil && ageInDays(*object.LastModified, s.now) < replicationMonthDays
+
+		for _, i := range matches {
+			cost := &s.costs[i]
+			cost.ObjectCount++
+			cost.DataSize += size
+			if recent {
+				cost.MonthlyReplicatedSize += size
+			}
+			if expired {
+				cost.ExpiredAtSource = true
+				cost.ExpiredAtSourceSize += size
+			}
+
+			//Replicas keep the object's storage class unless the rule overrides it
+			class := cost.DestinationStorageClass
+			if class == "" {
+				class = aws.StringValue(object.StorageClass)
+			}
+			//Storage classes without a known price, ex. OUTPOSTS, are left out of the cost
+			storageCost, err := estimate.CurrentStorageCost(size, class)
+			if err != nil {
+				continue
+			}
+			cost.StorageCost += storageCost
+			cost.ReplicaOfReplicasCost += storageCost * float64(len(cost.ReplicaOfReplicas))
+			if !coldReplicaStorageClasses[class] {
+				total := s.warm[i][class]
+				total.ObjectCount++
+				total.DataSize += size
+				s.warm[i][class] = total
+			}
+		}
+	}
+	return nil
+}
+
+// HELPER for Consume()
+// Returns the indexes of the enabled rules that replicate a key, the highest priority matching rule of each destination
+func (s *replicationScanner) matchingRules(key string) []int {
+	byDestination := make(map[string]int)
+	destinations := []string{}
+	for i, rule := range s.rules {
+		if !strings.HasPrefix(key, s.costs[i].Prefix) {
+			continue
+		}
+		destination := s.costs[i].DestinationBucket
+		match, ok := byDestination[destination]
+		if !ok {
+			destinations = append(destinations, destination)
+		}
+		if !ok || priority(rule) > priority(s.rules[match]) {
+			byDestination[destination] = i
+		}
+	}
+
+	matches := make([]int, 0, len(destinations))
+	for _, destination := range destinations {
+		matches = append(matches, byDestination[destination])
+	}
+	return matches
+}
+
+// Min savings move replicas in warm storage classes to STANDARD_IA, max savings to GLACIER, each only when it breaks even in time
+func (s *replicationScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "replication"}
+	if len(s.costs) == 0 {
+		return objectScan, nil
+	}
+
+	objectScan.ObjectCount = s.objectCount
+	objectScan.DataSize = s.dataSize
+	for i := range s.costs {
+		cost := &s.costs[i]
+		if cost.CrossRegion {
+			cost.TransferCost = estimate.CostForBytesTransferredCrossRegion(cost.MonthlyReplicatedSize)
+		}
+		s.estimateTransitions(cost, s.warm[i])
+		objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin += cost.EstimatedSavings.CalculatedMonthlylSavingsMin
+		objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += cost.EstimatedSavings.CalculatedMonthlySavingsMax
+	}
+	sort.SliceStable(s.costs, func(i, j int) bool {
+		return s.costs[i].totalCost() > s.costs[j].totalCost()
+	})
+
+	objectScan.Details = &ReplicationCostDetail{Rules: s.costs}
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Estimates moving a rule's warm replicas to STANDARD_IA and GLACIER and takes the savings of the moves that break even in time
+func (s *replicationScanner) estimateTransitions(cost *ReplicationRuleCost, warm map[string]StorageClassTotal) {
+	if len(warm) == 0 {
+		return
+	}
+	cost.Transitions = make(map[string]estimate.TransitionEstimate)
+	for _, toClass := range []string{"STANDARD_IA", "GLACIER"} {
+		var total estimate.TransitionEstimate
+		for fromClass, classTotal := range warm {
+			e, err := estimate.EstimateTransition(classTotal.ObjectCount, classTotal.DataSize, fromClass, toClass, s.policy.RetrievalRate, 0)
+			if err != nil {
+				continue
+			}
+			total = total.Add(e)
+		}
+		cost.Transitions[toClass] = total
+	}
+
+	if e := cost.Transitions["STANDARD_IA"]; e.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) {
+		cost.EstimatedSavings.CalculatedMonthlylSavingsMin = e.MonthlyDelta
+		cost.EstimatedSavings.CalculatedMonthlySavingsMax = e.MonthlyDelta
+	}
+	if e := cost.Transitions["GLACIER"]; e.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) && e.MonthlyDelta > cost.EstimatedSavings.CalculatedMonthlySavingsMax {
+		cost.EstimatedSavings.CalculatedMonthlySavingsMax = e.MonthlyDelta
+		if cost.EstimatedSavings.CalculatedMonthlylSavingsMin == 0 {
+			cost.EstimatedSavings.CalculatedMonthlylSavingsMin = e.MonthlyDelta
+		}
+	}
+}
+
+// HELPER for Finalize()
+// Returns the monthly storage and transfer cost of a rule's replicas, re-replicated copies included
+func (c ReplicationRuleCost) totalCost() float64 {
+	return c.StorageCost + c.TransferCost + c.ReplicaOfReplicasCost
+}
+
+// Checks if a replication rule is enabled
+func isReplicationRuleEnabled(rule *s3.ReplicationRule) bool {
+	return rule != nil && rule.Status != nil && *rule.Status == s3.ReplicationRuleStatusEnabled
+}
+
+// Returns the name of the bucket a replication rule replicates to, from its ARN
+func destinationBucket(rule *s3.ReplicationRule) string {
+	if rule == nil || rule.Destination == nil || rule.Destination.Bucket == nil {
+		return ""
+	}
+	return strings.TrimPrefix(*rule.Destination.Bucket, "arn:aws:s3:::")
+}
+
+// HELPER for newReplicationScanner()
+// Rules filter on Filter.Prefix, Filter.And.Prefix or the deprecated Prefix
+func replicationRulePrefix(rule *s3.ReplicationRule) string {
+	if rule.Filter != nil {
+		if rule.Filter.Prefix != nil {
+			return *rule.Filter.Prefix
+		}
+		if rule.Filter.And != nil {
+			return aws.StringValue(rule.Filter.And.Prefix)
+		}
+		return ""
+	}
+	return aws.StringValue(rule.Prefix)
+}
+
+// HELPER for matchingRules()
+func priority(rule *s3.ReplicationRule) int64 {
+	if rule.Priority == nil {
+		return 0
+	}
+	return *rule.Priority
+}
@@ -4,7 +4,9 @@ package scan
 
 import (
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/helloevanhere/simple_saver_service/pkg/awsHelpers"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
 	"github.com/helloevanhere/simple_saver_service/pkg/summary"
 )
 
@@ -18,12 +20,36 @@ type Scans struct {
 type BucketScans struct {
 	BucketSummary summary.BucketSummary `json:"bucket_summary"`
 	Scans         Scans                 `json:"scan_results"`
+	AccessDetail  *access.AccessDetail  `json:"access_detail,omitempty"` //nil when no access logs were provided
 }
 
-// Takes in a session and array of bucket names and returns the BucketScans for their data
-func ScanS3(sess *session.Session, buckets []string) ([]BucketScans, error) {
+// Takes in a session, array of bucket names and ScanOptions and returns the BucketScans for their data
+func ScanS3(sess *session.Session, buckets []string, opts ScanOptions) ([]BucketScans, error) {
 	results := []BucketScans{}
 
+	//Resolve the scanners enabled for this request
+	registrations, err := EnabledScanners(opts.Scanners)
+	if err != nil {
+		return results, err
+	}
+
+	//Objects of every bucket are indexed to find duplicates across buckets
+	var duplicateIndex *DuplicateIndex
+	for _, r := range registrations {
+		if r.Name == "cross_bucket_duplicates" {
+			duplicateIndex = NewDuplicateIndex()
+		}
+	}
+
+	//Access logs are read once for every bucket in the request
+	var tracker *access.Tracker
+	if opts.AccessLogs != nil {
+		tracker, err = access.Load(sess, *opts.AccessLogs)
+		if err != nil {
+			return results, err
+		}
+	}
+
 	//Creates []BucketSummary of all buckets provided
 	bucketsSummaries, err := summary.CreateBucketSummaries(sess, buckets)
 	if err != nil {
@@ -33,24 +59,53 @@ func ScanS3(sess *session.Session, buckets []string) ([]BucketScans, error) {
 	//Iterate through BucketSummaries to create both scan types
 	for _, bucketSummary := range bucketsSummaries {
 
-		//AWS SDK LIST CALL
-		bucketObjs, err := awsHelpers.ListBucketObjects(sess, bucketSummary.Name)
+		//Create bucketScan
+		bucketScan, err := bucketScan(sess, bucketSummary, opts)
 		if err != nil {
 			return results, err
 		}
 
-		//Create bucketScan
-		bucketScan, err := bucketScan(sess, bucketSummary, bucketObjs)
-		if err != nil {
-			return results, err
+		var accessDetail *access.AccessDetail
+		if tracker != nil {
+			detail := tracker.Detail(bucketSummary.Name)
+			accessDetail = &detail
+		}
+
+		ctx := ScanContext{
+			Session:    sess,
+			Bucket:     bucketSummary,
+			BucketScan: bucketScan,
+			Options:    opts,
+			Access:     accessDetail,
+
+			AccessTracker: tracker,
+
+			DuplicateIndex: duplicateIndex,
+		}
+
+		//AWS SDK LIST CALL
+		//Each page feeds the bucket's storage classes and every enabled scanner
+		pages := func(fn func(page *s3.ListObjectsV2Output) error) error {
+			return awsHelpers.ListBucketObjectPages(sess, bucketSummary.Name, func(page *s3.ListObjectsV2Output) error {
+				bucketScan.StorageClasses, err = storageClassScan(bucketScan.StorageClasses, page)
+				if err != nil {
+					return err
+				}
+				return fn(page)
+			})
 		}
 
 		//Create objectScan
-		objectScans, err := objectScans(sess, bucketSummary, bucketObjs)
+		objectScans, err := objectScans(ctx, registrations, pages)
 		if err != nil {
 			return results, err
 		}
 
+		//The label from the listing the scans saw replaces the summary's, objects may have changed in between
+		if name, ok := scannedWorkload(objectScans); ok {
+			bucketSummary.Workload = name
+		}
+
 		//Create BucketScans object for one bucket
 		result := BucketScans{
 			BucketSummary: bucketSummary,
@@ -58,6 +113,7 @@ func ScanS3(sess *session.Session, buckets []string) ([]BucketScans, error) {
 				BucketScan:  bucketScan,
 				ObjectScans: objectScans,
 			},
+			AccessDetail: accessDetail,
 		}
 
 		//append BucketScans
@@ -65,5 +121,11 @@ func ScanS3(sess *session.Session, buckets []string) ([]BucketScans, error) {
 
 	}
 
+	if duplicateIndex != nil {
+		if err := resolveCrossBucketDuplicates(sess, results, duplicateIndex, opts); err != nil {
+			return results, err
+		}
+	}
+
 	return results, nil
 }
@@ -0,0 +1,1602 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates a page func that hands the given pages to a scanner in order
+func testPages(pages ...*s3.ListObjectsV2Output) func(fn func(page *s3.ListObjectsV2Output) error) error {
+	return func(fn func(page *s3.ListObjectsV2Output) error) error {
+		for _, page := range pages {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func testObject(key string, size int64, etag string, storageClass string) *s3.Object {
+	return &s3.Object{
+		Key:          aws.String(key),
+		Size:         aws.Int64(size),
+		ETag:         aws.String(etag),
+		StorageClass: aws.String(storageClass),
+	}
+}
+
+func TestEnabledScanners(t *testing.T) {
+	all, err := EnabledScanners(nil)
+	assert.NoError(t, err)
+	assert.Len(t, all, len(RegisteredScanners()))
+
+	enabled, err := EnabledScanners([]string{"compressible_objects", "duplicate_objects"})
+	assert.NoError(t, err)
+	if assert.Len(t, enabled, 2) {
+		//registration order is kept regardless of request order
+		assert.Equal(t, "duplicate_objects", enabled[0].Name)
+		assert.Equal(t, "compressible_objects", enabled[1].Name)
+	}
+
+	_, err = EnabledScanners([]string{"not_a_scan"})
+	assert.Error(t, err)
+
+	//Bucket level calls are only made for the scanners that read them
+	opts := ScanOptions{Scanners: []string{"duplicate_objects"}}
+	assert.True(t, anyScannerEnabled(opts, bucketScanConsumers.objectLock))
+	assert.False(t, anyScannerEnabled(opts, bucketScanConsumers.tags))
+	assert.True(t, anyScannerEnabled(ScanOptions{}, bucketScanConsumers.tags))
+}
+
+func TestObjectScansAcrossPages(t *testing.T) {
+	registrations, err := EnabledScanners([]string{"duplicate_objects", "compressible_objects"})
+	assert.NoError(t, err)
+
+	pages := testPages(
+		&s3.ListObjectsV2Output{Contents: []*s3.Object{
+			testObject("a.json", 1000, "etag-1", "STANDARD"),
+			testObject("b.gz", 2000, "etag-2", "STANDARD"),
+		}},
+		&s3.ListObjectsV2Output{Contents: []*s3.Object{
+			testObject("copy-of-a.json", 1000, "etag-1", "STANDARD"),
+		}},
+	)
+
+	results, err := objectScans(ScanContext{}, registrations, pages)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "duplicate_objects", results[0].DataCategory)
+		assert.Equal(t, int64(1), results[0].ObjectCount)
+		assert.Equal(t, int64(1000), results[0].DataSize)
+
+		assert.Equal(t, "compressible_objects", results[1].DataCategory)
+		assert.Equal(t, int64(2), results[1].ObjectCount)
+		assert.Equal(t, int64(2000), results[1].DataSize)
+	}
+}
+
+func TestSummarizeMultipartUploads(t *testing.T) {
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	uploads := []multipartUpload{
+		{Key: "new", StorageClass: "STANDARD", Initiated: now.AddDate(0, 0, -1), PartCount: 2, Size: 1000000000},
+		{Key: "month", StorageClass: "STANDARD", Initiated: now.AddDate(0, 0, -10), PartCount: 1, Size: 1000000000},
+		{Key: "old", StorageClass: "STANDARD_IA", Initiated: now.AddDate(0, 0, -120), PartCount: 3, Size: 2000000000},
+	}
+
+	result := summarizeMultipartUploads(uploads, now)
+	assert.Equal(t, "incomplete_multipart_upload", result.DataCategory)
+	assert.Equal(t, int64(3), result.ObjectCount)
+	assert.Equal(t, int64(4000000000), result.DataSize)
+	//uploads under a week old may still complete
+	assert.InDelta(t, 0.023+0.025, result.EstimatedSavings.CalculatedMonthlylSavingsMin, 0.0001)
+	assert.InDelta(t, 0.023*2+0.025, result.EstimatedSavings.CalculatedMonthlySavingsMax, 0.0001)
+
+	detail, ok := result.Details.(*MultipartUploadDetail)
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(6), detail.PartCount)
+		assert.Equal(t, now.AddDate(0, 0, -120), detail.OldestInitiatedAt)
+		assert.Equal(t, int64(2000000000), detail.StorageClasses["STANDARD"])
+		assert.Equal(t, int64(1), detail.AgeBands[0].UploadCount)
+		assert.Equal(t, int64(1), detail.AgeBands[1].UploadCount)
+		assert.Equal(t, int64(0), detail.AgeBands[2].UploadCount)
+		assert.Equal(t, int64(1), detail.AgeBands[3].UploadCount)
+	}
+
+	assert.Nil(t, summarizeMultipartUploads(nil, now).Details)
+}
+
+func TestVersionTally(t *testing.T) {
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	tally := newVersionTally(now, nil)
+
+	version := func(key string, daysAgo int, latest bool, size int64) *s3.ObjectVersion {
+		return &s3.ObjectVersion{
+			Key:          aws.String(key),
+			LastModified: aws.Time(now.AddDate(0, 0, -daysAgo)),
+			IsLatest:     aws.Bool(latest),
+			Size:         aws.Int64(size),
+			StorageClass: aws.String("STANDARD"),
+		}
+	}
+	marker := func(key string, daysAgo int, latest bool) *s3.DeleteMarkerEntry {
+		return &s3.DeleteMarkerEntry{
+			Key:          aws.String(key),
+			LastModified: aws.Time(now.AddDate(0, 0, -daysAgo)),
+			IsLatest:     aws.Bool(latest),
+		}
+	}
+
+	//"a" continues onto the second page
+	tally.addPage(&s3.ListObjectVersionsOutput{
+		Versions: []*s3.ObjectVersion{
+			version("a", 1, true, 100),
+			version("a", 200, false, 1000000000),
+		},
+	})
+	tally.addPage(&s3.ListObjectVersionsOutput{
+		Versions: []*s3.ObjectVersion{
+			version("a", 300, false, 1000000000),
+			version("b", 10, true, 100),
+		},
+		DeleteMarkers: []*s3.DeleteMarkerEntry{
+			marker("a", 100, false),
+			marker("gone", 5, true),
+		},
+	})
+	tally.finish()
+
+	result := tally.objectScan()
+	assert.Equal(t, "object_versions", result.DataCategory)
+	assert.Equal(t, int64(2), result.ObjectCount)
+	assert.Equal(t, int64(2000000000), result.DataSize)
+
+	detail, ok := result.Details.(*VersionDetail)
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(3), detail.KeyCount)
+		assert.Equal(t, int64(2), detail.DeleteMarkerCount)
+		assert.Equal(t, int64(1), detail.ExpiredDeleteMarkerCount)
+		assert.Equal(t, int64(2), detail.NoncurrentStorageClasses["STANDARD"].ObjectCount)
+		//"a" has 3 versions and a delete marker
+		assert.Equal(t, int64(1), detail.VersionsPerKey[2].KeyCount)
+		assert.Equal(t, int64(2), detail.VersionsPerKey[0].KeyCount)
+		//the 200 day old version became noncurrent 1 day ago, the 300 day old one 100 days ago
+		assert.Equal(t, int64(1), detail.NoncurrentAgeBands[0].VersionCount)
+		assert.Equal(t, int64(1), detail.NoncurrentAgeBands[2].VersionCount)
+	}
+	assert.InDelta(t, 0.023, result.EstimatedSavings.CalculatedMonthlylSavingsMin, 0.0001)
+	assert.InDelta(t, 0.046, result.EstimatedSavings.CalculatedMonthlySavingsMax, 0.0001)
+
+	//Noncurrent versions under Object Lock save nothing
+	lookup := func(key string, versionID string) (objectLock, error) {
+		return objectLock{RetainUntil: now.AddDate(1, 0, 0)}, nil
+	}
+	tally = newVersionTally(now, newLockCheckerWithLookup(ObjectLockDetail{Enabled: true}, lookup, 10, now))
+	tally.addPage(&s3.ListObjectVersionsOutput{
+		Versions: []*s3.ObjectVersion{
+			version("a", 1, true, 100),
+			version("a", 200, false, 1000000000),
+		},
+	})
+	tally.finish()
+	result = tally.objectScan()
+	assert.Equal(t, int64(1), result.ObjectCount)
+	assert.Equal(t, 0.0, result.EstimatedSavings.CalculatedMonthlySavingsMax)
+	assert.Equal(t, int64(1), result.ObjectLock.LockedObjectCount)
+}
+
+func TestDuplicateObjectsScannerGroups(t *testing.T) {
+	scanner := newDuplicateObjectsScanner(nil)
+	err := scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("a", 100, "etag-1", "STANDARD"),
+		testObject("b", 100, "etag-1", "STANDARD"),
+		testObject("c", 100, "etag-1", "STANDARD"),
+		testObject("d", 100, "etag-2", "STANDARD"),
+	}})
+	assert.NoError(t, err)
+
+	result, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.ObjectCount)
+
+	detail, ok := result.Details.(*DuplicateDetail)
+	if assert.True(t, ok) && assert.Len(t, detail.Groups, 1) {
+		assert.Equal(t, "etag", detail.Mode)
+		assert.Equal(t, []string{"a", "b", "c"}, detail.Groups[0].Keys)
+		assert.Equal(t, int64(200), detail.Groups[0].DuplicateSize)
+	}
+}
+
+func TestConfirmDuplicates(t *testing.T) {
+	//same content uploaded with different part sizes, so the ETags differ
+	bySize := map[int64][]duplicateCandidate{
+		1000000000: {
+			{Key: "raw/data.bin", Size: 1000000000, ETag: "aaa-2", StorageClass: "STANDARD"},
+			{Key: "copy/data.bin", Size: 1000000000, ETag: "bbb-4", StorageClass: "STANDARD"},
+			{Key: "other.bin", Size: 1000000000, ETag: "ccc-4", StorageClass: "STANDARD"},
+			{Key: "unknown.bin", Size: 1000000000, ETag: "ddd-4", StorageClass: "STANDARD"},
+		},
+		//unique size, never identified
+		5: {{Key: "small", Size: 5, ETag: "eee", StorageClass: "STANDARD"}},
+	}
+	identified := []string{}
+	identify := func(c duplicateCandidate) (string, string, error) {
+		identified = append(identified, c.Key)
+		switch c.Key {
+		case "raw/data.bin", "copy/data.bin":
+			return "sha256:same", "sha256", nil
+		case "other.bin":
+			return "sha256:different", "sha256", nil
+		}
+		return "", "", nil
+	}
+
+	hash := func(c duplicateCandidate) (string, string, error) {
+		return "", "", nil
+	}
+
+	result, err := confirmDuplicates(bySize, identify, hash, nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, identified, "small")
+	assert.Equal(t, int64(1), result.ObjectCount)
+	assert.Equal(t, int64(1000000000), result.DataSize)
+	assert.InDelta(t, 0.023, result.EstimatedSavings.CalculatedMonthlySavingsMax, 0.0001)
+
+	detail, ok := result.Details.(*DuplicateDetail)
+	if assert.True(t, ok) && assert.Len(t, detail.Groups, 1) {
+		assert.Equal(t, "deep", detail.Mode)
+		assert.Equal(t, int64(1), detail.UnconfirmedCount)
+		assert.Equal(t, []string{"raw/data.bin", "copy/data.bin"}, detail.Groups[0].Keys)
+	}
+
+	//Same content identified by a checksum and by an ETag is compared by hashing the content
+	bySize = map[int64][]duplicateCandidate{
+		2000: {
+			{Key: "checksum.bin", Size: 2000, ETag: "fff-2", StorageClass: "STANDARD"},
+			{Key: "etag.bin", Size: 2000, ETag: "ggg", StorageClass: "STANDARD"},
+			{Key: "stored-sha.bin", Size: 2000, ETag: "hhh-2", StorageClass: "STANDARD"},
+			{Key: "too-large.bin", Size: 2000, ETag: "iii", StorageClass: "STANDARD"},
+		},
+	}
+	identify = func(c duplicateCandidate) (string, string, error) {
+		switch c.Key {
+		case "checksum.bin":
+			return "sha1:abc", "sha1", nil
+		case "stored-sha.bin":
+			return "sha256:same", "sha256", nil
+		}
+		return "etag:" + c.ETag, "etag", nil
+	}
+	hashed := []string{}
+	hash = func(c duplicateCandidate) (string, string, error) {
+		hashed = append(hashed, c.Key)
+		if c.Key == "too-large.bin" {
+			return "", "", nil
+		}
+		return "sha256:same", "sha256", nil
+	}
+	result, err = confirmDuplicates(bySize, identify, hash, nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, hashed, "stored-sha.bin", "a stored SHA-256 is already comparable")
+	assert.Equal(t, int64(2), result.ObjectCount)
+	detail = result.Details.(*DuplicateDetail)
+	assert.Equal(t, int64(1), detail.UnconfirmedCount)
+	if assert.Len(t, detail.Groups, 1) {
+		assert.Equal(t, "sha256", detail.Groups[0].ConfirmedBy)
+		assert.Equal(t, []string{"checksum.bin", "etag.bin", "stored-sha.bin"}, detail.Groups[0].Keys)
+	}
+
+	//Failed lookups leave the object unconfirmed and are counted
+	bySize = map[int64][]duplicateCandidate{
+		3000: {
+			{Key: "a.bin", Size: 3000, ETag: "jjj", StorageClass: "STANDARD"},
+			{Key: "denied.bin", Size: 3000, ETag: "jjj", StorageClass: "STANDARD"},
+		},
+	}
+	identify = func(c duplicateCandidate) (string, string, error) {
+		if c.Key == "denied.bin" {
+			return "", "", errors.New("AccessDenied")
+		}
+		return "etag:" + c.ETag, "etag", nil
+	}
+	result, err = confirmDuplicates(bySize, identify, hash, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.ObjectCount)
+	detail = result.Details.(*DuplicateDetail)
+	assert.Equal(t, int64(1), detail.LookupErrors)
+	assert.Equal(t, int64(1), detail.UnconfirmedCount)
+}
+
+func TestEtagIsMD5(t *testing.T) {
+	assert.True(t, etagIsMD5(&s3.HeadObjectOutput{}))
+	assert.True(t, etagIsMD5(&s3.HeadObjectOutput{ServerSideEncryption: aws.String("AES256")}))
+	assert.False(t, etagIsMD5(&s3.HeadObjectOutput{ServerSideEncryption: aws.String("aws:kms")}))
+	assert.False(t, etagIsMD5(&s3.HeadObjectOutput{SSECustomerAlgorithm: aws.String("AES256")}))
+}
+
+func TestChecksumFingerprint(t *testing.T) {
+	fingerprint, confirmedBy := checksumFingerprint(&s3.Checksum{
+		ChecksumCRC32:  aws.String("crc"),
+		ChecksumSHA256: aws.String("sha"),
+	})
+	assert.Equal(t, "sha256:sha", fingerprint)
+	assert.Equal(t, "sha256", confirmedBy)
+
+	//composite multipart checksums can't be compared
+	fingerprint, _ = checksumFingerprint(&s3.Checksum{ChecksumSHA256: aws.String("sha-3")})
+	assert.Equal(t, "", fingerprint)
+	fingerprint, _ = checksumFingerprint(nil)
+	assert.Equal(t, "", fingerprint)
+
+	//CRC checksums don't prove identical content
+	fingerprint, _ = checksumFingerprint(&s3.Checksum{ChecksumCRC32: aws.String("crc"), ChecksumCRC32C: aws.String("crcc")})
+	assert.Equal(t, "", fingerprint)
+}
+
+func TestDuplicateIndex(t *testing.T) {
+	idx := NewDuplicateIndex()
+	idx.MinSize = 10
+
+	older := testObject("raw/data.bin", 1000000000, "\"etag-1\"", "STANDARD")
+	older.LastModified = aws.Time(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := testObject("staging/data.bin", 1000000000, "\"etag-1\"", "STANDARD_IA")
+	newer.LastModified = aws.Time(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	idx.Add("staging", newer)
+	idx.Add("staging", newer)
+	idx.Add("raw", older)
+	idx.Add("raw", testObject("tiny", 5, "etag-2", "STANDARD"))
+	idx.Add("backup", testObject("only-here", 100, "etag-3", "STANDARD"))
+
+	duplicates := idx.Duplicates()
+	if assert.Len(t, duplicates, 1) {
+		assert.Equal(t, "raw", duplicates[0].Canonical.Bucket)
+		assert.Equal(t, "staging", duplicates[0].Copies[0].Bucket)
+		assert.Equal(t, int64(2), duplicates[0].Copies[0].Count)
+	}
+
+	objectScans, err := crossBucketObjectScans(duplicates, idx.MinSize, false, false, nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, objectScans, "raw")
+	staging := objectScans["staging"]
+	assert.Equal(t, int64(2), staging.ObjectCount)
+	assert.Equal(t, int64(2000000000), staging.DataSize)
+	assert.InDelta(t, 0.025, staging.EstimatedSavings.CalculatedMonthlySavingsMax, 0.0001)
+	detail, ok := staging.Details.(*CrossBucketDuplicateDetail)
+	if assert.True(t, ok) && assert.Len(t, detail.Pairs, 1) {
+		assert.Equal(t, "raw", detail.Pairs[0].CanonicalBucket)
+		assert.Equal(t, "staging", detail.Pairs[0].DuplicateBucket)
+	}
+
+	//duplicate_objects already counts the second copy inside staging
+	objectScans, err = crossBucketObjectScans(duplicates, idx.MinSize, false, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), objectScans["staging"].ObjectCount)
+	assert.Equal(t, int64(1000000000), objectScans["staging"].DataSize)
+
+	//A copy locked by Object Lock can't be deleted
+	now := time.Now()
+	lookup := func(key string, versionID string) (objectLock, error) {
+		return objectLock{LegalHold: true}, nil
+	}
+	locks := map[string]*lockChecker{"staging": newLockCheckerWithLookup(ObjectLockDetail{Enabled: true}, lookup, 10, now)}
+	objectScans, err = crossBucketObjectScans(duplicates, idx.MinSize, false, true, locks)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), objectScans["staging"].ObjectCount)
+	assert.Equal(t, 0.0, objectScans["staging"].EstimatedSavings.CalculatedMonthlySavingsMax)
+	assert.Equal(t, int64(1), objectScans["staging"].ObjectLock.LockedObjectCount)
+}
+
+func TestDuplicateIndexStaysBounded(t *testing.T) {
+	idx := NewDuplicateIndex()
+	idx.MinSize = 1
+	idx.MaxEntries = 10
+
+	for i := int64(1); i <= 100; i++ {
+		idx.Add("bucket", testObject("key", i, "etag", "STANDARD"))
+	}
+	assert.LessOrEqual(t, len(idx.entries), idx.MaxEntries)
+	assert.True(t, idx.truncated)
+	//the largest objects are kept
+	assert.Contains(t, idx.entries, duplicateFingerprint(100, "etag"))
+
+	//Objects only share an entry when both size and ETag match
+	idx.Add("other", testObject("key", 100, "other-etag", "STANDARD"))
+	assert.Len(t, idx.entries[duplicateFingerprint(100, "etag")].Copies, 1)
+	assert.Len(t, idx.entries[duplicateFingerprint(100, "other-etag")].Copies, 1)
+}
+
+func TestConfirmCrossBucketDuplicates(t *testing.T) {
+	duplicates := []CrossBucketDuplicate{{
+		Size:        100,
+		ETag:        "etag-1",
+		ConfirmedBy: "etag",
+		Canonical:   DuplicateCopy{Bucket: "raw", Key: "a"},
+		Copies: []DuplicateCopy{
+			{Bucket: "staging", Key: "a"},
+			{Bucket: "backup", Key: "a"},
+		},
+	}}
+	checksums := map[string]string{"raw/a": "sha256:1", "staging/a": "sha256:1", "backup/a": "sha256:2"}
+
+	confirmed, err := confirmCrossBucketDuplicates(duplicates, func(bucket string, key string) (string, error) {
+		return checksums[bucket+"/"+key], nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, confirmed, 1) && assert.Len(t, confirmed[0].Copies, 1) {
+		assert.Equal(t, "staging", confirmed[0].Copies[0].Bucket)
+		assert.Equal(t, "checksum", confirmed[0].ConfirmedBy)
+	}
+
+	//A copy with a checksum of another algorithm is kept unconfirmed
+	checksums["backup/a"] = "sha1:1"
+	confirmed, err = confirmCrossBucketDuplicates(duplicates, func(bucket string, key string) (string, error) {
+		return checksums[bucket+"/"+key], nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, confirmed, 1) {
+		assert.Len(t, confirmed[0].Copies, 2)
+		assert.Equal(t, "etag", confirmed[0].ConfirmedBy)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	format, compressed := detectFormat([]byte{0x1f, 0x8b, 0x08, 0x00})
+	assert.Equal(t, "gzip", format)
+	assert.True(t, compressed)
+
+	format, compressed = detectFormat([]byte("%PDF-1.7\n"))
+	assert.Equal(t, "pdf", format)
+	assert.True(t, compressed)
+
+	format, compressed = detectFormat([]byte("\x00\x00\x00\x18ftypmp42"))
+	assert.Equal(t, "mp4", format)
+	assert.True(t, compressed)
+
+	format, compressed = detectFormat([]byte(`{"id": 1}`))
+	assert.Equal(t, "unknown", format)
+	assert.False(t, compressed)
+}
+
+func TestTrialCompress(t *testing.T) {
+	text := []byte(strings.Repeat(`{"event":"page_view","user":"someone"}`+"\n", 200))
+	ratio, codec := trialCompress(text)
+	assert.Less(t, ratio, 0.2)
+	assert.NotEmpty(t, codec)
+
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	ratio, _ = trialCompress(random)
+	assert.Equal(t, 1.0, ratio)
+
+	ratio, codec = trialCompress([]byte("tiny"))
+	assert.Equal(t, 1.0, ratio)
+	assert.Empty(t, codec)
+}
+
+func TestSampledCompressionScanner(t *testing.T) {
+	text := []byte(strings.Repeat("2023-03-01 INFO request served in 12ms\n", 500))
+	gzipped := append([]byte{0x1f, 0x8b, 0x08, 0x00}, text...)
+	content := map[string][]byte{
+		"logs/app":      text,    //extensionless, but compressible
+		"logs/app.gz":   gzipped, //.gz is already compressed
+		"docs/file.pdf": []byte("%PDF-1.7" + string(text)),
+	}
+	fetched := []string{}
+	fetch := func(key string, n int64) ([]byte, error) {
+		fetched = append(fetched, key)
+		data := content[key]
+		if int64(len(data)) > n {
+			data = data[:n]
+		}
+		return data, nil
+	}
+
+	scanner := newSampledCompressionScannerWithFetcher(ScanOptions{CompressionSampleBytes: 4096}, fetch)
+	err := scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("logs/app", 1000000000, "etag-1", "STANDARD"),
+		testObject("logs/app.gz", 2000000000, "etag-2", "STANDARD"),
+		testObject("docs/file.pdf", 3000000000, "etag-3", "STANDARD"),
+		testObject("archive/old.json", 4000000000, "etag-4", "GLACIER"),
+	}})
+	assert.NoError(t, err)
+
+	result, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.NotContains(t, fetched, "archive/old.json")
+	//the extensionless log and the unread .json
+	assert.Equal(t, int64(2), result.ObjectCount)
+	assert.Equal(t, int64(5000000000), result.DataSize)
+	assert.Greater(t, result.EstimatedSavings.CalculatedMonthlylSavingsMin, 0.02)
+
+	detail, ok := result.Details.(*CompressionSampleDetail)
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(3), detail.SampledObjects)
+		assert.Equal(t, int64(1), detail.Formats["gzip"])
+		for _, ext := range detail.Extensions {
+			switch ext.Extension {
+			case "":
+				assert.True(t, ext.Compressible)
+				assert.Equal(t, "measured", ext.Method)
+			case ".gz", ".pdf":
+				assert.False(t, ext.Compressible)
+			case ".json":
+				//archived objects are never read, so the extension decides
+				assert.Equal(t, "extension", ext.Method)
+				assert.True(t, ext.Compressible)
+			}
+		}
+	}
+}
+
+func TestIntelligentTieringScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	aged := func(object *s3.Object, days int) *s3.Object {
+		object.LastModified = aws.Time(now.AddDate(0, 0, -days))
+		return object
+	}
+	bucketScan := BucketScan{
+		LifecycleDetail: LifecycleDetail{Rules: []*s3.LifecycleRule{{
+			Status:      aws.String("Enabled"),
+			Filter:      &s3.LifecycleRuleFilter{Prefix: aws.String("managed/")},
+			Transitions: []*s3.Transition{{Days: aws.Int64(30), StorageClass: aws.String("GLACIER")}},
+		}}},
+		IntelligentTieringDetail: IntelligentTieringDetail{Configurations: []*s3.IntelligentTieringConfiguration{{
+			Status:   aws.String("Enabled"),
+			Tierings: []*s3.Tiering{{AccessTier: aws.String("ARCHIVE_ACCESS"), Days: aws.Int64(90)}},
+		}}},
+	}
+
+	scanner := newIntelligentTieringScanner(bucketScan, policy.Default(), now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("data/new", 1e9, "a", "STANDARD"), 1),
+		aged(testObject("data/month", 1e9, "b", "STANDARD"), 45),
+		aged(testObject("data/old", 2e9, "c", "STANDARD"), 200),
+		aged(testObject("data/tiny", 1024, "d", "STANDARD"), 200),
+		aged(testObject("managed/old", 1e9, "e", "STANDARD"), 200),
+		aged(testObject("tiered/big", 1e9, "f", "INTELLIGENT_TIERING"), 200),
+		aged(testObject("tiered/tiny", 1024, "g", "INTELLIGENT_TIERING"), 200),
+	}}))
+
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), objectScan.ObjectCount, "small and already transitioned objects are not candidates")
+	assert.Equal(t, int64(4e9), objectScan.DataSize)
+	// min: 2GB to the Infrequent Access tier, max: 1GB there and 2GB to the Archive Instant Access tier, less the monitoring fee
+	assert.InDelta(t, 2*(0.023-0.0125)-0.0000075, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-9)
+	assert.InDelta(t, (0.023-0.0125)+2*(0.023-0.004)-0.0000075, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9)
+
+	detail := objectScan.Details.(*IntelligentTieringObjectDetail)
+	assert.Equal(t, int64(1), detail.SmallObjectCount)
+	assert.Equal(t, int64(1), detail.TransitionedObjectCount)
+	assert.Equal(t, int64(2), detail.TieredObjectCount)
+	assert.Equal(t, int64(1), detail.TieredSmallObjectCount)
+	assert.Equal(t, []string{"ARCHIVE_ACCESS"}, detail.ArchiveTiers)
+	assert.InDelta(t, 0.00003, detail.Transition.UpfrontCost, 1e-12)
+	assert.True(t, detail.Transition.BreaksEven)
+	assert.False(t, detail.ArchiveTiersUnknown)
+	//Lifecycle rules to STANDARD_IA and GLACIER_IR pay for retrievals Intelligent-Tiering doesn't charge
+	assert.InDelta(t, (0.023-0.0125)-0.01*0.01+2*(0.023-0.004)-2*0.01*0.03, detail.Lifecycle.MonthlyDelta, 1e-9)
+	assert.True(t, detail.BeatsLifecycle)
+
+	//No savings when lifecycle rules would save more, ex. when the retrieval rate is 0
+	p := policy.Default()
+	p.RetrievalRate = 0
+	bucketScan.IntelligentTieringDetail = IntelligentTieringDetail{Unknown: true}
+	scanner = newIntelligentTieringScanner(bucketScan, p, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("data/old", 2e9, "c", "STANDARD"), 200),
+	}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail = objectScan.Details.(*IntelligentTieringObjectDetail)
+	assert.False(t, detail.BeatsLifecycle)
+	assert.True(t, detail.ArchiveTiersUnknown)
+	assert.Equal(t, estimate.EstimatedSavings{}, objectScan.EstimatedSavings)
+
+	//Tiny objects alone are never worth the monitoring fee
+	scanner = newIntelligentTieringScanner(BucketScan{}, policy.Default(), now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("tiny", 1024, "a", "STANDARD"), 400),
+	}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), objectScan.ObjectCount)
+}
+
+func TestReplicationScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	bucketScan := BucketScan{
+		LifecycleDetail: LifecycleDetail{Rules: []*s3.LifecycleRule{{
+			Status:     aws.String("Enabled"),
+			Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("tmp/")},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(7)},
+		}}},
+		ReplicationDetail: ReplicationDetail{
+			Region: "us-east-1",
+			Rules: []*s3.ReplicationRule{
+				{
+					ID:          aws.String("everything"),
+					Status:      aws.String("Enabled"),
+					Priority:    aws.Int64(1),
+					Filter:      &s3.ReplicationRuleFilter{Prefix: aws.String("")},
+					Destination: &s3.Destination{Bucket: aws.String("arn:aws:s3:::dr-bucket")},
+				},
+				{
+					ID:          aws.String("tmp"),
+					Status:      aws.String("Enabled"),
+					Priority:    aws.Int64(2),
+					Filter:      &s3.ReplicationRuleFilter{Prefix: aws.String("tmp/")},
+					Destination: &s3.Destination{Bucket: aws.String("arn:aws:s3:::tmp-replica"), StorageClass: aws.String("STANDARD_IA")},
+				},
+				{
+					ID:          aws.String("disabled"),
+					Status:      aws.String("Disabled"),
+					Destination: &s3.Destination{Bucket: aws.String("arn:aws:s3:::unused")},
+				},
+			},
+			Destinations: map[string]ReplicationDestination{
+				"dr-bucket":   {Region: "us-west-2", ReplicatesTo: []string{"dr-bucket-copy"}},
+				"tmp-replica": {Region: "us-east-1"},
+			},
+		},
+	}
+
+	object := func(key string, size int64, days int) *s3.Object {
+		o := testObject(key, size, "etag", "STANDARD")
+		o.LastModified = aws.Time(now.AddDate(0, 0, -days))
+		return o
+	}
+	outposts := object("outposts", 1e9, 1)
+	outposts.StorageClass = aws.String("OUTPOSTS")
+	pol := policy.Default()
+	scanner := newReplicationScanner(bucketScan, pol, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		object("data/old", 2e9, 100),
+		object("data/new", 1e9, 5),
+		object("tmp/scratch", 1e9, 1),
+		outposts,
+	}}))
+
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), objectScan.ObjectCount, "objects replicated to two destinations are counted once")
+	assert.Equal(t, int64(5e9), objectScan.DataSize)
+	toIA, _ := estimate.EstimateTransition(3, 4e9, "STANDARD", "STANDARD_IA", pol.RetrievalRate, 0)
+	toGlacier, _ := estimate.EstimateTransition(3, 4e9, "STANDARD", "GLACIER", pol.RetrievalRate, 0)
+	assert.InDelta(t, toIA.MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-9)
+	assert.InDelta(t, toGlacier.MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9)
+
+	detail := objectScan.Details.(*ReplicationCostDetail)
+	assert.Len(t, detail.Rules, 2, "disabled rules are not costed")
+
+	everything := detail.Rules[0]
+	assert.Equal(t, "everything", everything.ID)
+	assert.True(t, everything.CrossRegion)
+	assert.Equal(t, int64(5e9), everything.DataSize)
+	assert.Equal(t, int64(3e9), everything.MonthlyReplicatedSize)
+	assert.InDelta(t, 4*0.023, everything.StorageCost, 1e-9, "storage classes without a price are left out")
+	assert.InDelta(t, 0.06, everything.TransferCost, 1e-9)
+	assert.Equal(t, []string{"dr-bucket-copy"}, everything.ReplicaOfReplicas)
+	assert.InDelta(t, 4*0.023, everything.ReplicaOfReplicasCost, 1e-9)
+	assert.InDelta(t, toGlacier.UpfrontCost, everything.Transitions["GLACIER"].UpfrontCost, 1e-12)
+	assert.Equal(t, int64(1e9), everything.ExpiredAtSourceSize, "tmp/ is expired at the source")
+
+	tmp := detail.Rules[1]
+	assert.Equal(t, int64(1), tmp.ObjectCount, "tmp/ is replicated to both destinations")
+	assert.False(t, tmp.CrossRegion)
+	assert.True(t, tmp.ExpiredAtSource)
+	assert.Equal(t, int64(1e9), tmp.ExpiredAtSourceSize)
+	assert.InDelta(t, 0.0125, tmp.StorageCost, 1e-9)
+	assert.Equal(t, 0.0, tmp.EstimatedSavings.CalculatedMonthlySavingsMax, "STANDARD_IA replicas are already cold")
+
+	//Lifecycle rules are evaluated for each replicated key, not only the replication rule's prefix
+	bucketScan.LifecycleDetail.Rules[0].Filter.Prefix = aws.String("data/old")
+	scanner = newReplicationScanner(bucketScan, pol, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		object("data/old", 2e9, 100),
+		object("data/new", 1e9, 5),
+	}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	everything = objectScan.Details.(*ReplicationCostDetail).Rules[0]
+	assert.True(t, everything.ExpiredAtSource)
+	assert.Equal(t, int64(2e9), everything.ExpiredAtSourceSize)
+
+	//Buckets without replication report nothing
+	objectScan, err = newReplicationScanner(BucketScan{}, pol, now).Finalize()
+	assert.NoError(t, err)
+	assert.Nil(t, objectScan.Details)
+}
+
+func TestKMSRequestScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	encryption := map[string]objectEncryption{
+		"kms":        {Algorithm: "aws:kms"},
+		"bucket-key": {Algorithm: "aws:kms", BucketKeyEnabled: true},
+		"sse-s3":     {Algorithm: "AES256"},
+	}
+	fetch := func(key string) (objectEncryption, bool, error) {
+		e, ok := encryption[key]
+		return e, ok, nil
+	}
+	objects := []*s3.Object{}
+	for _, key := range []string{"kms", "bucket-key", "sse-s3", "kms-missing"} {
+		o := testObject(key, 1e6, "etag", "STANDARD")
+		o.LastModified = aws.Time(now.AddDate(0, 0, -1))
+		objects = append(objects, o)
+	}
+
+	//From the listing, a third of the readable samples makes KMS requests
+	sampling := ScanOptions{EncryptionSampling: true}
+	scanner := newKMSRequestScanner(ScanContext{Options: sampling}, fetch, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: objects}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	detail := objectScan.Details.(*KMSRequestDetail)
+	assert.Equal(t, int64(3), detail.SampledObjects)
+	assert.InDelta(t, 1.0/3, detail.KMSFraction, 1e-9)
+	assert.Equal(t, "listing", detail.RequestSource)
+	assert.Equal(t, int64(4), detail.MonthlyRequestsMin)
+	assert.Equal(t, int64(8), detail.MonthlyRequestsMax)
+	assert.Equal(t, int64(1), objectScan.ObjectCount)
+
+	//Access logs replace the listing, scaled to 30 days
+	accessDetail := &access.AccessDetail{
+		WindowStart: now.AddDate(0, 0, -10),
+		WindowEnd:   now,
+		GetCount:    2000000,
+		PutCount:    1000000,
+	}
+	scanner = newKMSRequestScanner(ScanContext{Access: accessDetail, Options: sampling}, fetch, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: objects}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail = objectScan.Details.(*KMSRequestDetail)
+	assert.Equal(t, "access_logs", detail.RequestSource)
+	assert.Equal(t, int64(9000000), detail.MonthlyRequestsMax)
+	assert.InDelta(t, 9, detail.MonthlyKMSCostMax, 1e-9)
+	assert.InDelta(t, 9*0.99, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9)
+
+	//Without readable samples the default encryption decides
+	noSamples := func(key string) (objectEncryption, bool, error) { return objectEncryption{}, false, nil }
+	ctx := ScanContext{BucketScan: BucketScan{EncryptionDetail: EncryptionDetail{Rules: []*s3.ServerSideEncryptionRule{{
+		ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String("aws:kms")},
+		BucketKeyEnabled:                   aws.Bool(true),
+	}}}}}
+	ctx.Options = sampling
+	scanner = newKMSRequestScanner(ctx, noSamples, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: objects}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), objectScan.ObjectCount, "Bucket Keys are already enabled")
+
+	//Errors other than a missing object stop the scan
+	denied := func(key string) (objectEncryption, bool, error) {
+		return objectEncryption{}, false, errors.New("AccessDenied")
+	}
+	scanner = newKMSRequestScanner(ctx, denied, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: objects}))
+	_, err = scanner.Finalize()
+	assert.Error(t, err)
+
+	assert.True(t, isNotFound(awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "id")))
+	assert.False(t, isNotFound(awserr.NewRequestFailure(awserr.New("Forbidden", "Forbidden", nil), 403, "id")))
+
+	//Sampling is opt-in, without it no object is read and the default encryption decides
+	ctx.BucketScan.EncryptionDetail.Rules[0].BucketKeyEnabled = aws.Bool(false)
+	ctx.Options = ScanOptions{}
+	scanner = newKMSRequestScanner(ctx, denied, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: objects}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail = objectScan.Details.(*KMSRequestDetail)
+	assert.Equal(t, int64(0), detail.SampledObjects)
+	assert.Equal(t, 1.0, detail.KMSFraction)
+}
+
+func TestLockChecker(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	locks := map[string]objectLock{
+		"retained": {RetainUntil: now.AddDate(0, 2, 0)},
+		"expired":  {RetainUntil: now.AddDate(0, -1, 0)},
+		"held":     {LegalHold: true},
+	}
+	lookup := func(key string, versionID string) (objectLock, error) { return locks[key], nil }
+	detail := ObjectLockDetail{Enabled: true, Mode: "GOVERNANCE", RetentionDays: 30}
+	checker := newLockCheckerWithLookup(detail, lookup, 3, now)
+
+	for key, want := range map[string]bool{"retained": true, "expired": false, "held": true} {
+		locked, err := checker.locked(key, 100, now.AddDate(-1, 0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, want, locked, key)
+	}
+
+	//Past the budget the default retention is assumed from the last modified date
+	locked, err := checker.locked("recent", 100, now.AddDate(0, 0, -10))
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	locked, err = checker.locked("old", 100, now.AddDate(0, 0, -40))
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	summary := checker.lockSummary()
+	assert.Equal(t, int64(3), summary.LockedObjectCount)
+	assert.Equal(t, int64(300), summary.LockedSize)
+	assert.Equal(t, int64(1), summary.LegalHoldCount)
+	assert.Equal(t, now.AddDate(0, 2, 0), summary.DeletableAfter)
+	assert.True(t, summary.Estimated)
+
+	//Past the budget without default retention objects are assumed deletable and counted as unchecked
+	checker = newLockCheckerWithLookup(ObjectLockDetail{Enabled: true}, lookup, 0, now)
+	locked, err = checker.locked("retained", 100, now)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	summary = checker.lockSummary()
+	if assert.NotNil(t, summary) {
+		assert.Equal(t, int64(0), summary.LockedObjectCount)
+		assert.Equal(t, int64(1), summary.UncheckedCount)
+		assert.True(t, summary.Estimated)
+	}
+
+	var none *lockChecker
+	locked, err = none.locked("any", 100, now)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	assert.Nil(t, none.lockSummary())
+}
+
+func TestDuplicateObjectsScannerSkipsLockedSavings(t *testing.T) {
+	now := time.Now()
+	lookup := func(key string, versionID string) (objectLock, error) {
+		if key == "locked-copy" {
+			return objectLock{RetainUntil: now.AddDate(1, 0, 0)}, nil
+		}
+		return objectLock{}, nil
+	}
+	scanner := newDuplicateObjectsScanner(newLockCheckerWithLookup(ObjectLockDetail{Enabled: true}, lookup, 10, now))
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("original", 1e9, "a", "STANDARD"),
+		testObject("copy", 1e9, "a", "STANDARD"),
+		testObject("locked-copy", 1e9, "a", "STANDARD"),
+	}}))
+
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), objectScan.ObjectCount)
+	assert.InDelta(t, 0.023, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9, "only the unlocked copy saves")
+	assert.Equal(t, int64(1), objectScan.ObjectLock.LockedObjectCount)
+}
+
+func TestAuditBucketFeatures(t *testing.T) {
+	features := bucketFeatures{
+		RequesterPays: true,
+		Inventories: []*s3.InventoryConfiguration{
+			{
+				Id:          aws.String("daily"),
+				IsEnabled:   aws.Bool(true),
+				Schedule:    &s3.InventorySchedule{Frequency: aws.String("Daily")},
+				Destination: &s3.InventoryDestination{S3BucketDestination: &s3.InventoryS3BucketDestination{Bucket: aws.String("arn:aws:s3:::reports"), Prefix: aws.String("inventory/")}},
+			},
+			{Id: aws.String("disabled"), IsEnabled: aws.Bool(false)},
+		},
+		Analytics:         []*s3.AnalyticsConfiguration{{Id: aws.String("all"), StorageClassAnalysis: &s3.StorageClassAnalysis{}}},
+		MetricsCount:      1,
+		Logging:           &s3.LoggingEnabled{TargetBucket: aws.String("logs"), TargetPrefix: aws.String("bucket/")},
+		SameRegionLogging: true,
+		LogStorage:        map[string]StorageClassTotal{"STANDARD": {ObjectCount: 1000, DataSize: 10e9}},
+	}
+
+	//Without access logs nothing is known to be unused
+	objectScan := auditBucketFeatures(features, 2000000, nil)
+	detail := objectScan.Details.(*ConfigAuditDetail)
+	assert.Len(t, detail.Features, 5)
+	assert.Equal(t, "inventory", detail.Features[1].Feature)
+	assert.InDelta(t, 2*30*0.0025, detail.Features[1].MonthlyCost, 1e-9)
+	assert.InDelta(t, 2*0.10, detail.Features[2].MonthlyCost, 1e-9)
+	assert.InDelta(t, 16*0.30, detail.Features[3].MonthlyCost, 1e-9)
+	assert.InDelta(t, 10*0.023, detail.Features[4].MonthlyCost, 1e-9, "access logging is priced by the logs stored")
+	assert.Nil(t, detail.Features[1].Consumed)
+	assert.Equal(t, 0.0, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin)
+	assert.InDelta(t, 0.15+0.2+4.8+0.23, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9)
+
+	//Logs of a few hours can't tell if daily reports are read
+	now := time.Now()
+	tracker := access.NewTracker("server_access", 1)
+	tracker.Record(access.Event{Bucket: "logs", Key: "bucket/2023-06-01", Time: now, Operation: access.Read})
+	tracker.Record(access.Event{Bucket: "reports", Key: "inventory/manifest.json", Time: now, Operation: access.Write})
+	tracker.Observe("reports", now.Add(-time.Hour))
+	objectScan = auditBucketFeatures(features, 2000000, tracker)
+	detail = objectScan.Details.(*ConfigAuditDetail)
+	assert.Nil(t, detail.Features[1].Consumed)
+	assert.Nil(t, detail.Features[4].Consumed)
+	assert.Equal(t, 0.0, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin)
+
+	//Nobody reads the inventory reports over two days, the server logs are read
+	tracker.Observe("reports", now.AddDate(0, 0, -3))
+	tracker.Observe("logs", now.AddDate(0, 0, -15))
+	objectScan = auditBucketFeatures(features, 2000000, tracker)
+	detail = objectScan.Details.(*ConfigAuditDetail)
+	assert.False(t, *detail.Features[1].Consumed)
+	assert.True(t, *detail.Features[4].Consumed)
+	assert.InDelta(t, 0.15, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-9)
+
+	//Logs that expire are not a hidden cost
+	objectScan = auditBucketFeatures(bucketFeatures{Logging: features.Logging, SameRegionLogging: true, LoggingExpires: true}, 10, nil)
+	assert.Nil(t, objectScan.Details)
+}
+
+// Test the restored objects scanner
+func TestRestoredObjectScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	restores := map[string]string{
+		"restored": `ongoing-request="false", expiry-date="Sun, 11 Jun 2023 00:00:00 GMT"`,
+		"ongoing":  `ongoing-request="true"`,
+		"expired":  `ongoing-request="false", expiry-date="Mon, 01 May 2023 00:00:00 GMT"`,
+		"archived": "",
+	}
+	fetch := func(key string) (string, bool, error) {
+		restore, ok := restores[key]
+		return restore, ok, nil
+	}
+	page := &s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("restored", 1e9, "etag", "GLACIER"),
+		testObject("ongoing", 1e9, "etag", "DEEP_ARCHIVE"),
+		testObject("expired", 1e9, "etag", "GLACIER"),
+		testObject("archived", 1e9, "etag", "GLACIER"),
+		testObject("standard", 1e9, "etag", "STANDARD"),
+	}}
+
+	ctx := ScanContext{Options: ScanOptions{RestoreSampling: true}}
+	scanner := newRestoredObjectScanner(ctx, fetch, now)
+	assert.NoError(t, scanner.Consume(page))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+
+	detail := objectScan.Details.(*RestoredObjectDetail)
+	assert.Equal(t, int64(4), detail.ArchivedObjects)
+	assert.Equal(t, int64(4), detail.SampledObjects)
+	assert.Equal(t, int64(1), detail.RestoredSamples)
+	assert.Equal(t, int64(1), detail.OngoingRestores)
+	assert.InDelta(t, 0.25, detail.RestoredFraction, 1e-9)
+	assert.InDelta(t, 10, detail.AverageDaysLeft, 1e-9)
+	assert.Equal(t, int64(1), objectScan.ObjectCount)
+	assert.Equal(t, int64(1e9), objectScan.DataSize)
+
+	//10 days of a 1 GB STANDARD copy, 3 of them after the copy is used
+	assert.InDelta(t, 0.023*10/30, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-6)
+	assert.InDelta(t, 0.023*3/30, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-6)
+
+	_, restored := restoreExpiry(restores["ongoing"])
+	assert.False(t, restored)
+
+	//Errors other than a missing object stop the scan
+	denied := func(key string) (string, bool, error) {
+		return "", false, errors.New("AccessDenied")
+	}
+	scanner = newRestoredObjectScanner(ctx, denied, now)
+	assert.NoError(t, scanner.Consume(page))
+	_, err = scanner.Finalize()
+	assert.Error(t, err)
+
+	//Sampling is opt-in, without it no object is read
+	scanner = newRestoredObjectScanner(ScanContext{}, denied, now)
+	assert.NoError(t, scanner.Consume(page))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail = objectScan.Details.(*RestoredObjectDetail)
+	assert.Equal(t, int64(4), detail.ArchivedObjects)
+	assert.Equal(t, int64(0), detail.SampledObjects)
+	assert.Equal(t, 0.0, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax)
+}
+
+// Test the small archive objects scanner
+func TestSmallArchiveScanner(t *testing.T) {
+	page := &s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("tiny", 1024, "etag", "GLACIER"),
+		testObject("large", 1e9, "etag", "GLACIER"),
+		testObject("tiny-deep", 1024, "etag", "DEEP_ARCHIVE"),
+		testObject("tiny-standard", 1024, "etag", "STANDARD"),
+	}}
+
+	scanner := newSmallArchiveScanner(policy.Default(), time.Now())
+	assert.NoError(t, scanner.Consume(page))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(2), objectScan.ObjectCount)
+	assert.Equal(t, int64(2048), objectScan.DataSize)
+
+	detail := objectScan.Details.(*SmallArchiveDetail)
+	glacier := detail.StorageClasses["GLACIER"]
+	assert.Equal(t, int64(2), glacier.ObjectCount)
+	assert.Equal(t, int64(1), glacier.SmallObjectCount)
+	overhead, _ := estimate.ArchiveOverheadCost(1, "GLACIER")
+	assert.InDelta(t, overhead, glacier.SmallOverheadCost, 1e-12)
+	assert.InDelta(t, 2*overhead, glacier.OverheadCost, 1e-12)
+
+	//Restoring and copying one 1 KB object costs more than a year of its overhead
+	assert.Equal(t, 0.0, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin)
+	assert.Greater(t, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 0.0)
+
+	//Moves that pay back within the policy's break-even months count towards min savings
+	p := policy.Default()
+	p.MaxBreakEvenMonths = 120
+	scanner = newSmallArchiveScanner(p, time.Now())
+	assert.NoError(t, scanner.Consume(page))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	restore, _ := estimate.EstimateTransition(1, 1024, "GLACIER", "STANDARD", p.RetrievalRate, 0)
+	assert.True(t, restore.BreaksEvenWithin(120))
+	assert.Greater(t, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, restore.MonthlyDelta)
+	assert.Greater(t, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin)
+
+	//Objects still inside GLACIER's 90 day minimum pay the rest of it when moved, which pushes the break-even past 18 months
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	p.MaxBreakEvenMonths = 18
+	archived := func(days int) *s3.ListObjectsV2Output {
+		object := testObject("tiny", 1024, "etag", "GLACIER")
+		object.LastModified = aws.Time(now.AddDate(0, 0, -days))
+		return &s3.ListObjectsV2Output{Contents: []*s3.Object{object}}
+	}
+	scanner = newSmallArchiveScanner(p, now)
+	assert.NoError(t, scanner.Consume(archived(100)))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.InDelta(t, restore.MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-15)
+	assert.Equal(t, 0.0, objectScan.Details.(*SmallArchiveDetail).StorageClasses["GLACIER"].EarlyDeletionCost)
+
+	scanner = newSmallArchiveScanner(p, now)
+	assert.NoError(t, scanner.Consume(archived(10)))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	charge, _ := estimate.EarlyDeletionCost(1, 1024, "GLACIER", 10)
+	assert.InDelta(t, charge, objectScan.Details.(*SmallArchiveDetail).StorageClasses["GLACIER"].EarlyDeletionCost, 1e-15)
+	assert.Equal(t, 0.0, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin)
+}
+
+// Test the abandoned bucket scanner
+func TestAbandonedBucketScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	noVersions := func(tally *versionTally) error { return nil }
+	old := testObject("old", 1e9, "etag", "STANDARD")
+	old.LastModified = aws.Time(now.AddDate(-1, 0, 0))
+	recent := testObject("recent", 1e9, "etag", "STANDARD")
+	recent.LastModified = aws.Time(now.AddDate(0, 0, -1))
+
+	//Empty bucket
+	ctx := ScanContext{BucketScan: BucketScan{VersioningStatus: "Not Enabled"}}
+	scanner := newAbandonedBucketScanner(ctx, noVersions, now)
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{BucketEmpty}, objectScan.Details.(*AbandonedBucketDetail).Classifications)
+
+	//No writes in 6 months
+	scanner = newAbandonedBucketScanner(ctx, noVersions, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{old}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail := objectScan.Details.(*AbandonedBucketDetail)
+	assert.Equal(t, []string{BucketAbandoned}, detail.Classifications)
+	assert.InDelta(t, 0.023, detail.MonthlyCost, 1e-9)
+	archive, _ := estimate.EstimateTransition(1, 1e9, "STANDARD", "DEEP_ARCHIVE", policy.Default().RetrievalRate, 0)
+	assert.InDelta(t, archive.MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-12)
+	assert.InDelta(t, 0.023, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9)
+
+	//Recent reads in access logs keep it in use
+	ctx.Access = &access.AccessDetail{WindowStart: now.AddDate(-1, 0, 0), WindowEnd: now, LastAccessAt: now.AddDate(0, 0, -2)}
+	scanner = newAbandonedBucketScanner(ctx, noVersions, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{old}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Nil(t, objectScan.Details)
+
+	//Only noncurrent versions and delete markers left
+	ctx = ScanContext{BucketScan: BucketScan{VersioningStatus: "Enabled"}}
+	versions := func(tally *versionTally) error {
+		tally.addPage(&s3.ListObjectVersionsOutput{
+			Versions:      []*s3.ObjectVersion{{Key: aws.String("a"), Size: aws.Int64(1e9), LastModified: aws.Time(now.AddDate(0, -2, 0)), IsLatest: aws.Bool(false)}},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{{Key: aws.String("a"), LastModified: aws.Time(now.AddDate(0, -1, 0)), IsLatest: aws.Bool(true)}},
+		})
+		tally.finish()
+		return nil
+	}
+	scanner = newAbandonedBucketScanner(ctx, versions, now)
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail = objectScan.Details.(*AbandonedBucketDetail)
+	assert.Equal(t, []string{BucketAbandoned}, detail.Classifications)
+	assert.Equal(t, int64(1), detail.NoncurrentVersionCount)
+	assert.Equal(t, int64(1), detail.DeleteMarkerCount)
+	assert.Equal(t, now.AddDate(0, -1, 0), detail.LastWriteAt)
+	assert.Equal(t, int64(1e9), objectScan.DataSize)
+
+	//Active bucket of a decommissioned owner
+	ctx = ScanContext{
+		BucketScan: BucketScan{VersioningStatus: "Not Enabled", Tags: map[string]string{"team": "Legacy-Search", "env": "prod"}},
+		Options:    ScanOptions{DecommissionedOwners: []string{"legacy-search"}},
+	}
+	scanner = newAbandonedBucketScanner(ctx, noVersions, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{recent}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail = objectScan.Details.(*AbandonedBucketDetail)
+	assert.Equal(t, []string{BucketOrphaned}, detail.Classifications)
+	assert.Equal(t, map[string]string{"team": "Legacy-Search"}, detail.OrphanedTags)
+
+	//Only owner tags count, in the chargeback tag keys when they are set
+	ctx.BucketScan.Tags = map[string]string{"project": "legacy-search", "team": "search"}
+	scanner = newAbandonedBucketScanner(ctx, noVersions, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{recent}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Nil(t, objectScan.Details, "an active bucket with no orphaned owner tag isn't reported")
+
+	ctx.Options.ChargebackTagKeys = []string{"project"}
+	scanner = newAbandonedBucketScanner(ctx, noVersions, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{recent}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"project": "legacy-search"}, objectScan.Details.(*AbandonedBucketDetail).OrphanedTags)
+
+	//Deleting the bucket can't save what Object Lock retains
+	ctx = ScanContext{BucketScan: BucketScan{VersioningStatus: "Not Enabled"}}
+	scanner = newAbandonedBucketScanner(ctx, noVersions, now)
+	scanner.locks = newLockCheckerWithLookup(ObjectLockDetail{Enabled: true}, func(key string, versionID string) (objectLock, error) {
+		return objectLock{LegalHold: key == "old"}, nil
+	}, 10, now)
+	other := testObject("other", 1e9, "etag", "STANDARD")
+	other.LastModified = old.LastModified
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{old, other}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.046, objectScan.Details.(*AbandonedBucketDetail).MonthlyCost, 1e-9)
+	archive, _ = estimate.EstimateTransition(1, 1e9, "STANDARD", "DEEP_ARCHIVE", policy.Default().RetrievalRate, 0)
+	assert.InDelta(t, 0.023+archive.MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9, "the locked object can only be archived")
+	assert.Equal(t, int64(1), objectScan.ObjectLock.LockedObjectCount)
+}
+
+// Test the small files scanner
+func TestSmallFileScanner(t *testing.T) {
+	objects := []*s3.Object{}
+	for i := 0; i < 2000; i++ {
+		objects = append(objects, testObject(fmt.Sprintf("logs/2023/%d.log", i), 60000, "etag", "STANDARD"))
+	}
+	//Too few small objects
+	for i := 0; i < 10; i++ {
+		objects = append(objects, testObject(fmt.Sprintf("tmp/x/%d", i), 1024, "etag", "STANDARD"))
+	}
+	//Mostly large objects
+	for i := 0; i < 2000; i++ {
+		size := int64(1024)
+		if i%4 != 0 {
+			size = 1e6
+		}
+		objects = append(objects, testObject(fmt.Sprintf("data/parquet/%d", i), size, "etag", "STANDARD"))
+	}
+
+	scanner := newSmallFileScanner(0, policy.Default())
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: objects}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+
+	detail := objectScan.Details.(*SmallFileDetail)
+	assert.Len(t, detail.Prefixes, 1)
+	p := detail.Prefixes[0]
+	assert.Equal(t, "logs/2023/", p.Prefix)
+	assert.Equal(t, int64(2000), p.SmallObjectCount)
+	assert.Equal(t, int64(2000*60000), p.TargetObjectSize, "less small data than the target size is compacted into one object")
+	assert.Equal(t, int64(1), p.CompactedObjectCount)
+	assert.Len(t, p.Transitions, 4)
+
+	//As-is, every object is billed as 128 KB in STANDARD_IA
+	ia := p.Transitions[0]
+	assert.Equal(t, "STANDARD_IA", ia.StorageClass)
+	assert.InDelta(t, float64(2000*128*1024)/1e9*0.0125, ia.AsIsMonthlyCost, 1e-12)
+	assert.InDelta(t, float64(2000*60000)/1e9*0.0125, ia.CompactedMonthlyCost, 1e-12)
+	assert.InDelta(t, 0.02, ia.AsIsTransitionCost, 1e-12)
+	assert.False(t, ia.AsIs.BreaksEven, "as-is, the small objects cost more in STANDARD_IA than in STANDARD")
+	assert.InDelta(t, 0.00001+p.CompactionCost, ia.Compacted.UpfrontCost, 1e-12)
+	assert.InDelta(t, float64(2000*60000)/1e9*(0.023-0.0125-0.01*0.01), ia.Compacted.MonthlyDelta, 1e-12)
+
+	//Savings are against the STANDARD cost the objects have now, not against leaving them as-is in the target class
+	assert.InDelta(t, ia.Compacted.MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-12)
+	maxSavings := 0.0
+	for _, transition := range p.Transitions {
+		if transition.Compacted.BreaksEvenWithin(policy.Default().MaxBreakEvenMonths) {
+			maxSavings = math.Max(maxSavings, transition.Compacted.MonthlyDelta)
+		}
+	}
+	assert.InDelta(t, maxSavings, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-12)
+
+	//Too little data to pay back compaction
+	scanner = newSmallFileScanner(0, policy.Default())
+	tiny := []*s3.Object{}
+	for i := 0; i < 2000; i++ {
+		tiny = append(tiny, testObject(fmt.Sprintf("logs/%d.log", i), 100, "etag", "STANDARD"))
+	}
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: tiny}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax)
+	assert.GreaterOrEqual(t, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin)
+}
+
+// Test parsePartition
+func TestParsePartition(t *testing.T) {
+	partition, ok := parsePartition("events/dt=2022-01-05/part-0001.parquet")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2022, 1, 5, 0, 0, 0, 0, time.UTC), partition.date)
+	assert.Equal(t, "events/", partition.prefix)
+	assert.Equal(t, "events/dt=2022-", partition.yearPrefix)
+	assert.Equal(t, "key=YYYY-MM-DD", partition.layout)
+
+	partition, ok = parsePartition("logs/2021/11/03/app.log")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 11, 3, 0, 0, 0, 0, time.UTC), partition.date)
+	assert.Equal(t, "logs/", partition.prefix)
+	assert.Equal(t, "logs/2021/", partition.yearPrefix)
+
+	partition, ok = parsePartition("year=2020/month=7/data.csv")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC), partition.date)
+	assert.Equal(t, "", partition.prefix)
+
+	partition, ok = parsePartition("tables/orders/event_date=20230102/part.parquet")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), partition.date)
+	assert.Equal(t, "tables/orders/event_date=2023", partition.yearPrefix)
+
+	_, ok = parsePartition("images/2021/13/40/cat.jpg")
+	assert.False(t, ok, "not a valid date")
+	_, ok = parsePartition("images/cat.jpg")
+	assert.False(t, ok)
+}
+
+// Test the date partitions scanner
+func TestDatePartitionScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	//One 2021 partition was rewritten last week, 2023 partitions are recent
+	rewritten := testObject("events/dt=2021-03-01/part-0.parquet", 1e9, "etag", "STANDARD")
+	rewritten.LastModified = aws.Time(now.AddDate(0, 0, -7))
+	untouched := testObject("events/dt=2021-04-01/part-0.parquet", 1e9, "etag", "STANDARD")
+	untouched.LastModified = aws.Time(time.Date(2021, 4, 2, 0, 0, 0, 0, time.UTC))
+	recent := testObject("events/dt=2023-05-30/part-0.parquet", 1e9, "etag", "STANDARD")
+	recent.LastModified = aws.Time(now.AddDate(0, 0, -2))
+	other := testObject("readme.txt", 1e6, "etag", "STANDARD")
+	other.LastModified = aws.Time(now)
+	archived := testObject("events/dt=2020-01-01/part-0.parquet", 1e9, "etag", "GLACIER")
+	archived.LastModified = aws.Time(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+
+	scanner := newDatePartitionScanner(nil, policy.Default(), now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{rewritten, untouched, recent, other, archived}}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+
+	detail := objectScan.Details.(*DatePartitionDetail)
+	assert.True(t, detail.CoversBucket())
+	assert.Equal(t, time.Date(2023, 5, 30, 0, 0, 0, 0, time.UTC), detail.NewestPartition)
+	assert.Len(t, detail.Datasets, 1)
+	assert.Equal(t, int64(1), detail.Datasets[0].RewrittenObjectCount)
+	assert.Equal(t, int64(3e9), detail.Datasets[0].AgeBands[3].DataSize)
+	//The ongoing rule is priced for the warm data only, archived partitions have nowhere colder to go
+	assert.Equal(t, int64(4e9), detail.Datasets[0].DataSize)
+	assert.Equal(t, int64(3e9), detail.Datasets[0].WarmSize)
+
+	assert.Equal(t, []ProposedRule{
+		{
+			Prefix:       "events/",
+			Action:       "Transition",
+			StorageClass: "GLACIER",
+			Days:         90,
+			ObjectCount:  3,
+			DataSize:     3e9,
+			Reason:       detail.ProposedRules[0].Reason,
+
+			TransitionEstimate: detail.ProposedRules[0].TransitionEstimate,
+		},
+		{
+			Prefix:       "events/dt=2021-",
+			Action:       "Transition",
+			StorageClass: "GLACIER",
+			Days:         0,
+			ObjectCount:  2,
+			DataSize:     2e9,
+			Reason:       detail.ProposedRules[1].Reason,
+
+			TransitionEstimate: detail.ProposedRules[1].TransitionEstimate,
+		},
+	}, detail.ProposedRules)
+	//The one-time rule moves every 2021 partition, not only the rewritten one
+	glacierCost, _ := estimate.StorageCostForObjects(1, 1e9, "GLACIER")
+	assert.InDelta(t, 2*0.00003, detail.ProposedRules[1].UpfrontCost, 1e-12)
+	assert.InDelta(t, 2*(0.023-glacierCost-0.01*0.01), detail.ProposedRules[1].MonthlyDelta, 1e-12)
+	assert.True(t, detail.ProposedRules[1].BreaksEven)
+	assert.Contains(t, detail.ProposedRules[0].Reason, "LastModified")
+
+	//Savings only come from the proposed rules, the one-time rule's data is part of the ongoing rule's
+	assert.Equal(t, int64(2), objectScan.ObjectCount)
+	assert.InDelta(t, detail.ProposedRules[0].MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-12)
+	assert.InDelta(t, detail.ProposedRules[1].MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-12)
+
+	//No ongoing rule for datasets a lifecycle rule already transitions
+	rules := []*s3.LifecycleRule{{
+		Status:      aws.String("Enabled"),
+		Filter:      &s3.LifecycleRuleFilter{Prefix: aws.String("events/")},
+		Transitions: []*s3.Transition{{Days: aws.Int64(90), StorageClass: aws.String("GLACIER")}},
+	}}
+	scanner = newDatePartitionScanner(rules, policy.Default(), now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{rewritten, recent}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	detail = objectScan.Details.(*DatePartitionDetail)
+	assert.Len(t, detail.ProposedRules, 1)
+	assert.Equal(t, "events/dt=2021-", detail.ProposedRules[0].Prefix)
+	assert.InDelta(t, detail.ProposedRules[0].MonthlyDelta, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-12)
+
+	//Rules that don't break even in time add no savings
+	p := policy.Default()
+	p.MaxBreakEvenMonths = 0
+	scanner = newDatePartitionScanner(nil, p, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{rewritten, recent}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Empty(t, objectScan.Details.(*DatePartitionDetail).ProposedRules)
+	assert.Equal(t, estimate.EstimatedSavings{}, objectScan.EstimatedSavings)
+}
+
+// Test the workloads scan labels the bucket and its prefixes
+func TestWorkloadScanner(t *testing.T) {
+	scanner := newWorkloadScanner("query-output")
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("results/0f8fad5b-d9cb-469f-a165-70867728950e.csv", 100, "etag", "STANDARD"),
+		testObject("results/0f8fad5b-d9cb-469f-a165-70867728950e.csv.metadata", 10, "etag", "STANDARD"),
+		testObject("notes.txt", 5, "etag", "STANDARD"),
+	}}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), objectScan.ObjectCount)
+	assert.Equal(t, int64(110), objectScan.DataSize)
+
+	detail := objectScan.Details.(*WorkloadDetail)
+	assert.Equal(t, "athena_results", detail.Workload)
+	assert.Len(t, detail.Prefixes, 1)
+	assert.Equal(t, "results/", detail.Prefixes[0].Prefix)
+
+	name, ok := scannedWorkload([]ObjectScan{{DataCategory: "compressed"}, objectScan})
+	assert.True(t, ok)
+	assert.Equal(t, "athena_results", name)
+	_, ok = scannedWorkload(nil)
+	assert.False(t, ok)
+}
+
+// Test the compressible_objects scan uses the extension tables of the policy
+func TestUncompressedObjectsScannerPolicy(t *testing.T) {
+	p := policy.Default().Merge(policy.Policy{CompressibleExtensions: map[string]string{".ndjson": ".gzip", ".csv": ""}})
+	opts := ScanOptions{Policy: &p}
+	assert.NoError(t, opts.Validate())
+
+	scanner := &uncompressedObjectsScanner{policy: opts.EffectivePolicy()}
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		testObject("events/a.ndjson", 1000, "etag", "STANDARD"),
+		testObject("events/b.csv", 1000, "etag", "STANDARD"),
+		testObject("events/c.log.gz", 1000, "etag", "STANDARD"),
+	}}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), objectScan.ObjectCount)
+
+	invalid := policy.Policy{InactiveMonths: -1}
+	assert.Error(t, ScanOptions{Policy: &invalid}.Validate())
+
+	//Tag samples are capped, each one is a request
+	assert.NoError(t, ScanOptions{ChargebackTagSamples: maxChargebackTagSamples}.Validate())
+	assert.Error(t, ScanOptions{ChargebackTagSamples: maxChargebackTagSamples + 1}.Validate())
+}
+
+// Test the prefix_ages scan aggregates prefixes by storage class and age
+func TestPrefixAgeScanner(t *testing.T) {
+	now := time.Now()
+	old := testObject("events/2020/a.json", 300, "etag", "STANDARD")
+	old.LastModified = aws.Time(now.AddDate(0, 0, -200))
+	recent := testObject("events/2023/b.json", 100, "etag", "STANDARD")
+	recent.LastModified = aws.Time(now.AddDate(0, 0, -10))
+	archived := testObject("events/2019/c.json", 50, "etag", "GLACIER")
+	archived.LastModified = aws.Time(now.AddDate(0, 0, -400))
+	root := testObject("readme.txt", 5, "etag", "STANDARD")
+	root.LastModified = aws.Time(now)
+
+	scanner := newPrefixAgeScanner(0, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{old, recent, archived, root}}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), objectScan.ObjectCount)
+
+	detail := objectScan.Details.(*PrefixAgeDetail)
+	assert.Len(t, detail.Prefixes, 2)
+	events := detail.Prefixes[0]
+	assert.Equal(t, "events/", events.Prefix)
+	assert.Equal(t, int64(400), events.ClassSize("STANDARD"))
+	assert.Equal(t, int64(450), events.ClassSize(""))
+	assert.Equal(t, int64(1), events.ClassObjects("GLACIER"))
+	assert.Equal(t, int64(300), events.SizeOlderThan("STANDARD", 180))
+	assert.Equal(t, int64(350), events.SizeOlderThan("", 180))
+	assert.Equal(t, int64(455), detail.Bucket.DataSize)
+}
+
+// Test archiveTieringScanner prices each object in the colder classes it is old enough for
+func TestArchiveTieringScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	aged := func(object *s3.Object, days int) *s3.Object {
+		object.LastModified = aws.Time(now.AddDate(0, 0, -days))
+		return object
+	}
+	bucketScan := BucketScan{
+		LifecycleDetail: LifecycleDetail{Rules: []*s3.LifecycleRule{{
+			Status:      aws.String("Enabled"),
+			Filter:      &s3.LifecycleRuleFilter{Prefix: aws.String("managed/")},
+			Transitions: []*s3.Transition{{Days: aws.Int64(30), StorageClass: aws.String("GLACIER")}},
+		}, {
+			Status:     aws.String("Enabled"),
+			Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("expiring/")},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(400)},
+		}}},
+	}
+	//Old by its last modified date, but its prefix was read yesterday
+	accessDetail := &access.AccessDetail{Prefixes: []access.PrefixAccess{{Prefix: "hot/", LastReadAt: now.AddDate(0, 0, -1)}}}
+
+	scanner := newArchiveTieringScanner(bucketScan, accessDetail, policy.Default(), now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("recent.csv", 1e9, "etag", "STANDARD"), 40),
+		aged(testObject("old.csv", 1e9, "etag", "STANDARD"), 400),
+		aged(testObject("tiny.csv", 1024, "etag", "STANDARD"), 400),
+		aged(testObject("archived.csv", 1e9, "etag", "GLACIER"), 400),
+		aged(testObject("hot/data.csv", 1e9, "etag", "STANDARD"), 400),
+		aged(testObject("managed/data.csv", 1e9, "etag", "STANDARD"), 400),
+		aged(testObject("tiered.csv", 1e9, "etag", "INTELLIGENT_TIERING"), 400),
+		aged(testObject("expiring/data.csv", 1e9, "etag", "STANDARD"), 400),
+	}}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(3), objectScan.ObjectCount, "recent, old and archived save in a colder class")
+	detail := objectScan.Details.(*ArchiveTieringDetail)
+	assert.Equal(t, int64(1), detail.TransitionedObjectCount)
+
+	//Savings are the monthly delta after reading back 1% of the data each month
+	standardOverhead, glacierOverhead, deepOverhead := float64(8*1024)/1e9*0.023, float64(32*1024)/1e9*0.004, float64(32*1024)/1e9*0.00099
+	recentIA := 0.023 - 0.0125 - 0.01*0.01
+	oldIA := recentIA
+	oldDeep := 0.023 - 0.00099 - standardOverhead - deepOverhead - 0.01*0.02
+	archivedDeep := 0.004 - 0.00099 + glacierOverhead - deepOverhead - 0.01*0.02
+	assert.InDelta(t, recentIA+oldIA+archivedDeep, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-9)
+	assert.InDelta(t, recentIA+oldDeep+archivedDeep, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-9)
+
+	assert.Len(t, detail.Targets, 2)
+	assert.Equal(t, "STANDARD_IA", detail.Targets[0].StorageClass)
+	assert.Equal(t, "DEEP_ARCHIVE", detail.Targets[1].StorageClass)
+	assert.Equal(t, int64(2), detail.Targets[1].ObjectCount)
+	assert.InDelta(t, 2*0.00005, detail.Targets[1].UpfrontCost, 1e-12)
+	assert.InDelta(t, detail.Targets[1].UpfrontCost/detail.Targets[1].MonthlyDelta, detail.Targets[1].BreakEvenMonths, 1e-12)
+
+	//Cohorts are ordered from the warmest class and youngest band
+	assert.Len(t, detail.Cohorts, 4)
+	assert.Equal(t, "0-30 days", detail.Cohorts[0].AgeBand, "hot is aged by its last read")
+	assert.Equal(t, "30-90 days", detail.Cohorts[1].AgeBand)
+	assert.Equal(t, "365+ days", detail.Cohorts[2].AgeBand)
+	assert.Equal(t, int64(4), detail.Cohorts[2].ObjectCount)
+	assert.Equal(t, int64(1e9), detail.Cohorts[2].EligibleBytes["DEEP_ARCHIVE"], "tiny saves nowhere, managed is already transitioned and expiring is deleted today")
+
+	//Moves that don't pay back their transition fees in time are left out
+	strict := policy.Default()
+	strict.MaxBreakEvenMonths = 1
+	scanner = newArchiveTieringScanner(BucketScan{}, nil, strict, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("small.csv", 200*1024, "etag", "STANDARD"), 400),
+	}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), objectScan.ObjectCount)
+	assert.Equal(t, "GLACIER", detail.Cohorts[3].StorageClass)
+}
+
+// Test earlyDeletionScanner finds rules that end a stay before the storage class's minimum duration
+func TestEarlyDeletionScanner(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	aged := func(object *s3.Object, days int) *s3.Object {
+		object.LastModified = aws.Time(now.AddDate(0, 0, -days))
+		return object
+	}
+	rules := []*s3.LifecycleRule{{
+		ID:         aws.String("expire-archive"),
+		Status:     aws.String("Enabled"),
+		Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("archive/")},
+		Expiration: &s3.LifecycleExpiration{Days: aws.Int64(30)},
+	}, {
+		ID:     aws.String("tier-logs"),
+		Status: aws.String("Enabled"),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+		Transitions: []*s3.Transition{
+			{Days: aws.Int64(30), StorageClass: aws.String("STANDARD_IA")},
+			{Days: aws.Int64(45), StorageClass: aws.String("GLACIER")},
+		},
+		Expiration: &s3.LifecycleExpiration{Days: aws.Int64(100)},
+	}}
+
+	scanner := newEarlyDeletionScanner(rules, now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("archive/a", 1e9, "etag", "GLACIER"), 10),
+		aged(testObject("logs/new", 1e9, "etag", "STANDARD"), 5),
+		aged(testObject("logs/old", 1e9, "etag", "STANDARD_IA"), 35),
+		aged(testObject("data/x", 1e9, "etag", "GLACIER"), 5),
+		aged(testObject("archive/b", 1e9, "etag", "STANDARD"), 10),
+	}}))
+	objectScan, err := scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), objectScan.ObjectCount, "STANDARD has no minimum duration and data/ has no rule")
+
+	glacierCost, _ := estimate.StorageCostForObjects(1, 1e9, "GLACIER")
+	iaCost, _ := estimate.StorageCostForObjects(1, 1e9, "STANDARD_IA")
+	detail := objectScan.Details.(*EarlyDeletionDetail)
+	assert.Len(t, detail.Findings, 3)
+	assert.Equal(t, EarlyDeletionFinding{
+		RuleID:        "tier-logs",
+		Action:        "Transition to GLACIER",
+		Days:          45,
+		StorageClass:  "STANDARD_IA",
+		EnteredDays:   30,
+		MinimumDays:   30,
+		SuggestedDays: 60,
+		ObjectCount:   2,
+		DataSize:      2e9,
+		Penalty:       detail.Findings[0].Penalty,
+	}, detail.Findings[0])
+	assert.InDelta(t, 2*iaCost*15/30, detail.Findings[0].Penalty, 1e-12)
+
+	assert.Equal(t, "Expiration", detail.Findings[1].Action)
+	assert.Equal(t, "GLACIER", detail.Findings[1].StorageClass)
+	assert.Equal(t, int64(45), detail.Findings[1].EnteredDays)
+	assert.Equal(t, int64(135), detail.Findings[1].SuggestedDays)
+	assert.InDelta(t, 2*glacierCost*35/30, detail.Findings[1].Penalty, 1e-12)
+
+	assert.Equal(t, "expire-archive", detail.Findings[2].RuleID)
+	assert.Equal(t, int64(90), detail.Findings[2].SuggestedDays, "written directly to GLACIER")
+	assert.InDelta(t, glacierCost*60/30, detail.Findings[2].Penalty, 1e-12)
+
+	//Only the archive expiration, in 20 days, and the old log's transition, in 10, are due within 30 days
+	assert.InDelta(t, glacierCost*2+iaCost/2, objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin, 1e-12)
+	assert.InDelta(t, glacierCost*2+iaCost/2, objectScan.EstimatedSavings.CalculatedMonthlySavingsMax, 1e-12, "one-time charges are not monthly savings")
+
+	//An expiration whose day has passed is still charged but isn't due
+	scanner = newEarlyDeletionScanner(rules[:1], now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("archive/c", 1e9, "etag", "GLACIER"), 40),
+	}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Len(t, objectScan.Details.(*EarlyDeletionDetail).Findings, 1)
+	assert.Equal(t, estimate.EstimatedSavings{}, objectScan.EstimatedSavings)
+
+	//Rules that respect the minimum durations find nothing
+	scanner = newEarlyDeletionScanner(rules[1:], now)
+	assert.NoError(t, scanner.Consume(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		aged(testObject("other/x", 1e9, "etag", "GLACIER"), 5),
+	}}))
+	objectScan, err = scanner.Finalize()
+	assert.NoError(t, err)
+	assert.Nil(t, objectScan.Details)
+}
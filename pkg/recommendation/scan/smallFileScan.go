@@ -0,0 +1,202 @@
+package scan
+
+//This file scans for prefixes dominated by small objects, ex. log and event buckets
+//Small objects are billed at the minimum billable size of IA classes and pay transition fees and archive overhead per object
+
+import (
+	"math"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/access"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/policy"
+)
+
+// Defaults for small-file consolidation
+const (
+	smallFileSize               = 128 * 1024        //the minimum billable size of the IA classes
+	smallFileDominance          = 0.5               //share of a prefix's objects that have to be small
+	smallFileMinObjects         = 1000              //small objects a prefix needs before compaction is worth it
+	smallFileTargetSize         = 128 * 1024 * 1024 //recommended size of compacted objects
+	defaultSmallFilePrefixDepth = 2
+	maxSmallFilePrefixes        = 100 //prefixes reported, by number of small objects
+)
+
+// The storage classes small objects are priced in, as-is and after compaction
+var smallFileTransitionClasses = []string{"STANDARD_IA", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"}
+
+// SmallFileDetail is the ObjectScan.Details of the small_files scan
+// ObjectCount and DataSize of the ObjectScan are the small objects in the reported prefixes
+type SmallFileDetail struct {
+	SmallObjectSize int64             `json:"small_object_size"` //objects smaller than this are small
+	Prefixes        []SmallFilePrefix `json:"prefixes"`
+}
+
+// SmallFilePrefix contains the small objects of one prefix and what they cost to transition as-is and compacted
+type SmallFilePrefix struct {
+	Prefix               string              `json:"prefix"`
+	ObjectCount          int64               `json:"object_count"`
+	DataSize             int64               `json:"data_size"`
+	SmallObjectCount     int64               `json:"small_object_count"`
+	SmallDataSize        int64               `json:"small_data_size"`
+	TargetObjectSize     int64               `json:"target_object_size"` //recommended size of the compacted objects
+	CompactedObjectCount int64               `json:"compacted_object_count"`
+	CompactionCost       float64             `json:"compaction_cost"` //one-time cost of reading the small objects and writing the compacted ones
+	Transitions          []ConsolidationCost `json:"transitions"`
+}
+
+// ConsolidationCost compares transitioning small objects as-is to transitioning them after compaction
+type ConsolidationCost struct {
+	StorageClass            string  `json:"storage_class"`
+	AsIsTransitionCost      float64 `json:"as_is_transition_cost"` //one-time
+	AsIsMonthlyCost         float64 `json:"as_is_monthly_cost"`
+	CompactedTransitionCost float64 `json:"compacted_transition_cost"` //one-time, compaction included
+	CompactedMonthlyCost    float64 `json:"compacted_monthly_cost"`
+
+	//Cost-benefit of moving the small objects out of STANDARD as-is and compacted
+	AsIs      estimate.TransitionEstimate `json:"as_is"`
+	Compacted estimate.TransitionEstimate `json:"compacted"`
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "small_files",
+		Permissions: []string{"s3:ListBucket"},
+		New: func(ctx ScanContext) Scanner {
+			return newSmallFileScanner(ctx.Options.SmallFilePrefixDepth, ctx.Options.EffectivePolicy())
+		},
+	})
+}
+
+// Scanner for prefixes dominated by small objects
+type smallFileScanner struct {
+	depth    int
+	policy   policy.Policy
+	prefixes map[string]*SmallFilePrefix
+}
+
+func newSmallFileScanner(depth int, p policy.Policy) *smallFileScanner {
+	if depth == 0 {
+		depth = defaultSmallFilePrefixDepth
+	}
+	return &smallFileScanner{
+		depth:    depth,
+		policy:   p,
+		prefixes: make(map[string]*SmallFilePrefix),
+	}
+}
+
+func (s *smallFileScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		prefix := access.KeyPrefix(*object.Key, s.depth)
+		p, ok := s.prefixes[prefix]
+		if !ok {
+			p = &SmallFilePrefix{Prefix: prefix}
+			s.prefixes[prefix] = p
+		}
+		p.ObjectCount++
+		p.DataSize += *object.Size
+		if *object.Size < smallFileSize {
+			p.SmallObjectCount++
+			p.SmallDataSize += *object.Size
+		}
+	}
+	return nil
+}
+
+// Min savings are the monthly savings of compacting before a STANDARD_IA transition,
+// max savings the largest monthly savings of any storage class
+// Savings are the Compacted estimate's, against what the small objects cost in STANDARD now,
+// storage classes where it doesn't pay back compaction and transition in time save nothing
+func (s *smallFileScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "small_files"}
+	detail := &SmallFileDetail{SmallObjectSize: smallFileSize}
+
+	for _, p := range s.prefixes {
+		if p.SmallObjectCount < smallFileMinObjects || float64(p.SmallObjectCount) < smallFileDominance*float64(p.ObjectCount) {
+			continue
+		}
+		detail.Prefixes = append(detail.Prefixes, *p)
+	}
+	sort.Slice(detail.Prefixes, func(i, j int) bool {
+		if detail.Prefixes[i].SmallObjectCount != detail.Prefixes[j].SmallObjectCount {
+			return detail.Prefixes[i].SmallObjectCount > detail.Prefixes[j].SmallObjectCount
+		}
+		return detail.Prefixes[i].Prefix < detail.Prefixes[j].Prefix
+	})
+	if len(detail.Prefixes) > maxSmallFilePrefixes {
+		detail.Prefixes = detail.Prefixes[:maxSmallFilePrefixes]
+	}
+	if len(detail.Prefixes) == 0 {
+		return objectScan, nil
+	}
+
+	for i := range detail.Prefixes {
+		p := &detail.Prefixes[i]
+		if err := consolidate(p, s.policy.RetrievalRate); err != nil {
+			return objectScan, err
+		}
+
+		var maxSavings float64
+		for _, transition := range p.Transitions {
+			if !transition.Compacted.BreaksEvenWithin(s.policy.MaxBreakEvenMonths) {
+				continue
+			}
+			savings := math.Max(transition.Compacted.MonthlyDelta, 0)
+			if transition.StorageClass == "STANDARD_IA" {
+				objectScan.EstimatedSavings.CalculatedMonthlylSavingsMin += savings
+			}
+			maxSavings = math.Max(maxSavings, savings)
+		}
+		objectScan.EstimatedSavings.CalculatedMonthlySavingsMax += maxSavings
+		objectScan.ObjectCount += p.SmallObjectCount
+		objectScan.DataSize += p.SmallDataSize
+	}
+	objectScan.Details = detail
+	return objectScan, nil
+}
+
+// HELPER for Finalize()
+// Prices the small objects of a prefix in every transition class, as-is and compacted into objects of the target size
+// Prefixes with less small data than the target size are compacted into one object
+func consolidate(p *SmallFilePrefix, retrievalRate float64) error {
+	p.TargetObjectSize = smallFileTargetSize
+	if p.SmallDataSize < p.TargetObjectSize {
+		p.TargetObjectSize = p.SmallDataSize
+	}
+	p.CompactedObjectCount = 1
+	if p.TargetObjectSize > 0 {
+		p.CompactedObjectCount = int64(math.Ceil(float64(p.SmallDataSize) / float64(p.TargetObjectSize)))
+	}
+	p.CompactionCost = estimate.CostForCompaction(p.SmallObjectCount, p.CompactedObjectCount)
+
+	p.Transitions = []ConsolidationCost{}
+	for _, storageClass := range smallFileTransitionClasses {
+		cost := ConsolidationCost{StorageClass: storageClass}
+		var err error
+		if cost.AsIsTransitionCost, err = estimate.CostForTransitions(p.SmallObjectCount, storageClass); err != nil {
+			return err
+		}
+		if cost.AsIsMonthlyCost, err = estimate.StorageCostForObjects(p.SmallObjectCount, p.SmallDataSize, storageClass); err != nil {
+			return err
+		}
+		if cost.CompactedTransitionCost, err = estimate.CostForTransitions(p.CompactedObjectCount, storageClass); err != nil {
+			return err
+		}
+		cost.CompactedTransitionCost += p.CompactionCost
+		if cost.CompactedMonthlyCost, err = estimate.StorageCostForObjects(p.CompactedObjectCount, p.SmallDataSize, storageClass); err != nil {
+			return err
+		}
+		if cost.AsIs, err = estimate.EstimateTransition(p.SmallObjectCount, p.SmallDataSize, "STANDARD", storageClass, retrievalRate, 0); err != nil {
+			return err
+		}
+		compacted, err := estimate.EstimateTransition(p.CompactedObjectCount, p.SmallDataSize, "STANDARD", storageClass, retrievalRate, 0)
+		if err != nil {
+			return err
+		}
+		cost.Compacted = estimate.NewTransitionEstimate(compacted.UpfrontCost+p.CompactionCost, compacted.MonthlyDelta)
+		p.Transitions = append(p.Transitions, cost)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package scan
+
+//This file scans the noncurrent versions and delete markers of versioned buckets
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/recommendation/estimate"
+)
+
+// Noncurrent versions this old count towards min savings, the common NoncurrentDays for version expiration
+const noncurrentVersionExpirationDays = 90
+
+// The age bands noncurrent versions are grouped into, age is the time since the version became noncurrent
+var noncurrentVersionAgeBands = []ageBand{
+	{"0-30 days", 0},
+	{"30-90 days", 30},
+	{"90-365 days", 90},
+	{"365+ days", 365},
+}
+
+// The bands keys are grouped into by their number of versions, delete markers included
+var versionsPerKeyBands = []struct {
+	Name        string
+	MinVersions int64
+}{
+	{"1", 1},
+	{"2", 2},
+	{"3-5", 3},
+	{"6-10", 6},
+	{"11+", 11},
+}
+
+// VersionDetail is the ObjectScan.Details of the object_versions scan
+type VersionDetail struct {
+	NoncurrentVersionCount    int64                        `json:"noncurrent_version_count"`
+	NoncurrentVersionSize     int64                        `json:"noncurrent_version_size"`
+	NoncurrentStorageClasses  map[string]StorageClassTotal `json:"noncurrent_storage_classes"`
+	NoncurrentAgeBands        []VersionAgeBand             `json:"noncurrent_age_bands"`
+	VersionsPerKey            []VersionsPerKeyBand         `json:"versions_per_key"`
+	KeyCount                  int64                        `json:"key_count"`
+	DeleteMarkerCount         int64                        `json:"delete_marker_count"`
+	ExpiredDeleteMarkerCount  int64                        `json:"expired_delete_marker_count"` //delete markers with no versions left behind them
+	OldestNoncurrentVersionAt time.Time                    `json:"oldest_noncurrent_version_at"`
+	LastModifiedAt            time.Time                    `json:"last_modified_at"` //newest version or delete marker
+}
+
+// StorageClassTotal contains the number and size of objects in one storage class
+type StorageClassTotal struct {
+	ObjectCount int64 `json:"object_count"`
+	DataSize    int64 `json:"data_size"`
+}
+
+// VersionAgeBand contains the noncurrent versions that became noncurrent within an age band
+type VersionAgeBand struct {
+	Band             string                    `json:"band"`
+	VersionCount     int64                     `json:"version_count"`
+	DataSize         int64                     `json:"data_size"`
+	EstimatedSavings estimate.EstimatedSavings `json:"estimated_savings"`
+}
+
+// VersionsPerKeyBand contains the number of keys with a number of versions in a band
+type VersionsPerKeyBand struct {
+	Band     string `json:"band"`
+	KeyCount int64  `json:"key_count"`
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:                "object_versions",
+		Permissions:         []string{"s3:ListBucketVersions"},
+		OptionalPermissions: []string{"s3:GetObjectRetention", "s3:GetObjectLegalHold"},
+		New: func(ctx ScanContext) Scanner {
+			return &objectVersionsScanner{ctx: ctx}
+		},
+	})
+}
+
+// Scanner for noncurrent versions and delete markers
+// Does not use the object listing, versions are listed in Finalize
+// Buckets that never had versioning enabled are skipped, versions locked by Object Lock are left out of the savings
+type objectVersionsScanner struct {
+	ctx ScanContext
+}
+
+func (s *objectVersionsScanner) Consume(page *s3.ListObjectsV2Output) error {
+	return nil
+}
+
+func (s *objectVersionsScanner) Finalize() (ObjectScan, error) {
+	now := time.Now()
+	tally := newVersionTally(now, newLockChecker(s.ctx, now))
+	if s.ctx.BucketScan.VersioningStatus == "Not Enabled" {
+		return tally.objectScan(), nil
+	}
+
+	err := objectVersionsScan(s.ctx.Session, s.ctx.Bucket.Name, tally)
+	if err != nil {
+		return ObjectScan{DataCategory: "object_versions"}, err
+	}
+
+	return tally.objectScan(), nil
+}
+
+// One version or delete marker from ListObjectVersions
+type objectVersion struct {
+	Key            string
+	VersionID      string
+	LastModified   time.Time
+	IsLatest       bool
+	IsDeleteMarker bool
+	Size           int64
+	StorageClass   string
+}
+
+// Takes in a session, bucketname and versionTally and adds every version and delete marker in the bucket to the tally
+func objectVersionsScan(sess *session.Session, bucketName string, tally *versionTally) error {
+	svc := s3.New(sess)
+
+	//AWS SDK LIST CALL
+	err := svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: &bucketName,
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		tally.addPage(page)
+		return tally.err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if tally.err != nil {
+		return tally.err
+	}
+
+	tally.finish()
+	return nil
+}
+
+// Totals versions one key at a time
+// ListObjectVersions returns keys in order and each key's versions newest first, a key can continue on the next page
+type versionTally struct {
+	now    time.Time
+	detail VersionDetail
+	min    float64
+	max    float64
+	locks  *lockChecker
+	err    error //first failed Object Lock lookup
+
+	lockedClasses map[string]StorageClassTotal //noncurrent versions Object Lock keeps from being deleted
+
+	//state of the key being tallied
+	key          string
+	newerAt      time.Time //when the next newer version was written, which is when the current one became noncurrent
+	versionCount int64
+	hasVersion   bool //at least one version that isn't a delete marker
+	latestMarker bool //the latest version is a delete marker
+}
+
+// Takes in the time ages are measured from and the bucket's lockChecker, nil when it doesn't have Object Lock enabled
+func newVersionTally(now time.Time, locks *lockChecker) *versionTally {
+	tally := &versionTally{now: now, locks: locks, lockedClasses: make(map[string]StorageClassTotal)}
+	tally.detail.NoncurrentStorageClasses = make(map[string]StorageClassTotal)
+	tally.detail.NoncurrentAgeBands = make([]VersionAgeBand, len(noncurrentVersionAgeBands))
+	for i, band := range noncurrentVersionAgeBands {
+		tally.detail.NoncurrentAgeBands[i].Band = band.Name
+	}
+	tally.detail.VersionsPerKey = make([]VersionsPerKeyBand, len(versionsPerKeyBands))
+	for i, band := range versionsPerKeyBands {
+		tally.detail.VersionsPerKey[i].Band = band.Name
+	}
+	return tally
+}
+
+// Merges a page's versions and delete markers back into key order and adds them to the tally
+func (t *versionTally) addPage(page *s3.ListObjectVersionsOutput) {
+	versions := []objectVersion{}
+	for _, v := range page.Versions {
+		version := objectVersion{Key: *v.Key, VersionID: aws.StringValue(v.VersionId), StorageClass: "STANDARD"}
+		if v.LastModified != nil {
+			version.LastModified = *v.LastModified
+		}
+		if v.IsLatest != nil {
+			version.IsLatest = *v.IsLatest
+		}
+		if v.Size != nil {
+			version.Size = *v.Size
+		}
+		if v.StorageClass != nil {
+			version.StorageClass = *v.StorageClass
+		}
+		versions = append(versions, version)
+	}
+	for _, m := range page.DeleteMarkers {
+		marker := objectVersion{Key: *m.Key, IsDeleteMarker: true}
+		if m.LastModified != nil {
+			marker.LastModified = *m.LastModified
+		}
+		if m.IsLatest != nil {
+			marker.IsLatest = *m.IsLatest
+		}
+		versions = append(versions, marker)
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key < versions[j].Key
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+
+	for _, version := range versions {
+		t.add(version)
+	}
+}
+
+// Adds one version to the tally, versions of a key have to be added newest first
+func (t *versionTally) add(version objectVersion) {
+	if version.Key != t.key || t.versionCount == 0 {
+		t.finish()
+		t.key = version.Key
+		t.latestMarker = version.IsDeleteMarker
+	}
+	t.versionCount++
+	if version.LastModified.After(t.detail.LastModifiedAt) {
+		t.detail.LastModifiedAt = version.LastModified
+	}
+
+	if version.IsDeleteMarker {
+		t.detail.DeleteMarkerCount++
+	} else {
+		t.hasVersion = true
+	}
+
+	if !version.IsLatest && !version.IsDeleteMarker {
+		noncurrentAt := t.newerAt
+		if noncurrentAt.IsZero() {
+			noncurrentAt = version.LastModified
+		}
+		ageDays := ageInDays(noncurrentAt, t.now)
+		savings := estimate.SavingsForBytesDeletedByStorageClass(version.Size, version.StorageClass)
+
+		t.detail.NoncurrentVersionCount++
+		t.detail.NoncurrentVersionSize += version.Size
+		classTotal := t.detail.NoncurrentStorageClasses[version.StorageClass]
+		classTotal.ObjectCount++
+		classTotal.DataSize += version.Size
+		t.detail.NoncurrentStorageClasses[version.StorageClass] = classTotal
+		if t.detail.OldestNoncurrentVersionAt.IsZero() || noncurrentAt.Before(t.detail.OldestNoncurrentVersionAt) {
+			t.detail.OldestNoncurrentVersionAt = noncurrentAt
+		}
+
+		b := ageBandIndex(noncurrentVersionAgeBands, ageDays)
+		t.detail.NoncurrentAgeBands[b].VersionCount++
+		t.detail.NoncurrentAgeBands[b].DataSize += version.Size
+		if t.isLocked(version) {
+			lockedTotal := t.lockedClasses[version.StorageClass]
+			lockedTotal.ObjectCount++
+			lockedTotal.DataSize += version.Size
+			t.lockedClasses[version.StorageClass] = lockedTotal
+			savings = 0
+		}
+		t.detail.NoncurrentAgeBands[b].EstimatedSavings.CalculatedMonthlySavingsMax += savings
+		t.max += savings
+		if ageDays >= noncurrentVersionExpirationDays {
+			t.detail.NoncurrentAgeBands[b].EstimatedSavings.CalculatedMonthlylSavingsMin += savings
+			t.min += savings
+		}
+	}
+
+	t.newerAt = version.LastModified
+}
+
+// HELPER for add()
+// Checks a noncurrent version against Object Lock, the first failed lookup is kept in err and stops the checks
+func (t *versionTally) isLocked(version objectVersion) bool {
+	if t.err != nil {
+		return false
+	}
+	locked, err := t.locks.lockedVersion(version.Key, version.VersionID, version.Size, version.LastModified)
+	if err != nil {
+		t.err = err
+		return false
+	}
+	return locked
+}
+
+// Closes out the key being tallied
+func (t *versionTally) finish() {
+	if t.versionCount == 0 {
+		return
+	}
+
+	t.detail.KeyCount++
+	b := 0
+	for i, band := range versionsPerKeyBands {
+		if t.versionCount >= band.MinVersions {
+			b = i
+		}
+	}
+	t.detail.VersionsPerKey[b].KeyCount++
+
+	if t.latestMarker && !t.hasVersion {
+		t.detail.ExpiredDeleteMarkerCount++
+	}
+
+	t.key = ""
+	t.newerAt = time.Time{}
+	t.versionCount = 0
+	t.hasVersion = false
+	t.latestMarker = false
+}
+
+// Returns the object_versions ObjectScan for the versions tallied so far
+func (t *versionTally) objectScan() ObjectScan {
+	objectScan := ObjectScan{
+		DataCategory: "object_versions",
+		ObjectCount:  t.detail.NoncurrentVersionCount,
+		DataSize:     t.detail.NoncurrentVersionSize,
+		EstimatedSavings: estimate.EstimatedSavings{
+			CalculatedMonthlylSavingsMin: t.min,
+			CalculatedMonthlySavingsMax:  t.max,
+		},
+		ObjectLock: t.locks.lockSummary(),
+	}
+	if t.detail.KeyCount > 0 {
+		objectScan.Details = &t.detail
+	}
+	return objectScan
+}
@@ -0,0 +1,68 @@
+package scan
+
+//This file fingerprints the workloads that wrote a bucket's objects, for the bucket and each of its prefixes
+//The bucket summary fingerprints the same full listing, ScanS3 replaces its label with this scan's
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/helloevanhere/simple_saver_service/pkg/workload"
+)
+
+// WorkloadDetail is the ObjectScan.Details of the workloads scan
+// ObjectCount and DataSize of the ObjectScan are the objects written by a known workload
+type WorkloadDetail struct {
+	Workload string                    `json:"workload"` //workload of the bucket, empty if none wrote most of its objects
+	Prefixes []workload.PrefixWorkload `json:"prefixes"`
+}
+
+func init() {
+	RegisterScanner(ScannerRegistration{
+		Name:        "workloads",
+		Permissions: []string{"s3:ListBucket"},
+		New: func(ctx ScanContext) Scanner {
+			return newWorkloadScanner(ctx.Bucket.Name)
+		},
+	})
+}
+
+// Scanner for the workloads that wrote a bucket's objects
+type workloadScanner struct {
+	detector *workload.Detector
+}
+
+func newWorkloadScanner(bucket string) *workloadScanner {
+	return &workloadScanner{detector: workload.NewDetector(bucket)}
+}
+
+func (s *workloadScanner) Consume(page *s3.ListObjectsV2Output) error {
+	for _, object := range page.Contents {
+		s.detector.Observe(*object.Key, *object.Size)
+	}
+	return nil
+}
+
+// Fingerprinting has no savings of its own, the analyses use the labels to pick their advice
+func (s *workloadScanner) Finalize() (ObjectScan, error) {
+	objectScan := ObjectScan{DataCategory: "workloads"}
+	detail := &WorkloadDetail{
+		Workload: s.detector.Workload(),
+		Prefixes: s.detector.Prefixes(),
+	}
+	for _, p := range detail.Prefixes {
+		objectScan.ObjectCount += p.ObjectCount
+		objectScan.DataSize += p.DataSize
+	}
+	objectScan.Details = detail
+	return objectScan, nil
+}
+
+// HELPER for ScanS3()
+// Returns the workload the workloads scan found for a bucket, ok is false if the scan didn't run
+func scannedWorkload(objectScans []ObjectScan) (name string, ok bool) {
+	for _, objectScan := range objectScans {
+		if detail, isWorkload := objectScan.Details.(*WorkloadDetail); isWorkload {
+			return detail.Workload, true
+		}
+	}
+	return "", false
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/helloevanhere/simple_saver_service/pkg/awsHelpers"
+	"github.com/helloevanhere/simple_saver_service/pkg/workload"
 )
 
 type S3Summary struct {
@@ -24,8 +25,9 @@ type S3Summary struct {
 type BucketSummary struct {
 	Name           string    `json:"bucket_name"`
 	ObjectCount    int64     `json:"object_count"`
-	Size           int64     `json:"bucket_size"`      //in bytes
-	ModifiedLastAt time.Time `json:"modified_last_at"` //nil equivalent if empty
+	Size           int64     `json:"bucket_size"`        //in bytes
+	ModifiedLastAt time.Time `json:"modified_last_at"`   //nil equivalent if empty
+	Workload       string    `json:"workload,omitempty"` //well-known producer of the bucket's data, ex. athena_results
 }
 
 // Takes in a session obj and array of bucket names and returns an array of BucketSummary
@@ -52,18 +54,24 @@ func CreateBucketSummaries(sess *session.Session, buckets []string) ([]BucketSum
 					//TO DO: Make ModifiedLastAt the bucket's creation date, which requires ListBuckets
 					ModifiedLastAt: time.Time{},
 				}
+				//Only the bucket's name is left to fingerprint
+				b.Workload, _ = workload.MatchBucket(bucketName)
 				//add BucketSummary to final result
 				bucketSummaries = append(bucketSummaries, b)
+				return
 			}
 
 			// Initialize variables for metadata
 			var totalSize int64
 			var lastModTime time.Time
 			numObjects := len(objResp.Contents)
+			//ListBucketObjects pages through the whole bucket, so every key is fingerprinted
+			detector := workload.NewDetector(bucketName)
 
 			// Loop through response and calculate metadata
 			for _, obj := range objResp.Contents {
 				totalSize += *obj.Size
+				detector.Observe(*obj.Key, *obj.Size)
 
 				if obj.LastModified.After(lastModTime) {
 					lastModTime = *obj.LastModified
@@ -76,6 +84,7 @@ func CreateBucketSummaries(sess *session.Session, buckets []string) ([]BucketSum
 				ObjectCount:    int64(numObjects),
 				Size:           totalSize,
 				ModifiedLastAt: lastModTime,
+				Workload:       detector.Workload(),
 			}
 
 			//add BucketSummary to final result
@@ -0,0 +1,214 @@
+package workload
+
+//This package fingerprints the well-known producers that write to buckets, ex. load balancer logs or Athena query results,
+//from the layout of their keys and the names of their buckets
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Fingerprint recognizes the data of one workload
+type Fingerprint struct {
+	Name        string
+	Description string
+	//Matches the keys the workload writes, the first group ends the workload's prefix
+	KeyPattern *regexp.Regexp
+	//Matches the names of buckets the workload creates for itself, nil if it writes to any bucket
+	BucketPattern *regexp.Regexp
+	Temporary     bool              //only needed for a short while after it is written
+	NeverArchive  bool              //read no matter its age, so archive storage classes don't suit it
+	Advice        map[string]string //advice by Analysis name
+}
+
+// Every known workload, checked in order
+var Fingerprints = []Fingerprint{
+	{
+		Name:        "alb_logs",
+		Description: "Elastic Load Balancing access logs",
+		KeyPattern:  regexp.MustCompile(`^(.*?AWSLogs/\d{12}/elasticloadbalancing/)`),
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "Load balancer logs are rarely read after a few weeks, transition them to GLACIER after 30 days and expire them at the end of your retention period.",
+			"Archive Storage Analysis":      "Load balancer logs are written once and read only to investigate incidents, they suit GLACIER or DEEP_ARCHIVE.",
+		},
+	},
+	{
+		Name:        "cloudtrail",
+		Description: "CloudTrail logs",
+		KeyPattern:  regexp.MustCompile(`^(.*?AWSLogs/(?:o-[a-z0-9]+/)?\d{12}/CloudTrail(?:-Digest|-Insight)?/)`),
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "CloudTrail logs are usually kept for compliance rather than read, transition them to GLACIER after 90 days and expire them when your audit retention ends.",
+			"Archive Storage Analysis":      "CloudTrail logs kept for audits suit DEEP_ARCHIVE once they are older than your investigation window.",
+		},
+	},
+	{
+		Name:        "vpc_flow_logs",
+		Description: "VPC Flow Logs",
+		KeyPattern:  regexp.MustCompile(`^(.*?AWSLogs/\d{12}/vpcflowlogs/)`),
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "VPC Flow Logs grow quickly, expire them after 90 days or transition them to GLACIER if they have to be kept.",
+			"Compressed Data Analysis":      "VPC Flow Logs can be delivered in Parquet, which is smaller than the default gzipped text and cheaper to query.",
+		},
+	},
+	{
+		Name:        "s3_access_logs",
+		Description: "S3 server access logs",
+		KeyPattern:  regexp.MustCompile(`^(.*?)\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-[0-9A-F]{16}$`),
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "S3 server access logs are many small objects, expire them after 90 days or compact them before transitioning them.",
+			"Temporary Storage Analysis":    "S3 server access logs are only needed until they are loaded into your analytics, expire them after 30 to 90 days.",
+		},
+	},
+	{
+		Name:        "cloudfront_logs",
+		Description: "CloudFront standard logs",
+		KeyPattern:  regexp.MustCompile(`^(.*?)[A-Z0-9]{13,14}\.\d{4}-\d{2}-\d{2}-\d{2}\.[0-9a-f]{8}\.gz$`),
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "CloudFront logs are rarely read after a few weeks, expire them after 90 days or transition them to GLACIER.",
+		},
+	},
+	{
+		Name:          "athena_results",
+		Description:   "Athena query results",
+		KeyPattern:    regexp.MustCompile(`^(.*?)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}(?:\.csv|\.csv\.metadata|\.txt|-manifest\.csv)$`),
+		BucketPattern: regexp.MustCompile(`^aws-athena-query-results-`),
+		Temporary:     true,
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "Athena query results are not read again once the query finishes, expire them after 7 days.",
+			"Temporary Storage Analysis":    "Athena query results are not read again once the query finishes, expire them after 7 days.",
+		},
+	},
+	{
+		Name:        "emr_logs",
+		Description: "EMR cluster logs",
+		KeyPattern:  regexp.MustCompile(`^(.*?)j-[A-Z0-9]{8,}/(?:node|steps|containers|daemons)/`),
+		Temporary:   true,
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "EMR logs are only needed to debug recent clusters, expire them after 30 days.",
+			"Temporary Storage Analysis":    "EMR logs are only needed to debug recent clusters, expire them after 30 days.",
+		},
+	},
+	{
+		Name:         "terraform_state",
+		Description:  "Terraform state",
+		KeyPattern:   regexp.MustCompile(`^(.*?)[^/]*\.tfstate(?:\.backup)?$`),
+		NeverArchive: true,
+		Advice: map[string]string{
+			"Bucket Versioning Analysis":    "Keep versioning on for Terraform state, but expire noncurrent versions after 90 days since every apply writes a new one.",
+			"Lifecycle Management Analysis": "Never transition or expire current Terraform state, limit lifecycle rules to noncurrent versions.",
+		},
+	},
+	{
+		Name:          "cdk_assets",
+		Description:   "CDK assets",
+		KeyPattern:    regexp.MustCompile(`^(.*?)(?:assets/)?[0-9a-f]{64}\.(?:zip|json)$`),
+		BucketPattern: regexp.MustCompile(`^cdk-[a-z0-9]+-assets-\d{12}-`),
+		NeverArchive:  true,
+		Advice: map[string]string{
+			"Lifecycle Management Analysis": "CDK never deletes the assets of old deployments, run `cdk gc` or expire assets older than your rollback window.",
+			"Duplicate Data Analysis":       "CDK uploads an asset again whenever its content hash changes, old asset versions can be garbage collected with `cdk gc`.",
+		},
+	},
+}
+
+// Returns the Fingerprint of a workload by name
+func Lookup(name string) (Fingerprint, bool) {
+	for _, fingerprint := range Fingerprints {
+		if fingerprint.Name == name {
+			return fingerprint, true
+		}
+	}
+	return Fingerprint{}, false
+}
+
+// Returns the workload that wrote a key and the prefix it writes under, ok is false if no workload matches
+func Match(key string) (name string, prefix string, ok bool) {
+	for _, fingerprint := range Fingerprints {
+		if match := fingerprint.KeyPattern.FindStringSubmatchIndex(key); match != nil {
+			return fingerprint.Name, key[:match[3]], true
+		}
+	}
+	return "", "", false
+}
+
+// Returns the workload that created a bucket from its name, ok is false if no workload matches
+func MatchBucket(bucket string) (name string, ok bool) {
+	for _, fingerprint := range Fingerprints {
+		if fingerprint.BucketPattern != nil && fingerprint.BucketPattern.MatchString(bucket) {
+			return fingerprint.Name, true
+		}
+	}
+	return "", false
+}
+
+// PrefixWorkload contains the objects a workload wrote under one prefix
+type PrefixWorkload struct {
+	Prefix      string `json:"prefix"`
+	Workload    string `json:"workload"`
+	ObjectCount int64  `json:"object_count"`
+	DataSize    int64  `json:"data_size"`
+}
+
+// Detector fingerprints the objects of one bucket
+type Detector struct {
+	bucket      string
+	objectCount int64
+	prefixes    map[string]*PrefixWorkload
+}
+
+func NewDetector(bucket string) *Detector {
+	return &Detector{bucket: bucket, prefixes: make(map[string]*PrefixWorkload)}
+}
+
+// Adds one object to the Detector
+func (d *Detector) Observe(key string, size int64) {
+	d.objectCount++
+	name, prefix, ok := Match(key)
+	if !ok {
+		return
+	}
+	p, ok := d.prefixes[prefix]
+	if !ok {
+		p = &PrefixWorkload{Prefix: prefix, Workload: name}
+		d.prefixes[prefix] = p
+	}
+	p.ObjectCount++
+	p.DataSize += size
+}
+
+// Returns the workload of every prefix, largest first
+func (d *Detector) Prefixes() []PrefixWorkload {
+	prefixes := []PrefixWorkload{}
+	for _, p := range d.prefixes {
+		prefixes = append(prefixes, *p)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].DataSize != prefixes[j].DataSize {
+			return prefixes[i].DataSize > prefixes[j].DataSize
+		}
+		return prefixes[i].Prefix < prefixes[j].Prefix
+	})
+	return prefixes
+}
+
+// Returns the workload of the bucket, the one that wrote most of its objects, or the one its name belongs to
+// Empty if no workload wrote at least half of the objects
+func (d *Detector) Workload() string {
+	counts := make(map[string]int64)
+	for _, p := range d.prefixes {
+		counts[p.Workload] += p.ObjectCount
+	}
+	best, bestCount := "", int64(0)
+	for name, count := range counts {
+		if count > bestCount || (count == bestCount && name < best) {
+			best, bestCount = name, count
+		}
+	}
+	if bestCount > 0 && 2*bestCount >= d.objectCount {
+		return best
+	}
+	if name, ok := MatchBucket(d.bucket); ok {
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package workload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test Match with keys of every workload
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		key, name, prefix string
+	}{
+		{"lb/AWSLogs/123456789012/elasticloadbalancing/us-east-1/2023/01/02/file.log.gz", "alb_logs", "lb/AWSLogs/123456789012/elasticloadbalancing/"},
+		{"AWSLogs/o-abc123/123456789012/CloudTrail/us-east-1/2023/01/02/file.json.gz", "cloudtrail", "AWSLogs/o-abc123/123456789012/CloudTrail/"},
+		{"AWSLogs/123456789012/CloudTrail-Digest/us-east-1/2023/01/02/file.json.gz", "cloudtrail", "AWSLogs/123456789012/CloudTrail-Digest/"},
+		{"flows/AWSLogs/123456789012/vpcflowlogs/us-east-1/2023/01/02/file.log.gz", "vpc_flow_logs", "flows/AWSLogs/123456789012/vpcflowlogs/"},
+		{"logs/2023-01-02-03-04-05-0123456789ABCDEF", "s3_access_logs", "logs/"},
+		{"cdn/E2ABCDEFGHIJKL.2023-01-02-03.a1b2c3d4.gz", "cloudfront_logs", "cdn/"},
+		{"results/0f8fad5b-d9cb-469f-a165-70867728950e.csv", "athena_results", "results/"},
+		{"results/0f8fad5b-d9cb-469f-a165-70867728950e.csv.metadata", "athena_results", "results/"},
+		{"emr/j-2AXXXXXXGAPLF/node/i-0123/applications/hadoop.log.gz", "emr_logs", "emr/"},
+		{"env/prod/terraform.tfstate", "terraform_state", "env/prod/"},
+		{"assets/2b3c1f0e9d8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c.zip", "cdk_assets", ""},
+	}
+	for _, c := range cases {
+		name, prefix, ok := Match(c.key)
+		assert.True(t, ok, c.key)
+		assert.Equal(t, c.name, name, c.key)
+		assert.Equal(t, c.prefix, prefix, c.key)
+	}
+
+	_, _, ok := Match("images/cat.png")
+	assert.False(t, ok)
+}
+
+// Test MatchBucket and Lookup
+func TestMatchBucket(t *testing.T) {
+	name, ok := MatchBucket("aws-athena-query-results-123456789012-us-east-1")
+	assert.True(t, ok)
+	assert.Equal(t, "athena_results", name)
+
+	name, ok = MatchBucket("cdk-hnb659fds-assets-123456789012-us-east-1")
+	assert.True(t, ok)
+	assert.Equal(t, "cdk_assets", name)
+
+	_, ok = MatchBucket("my-app-data")
+	assert.False(t, ok)
+
+	fingerprint, ok := Lookup("athena_results")
+	assert.True(t, ok)
+	assert.True(t, fingerprint.Temporary)
+	_, ok = Lookup("unknown")
+	assert.False(t, ok)
+}
+
+// Test the Detector labels a bucket with its dominant workload
+func TestDetector(t *testing.T) {
+	d := NewDetector("shared-logs")
+	d.Observe("AWSLogs/123456789012/elasticloadbalancing/us-east-1/a.log.gz", 100)
+	d.Observe("AWSLogs/123456789012/elasticloadbalancing/us-east-1/b.log.gz", 100)
+	d.Observe("AWSLogs/123456789012/CloudTrail/us-east-1/c.json.gz", 50)
+	d.Observe("readme.txt", 10)
+
+	assert.Equal(t, "alb_logs", d.Workload())
+	assert.Equal(t, []PrefixWorkload{
+		{Prefix: "AWSLogs/123456789012/elasticloadbalancing/", Workload: "alb_logs", ObjectCount: 2, DataSize: 200},
+		{Prefix: "AWSLogs/123456789012/CloudTrail/", Workload: "cloudtrail", ObjectCount: 1, DataSize: 50},
+	}, d.Prefixes())
+
+	//No workload wrote half of the objects
+	d = NewDetector("app-data")
+	d.Observe("env/terraform.tfstate", 10)
+	d.Observe("a.png", 10)
+	d.Observe("b.png", 10)
+	assert.Equal(t, "", d.Workload())
+
+	//The bucket name labels buckets whose keys don't match
+	d = NewDetector("aws-athena-query-results-123456789012-us-east-1")
+	d.Observe("adhoc/readme.txt", 10)
+	assert.Equal(t, "athena_results", d.Workload())
+}